@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/birdrides/nlb-attacher/pkg/cli"
+)
+
+func main() {
+	os.Exit(cli.Plugin(os.Args[1:]))
+}
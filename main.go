@@ -8,8 +8,7 @@ import (
 	//"time"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/birdrides/nlb-attacher/pkg/config"
-	"github.com/birdrides/nlb-attacher/pkg/deployable"
+	"github.com/birdrides/nlb-attacher/pkg/cli"
 )
 
 func init() {
@@ -26,18 +25,5 @@ func init() {
 }
 
 func main() {
-	//Todo make this config come from CLI or possibly vault
-	config := config.CreateConfig(false)
-
-	app := deployable.NewDeployable(config)
-
-	if err := app.Run(); err != nil {
-		panic(err)
-	}
+	os.Exit(cli.Main(os.Args[1:]))
 }
-
-//	//Todo make this config come from CLI or possibly vault
-//	config := config.CreateConfig(false)
-//
-//	c := new(controller.Controller)
-//	c.Init(config)
@@ -0,0 +1,130 @@
+package access
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+// The virtual resource pods' service accounts need the bind verb on, e.g. in a Role granting
+// bind on targetgroups.nlb-attacher.bird.co with the target group name in resourceNames
+const (
+	Group    = "nlb-attacher.bird.co"
+	Resource = "targetgroups"
+	Verb     = "bind"
+)
+
+var reviewsTotal = metrics.NewCounter(
+	"nlb_attacher_access_reviews_total",
+	"SubjectAccessReviews made for pods' service accounts, by result.",
+	"result",
+)
+
+// Reviewer asks the apiserver whether a service account may bind a target group, and remembers the answers for a while.
+type Reviewer struct {
+	client authorizationclient.SubjectAccessReviewInterface
+	ttl    time.Duration
+
+	mutex     sync.Mutex
+	decisions map[string]decision
+	// swept is when the expired decisions were last dropped
+	swept time.Time
+	// now is the clock, replaced in tests
+	now func() time.Time
+}
+
+// decision is a cached review result
+type decision struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+// NewReviewer - return a reviewer keeping each answer for ttl
+func NewReviewer(clientset kubernetes.Interface, ttl time.Duration) *Reviewer {
+	return &Reviewer{
+		client:    clientset.AuthorizationV1().SubjectAccessReviews(),
+		ttl:       ttl,
+		decisions: make(map[string]decision),
+		now:       time.Now,
+	}
+}
+
+// Allowed - report whether the service account of the namespace may bind the target group, and why not.
+// An empty service account is the namespace's default one
+func (reviewer *Reviewer) Allowed(namespace string, serviceAccount string, tgName string) (bool, string, error) {
+	if reviewer == nil {
+		return true, "", nil
+	}
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	key := namespace + "/" + serviceAccount + "/" + tgName
+
+	reviewer.mutex.Lock()
+	cached, ok := reviewer.decisions[key]
+	if ok && !reviewer.now().Before(cached.expires) {
+		delete(reviewer.decisions, key)
+		ok = false
+	}
+	reviewer.mutex.Unlock()
+	if ok {
+		return cached.allowed, cached.reason, nil
+	}
+
+	review, err := reviewer.client.Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      Verb,
+				Group:     Group,
+				Resource:  Resource,
+				Name:      tgName,
+			},
+		},
+	})
+	if err != nil {
+		reviewsTotal.Inc("error")
+		return false, "", fmt.Errorf("failed to review whether service account %s/%s may bind target group %s: %v", namespace, serviceAccount, tgName, err)
+	}
+
+	result := decision{allowed: review.Status.Allowed, expires: reviewer.now().Add(reviewer.ttl)}
+	if result.allowed {
+		reviewsTotal.Inc("allowed")
+	} else {
+		reviewsTotal.Inc("denied")
+		result.reason = fmt.Sprintf("service account %s/%s may not %s %s.%s %s", namespace, serviceAccount, Verb, Resource, Group, tgName)
+		if review.Status.Reason != "" {
+			result.reason += ": " + review.Status.Reason
+		}
+	}
+
+	reviewer.mutex.Lock()
+	reviewer.decisions[key] = result
+	reviewer.sweep()
+	reviewer.mutex.Unlock()
+	return result.allowed, result.reason, nil
+}
+
+// sweep drops the expired decisions, at most once per ttl, so answers for namespaces, service accounts and
+// target groups that are gone do not pile up. Callers hold the mutex
+func (reviewer *Reviewer) sweep() {
+	now := reviewer.now()
+	if now.Sub(reviewer.swept) < reviewer.ttl {
+		return
+	}
+	reviewer.swept = now
+	for key, cached := range reviewer.decisions {
+		if !now.Before(cached.expires) {
+			delete(reviewer.decisions, key)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestReviewer returns a reviewer whose reviews allow the service accounts in allowed, with the number of reviews made
+func newTestReviewer(ttl time.Duration, allowed map[string]bool) (*Reviewer, *int, *time.Time) {
+	clientset := fake.NewSimpleClientset()
+	reviews := 0
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = allowed[review.Spec.User]
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+	now := time.Now()
+	reviewer := NewReviewer(clientset, ttl)
+	reviewer.now = func() time.Time { return now }
+	return reviewer, &reviews, &now
+}
+
+func TestReviewerAllowed(t *testing.T) {
+	cases := []struct {
+		name           string
+		serviceAccount string
+		want           bool
+		wantReason     string
+	}{
+		{name: "bound service account", serviceAccount: "web", want: true},
+		{name: "empty is the default service account", serviceAccount: "", want: true},
+		{
+			name:           "unbound service account",
+			serviceAccount: "batch",
+			wantReason:     "service account team-a/batch may not bind targetgroups.nlb-attacher.bird.co web-public: no RBAC policy matched",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reviewer, _, _ := newTestReviewer(time.Minute, map[string]bool{
+				"system:serviceaccount:team-a:web":     true,
+				"system:serviceaccount:team-a:default": true,
+			})
+			allowed, reason, err := reviewer.Allowed("team-a", tc.serviceAccount, "web-public")
+			if err != nil {
+				t.Fatalf("Allowed() error = %v", err)
+			}
+			if allowed != tc.want || reason != tc.wantReason {
+				t.Errorf("Allowed() = %v, %q, want %v, %q", allowed, reason, tc.want, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestReviewerCachesDecisions(t *testing.T) {
+	cases := []struct {
+		name        string
+		after       time.Duration
+		wantReviews int
+	}{
+		{name: "within the ttl", after: 30 * time.Second, wantReviews: 1},
+		{name: "after the ttl", after: time.Minute, wantReviews: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reviewer, reviews, now := newTestReviewer(time.Minute, nil)
+			for i := 0; i < 2; i++ {
+				if _, _, err := reviewer.Allowed("team-a", "web", "web-public"); err != nil {
+					t.Fatalf("Allowed() error = %v", err)
+				}
+				*now = now.Add(tc.after)
+			}
+			if *reviews != tc.wantReviews {
+				t.Errorf("%d reviews, want %d", *reviews, tc.wantReviews)
+			}
+		})
+	}
+}
+
+func TestReviewerDropsExpiredDecisions(t *testing.T) {
+	reviewer, _, now := newTestReviewer(time.Minute, nil)
+	for _, namespace := range []string{"team-a", "team-b", "team-c"} {
+		if _, _, err := reviewer.Allowed(namespace, "web", "web-public"); err != nil {
+			t.Fatalf("Allowed() error = %v", err)
+		}
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if _, _, err := reviewer.Allowed("team-d", "web", "web-public"); err != nil {
+		t.Fatalf("Allowed() error = %v", err)
+	}
+	if len(reviewer.decisions) != 1 {
+		t.Errorf("%d decisions cached, want only the one of team-d", len(reviewer.decisions))
+	}
+}
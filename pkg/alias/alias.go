@@ -0,0 +1,96 @@
+package alias
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var (
+	aliasesDefined = metrics.NewGauge(
+		"nlb_attacher_target_group_aliases",
+		"Target group aliases defined in the alias config map.",
+	)
+	aliasChangesTotal = metrics.NewCounter(
+		"nlb_attacher_target_group_alias_changes_total",
+		"Aliases added, retargeted or removed by updates of the alias config map.",
+	)
+)
+
+// Store maps target group aliases to ARNs, from the keys of the alias config map.
+type Store struct {
+	namespace string
+	name      string
+
+	mutex   sync.RWMutex
+	aliases map[string]string
+}
+
+// NewStore - return an empty store for the config map namespace/name
+func NewStore(namespace string, name string) *Store {
+	return &Store{namespace: namespace, name: name, aliases: make(map[string]string)}
+}
+
+// Source - return the namespace/name of the config map the aliases come from
+func (store *Store) Source() string {
+	if store == nil {
+		return ""
+	}
+	return store.namespace + "/" + store.name
+}
+
+// Resolve - return the target group ARN of the alias
+func (store *Store) Resolve(alias string) (string, bool) {
+	if store == nil {
+		return "", false
+	}
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	tgArn, ok := store.aliases[alias]
+	return tgArn, ok
+}
+
+// Update - replace the aliases with the data of the config map, nil when it was deleted, and return the aliases that
+// were added, removed or now resolve to another ARN. Values that are not target group ARNs are logged and left out
+func (store *Store) Update(configMap *v1.ConfigMap) []string {
+	aliases := make(map[string]string)
+	if configMap != nil {
+		for key, value := range configMap.Data {
+			if !annotation.IsTargetGroupArn(value) {
+				log.Errorf("Ignoring alias %q of config map %s: %q is not a target group ARN", key, store.Source(), value)
+				continue
+			}
+			aliases[key] = value
+		}
+	}
+
+	store.mutex.Lock()
+	previous := store.aliases
+	store.aliases = aliases
+	store.mutex.Unlock()
+
+	changed := make([]string, 0)
+	for key, value := range aliases {
+		if previous[key] != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := aliases[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+
+	aliasesDefined.Set(float64(len(aliases)))
+	aliasChangesTotal.Add(float64(len(changed)))
+	if len(changed) > 0 {
+		log.Infof("Target group aliases of config map %s changed: %v", store.Source(), changed)
+	}
+	return changed
+}
@@ -0,0 +1,65 @@
+package alias
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	webArn    = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/web/73e2d6bc24d8a067"
+	webNewArn = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/web-v2/0123456789abcdef"
+	adminArn  = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/admin/2453ed029918f21f"
+)
+
+func configMap(aliases map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{Data: aliases}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	initial := map[string]string{"web": webArn, "admin": adminArn}
+	cases := []struct {
+		name        string
+		update      *v1.ConfigMap
+		wantChanged []string
+		wantAliases map[string]string
+	}{
+		{name: "unchanged", update: configMap(initial), wantChanged: []string{}, wantAliases: initial},
+		{
+			name:        "retargeted",
+			update:      configMap(map[string]string{"web": webNewArn, "admin": adminArn}),
+			wantChanged: []string{"web"},
+			wantAliases: map[string]string{"web": webNewArn, "admin": adminArn},
+		},
+		{
+			name:        "added and removed",
+			update:      configMap(map[string]string{"web": webArn, "web-v2": webNewArn}),
+			wantChanged: []string{"admin", "web-v2"},
+			wantAliases: map[string]string{"web": webArn, "web-v2": webNewArn},
+		},
+		{
+			name:        "values that are not ARNs are left out",
+			update:      configMap(map[string]string{"web": webArn, "admin": "admin"}),
+			wantChanged: []string{"admin"},
+			wantAliases: map[string]string{"web": webArn},
+		},
+		{name: "deleted config map", update: nil, wantChanged: []string{"admin", "web"}, wantAliases: map[string]string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewStore("kube-system", "nlb-attacher-aliases")
+			store.Update(configMap(initial))
+
+			if changed := store.Update(tc.update); !reflect.DeepEqual(changed, tc.wantChanged) {
+				t.Errorf("Update() = %q, want %q", changed, tc.wantChanged)
+			}
+			for _, alias := range []string{"web", "web-v2", "admin"} {
+				want, wantOk := tc.wantAliases[alias]
+				if got, ok := store.Resolve(alias); got != want || ok != wantOk {
+					t.Errorf("Resolve(%q) = %q, %v, want %q, %v", alias, got, ok, want, wantOk)
+				}
+			}
+		})
+	}
+}
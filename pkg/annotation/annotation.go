@@ -0,0 +1,232 @@
+package annotation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// TargetGroup is a single entry of the target-groups annotation, in either format.
+// v1 only sets Arn and PortName, the other fields come from the v2 format
+type TargetGroup struct {
+	Arn      string
+	PortName string
+
+	Name      string
+	Alias     string
+	Port      int32
+	Readiness *Readiness
+	Health    *Health
+	Weight    *int32
+	Subset    *metav1.LabelSelector
+
+	// version is the format the entry was decoded from
+	version string
+}
+
+// targetGroupV1 is a single entry of the v1 format
+type targetGroupV1 struct {
+	Arn      string
+	PortName string
+}
+
+var targetGroupArnPattern = regexp.MustCompile(
+	`^arn:aws[a-z-]*:elasticloadbalancing:[a-z0-9-]+:[0-9]{12}:targetgroup/[a-zA-Z0-9-]{1,32}/[0-9a-f]{16}$`,
+)
+
+// IsTargetGroupArn - report whether the value is a well formed target group ARN
+func IsTargetGroupArn(value string) bool {
+	return targetGroupArnPattern.MatchString(value)
+}
+
+// Parse - decode the target-groups annotation value. v1 is a JSON array of {Arn, PortName}, v2 an object with version: v2
+// in JSON or YAML. v2 is decoded strictly, and every problem is reported at once
+func Parse(value string) ([]TargetGroup, error) {
+	if isV2(value) {
+		targetGroups, problems := decodeV2(value)
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("invalid target-groups annotation: %s", strings.Join(problems, "; "))
+		}
+		return targetGroups, nil
+	}
+
+	var entries []targetGroupV1
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("invalid target-groups annotation: %v", err)
+	}
+	targetGroups := make([]TargetGroup, 0, len(entries))
+	for _, entry := range entries {
+		targetGroups = append(targetGroups, TargetGroup{Arn: entry.Arn, PortName: entry.PortName})
+	}
+	return targetGroups, nil
+}
+
+// Ref - return how the entry references its target group: the ARN, the name or the alias
+func (targetGroup TargetGroup) Ref() string {
+	switch {
+	case targetGroup.Arn != "":
+		return targetGroup.Arn
+	case targetGroup.Name != "":
+		return targetGroup.Name
+	}
+	return targetGroup.Alias
+}
+
+// TargetPort - return the port the pod is registered on, or 0 for the port of the target group.
+// A v1 PortName only names the port the target group serves, a v2 port is the port registered
+func (targetGroup TargetGroup) TargetPort(pod *v1.Pod) int64 {
+	if targetGroup.version != Version2 {
+		return 0
+	}
+	if targetGroup.Port != 0 {
+		return int64(targetGroup.Port)
+	}
+	if targetGroup.PortName != "" {
+		return int64(ContainerPort(pod, targetGroup.PortName))
+	}
+	return 0
+}
+
+// Gated - report whether the target group counts towards the registered readiness gate
+func (targetGroup TargetGroup) Gated() bool {
+	return targetGroup.Readiness == nil || targetGroup.Readiness.Gate == nil || *targetGroup.Readiness.Gate
+}
+
+// Selects - report whether the pod is to be registered with the target group, or why not.
+// The weight picks that share of the pods by a hash of the pod and the target group, so the choice is stable
+func (targetGroup TargetGroup) Selects(pod *v1.Pod, tgArn string) (bool, string) {
+	if targetGroup.Subset != nil {
+		selector, err := metav1.LabelSelectorAsSelector(targetGroup.Subset)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			return false, "the pod is not in the subset"
+		}
+	}
+	if targetGroup.Weight != nil {
+		hash := fnv.New32a()
+		hash.Write([]byte(pod.Namespace + "/" + pod.Name + "/" + tgArn))
+		if int32(hash.Sum32()%100) >= *targetGroup.Weight {
+			return false, fmt.Sprintf("the pod is outside the weight of %d%%", *targetGroup.Weight)
+		}
+	}
+	if targetGroup.Readiness != nil && targetGroup.Readiness.WaitForPodReady && !containersReady(pod) {
+		return false, "the pod's containers are not ready"
+	}
+	return true, ""
+}
+
+// Validate - strictly decode the annotation on a pod and check every entry against the pod spec.
+// All problems are collected so the caller can report them at once
+func Validate(pod *v1.Pod, annotationKey string) []string {
+	value, ok := pod.GetAnnotations()[annotationKey]
+	if !ok {
+		return nil
+	}
+	if isV2(value) {
+		return validateV2(pod, annotationKey, value)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+
+	var targetGroups []targetGroupV1
+	if err := decoder.Decode(&targetGroups); err != nil {
+		return []string{fmt.Sprintf("%s: invalid JSON: %v", annotationKey, err)}
+	}
+
+	portNames := containerPortNames(pod)
+	problems := make([]string, 0)
+	for i, targetGroup := range targetGroups {
+		field := fmt.Sprintf("%s[%d]", annotationKey, i)
+
+		if targetGroup.Arn == "" {
+			problems = append(problems, fmt.Sprintf("%s.Arn: required", field))
+		} else if !targetGroupArnPattern.MatchString(targetGroup.Arn) {
+			problems = append(problems, fmt.Sprintf("%s.Arn: %q is not a target group ARN", field, targetGroup.Arn))
+		}
+
+		// PortName is optional, as it always was in v1
+		if targetGroup.PortName != "" && !portNames[targetGroup.PortName] {
+			problems = append(problems, fmt.Sprintf(
+				"%s.PortName: %q is not a named container port (have: %s)",
+				field, targetGroup.PortName, strings.Join(sortedKeys(portNames), ", "),
+			))
+		}
+	}
+	return problems
+}
+
+// validateV2 checks the v2 annotation on its own and against the pod spec
+func validateV2(pod *v1.Pod, annotationKey string, value string) []string {
+	targetGroups, decodeProblems := decodeV2(value)
+	problems := make([]string, 0, len(decodeProblems))
+	for _, problem := range decodeProblems {
+		problems = append(problems, annotationKey+"."+problem)
+	}
+	if targetGroups == nil {
+		return problems
+	}
+
+	portNames := containerPortNames(pod)
+	for i, targetGroup := range targetGroups {
+		field := fmt.Sprintf("%s.targetGroups[%d]", annotationKey, i)
+		if targetGroup.PortName != "" && !portNames[targetGroup.PortName] {
+			problems = append(problems, fmt.Sprintf(
+				"%s.port: %q is not a named container port (have: %s)",
+				field, targetGroup.PortName, strings.Join(sortedKeys(portNames), ", "),
+			))
+		}
+	}
+	return problems
+}
+
+// ContainerPort - return the container port of the pod with the given name, or 0 when there is none
+func ContainerPort(pod *v1.Pod, name string) int32 {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == name {
+				return port.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+// containersReady reports whether the pod's containers are all ready. Unlike the Ready condition it does not wait
+// for readiness gates, so waiting for it cannot deadlock with the registered gate
+func containersReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.ContainersReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func containerPortNames(pod *v1.Pod) map[string]bool {
+	names := make(map[string]bool)
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name != "" {
+				names[port.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+package annotation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testKey = "nlb-attacher.bird.co/target-groups"
+	testArn = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-a/73e2d6bc24d8a067"
+)
+
+func testPod(value string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default", Annotations: map[string]string{testKey: value}},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}}},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	weight := int32(50)
+	cases := []struct {
+		name    string
+		value   string
+		want    []TargetGroup
+		wantErr string
+	}{
+		{
+			name:  "v1",
+			value: `[{"Arn": "` + testArn + `", "PortName": "http"}]`,
+			want:  []TargetGroup{{Arn: testArn, PortName: "http"}},
+		},
+		{
+			name:  "v1 empty",
+			value: `[]`,
+			want:  []TargetGroup{},
+		},
+		{
+			name:  "v2 json with a port",
+			value: `{"version": "v2", "targetGroups": [{"arn": "` + testArn + `", "port": 9090}]}`,
+			want:  []TargetGroup{{Arn: testArn, Port: 9090, version: Version2}},
+		},
+		{
+			name:  "v2 yaml by name and alias",
+			value: "version: v2\ntargetGroups:\n- name: tg-a\n  port: http\n  weight: 50\n- alias: web\n",
+			want: []TargetGroup{
+				{Name: "tg-a", PortName: "http", Weight: &weight, version: Version2},
+				{Alias: "web", version: Version2},
+			},
+		},
+		{
+			name:    "v1 malformed",
+			value:   `[{"Arn": `,
+			wantErr: "invalid target-groups annotation",
+		},
+		{
+			name:    "v2 wrong version",
+			value:   `{"version": "v3", "targetGroups": []}`,
+			wantErr: `version: must be "v2", got "v3"`,
+		},
+		{
+			name:    "v2 unknown field",
+			value:   `{"version": "v2", "targetGroups": [{"arn": "` + testArn + `", "prot": 80}]}`,
+			wantErr: "targetGroups[0].prot",
+		},
+		{
+			name:    "v2 several references",
+			value:   `{"version": "v2", "targetGroups": [{"arn": "` + testArn + `", "name": "tg-a"}]}`,
+			wantErr: "only one of arn, name and alias may be set",
+		},
+		{
+			name:    "v2 weight out of range",
+			value:   `{"version": "v2", "targetGroups": [{"name": "tg-a", "weight": 101}]}`,
+			wantErr: "targetGroups[0].weight: 101 is not between 0 and 100",
+		},
+		{
+			name:    "v2 remediation without after",
+			value:   `{"version": "v2", "targetGroups": [{"name": "tg-a", "health": {"remediation": "evict"}}]}`,
+			wantErr: "targetGroups[0].health.after",
+		},
+		{
+			name:    "v2 every problem reported",
+			value:   `{"version": "v2", "targetGroups": [{"name": "-bad-"}, {}]}`,
+			wantErr: `targetGroups[0].name: "-bad-" is not a target group name, which has up to 32 letters, digits and inner hyphens; targetGroups[1]: one of arn, name or alias is required`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Parse() error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{
+			name:  "v1 valid",
+			value: `[{"Arn": "` + testArn + `", "PortName": "http"}]`,
+			want:  []string{},
+		},
+		{
+			name:  "v1 without port name",
+			value: `[{"Arn": "` + testArn + `"}]`,
+			want:  []string{},
+		},
+		{
+			name:  "v1 unknown field",
+			value: `[{"Arn": "` + testArn + `", "PortName": "http", "Port": 80}]`,
+			want:  []string{testKey + `: invalid JSON: json: unknown field "Port"`},
+		},
+		{
+			name:  "v1 missing and malformed fields",
+			value: `[{"Arn": "arn:aws:nope"}, {"PortName": "grpc"}]`,
+			want: []string{
+				testKey + `[0].Arn: "arn:aws:nope" is not a target group ARN`,
+				testKey + `[1].Arn: required`,
+				testKey + `[1].PortName: "grpc" is not a named container port (have: http)`,
+			},
+		},
+		{
+			name:  "v2 valid",
+			value: "version: v2\ntargetGroups:\n- name: tg-a\n  port: http\n",
+			want:  []string{},
+		},
+		{
+			name:  "v2 unknown port name",
+			value: "version: v2\ntargetGroups:\n- name: tg-a\n  port: grpc\n",
+			want:  []string{testKey + `.targetGroups[0].port: "grpc" is not a named container port (have: http)`},
+		},
+		{
+			name:  "v2 decode problems carry the key",
+			value: "version: v2\ntargetGroups:\n- name: tg-a\n  weight: -1\n",
+			want:  []string{testKey + `.targetGroups[0].weight: -1 is not between 0 and 100`},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Validate(testPod(tc.value), testKey)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Validate() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateWithoutAnnotation(t *testing.T) {
+	pod := testPod("")
+	delete(pod.Annotations, testKey)
+	if problems := Validate(pod, testKey); problems != nil {
+		t.Errorf("Validate() = %q, want nil", problems)
+	}
+}
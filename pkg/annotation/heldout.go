@@ -0,0 +1,36 @@
+package annotation
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// HeldOutKey is the annotation listing, comma separated, the target groups a deregister remediation took the pod out of.
+// The pod is not registered with them again until the annotation no longer lists them
+const HeldOutKey = "nlb-attacher.bird.co/held-out"
+
+// HeldOut - return the target groups the pod is held out of
+func HeldOut(pod *v1.Pod) []string {
+	value := pod.GetAnnotations()[HeldOutKey]
+	if value == "" {
+		return nil
+	}
+	tgArns := make([]string, 0)
+	for _, tgArn := range strings.Split(value, ",") {
+		if tgArn = strings.TrimSpace(tgArn); tgArn != "" {
+			tgArns = append(tgArns, tgArn)
+		}
+	}
+	return tgArns
+}
+
+// IsHeldOut - report whether the pod is held out of the target group
+func IsHeldOut(pod *v1.Pod, tgArn string) bool {
+	for _, heldOut := range HeldOut(pod) {
+		if heldOut == tgArn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package annotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StatusKey is the annotation the attacher reports the state of a pod's targets in
+const StatusKey = "nlb-attacher.bird.co/status"
+
+// States of a pod's target in one target group
+const (
+	StateRegistered = "registered"
+	StateFailed     = "failed"
+	StatePaused     = "paused"
+	StateHeldOut    = "held-out"
+	StateDryRun     = "dry-run"
+	StateNotAllowed = "not-allowed"
+	StateDetached   = "detached"
+	// StateDenied is a target group the policy does not allow the pod's namespace to use
+	StateDenied = "denied"
+	// StateNotSelected is a target group the entry's weight, subset or readiness options leave the pod out of
+	StateNotSelected = "not-selected"
+	// StateNodeDraining is a target group the pod was deregistered from because its node is cordoned or about to be terminated
+	StateNodeDraining = "node-draining"
+)
+
+// TargetStatus is the state of a pod's target in one target group, with the health the target group last reported.
+// The last error stays after the target recovered so the cause of a past failure can still be found
+type TargetStatus struct {
+	Arn string `json:"arn,omitempty"`
+	// Ref is the name or alias the pod references the target group by, the ARN is empty while it does not resolve
+	Ref          string       `json:"ref,omitempty"`
+	Port         int64        `json:"port,omitempty"`
+	State        string       `json:"state"`
+	Since        metav1.Time  `json:"since"`
+	Health       string       `json:"health,omitempty"`
+	HealthReason string       `json:"healthReason,omitempty"`
+	LastError    string       `json:"lastError,omitempty"`
+	LastErrorAt  *metav1.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Status is the value of the status annotation
+type Status struct {
+	TargetGroups []TargetStatus `json:"targetGroups"`
+}
+
+// ParseStatus - decode the status annotation of the pod, reporting whether it has one
+func ParseStatus(pod *v1.Pod) (Status, bool, error) {
+	value, ok := pod.GetAnnotations()[StatusKey]
+	if !ok {
+		return Status{}, false, nil
+	}
+	var status Status
+	if err := json.Unmarshal([]byte(value), &status); err != nil {
+		return Status{}, true, fmt.Errorf("invalid %s annotation: %v", StatusKey, err)
+	}
+	return status, true, nil
+}
+
+// Encode - return the annotation value, with the target groups in a stable order
+func (status Status) Encode() (string, error) {
+	targetGroups := append([]TargetStatus(nil), status.TargetGroups...)
+	sort.Slice(targetGroups, func(i, j int) bool {
+		return targetGroups[i].Arn+targetGroups[i].Ref < targetGroups[j].Arn+targetGroups[j].Ref
+	})
+	raw, err := json.Marshal(Status{TargetGroups: targetGroups})
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,282 @@
+package annotation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Version2 is the version field of the v2 format
+const Version2 = "v2"
+
+// Remediation actions for pods that stay unhealthy in a target group. RemediationNone turns the remediation
+// of a target group off for the pod, whatever the configured policies say
+const (
+	RemediationDeregister = "deregister"
+	RemediationLabel      = "label"
+	RemediationEvict      = "evict"
+	RemediationNone       = "none"
+)
+
+var targetGroupNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,30}[a-zA-Z0-9])?$`)
+
+// Readiness are the readiness options of a target group entry
+type Readiness struct {
+	// WaitForPodReady registers the pod only while all of its containers are ready
+	WaitForPodReady bool `json:"waitForPodReady,omitempty"`
+	// Gate counts the target group towards the registered readiness gate, true unless set to false
+	Gate *bool `json:"gate,omitempty"`
+}
+
+// Health are the health options of a target group entry
+type Health struct {
+	// Remediation overrides the configured remediation action for the target group, none turns it off
+	Remediation string `json:"remediation,omitempty"`
+	// After overrides how long the target must be unhealthy before it is remediated
+	After *metav1.Duration `json:"after,omitempty"`
+}
+
+// spec is the v2 format of the annotation
+type spec struct {
+	Version      string          `json:"version"`
+	TargetGroups []targetGroupV2 `json:"targetGroups"`
+}
+
+// targetGroupV2 is a single entry of the v2 format
+type targetGroupV2 struct {
+	Arn       string                `json:"arn,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Alias     string                `json:"alias,omitempty"`
+	Port      *intstr.IntOrString   `json:"port,omitempty"`
+	Readiness *Readiness            `json:"readiness,omitempty"`
+	Health    *Health               `json:"health,omitempty"`
+	Weight    *int32                `json:"weight,omitempty"`
+	Subset    *metav1.LabelSelector `json:"subset,omitempty"`
+}
+
+// knownFields are the fields of every object of the v2 format, by their path with list indexes left out
+var knownFields = map[string][]string{
+	"":                                     {"version", "targetGroups"},
+	"targetGroups":                         {"arn", "name", "alias", "port", "readiness", "health", "weight", "subset"},
+	"targetGroups.readiness":               {"waitForPodReady", "gate"},
+	"targetGroups.health":                  {"remediation", "after"},
+	"targetGroups.subset":                  {"matchLabels", "matchExpressions"},
+	"targetGroups.subset.matchExpressions": {"key", "operator", "values"},
+}
+
+// isV2 reports whether the annotation uses the v2 format. v1 is always a JSON array
+func isV2(value string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(value), "[")
+}
+
+// decodeV2 strictly decodes the v2 format, JSON or YAML, and checks every value that does not depend on the pod.
+// Problems are prefixed with their field path
+func decodeV2(value string) ([]TargetGroup, []string) {
+	raw, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return nil, []string{fmt.Sprintf("invalid YAML or JSON: %v", err)}
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if _, ok := generic.(map[string]interface{}); !ok {
+		return nil, []string{"must be a list of target groups (v1) or an object with version: v2"}
+	}
+
+	problems := unknownFields("", "", generic)
+	var decoded spec
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := decoder.Decode(&decoded); err != nil {
+		// unknown fields are reported above, this only reports values of the wrong type
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			problems = append(problems, fmt.Sprintf("%s: expected %s, got %s", typeErrorPath(raw, typeErr), typeErr.Type, typeErr.Value))
+		} else {
+			problems = append(problems, err.Error())
+		}
+		return nil, problems
+	}
+
+	if decoded.Version != Version2 {
+		problems = append(problems, fmt.Sprintf("version: must be %q, got %q", Version2, decoded.Version))
+	}
+	targetGroups := make([]TargetGroup, 0, len(decoded.TargetGroups))
+	for i, entry := range decoded.TargetGroups {
+		field := fmt.Sprintf("targetGroups[%d]", i)
+		targetGroup, entryProblems := entry.normalize(field)
+		problems = append(problems, entryProblems...)
+		targetGroups = append(targetGroups, targetGroup)
+	}
+	return targetGroups, problems
+}
+
+// normalize checks the entry and converts it to the format independent TargetGroup
+func (entry targetGroupV2) normalize(field string) (TargetGroup, []string) {
+	problems := make([]string, 0)
+	targetGroup := TargetGroup{
+		Arn:       entry.Arn,
+		Name:      entry.Name,
+		Alias:     entry.Alias,
+		Readiness: entry.Readiness,
+		Health:    entry.Health,
+		Weight:    entry.Weight,
+		Subset:    entry.Subset,
+		version:   Version2,
+	}
+
+	references := 0
+	for _, set := range []string{entry.Arn, entry.Name, entry.Alias} {
+		if set != "" {
+			references++
+		}
+	}
+	switch {
+	case references == 0:
+		problems = append(problems, fmt.Sprintf("%s: one of arn, name or alias is required", field))
+	case references > 1:
+		problems = append(problems, fmt.Sprintf("%s: only one of arn, name and alias may be set", field))
+	}
+	if entry.Arn != "" && !targetGroupArnPattern.MatchString(entry.Arn) {
+		problems = append(problems, fmt.Sprintf("%s.arn: %q is not a target group ARN", field, entry.Arn))
+	}
+	if entry.Name != "" && !targetGroupNamePattern.MatchString(entry.Name) {
+		problems = append(problems, fmt.Sprintf("%s.name: %q is not a target group name, which has up to 32 letters, digits and inner hyphens", field, entry.Name))
+	}
+	if entry.Alias != "" {
+		if errs := validation.IsConfigMapKey(entry.Alias); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s.alias: %q: %s", field, entry.Alias, strings.Join(errs, ", ")))
+		}
+	}
+
+	if entry.Port != nil {
+		if entry.Port.Type == intstr.Int {
+			if errs := validation.IsValidPortNum(entry.Port.IntValue()); len(errs) > 0 {
+				problems = append(problems, fmt.Sprintf("%s.port: %d: %s", field, entry.Port.IntValue(), strings.Join(errs, ", ")))
+			}
+			targetGroup.Port = int32(entry.Port.IntValue())
+		} else {
+			if errs := validation.IsValidPortName(entry.Port.StrVal); len(errs) > 0 {
+				problems = append(problems, fmt.Sprintf("%s.port: %q: %s", field, entry.Port.StrVal, strings.Join(errs, ", ")))
+			}
+			targetGroup.PortName = entry.Port.StrVal
+		}
+	}
+
+	if entry.Health != nil {
+		switch entry.Health.Remediation {
+		case "":
+			if entry.Health.After != nil {
+				problems = append(problems, fmt.Sprintf("%s.health.after: requires health.remediation", field))
+			}
+		case RemediationNone:
+		case RemediationDeregister, RemediationLabel, RemediationEvict:
+			if entry.Health.After == nil || entry.Health.After.Duration <= 0 {
+				problems = append(problems, fmt.Sprintf("%s.health.after: a duration above zero is required with health.remediation %s", field, entry.Health.Remediation))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s.health.remediation: %q is not one of %s, %s, %s or %s", field, entry.Health.Remediation,
+				RemediationDeregister, RemediationLabel, RemediationEvict, RemediationNone))
+		}
+	}
+	if entry.Weight != nil && (*entry.Weight < 0 || *entry.Weight > 100) {
+		problems = append(problems, fmt.Sprintf("%s.weight: %d is not between 0 and 100", field, *entry.Weight))
+	}
+	if entry.Subset != nil {
+		if _, err := metav1.LabelSelectorAsSelector(entry.Subset); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.subset: %v", field, err))
+		}
+	}
+	return targetGroup, problems
+}
+
+// unknownFields reports every object key the v2 format does not have, with its path
+func unknownFields(path string, schemaPath string, value interface{}) []string {
+	problems := make([]string, 0)
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		known, checked := knownFields[schemaPath]
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldPath := strings.TrimPrefix(path+"."+key, ".")
+			if checked && !contains(known, key) {
+				problems = append(problems, fmt.Sprintf("%s: unknown field, expected one of %s", fieldPath, strings.Join(known, ", ")))
+				continue
+			}
+			// the keys of matchLabels are label names, not fields
+			if schemaPath == "targetGroups.subset" && key == "matchLabels" {
+				continue
+			}
+			problems = append(problems, unknownFields(fieldPath, strings.TrimPrefix(schemaPath+"."+key, "."), typed[key])...)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			problems = append(problems, unknownFields(fmt.Sprintf("%s[%d]", path, i), schemaPath, item)...)
+		}
+	}
+	return problems
+}
+
+// typeErrorPath returns the path of the field a type error is about, with the index of its target group entry when it can be found
+func typeErrorPath(raw []byte, typeErr *json.UnmarshalTypeError) string {
+	if typeErr.Field == "" {
+		return "annotation"
+	}
+	if !strings.HasPrefix(typeErr.Field, "targetGroups.") {
+		return typeErr.Field
+	}
+	// decode entry by entry to find the one with the bad value
+	var entries struct {
+		TargetGroups []json.RawMessage `json:"targetGroups"`
+	}
+	if json.Unmarshal(raw, &entries) == nil {
+		for i, entry := range entries.TargetGroups {
+			var decoded targetGroupV2
+			if json.Unmarshal(entry, &decoded) != nil {
+				return fmt.Sprintf("targetGroups[%d].%s", i, strings.TrimPrefix(typeErr.Field, "targetGroups."))
+			}
+		}
+	}
+	return typeErr.Field
+}
+
+// EncodeV2 - return the v2 annotation value referencing the target groups by ARN, with their port when it is set
+func EncodeV2(targetGroups []TargetGroup) (string, error) {
+	encoded := spec{Version: Version2, TargetGroups: make([]targetGroupV2, 0, len(targetGroups))}
+	for _, targetGroup := range targetGroups {
+		entry := targetGroupV2{Arn: targetGroup.Arn, Name: targetGroup.Name, Alias: targetGroup.Alias}
+		if targetGroup.Port != 0 {
+			port := intstr.FromInt(int(targetGroup.Port))
+			entry.Port = &port
+		} else if targetGroup.PortName != "" {
+			port := intstr.FromString(targetGroup.PortName)
+			entry.Port = &port
+		}
+		encoded.TargetGroups = append(encoded.TargetGroups, entry)
+	}
+	raw, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+// Outcomes of an audited call
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Stdout is the audit-log destination writing to standard output
+const Stdout = "-"
+
+var writeErrorsTotal = metrics.NewCounter(
+	"nlb_attacher_audit_write_errors_total",
+	"Audit entries that could not be written to the audit sink.",
+)
+
+// Target is one target of an audited call and the pod it belongs to
+type Target struct {
+	ID     string    `json:"id"`
+	Port   int64     `json:"port,omitempty"`
+	Pod    string    `json:"pod,omitempty"`
+	PodUID types.UID `json:"podUID,omitempty"`
+}
+
+// Trigger is what made the controller call the api: a queued event, an admin action or a remediation
+type Trigger struct {
+	Event  string `json:"event"`
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Entry is one mutating api call
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	TargetGroup string    `json:"targetGroup"`
+	Targets     []Target  `json:"targets"`
+	Trigger     *Trigger  `json:"trigger,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	RequestID   string    `json:"requestId,omitempty"`
+}
+
+// Sink receives every audit entry. Implementations must be safe for concurrent use
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// JSONLinesSink writes every entry as one line of JSON
+type JSONLinesSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesSink - return a sink writing to w. Closing the sink does not close w
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{writer: w}
+}
+
+// Open - return a JSON lines sink appending to the file at path, or writing to stdout for "-"
+func Open(path string) (*JSONLinesSink, error) {
+	if path == Stdout {
+		return NewJSONLinesSink(os.Stdout), nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &JSONLinesSink{writer: file, closer: file}, nil
+}
+
+// Write - append the entry as a single line
+func (sink *JSONLinesSink) Write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	_, err = sink.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close - close the file the sink appends to, if it opened one
+func (sink *JSONLinesSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closer == nil {
+		return nil
+	}
+	return sink.closer.Close()
+}
+
+// Record - write the entry to the sink, counting failures. A nil sink records nothing
+func Record(sink Sink, entry Entry) {
+	if sink == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if err := sink.Write(entry); err != nil {
+		log.Errorf("Failed to write the audit entry of %s on %s: %v", entry.Action, entry.TargetGroup, err)
+		writeErrorsTotal.Inc()
+	}
+}
+
+type triggerKey struct{}
+
+// WithTrigger - return a context carrying what triggered the calls made with it
+func WithTrigger(ctx context.Context, trigger Trigger) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// TriggerFrom - return the trigger carried by the context, if any
+func TriggerFrom(ctx context.Context) *Trigger {
+	if trigger, ok := ctx.Value(triggerKey{}).(Trigger); ok {
+		return &trigger
+	}
+	return nil
+}
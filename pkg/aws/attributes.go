@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+const deregistrationDelayAttribute = "deregistration_delay.timeout_seconds"
+
+type cachedDelay struct {
+	seconds   int64
+	fetchedAt time.Time
+}
+
+// DeregistrationDelayLookup reads and caches the deregistration delay of target groups
+type DeregistrationDelayLookup struct {
+	client elbv2iface.ELBV2API
+	ttl    time.Duration
+
+	mutex  sync.Mutex
+	delays map[string]cachedDelay
+}
+
+// NewDeregistrationDelayLookup - return a lookup that caches attributes for the given duration
+func NewDeregistrationDelayLookup(ttl time.Duration) *DeregistrationDelayLookup {
+	return &DeregistrationDelayLookup{
+		client: withRateLimits(elbv2.New(session.New())),
+		ttl:    ttl,
+		delays: make(map[string]cachedDelay),
+	}
+}
+
+// SetClient - replace the ELBv2 client, e.g. with a fake
+func (lookup *DeregistrationDelayLookup) SetClient(client elbv2iface.ELBV2API) {
+	lookup.client = withRateLimits(client)
+}
+
+// DeregistrationDelay - return the deregistration delay of the target group, referenced by ARN or name, in seconds
+func (lookup *DeregistrationDelayLookup) DeregistrationDelay(ctx context.Context, tgRef string) (int64, error) {
+	lookup.mutex.Lock()
+	cached, ok := lookup.delays[tgRef]
+	lookup.mutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < lookup.ttl {
+		return cached.seconds, nil
+	}
+
+	tgArn := tgRef
+	if !strings.HasPrefix(tgRef, "arn:") {
+		described, err := lookup.client.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+			Names: []*string{aws.String(tgRef)},
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(described.TargetGroups) == 0 {
+			return 0, fmt.Errorf("target group %q does not exist", tgRef)
+		}
+		tgArn = aws.StringValue(described.TargetGroups[0].TargetGroupArn)
+	}
+
+	result, err := lookup.client.DescribeTargetGroupAttributesWithContext(ctx, &elbv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(tgArn),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, attribute := range result.Attributes {
+		if aws.StringValue(attribute.Key) != deregistrationDelayAttribute {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(aws.StringValue(attribute.Value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s on %s: %v", deregistrationDelayAttribute, tgArn, err)
+		}
+
+		lookup.mutex.Lock()
+		lookup.delays[tgRef] = cachedDelay{seconds: seconds, fetchedAt: time.Now()}
+		lookup.mutex.Unlock()
+		return seconds, nil
+	}
+	return 0, fmt.Errorf("target group %s has no %s attribute", tgArn, deregistrationDelayAttribute)
+}
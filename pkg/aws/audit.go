@@ -0,0 +1,58 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+)
+
+// SetAuditSink - set the sink every RegisterTargets and DeregisterTargets call is recorded in. A nil sink records nothing
+func (handler *Handler) SetAuditSink(sink audit.Sink) {
+	handler.audit = sink
+}
+
+// captureRequestID returns a request option storing the AWS request id of the call in id
+func captureRequestID(id *string) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			*id = r.RequestID
+		})
+	}
+}
+
+// recordMutation writes the audit entry of a mutating call on the pods' targets
+func (handler *Handler) recordMutation(ctx context.Context, action string, tgArn string, assignments []targetGroupPodAssignment, requestID string, err error) {
+	if handler.audit == nil {
+		return
+	}
+	targets := make([]audit.Target, 0, len(assignments))
+	for _, assignment := range assignments {
+		pod := assignment.pod
+		targets = append(targets, audit.Target{
+			ID:     pod.Status.PodIP,
+			Port:   handler.targetPort(assignment),
+			Pod:    pod.Namespace + "/" + pod.Name,
+			PodUID: pod.UID,
+		})
+	}
+
+	entry := audit.Entry{
+		Action:      action,
+		TargetGroup: tgArn,
+		Targets:     targets,
+		Trigger:     audit.TriggerFrom(ctx),
+		Outcome:     audit.OutcomeSuccess,
+		RequestID:   requestID,
+	}
+	if err != nil {
+		entry.Outcome = audit.OutcomeFailure
+		entry.Error = err.Error()
+		if failure, ok := err.(awserr.RequestFailure); ok && entry.RequestID == "" {
+			entry.RequestID = failure.RequestID()
+		}
+	}
+	audit.Record(handler.audit, entry)
+}
@@ -1,86 +1,349 @@
 package aws
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/access"
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/events"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
 )
 
-type targetGroupAnnotation struct {
-	Arn      string
-	PortName string
-}
-
-type targetGroupPodAssignment struct {
-	tgArn        string
-	podIPAddress string
-	pod          *v1.Pod
-}
-
 var ensureMutex sync.Mutex
 
-// Handler implements handlers.Handler interface
+// Handler implements the handlers.Handler and handlers.TargetGroupAdmin interfaces
 type Handler struct {
-	client                   *elbv2.ELBV2
-	targetGroups             *[]*elbv2.TargetGroup
+	client                   elbv2iface.ELBV2API
+	catalog                  *targetGroupCatalog
+	stop                     <-chan struct{}
 	targetGroupAnnotationKey string
 	annotationEnableValue    string
+
+	pausedMutex        sync.RWMutex
+	pausedTargetGroups map[string]bool
+	// deferredTargets are the targets whose deregistration waits for their paused target group to resume, by target group and ip:port
+	deferredTargets map[string]map[string]targetGroupPodAssignment
+
+	conditionWriter *readiness.Writer
+	config          *config.Store
+	namespaces      corelisters.NamespaceLister
+	pods            corelisters.PodLister
+	nodes           corelisters.NodeLister
+	recorder        *events.Recorder
+	clientset       kubernetes.Interface
+	state           *state.Store
+	aliases         *alias.Store
+	policy          *policy.Store
+	access          *access.Reviewer
+	detached        func(pod *v1.Pod) bool
+	audit           audit.Sink
+	health          healthWatcher
+	statuses        statusTracker
+	remediations    remediator
+	// remediationMutex keeps a remediation from reserving a pod while a registration of it is in flight
+	remediationMutex sync.RWMutex
 }
 
 // Init - initialize the aws nlb modifier
 func (handler *Handler) Init(tgAnnotation string, annotationEnabledValue string) error {
-	elbClient := elbv2.New(session.New())
+	if handler.client == nil {
+		handler.client = elbv2.New(session.New())
+	}
+	handler.client = withRateLimits(handler.client)
 
+	if tgAnnotation == "" {
+		return fmt.Errorf("the target group annotation key must not be empty")
+	}
 	handler.targetGroupAnnotationKey = tgAnnotation
 	handler.annotationEnableValue = annotationEnabledValue
-	handler.client = elbClient
-	defaultTgSlice := make([]*elbv2.TargetGroup, 0)
-	handler.targetGroups = &defaultTgSlice
+	handler.pausedTargetGroups = make(map[string]bool)
+	handler.deferredTargets = make(map[string]map[string]targetGroupPodAssignment)
 
-	//TODO: start goroutine to continually update the list of valid load balancers in the background
-	//TODO: validate settings and return error
+	//the catalog lets registrations fail fast on target groups that cannot take pod ips
+	handler.catalog = newTargetGroupCatalog(handler.client)
+	if err := handler.catalog.refresh(aws.BackgroundContext()); err != nil {
+		log.Warnf("Starting without a target group catalog, target groups are described on first use: %v", err)
+	}
+	refreshPeriod := 5 * time.Minute
+	if handler.config != nil {
+		refreshPeriod = handler.config.Get().GetCatalogRefreshPeriod()
+	}
+	go handler.catalog.run(refreshPeriod, handler.stop)
+
+	if handler.config != nil && handler.config.Get().GetHealthCheckPeriod() > 0 {
+		go handler.watchTargetHealth(handler.config.Get().GetHealthCheckPeriod(), handler.stop)
+	}
 	return nil
 }
 
+// SetClient - set the ELBv2 client, e.g. to a fake. Init creates a client from the default session when none is set
+func (handler *Handler) SetClient(client elbv2iface.ELBV2API) {
+	handler.client = client
+}
+
+// SetStopChannel - set the channel that stops the background target group catalog refresh
+func (handler *Handler) SetStopChannel(stop <-chan struct{}) {
+	handler.stop = stop
+}
+
+// SetConditionWriter - set the writer used to mark pods ready once they are in all of their target groups
+func (handler *Handler) SetConditionWriter(writer *readiness.Writer) {
+	handler.conditionWriter = writer
+}
+
+// SetConfig - set the configuration store used for the target group allowlist
+func (handler *Handler) SetConfig(store *config.Store) {
+	handler.config = store
+}
+
 // PodCreated - Handle the creation event of a pod and ensure it's attached to all of the specified target groups
-func (handler *Handler) PodCreated(created *v1.Pod) {
+func (handler *Handler) PodCreated(ctx context.Context, created *v1.Pod) (err error) {
+	ctx, span := startPodSpan(ctx, "PodCreated", created)
+	defer func() { endSpan(span, err) }()
+
 	if created.DeletionTimestamp != nil {
 		log.Infof("Discovered pod %s with deletionTimestamp already set. skiping...", created.Name)
-		return
+		return nil
 	}
 
 	if created.Status.PodIP == "" {
 		log.Infof("Recieved event for pod %s without an ip address yet. skipping...", created.Name)
-		return
+		return nil
 	}
 
 	log.Debugf("created object: %v", created.Name)
-	handler.addToTargetGroups(created)
+	return handler.addToTargetGroups(ctx, created)
 }
 
 // PodDeleted - Handle the deletion event of a pod and ensure it has been removed from all associated target groups
-func (handler *Handler) PodDeleted(deleted *v1.Pod) {
+func (handler *Handler) PodDeleted(ctx context.Context, deleted *v1.Pod) (err error) {
+	ctx, span := startPodSpan(ctx, "PodDeleted", deleted)
+	defer func() { endSpan(span, err) }()
+
 	log.Debugf("delete pod: %v", deleted.Name)
-	handler.removeFromTargetGroups(deleted)
+	err = handler.removeFromTargetGroups(ctx, deleted)
+	handler.statuses.forgetPod(deleted.UID)
+	return err
 }
 
-// PodUpdated - Handle pod update
-func (handler *Handler) PodUpdated(oldPod, newPod *v1.Pod) {
+// PodUpdated - Handle pod update. The pod is removed from target groups that oldPod had and newPod no longer has
+func (handler *Handler) PodUpdated(ctx context.Context, oldPod, newPod *v1.Pod) (err error) {
+	ctx, span := startPodSpan(ctx, "PodUpdated", newPod)
+	defer func() { endSpan(span, err) }()
+
 	log.Debugf("manage update: %v", newPod.Name)
 	if newPod.DeletionTimestamp != nil {
 		log.Infof("Pod %s is marked for deletion, removing from target group", newPod.Name)
-		handler.removeFromTargetGroups(newPod)
-	} else {
-		log.Infof("Ensuring pod %s is properly attached to the target group", newPod.Name)
-		handler.addToTargetGroups(newPod)
+		if err := handler.removeFromTargetGroups(ctx, newPod); err != nil {
+			return err
+		}
+		// the controller does not call PodDeleted for pods removed while terminating
+		handler.statuses.forgetPod(newPod.UID)
+		return nil
+	}
+
+	errs := make([]error, 0)
+	for _, stale := range handler.staleTargetGroups(oldPod, newPod) {
+		log.Infof("Pod %s no longer references target group %s on port %d, removing it", newPod.Name, stale.tgArn, handler.targetPort(stale))
+		errs = append(errs, handler.deregisterTargets(ctx, stale))
+	}
+
+	log.Infof("Ensuring pod %s is properly attached to the target group", newPod.Name)
+	errs = append(errs, handler.addToTargetGroups(ctx, newPod))
+	return handlers.Combine(errs...)
+}
+
+// staleTargetGroups returns the assignments of oldPod that newPod does not have, or has on another port
+func (handler *Handler) staleTargetGroups(oldPod, newPod *v1.Pod) []targetGroupPodAssignment {
+	if oldPod == nil || oldPod == newPod || oldPod.Status.PodIP == "" {
+		return nil
+	}
+	oldAssignments, err := handler.getPodTargetGroupAssignments(oldPod)
+	if err != nil {
+		return nil
+	}
+	newAssignments, err := handler.getPodTargetGroupAssignments(newPod)
+	if err != nil || len(resolved(newAssignments)) < len(newAssignments) {
+		// an invalid annotation or a reference that does not resolve is not a request to leave every target group
+		return nil
+	}
+
+	current := make(map[string]bool)
+	for _, assignment := range newAssignments {
+		current[fmt.Sprintf("%s:%d", assignment.tgArn, handler.targetPort(assignment))] = true
+	}
+	stale := make([]targetGroupPodAssignment, 0)
+	for _, assignment := range resolved(oldAssignments) {
+		if !current[fmt.Sprintf("%s:%d", assignment.tgArn, handler.targetPort(assignment))] {
+			stale = append(stale, assignment)
+		}
+	}
+	return stale
+}
+
+// PodDetached - Remove a pod from all of its target groups without it being deleted
+func (handler *Handler) PodDetached(ctx context.Context, detached *v1.Pod) (err error) {
+	ctx, span := startPodSpan(ctx, "PodDetached", detached)
+	defer func() { endSpan(span, err) }()
+
+	log.Infof("Detaching pod %s from its target groups", detached.Name)
+	err = handler.removeFromTargetGroups(ctx, detached)
+	if assignments, parseErr := handler.getPodTargetGroupAssignments(detached); parseErr == nil {
+		for _, assignment := range assignments {
+			if err == nil {
+				handler.setTargetState(detached, assignment.key(), annotation.StateDetached, nil)
+			} else {
+				handler.setTargetState(detached, assignment.key(), "", err)
+			}
+		}
+		handler.writeStatus(detached)
+	}
+	return err
+}
+
+// ReconcileTargetGroup - ensure every pod that references the target group is attached to it, and that the targets
+// of pods that no longer want it are not
+func (handler *Handler) ReconcileTargetGroup(ctx context.Context, tgArn string, pods []*v1.Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "aws.ReconcileTargetGroup", tracing.KindInternal,
+		tracing.String("elbv2.target_group", tgArn),
+		tracing.Int("k8s.pods", int64(len(pods))),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if handler.isPaused(tgArn) {
+		log.Infof("Target group %s is paused, skipping reconcile", tgArn)
+		return nil
+	}
+	if !handler.targetGroupAllowed(tgArn) {
+		log.Warnf("Target group %s is not in the allowlist, skipping reconcile", tgArn)
+		return nil
+	}
+
+	if err := handler.checkTargetGroup(ctx, tgArn); err != nil {
+		return err
 	}
+
+	tgAssignments := make([]targetGroupPodAssignment, 0)
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		if reason, _ := handler.nodeDraining(pod); reason != "" {
+			continue
+		}
+		assignments, err := handler.getPodTargetGroupAssignments(pod)
+		if err != nil {
+			log.Errorf("Ignoring target groups of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		if assignment, ok := assignmentFor(selected(assignments), tgArn); ok {
+			if allowed, _, _ := handler.permitted(ctx, pod, tgArn); !allowed {
+				continue
+			}
+			tgAssignments = append(tgAssignments, assignment)
+		}
+	}
+
+	return handler.ensurePodsAreAttached(ctx, map[string][]targetGroupPodAssignment{tgArn: tgAssignments})
+}
+
+// PauseTargetGroup - stop all registrations and deregistrations against a target group
+func (handler *Handler) PauseTargetGroup(tgArn string) {
+	handler.pausedMutex.Lock()
+	defer handler.pausedMutex.Unlock()
+	handler.pausedTargetGroups[tgArn] = true
+	log.Warnf("Paused all mutations to target group %s", tgArn)
+}
+
+// ResumeTargetGroup - allow mutations to a previously paused target group. The deregistrations deferred while it was paused
+// are made by the next reconcile of the target group
+func (handler *Handler) ResumeTargetGroup(tgArn string) {
+	handler.pausedMutex.Lock()
+	defer handler.pausedMutex.Unlock()
+	delete(handler.pausedTargetGroups, tgArn)
+	log.Warnf("Resumed mutations to target group %s, %d deferred deregistrations wait for its reconcile", tgArn, len(handler.deferredTargets[tgArn]))
+}
+
+// deferDeregistration records a deregistration from a paused target group, for the reconcile after its resume
+func (handler *Handler) deferDeregistration(assignment targetGroupPodAssignment) {
+	handler.pausedMutex.Lock()
+	defer handler.pausedMutex.Unlock()
+	if handler.deferredTargets == nil {
+		handler.deferredTargets = make(map[string]map[string]targetGroupPodAssignment)
+	}
+	if handler.deferredTargets[assignment.tgArn] == nil {
+		handler.deferredTargets[assignment.tgArn] = make(map[string]targetGroupPodAssignment)
+	}
+	handler.deferredTargets[assignment.tgArn][handler.targetKey(assignment)] = assignment
+}
+
+// deferredDeregistrations returns a copy of the deregistrations deferred while the target group was paused
+func (handler *Handler) deferredDeregistrations(tgArn string) map[string]targetGroupPodAssignment {
+	handler.pausedMutex.RLock()
+	defer handler.pausedMutex.RUnlock()
+	deferred := make(map[string]targetGroupPodAssignment, len(handler.deferredTargets[tgArn]))
+	for key, assignment := range handler.deferredTargets[tgArn] {
+		deferred[key] = assignment
+	}
+	return deferred
+}
+
+// clearDeferred drops a deferred deregistration once it was made or is no longer needed
+func (handler *Handler) clearDeferred(tgArn string, key string) {
+	handler.pausedMutex.Lock()
+	defer handler.pausedMutex.Unlock()
+	delete(handler.deferredTargets[tgArn], key)
+	if len(handler.deferredTargets[tgArn]) == 0 {
+		delete(handler.deferredTargets, tgArn)
+	}
+}
+
+// targetKey returns the ip:port of the assignment's target, with port 0 when the target group's port is not known
+func (handler *Handler) targetKey(assignment targetGroupPodAssignment) string {
+	return targetID(assignment.pod.Status.PodIP, handler.targetPort(assignment))
+}
+
+// checkTargetGroup returns a terminal error when the target group cannot take pod ips
+func (handler *Handler) checkTargetGroup(ctx context.Context, tgArn string) error {
+	if handler.catalog == nil {
+		return nil
+	}
+	vpcID := ""
+	if handler.config != nil {
+		vpcID = handler.config.Get().GetVPCID()
+	}
+	return handler.catalog.check(ctx, tgArn, vpcID)
+}
+
+func (handler *Handler) targetGroupAllowed(tgArn string) bool {
+	return handler.config == nil || handler.config.Get().TargetGroupAllowed(tgArn)
+}
+
+func (handler *Handler) isPaused(tgArn string) bool {
+	handler.pausedMutex.RLock()
+	defer handler.pausedMutex.RUnlock()
+	return handler.pausedTargetGroups[tgArn]
 }
 
 // TestHandler tests the configurarion by printing dummy lines.
@@ -88,234 +351,433 @@ func (handler *Handler) TestHandler() {
 	log.Debug("testing")
 }
 
-func (handler *Handler) getPodTargetGroupAssignments(pod *v1.Pod) []targetGroupPodAssignment {
-	tgAnnotations := make([]targetGroupAnnotation, 0)
-	for annotation, value := range pod.GetAnnotations() {
-		if annotation == handler.targetGroupAnnotationKey {
-			tgAnnotations = serializeAnnotation(value)
+// getPodTargetGroupAssignments returns the pod's target group entries, with their references resolved and the
+// entries the pod is not selected for marked. Only a malformed annotation is an error
+func (handler *Handler) getPodTargetGroupAssignments(pod *v1.Pod) ([]targetGroupPodAssignment, error) {
+	tgAnnotations := make([]annotation.TargetGroup, 0)
+	if value, ok := pod.GetAnnotations()[handler.targetGroupAnnotationKey]; ok {
+		parsed, err := annotation.Parse(value)
+		if err != nil {
+			return nil, err
 		}
+		tgAnnotations = parsed
 	}
 
 	assignments := make([]targetGroupPodAssignment, 0)
-	for _, annotation := range tgAnnotations {
-		assignments = append(assignments, targetGroupPodAssignment{
-			tgArn:        annotation.Arn,
+	for _, tgAnnotation := range tgAnnotations {
+		assignment := targetGroupPodAssignment{
 			podIPAddress: pod.Status.PodIP,
 			pod:          pod,
-		})
+			port:         tgAnnotation.TargetPort(pod),
+			options:      tgAnnotation,
+		}
+		if tgAnnotation.Arn == "" {
+			assignment.ref = tgAnnotation.Ref()
+		}
+		assignment.tgArn, assignment.err = handler.resolveTargetGroup(aws.BackgroundContext(), tgAnnotation)
+		if assignment.err == nil {
+			assignment.selected, assignment.reason = tgAnnotation.Selects(pod, assignment.tgArn)
+		}
+		assignments = append(assignments, assignment)
 	}
-	return assignments
+	return assignments, nil
 }
 
-func serializeAnnotation(value string) []targetGroupAnnotation {
-	var targetGroups []targetGroupAnnotation
-	err := json.Unmarshal([]byte(value), &targetGroups)
+func (handler *Handler) addToTargetGroups(ctx context.Context, pod *v1.Pod) error {
+	podTargetGroupAssignments, err := handler.getPodTargetGroupAssignments(pod)
 	if err != nil {
-		log.Fatalf("Failed to serialize annotations: %s", err)
+		handler.setRegisteredCondition(pod, v1.ConditionFalse, "InvalidAnnotation", err.Error())
+		return handlers.NewTerminal(fmt.Errorf("invalid target groups on pod %s/%s: %v", pod.Namespace, pod.Name, err))
 	}
 
-	return targetGroups
+	registered := true
+	skipped, skippedFrom := "", ""
+	heldOutFrom := ""
+	errs := []error{handler.leaveRetargeted(ctx, pod, podTargetGroupAssignments)}
+	for _, assignment := range podTargetGroupAssignments {
+		handler.setTargetRef(pod, assignment)
+		if assignment.err != nil {
+			log.Errorf("Not registering pod %s/%s: %v", pod.Namespace, pod.Name, assignment.err)
+			handler.setTargetState(pod, assignment.key(), annotation.StateFailed, assignment.err)
+			errs = append(errs, assignment.err)
+			registered = registered && !assignment.options.Gated()
+			continue
+		}
+		if !handler.targetGroupAllowed(assignment.tgArn) {
+			log.Warnf("Target group %s of pod %s/%s is not in the allowlist, skipping", assignment.tgArn, pod.Namespace, pod.Name)
+			handler.setTargetState(pod, assignment.tgArn, annotation.StateNotAllowed, nil)
+			registered = registered && !assignment.options.Gated()
+			continue
+		}
+		allowed, reason, err := handler.permitted(ctx, pod, assignment.tgArn)
+		if err != nil || !allowed {
+			if err != nil {
+				log.Errorf("Not registering pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				handler.setTargetState(pod, assignment.tgArn, "", err)
+			} else {
+				err = handler.leaveDenied(ctx, assignment, reason)
+			}
+			errs = append(errs, err)
+			registered = registered && !assignment.options.Gated()
+			continue
+		}
+		if !assignment.selected {
+			errs = append(errs, handler.leaveUnselected(ctx, assignment))
+			continue
+		}
+		if reason, description := handler.nodeDraining(pod); reason != "" {
+			errs = append(errs, handler.leaveDrainingNode(ctx, assignment, reason, description))
+			continue
+		}
+		if err := handler.checkTargetGroup(ctx, assignment.tgArn); err != nil {
+			log.Errorf("Not registering pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			handler.setTargetState(pod, assignment.tgArn, annotation.StateFailed, err)
+			errs = append(errs, err)
+			registered = registered && !assignment.options.Gated()
+			continue
+		}
+		attached, err := handler.registerTargets(ctx, []targetGroupPodAssignment{assignment}, assignment.tgArn)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !attached && assignment.options.Gated() {
+			// targets left out on purpose do not hold back the readiness gate, the condition reason names why.
+			// A held out pod receives no traffic, so it is not reported ready
+			state := handler.statuses.state(pod, assignment.tgArn)
+			if state == annotation.StateHeldOut && err == nil {
+				registered = false
+				if heldOutFrom == "" {
+					heldOutFrom = assignment.tgArn
+				}
+			} else if reason, ok := skippedConditionReasons[state]; ok && err == nil {
+				if skipped == "" {
+					skipped, skippedFrom = reason, assignment.tgArn
+				}
+			} else {
+				registered = false
+			}
+		}
+	}
+
+	handler.writeStatus(pod)
+
+	err = handlers.Combine(errs...)
+	if err != nil && handlers.Classify(err) == handlers.Terminal {
+		handler.setRegisteredCondition(pod, v1.ConditionFalse, "RegistrationFailed", err.Error())
+	} else if heldOutFrom != "" {
+		message := fmt.Sprintf("pod was deregistered from target group %s for staying unhealthy", heldOutFrom)
+		handler.setRegisteredCondition(pod, v1.ConditionFalse, "HeldOut", message)
+	} else if registered && skipped != "" {
+		message := fmt.Sprintf("pod is registered with all of its target groups except %s (%s)", skippedFrom, handler.statuses.state(pod, skippedFrom))
+		handler.setRegisteredCondition(pod, v1.ConditionTrue, skipped, message)
+	} else if registered {
+		handler.setRegisteredCondition(pod, v1.ConditionTrue, "Registered", "pod is registered with all of its target groups")
+	}
+	return err
 }
 
-func (handler *Handler) addToTargetGroups(pod *v1.Pod) {
-	podTargetGroupAssignments := handler.getPodTargetGroupAssignments(pod)
+// leaveRetargeted deregisters the pod from target groups that its name or alias references resolved to before and no longer do,
+// e.g. after an alias was pointed at another target group
+func (handler *Handler) leaveRetargeted(ctx context.Context, pod *v1.Pod, assignments []targetGroupPodAssignment) error {
+	errs := make([]error, 0)
+	for _, target := range handler.statuses.retargeted(pod, assignments) {
+		log.Infof("%s of pod %s/%s no longer resolves to target group %s, removing it", target.Ref, pod.Namespace, pod.Name, target.Arn)
+		stale := targetGroupPodAssignment{tgArn: target.Arn, podIPAddress: pod.Status.PodIP, pod: pod, port: target.Port}
+		if err := handler.deregisterTargets(ctx, stale); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		handler.statuses.remove(pod, target.Arn)
+	}
+	return handlers.Combine(errs...)
+}
 
-	for _, assignment := range podTargetGroupAssignments {
-		ips := []string{assignment.podIPAddress}
-		handler.registerTargets(ips, assignment.tgArn)
+// leaveUnselected deregisters the pod from a target group its entry no longer selects it for. Only the first time
+// the entry is seen unselected calls ELBv2, later events find the not-selected state and leave it
+func (handler *Handler) leaveUnselected(ctx context.Context, assignment targetGroupPodAssignment) error {
+	pod := assignment.pod
+	if handler.statuses.state(pod, assignment.tgArn) == annotation.StateNotSelected {
+		return nil
 	}
+	log.Infof("Pod %s/%s is not selected for target group %s: %s", pod.Namespace, pod.Name, assignment.tgArn, assignment.reason)
+	if err := handler.deregisterTargets(ctx, assignment); err != nil {
+		handler.setTargetState(pod, assignment.tgArn, "", err)
+		return err
+	}
+	handler.setTargetState(pod, assignment.tgArn, annotation.StateNotSelected, nil)
+	return nil
 }
 
-func (handler *Handler) removeFromTargetGroups(pod *v1.Pod) {
-	podTargetGroupAssignments := handler.getPodTargetGroupAssignments(pod)
+// skippedConditionReasons are the registered condition reasons of the target states a pod is left out in on purpose
+var skippedConditionReasons = map[string]string{
+	annotation.StateDryRun: "DryRun",
+	annotation.StatePaused: "TargetGroupPaused",
+}
 
+// setRegisteredCondition updates the readiness gate of pods that declare it
+func (handler *Handler) setRegisteredCondition(pod *v1.Pod, status v1.ConditionStatus, reason string, message string) {
+	if handler.conditionWriter == nil || !readiness.HasGate(pod, readiness.ConditionType) {
+		return
+	}
+	if err := handler.conditionWriter.SetCondition(pod, readiness.ConditionType, status, reason, message); err != nil {
+		log.Error(err)
+	}
+}
+
+func (handler *Handler) removeFromTargetGroups(ctx context.Context, pod *v1.Pod) error {
+	podTargetGroupAssignments, err := handler.getPodTargetGroupAssignments(pod)
+	if err != nil {
+		return handlers.NewTerminal(fmt.Errorf("invalid target groups on pod %s/%s: %v", pod.Namespace, pod.Name, err))
+	}
+
+	errs := []error{handler.leaveRetargeted(ctx, pod, podTargetGroupAssignments)}
 	for _, assignment := range podTargetGroupAssignments {
-		handler.deregisterTargets(assignment.podIPAddress, assignment.tgArn)
+		if assignment.err != nil {
+			log.Warnf("Not removing pod %s/%s from %s: %v", pod.Namespace, pod.Name, assignment.ref, assignment.err)
+			continue
+		}
+		errs = append(errs, handler.deregisterTargets(ctx, assignment))
 	}
+	return handlers.Combine(errs...)
 }
 
-func (handler *Handler) deregisterTargets(ip string, tgArn string) {
+func (handler *Handler) deregisterTargets(ctx context.Context, assignment targetGroupPodAssignment) error {
+	pod, tgArn := assignment.pod, assignment.tgArn
+	ip := pod.Status.PodIP
+	if handler.isPaused(tgArn) {
+		// the reconcile after the resume deregisters the target if no pod wants it by then
+		handler.deferDeregistration(assignment)
+		log.Infof("Target group %s is paused, the deregistration of %s is deferred until it is resumed", tgArn, ip)
+		return nil
+	}
+
+	if handler.dryRun(pod) {
+		handler.reportDryRun([]*v1.Pod{pod}, "DeregisterTargets", tgArn)
+		return nil
+	}
+
 	input := &elbv2.DeregisterTargetsInput{
 		TargetGroupArn: aws.String(tgArn),
 		Targets: []*elbv2.TargetDescription{
-			{
-				Id: aws.String(ip),
-			},
+			targetDescription(assignment),
 		},
 	}
 
-	result, err := handler.client.DeregisterTargets(input)
+	requestID := ""
+	result, err := handler.client.DeregisterTargetsWithContext(ctx, input, captureRequestID(&requestID))
+	handler.recordMutation(ctx, "DeregisterTargets", tgArn, []targetGroupPodAssignment{assignment}, requestID, err)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elbv2.ErrCodeTargetGroupNotFoundException:
-				log.Error(elbv2.ErrCodeTargetGroupNotFoundException, aerr.Error())
-			case elbv2.ErrCodeInvalidTargetException:
-				log.Error(elbv2.ErrCodeInvalidTargetException, aerr.Error())
-			default:
-				log.Error(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Error(err.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeInvalidTargetException {
+			log.Infof("%s is not registered with target group %s, nothing to detach", ip, tgArn)
+			handler.state.Deregistered(tgArn, pod.UID)
+			return nil
 		}
-		return
+		return classifyError("DeregisterTargets", tgArn, err)
 	}
 	log.Infof("Successfully detached: %v from target group %s", ip, tgArn)
+	handler.state.Deregistered(tgArn, pod.UID)
+	handler.clearDeferred(tgArn, handler.targetKey(assignment))
 
 	log.Info(result)
+	return nil
 }
 
-func (handler *Handler) registerTargets(ips []string, tgArn string) {
+// registerTargets attaches the pods to the target group and reports whether they were all attached
+func (handler *Handler) registerTargets(ctx context.Context, assignments []targetGroupPodAssignment, tgArn string) (bool, error) {
+	if handler.isPaused(tgArn) {
+		log.Infof("Target group %s is paused, not attaching %d pods", tgArn, len(assignments))
+		for _, assignment := range assignments {
+			handler.setTargetState(assignment.pod, tgArn, annotation.StatePaused, nil)
+		}
+		return false, nil
+	}
+
+	handler.remediationMutex.RLock()
+	defer handler.remediationMutex.RUnlock()
+
+	live := make([]targetGroupPodAssignment, 0, len(assignments))
+	dryRunPods := make([]*v1.Pod, 0)
+	heldOut := 0
+	for _, assignment := range assignments {
+		pod := assignment.pod
+		if handler.holdsOut(pod, tgArn) {
+			log.Infof("Pod %s/%s was deregistered from %s for staying unhealthy, not attaching it", pod.Namespace, pod.Name, tgArn)
+			handler.setTargetState(pod, tgArn, annotation.StateHeldOut, nil)
+			heldOut++
+			continue
+		}
+		if handler.dryRun(pod) {
+			handler.setTargetState(pod, tgArn, annotation.StateDryRun, nil)
+			dryRunPods = append(dryRunPods, pod)
+		} else {
+			live = append(live, assignment)
+		}
+	}
+	if len(dryRunPods) > 0 {
+		handler.reportDryRun(dryRunPods, "RegisterTargets", tgArn)
+	}
+	if len(live) == 0 {
+		return false, nil
+	}
+
+	ips := make([]string, 0, len(live))
 	input := &elbv2.RegisterTargetsInput{
 		TargetGroupArn: aws.String(tgArn),
 		Targets:        make([]*elbv2.TargetDescription, 0),
 	}
 
-	for _, ip := range ips {
-		local := ip
-		input.Targets = append(input.Targets, &elbv2.TargetDescription{Id: &local})
-		log.Debugf("Attempting to attach: %s", ip)
+	for _, assignment := range live {
+		ips = append(ips, assignment.pod.Status.PodIP)
+		input.Targets = append(input.Targets, targetDescription(assignment))
+		log.Debugf("Attempting to attach: %s", assignment.pod.Status.PodIP)
 	}
 
-	result, err := handler.client.RegisterTargets(input)
+	requestID := ""
+	result, err := handler.client.RegisterTargetsWithContext(ctx, input, captureRequestID(&requestID))
+	handler.recordMutation(ctx, "RegisterTargets", tgArn, live, requestID, err)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elbv2.ErrCodeTargetGroupNotFoundException:
-				log.Error(elbv2.ErrCodeTargetGroupNotFoundException, aerr.Error())
-			case elbv2.ErrCodeTooManyTargetsException:
-				log.Error(elbv2.ErrCodeTooManyTargetsException, aerr.Error())
-			case elbv2.ErrCodeInvalidTargetException:
-				log.Error(elbv2.ErrCodeInvalidTargetException, aerr.Error())
-			case elbv2.ErrCodeTooManyRegistrationsForTargetIdException:
-				log.Error(elbv2.ErrCodeTooManyRegistrationsForTargetIdException, aerr.Error())
-			default:
-				log.Error(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Error(err.Error())
+		err = classifyError("RegisterTargets", tgArn, err)
+		for _, assignment := range live {
+			handler.setTargetState(assignment.pod, tgArn, annotation.StateFailed, err)
 		}
-		return
+		return false, err
 	}
 	log.Infof("Successfully attached: %v to target group %s", ips, tgArn)
+	handler.recordRegistered(tgArn, live)
+	for _, assignment := range live {
+		handler.setTargetState(assignment.pod, tgArn, annotation.StateRegistered, nil)
+	}
 
 	log.Debug(result)
+	return len(dryRunPods) == 0 && heldOut == 0, nil
 }
 
-func getAllNetworkLoadbalancers(client *elbv2.ELBV2) *[]*elbv2.LoadBalancer {
+// ensurePodsAreAttached - ensure that the following target groups have the correct IP addresses attached
+func (handler *Handler) ensurePodsAreAttached(ctx context.Context, tgPodMap map[string][]targetGroupPodAssignment) error {
 	//todo: make this paginate and assemble all load balancers
 
-	input := &elbv2.DescribeLoadBalancersInput{}
+	ensureMutex.Lock()
+	defer ensureMutex.Unlock()
 
-	result, err := client.DescribeLoadBalancers(input)
+	errs := make([]error, 0)
+	for tgArn, assignments := range tgPodMap {
+		log.Debugf("EnsurePodsAreAttached: key - %s , value - %d pods", tgArn, len(assignments))
+		input := &elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(tgArn),
+		}
 
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elbv2.ErrCodeLoadBalancerNotFoundException:
-				log.Error(elbv2.ErrCodeLoadBalancerNotFoundException, aerr.Error())
-			default:
-				log.Error(aerr.Error())
+		result, err := handler.client.DescribeTargetHealthWithContext(ctx, input)
+		if err != nil {
+			errs = append(errs, classifyError("DescribeTargetHealth", tgArn, err))
+			continue
+		}
+		log.Debug(result)
+
+		podsToRegister := make([]targetGroupPodAssignment, 0)
+		registeredIps := make([]string, 0)
+		registeredTargets := make([]string, 0)
+		for _, target := range result.TargetHealthDescriptions {
+			registeredIps = append(registeredIps, *target.Target.Id)
+			registeredTargets = append(registeredTargets, fmt.Sprintf("%s:%d", *target.Target.Id, aws.Int64Value(target.Target.Port)))
+		}
+		for _, assignment := range assignments {
+			pod := assignment.pod
+			// without an explicit port any port of the pod ip counts, as the target group's port is all it can be registered on
+			attached := contains(registeredIps, pod.Status.PodIP)
+			if assignment.port != 0 {
+				attached = contains(registeredTargets, fmt.Sprintf("%s:%d", pod.Status.PodIP, assignment.port))
+			}
+			if !attached {
+				podsToRegister = append(podsToRegister, assignment)
+			} else if !handler.dryRun(pod) {
+				handler.recordRegistered(tgArn, []targetGroupPodAssignment{assignment})
+				handler.setTargetState(pod, tgArn, annotation.StateRegistered, nil)
 			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Error(err.Error())
 		}
 
-		panic("Failed to describe load balancers")
+		if len(podsToRegister) > 0 {
+			_, err = handler.registerTargets(ctx, podsToRegister, tgArn)
+		}
+		for _, assignment := range assignments {
+			handler.writeStatus(assignment.pod)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := handler.removeStaleTargets(ctx, tgArn, assignments, result.TargetHealthDescriptions); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		handler.state.Reconciled(tgArn)
 	}
-
-	return &result.LoadBalancers
+	return handlers.Combine(errs...)
 }
 
-func getTargetGroups(client *elbv2.ELBV2, groupArns []*string) *[]*elbv2.TargetGroup {
-	//todo: make this paginate and assemble all load balancers
-
-	//for _, arn := range groupArns {
-	//	log.Debug(*arn)
-	//}
-
-	if len(groupArns) == 0 {
-		local := make([]*elbv2.TargetGroup, 0)
-		return &local
+// removeStaleTargets deregisters the registered targets of the target group that no assignment wants, of those the attacher owns:
+// the deregistrations deferred while the target group was paused and the targets in the persisted state. Targets the attacher
+// never registered are left alone
+func (handler *Handler) removeStaleTargets(ctx context.Context, tgArn string, assignments []targetGroupPodAssignment, registered []*elbv2.TargetHealthDescription) error {
+	wantedIPs := make(map[string]bool)
+	wantedTargets := make(map[string]bool)
+	for _, assignment := range assignments {
+		// without an explicit port any port of the pod ip is wanted, the same way ensurePodsAreAttached counts it as attached
+		if assignment.port == 0 {
+			wantedIPs[assignment.pod.Status.PodIP] = true
+		} else {
+			wantedTargets[fmt.Sprintf("%s:%d", assignment.pod.Status.PodIP, assignment.port)] = true
+		}
 	}
 
-	input := &elbv2.DescribeTargetGroupsInput{
-		TargetGroupArns: groupArns,
+	deferred := handler.deferredDeregistrations(tgArn)
+	owned := make(map[string]targetGroupPodAssignment)
+	for key, assignment := range deferred {
+		owned[key] = assignment
 	}
-
-	result, err := client.DescribeTargetGroups(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elbv2.ErrCodeLoadBalancerNotFoundException:
-				log.Error(elbv2.ErrCodeLoadBalancerNotFoundException, aerr.Error())
-			case elbv2.ErrCodeTargetGroupNotFoundException:
-				log.Error(elbv2.ErrCodeTargetGroupNotFoundException, aerr.Error())
-			default:
-				log.Error(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Error(err.Error())
+	for _, owner := range handler.state.Owners() {
+		if !contains(owner.TargetGroups, tgArn) {
+			continue
+		}
+		pod := stalePod(TargetStatus{IP: owner.IP, Pod: owner.Pod, PodUID: owner.UID})
+		assignment := targetGroupPodAssignment{tgArn: tgArn, podIPAddress: owner.IP, pod: pod, port: owner.Ports[tgArn]}
+		if _, ok := owned[handler.targetKey(assignment)]; !ok {
+			owned[handler.targetKey(assignment)] = assignment
 		}
-		panic("failed to describe target groups")
 	}
-	return &result.TargetGroups
-}
-
-// ensurePodsAreAttached - ensure that the following target groups have the correct IP addresses attached
-func (handler *Handler) ensurePodsAreAttached(context *Handler, tgPodMap map[string][]string) {
-	//todo: make this paginate and assemble all load balancers
 
-	ensureMutex.Lock()
-	for tgArn, podAddresses := range tgPodMap {
-		log.Debugf("EnsurePodsAreAttached: key - %s , value - %s", tgArn, podAddresses)
-		input := &elbv2.DescribeTargetHealthInput{
-			TargetGroupArn: aws.String(tgArn),
+	errs := make([]error, 0)
+	failed := make(map[string]bool)
+	for _, description := range registered {
+		if description.TargetHealth != nil && aws.StringValue(description.TargetHealth.State) == elbv2.TargetHealthStateEnumDraining {
+			continue
 		}
-
-		result, err := context.client.DescribeTargetHealth(input)
-		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case elbv2.ErrCodeInvalidTargetException:
-					log.Error(elbv2.ErrCodeInvalidTargetException, aerr.Error())
-				case elbv2.ErrCodeTargetGroupNotFoundException:
-					log.Error(elbv2.ErrCodeTargetGroupNotFoundException, aerr.Error())
-				case elbv2.ErrCodeHealthUnavailableException:
-					log.Error(elbv2.ErrCodeHealthUnavailableException, aerr.Error())
-				default:
-					log.Error(aerr.Error())
-				}
-			} else {
-				// Print the error, cast err to awserr.Error to get the Code and
-				// Message from an error.
-				log.Error(err.Error())
+		ip, port := aws.StringValue(description.Target.Id), aws.Int64Value(description.Target.Port)
+		key := fmt.Sprintf("%s:%d", ip, port)
+		if wantedIPs[ip] || wantedTargets[key] {
+			continue
+		}
+		ownedKey := key
+		stale, ok := owned[ownedKey]
+		if !ok {
+			ownedKey = ip + ":0"
+			if stale, ok = owned[ownedKey]; !ok {
+				continue
 			}
 		}
-		log.Debug(result)
-
-		ipsToRegister := make([]string, 0)
-		registeredIps := make([]string, 0)
-		for _, target := range result.TargetHealthDescriptions {
-			registeredIps = append(registeredIps, *target.Target.Id)
+		stale.port = port
+		log.Infof("Target %s of target group %s belongs to no pod that wants it anymore, removing it", key, tgArn)
+		if err := handler.deregisterTargets(ctx, stale); err != nil {
+			errs = append(errs, err)
+			failed[ownedKey] = true
 		}
-		for _, ip := range podAddresses {
-			if !contains(registeredIps, ip) {
-				ipsToRegister = append(ipsToRegister, ip)
-			}
+	}
+	// the other deferred targets were deregistered, are not registered anymore or are wanted again
+	for key := range deferred {
+		if !failed[key] {
+			handler.clearDeferred(tgArn, key)
 		}
-
-		handler.registerTargets(ipsToRegister, tgArn)
 	}
-	ensureMutex.Unlock()
+	return handlers.Combine(errs...)
 }
 
 func contains(arr []string, target string) bool {
@@ -326,3 +788,12 @@ func contains(arr []string, target string) bool {
 	}
 	return false
 }
+
+// targetDescription returns the target the assignment registers, with its port when it is not the target group's
+func targetDescription(assignment targetGroupPodAssignment) *elbv2.TargetDescription {
+	description := &elbv2.TargetDescription{Id: aws.String(assignment.pod.Status.PodIP)}
+	if assignment.port != 0 {
+		description.Port = aws.Int64(assignment.port)
+	}
+	return description
+}
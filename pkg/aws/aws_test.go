@@ -0,0 +1,568 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/aws/fake"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+)
+
+const (
+	testKey          = "nlb-attacher.bird.co/target-groups"
+	testLoadBalancer = "arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/net/nlb/50dc6c495c0c9188"
+	testTargetGroupA = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-a/73e2d6bc24d8a067"
+	testTargetGroupB = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-b/2453ed029918f21f"
+	testMissingGroup = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/gone/0123456789abcdef"
+	testPodIP        = "10.0.0.1"
+)
+
+// newTestHandler returns a handler attached to a fake with target groups tg-a and tg-b. Call the returned func to stop it
+func newTestHandler(t *testing.T) (*Handler, *fake.Client, func()) {
+	SetAPIRateLimits(1000, 1000)
+
+	elb := fake.NewClient()
+	elb.AddLoadBalancer(testLoadBalancer, "nlb", "network", "vpc-test")
+	for name, arn := range map[string]string{"tg-a": testTargetGroupA, "tg-b": testTargetGroupB} {
+		elb.AddTargetGroup(fake.TargetGroup{
+			Arn:                 arn,
+			Name:                name,
+			VpcID:               "vpc-test",
+			Port:                8080,
+			LoadBalancerArns:    []string{testLoadBalancer},
+			DeregistrationDelay: time.Hour,
+		})
+	}
+
+	stop := make(chan struct{})
+	handler := new(Handler)
+	handler.SetClient(elb)
+	handler.SetStopChannel(stop)
+	if err := handler.Init(testKey, "true"); err != nil {
+		close(stop)
+		t.Fatalf("Init() error = %v", err)
+	}
+	return handler, elb, func() { close(stop) }
+}
+
+// testPod returns a pod with http and admin ports, referencing the target groups with the given annotation value
+func testPod(ip string, value string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default", UID: types.UID("uid-web-0")},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app", Ports: []v1.ContainerPort{
+				{Name: "http", ContainerPort: 8080},
+				{Name: "admin", ContainerPort: 9090},
+			}}},
+		},
+		Status: v1.PodStatus{PodIP: ip},
+	}
+	if value != "" {
+		pod.Annotations = map[string]string{testKey: value}
+	}
+	return pod
+}
+
+// references returns the v1 annotation value referencing each target group, which registers on the port of the target group
+func references(portName string, tgArns ...string) string {
+	value := "["
+	for i, tgArn := range tgArns {
+		if i > 0 {
+			value += ","
+		}
+		value += fmt.Sprintf(`{"Arn": %q, "PortName": %q}`, tgArn, portName)
+	}
+	return value + "]"
+}
+
+func healthy(port int64) fake.Target {
+	return fake.Target{ID: testPodIP, Port: port, State: elbv2.TargetHealthStateEnumHealthy}
+}
+
+func draining(port int64) fake.Target {
+	return fake.Target{ID: testPodIP, Port: port, State: elbv2.TargetHealthStateEnumDraining, Reason: elbv2.TargetHealthReasonEnumTargetDeregistrationInProgress}
+}
+
+func TestPodRegistration(t *testing.T) {
+	created := func(ctx context.Context, handler *Handler, pod *v1.Pod) error {
+		return handler.PodCreated(ctx, pod)
+	}
+	createdThenDeleted := func(ctx context.Context, handler *Handler, pod *v1.Pod) error {
+		if err := handler.PodCreated(ctx, pod); err != nil {
+			return err
+		}
+		return handler.PodDeleted(ctx, pod)
+	}
+
+	cases := []struct {
+		name        string
+		ip          string
+		annotation  string
+		faults      func(*fake.Client)
+		run         func(context.Context, *Handler, *v1.Pod) error
+		wantErr     bool
+		wantClass   handlers.ErrorClass
+		wantTargets map[string][]fake.Target
+	}{
+		{
+			name:        "create registers the pod",
+			ip:          testPodIP,
+			annotation:  references("http", testTargetGroupA),
+			run:         created,
+			wantTargets: map[string][]fake.Target{testTargetGroupA: {healthy(8080)}},
+		},
+		{
+			name:       "create registers every target group on its port",
+			ip:         testPodIP,
+			annotation: `{"version": "v2", "targetGroups": [{"name": "tg-a", "port": "http"}, {"name": "tg-b", "port": "admin"}]}`,
+			run:        created,
+			wantTargets: map[string][]fake.Target{
+				testTargetGroupA: {healthy(8080)},
+				testTargetGroupB: {healthy(9090)},
+			},
+		},
+		{
+			name:       "create skips a pod without an ip",
+			annotation: references("http", testTargetGroupA),
+			run:        created,
+		},
+		{
+			name:        "delete deregisters the pod",
+			ip:          testPodIP,
+			annotation:  references("http", testTargetGroupA),
+			run:         createdThenDeleted,
+			wantTargets: map[string][]fake.Target{testTargetGroupA: {draining(8080)}},
+		},
+		{
+			name:       "delete of a pod that was never registered",
+			ip:         testPodIP,
+			annotation: references("http", testTargetGroupA),
+			run: func(ctx context.Context, handler *Handler, pod *v1.Pod) error {
+				return handler.PodDeleted(ctx, pod)
+			},
+		},
+		{
+			name:       "malformed annotation is terminal",
+			ip:         testPodIP,
+			annotation: `[{"Arn": `,
+			run:        created,
+			wantErr:    true,
+			wantClass:  handlers.Terminal,
+		},
+		{
+			name:       "unknown target group is terminal",
+			ip:         testPodIP,
+			annotation: references("http", testMissingGroup),
+			run:        created,
+			wantErr:    true,
+			wantClass:  handlers.Terminal,
+		},
+		{
+			name:       "throttled registration",
+			ip:         testPodIP,
+			annotation: references("http", testTargetGroupA),
+			faults:     func(elb *fake.Client) { elb.InjectFault("RegisterTargets", fake.ThrottlingError(), 1) },
+			run:        created,
+			wantErr:    true,
+			wantClass:  handlers.Throttled,
+		},
+		{
+			name:        "failed deregistration is retryable",
+			ip:          testPodIP,
+			annotation:  references("http", testTargetGroupA),
+			faults:      func(elb *fake.Client) { elb.InjectFault("DeregisterTargets", fmt.Errorf("connection reset"), 1) },
+			run:         createdThenDeleted,
+			wantErr:     true,
+			wantClass:   handlers.Retryable,
+			wantTargets: map[string][]fake.Target{testTargetGroupA: {healthy(8080)}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, elb, stop := newTestHandler(t)
+			defer stop()
+			if tc.faults != nil {
+				tc.faults(elb)
+			}
+
+			err := tc.run(context.Background(), handler, testPod(tc.ip, tc.annotation))
+			if !tc.wantErr && err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("error = nil, want one")
+				}
+				if got := handlers.Classify(err); got != tc.wantClass {
+					t.Errorf("class of %v = %s, want %s", err, got, tc.wantClass)
+				}
+			}
+			for _, tgArn := range []string{testTargetGroupA, testTargetGroupB} {
+				got, want := elb.Targets(tgArn), tc.wantTargets[tgArn]
+				if len(got) == 0 && len(want) == 0 {
+					continue
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("targets of %s = %+v, want %+v", tgArn, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPodUpdatedLeavesStaleTargetGroups(t *testing.T) {
+	handler, elb, stop := newTestHandler(t)
+	defer stop()
+	ctx := context.Background()
+
+	oldPod := testPod(testPodIP, references("http", testTargetGroupA, testTargetGroupB))
+	if err := handler.PodCreated(ctx, oldPod); err != nil {
+		t.Fatalf("PodCreated() error = %v", err)
+	}
+	newPod := testPod(testPodIP, references("http", testTargetGroupA))
+	if err := handler.PodUpdated(ctx, oldPod, newPod); err != nil {
+		t.Fatalf("PodUpdated() error = %v", err)
+	}
+
+	if got, want := elb.Targets(testTargetGroupA), []fake.Target{healthy(8080)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets of tg-a = %+v, want %+v", got, want)
+	}
+	if got, want := elb.Targets(testTargetGroupB), []fake.Target{draining(8080)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets of tg-b = %+v, want %+v", got, want)
+	}
+}
+
+func TestStaleTargetGroups(t *testing.T) {
+	cases := []struct {
+		name  string
+		old   *v1.Pod
+		new   *v1.Pod
+		stale []string
+	}{
+		{
+			name: "unchanged",
+			old:  testPod(testPodIP, references("http", testTargetGroupA)),
+			new:  testPod(testPodIP, references("http", testTargetGroupA)),
+		},
+		{
+			name:  "target group removed",
+			old:   testPod(testPodIP, references("http", testTargetGroupA, testTargetGroupB)),
+			new:   testPod(testPodIP, references("http", testTargetGroupA)),
+			stale: []string{testTargetGroupB + ":8080"},
+		},
+		{
+			name:  "annotation removed",
+			old:   testPod(testPodIP, references("http", testTargetGroupA)),
+			new:   testPod(testPodIP, ""),
+			stale: []string{testTargetGroupA + ":8080"},
+		},
+		{
+			name:  "port changed",
+			old:   testPod(testPodIP, `{"version": "v2", "targetGroups": [{"name": "tg-a", "port": "http"}]}`),
+			new:   testPod(testPodIP, `{"version": "v2", "targetGroups": [{"name": "tg-a", "port": "admin"}]}`),
+			stale: []string{testTargetGroupA + ":8080"},
+		},
+		{
+			name: "same port by name and number",
+			old:  testPod(testPodIP, `{"version": "v2", "targetGroups": [{"name": "tg-a", "port": "http"}]}`),
+			new:  testPod(testPodIP, `{"version": "v2", "targetGroups": [{"name": "tg-a", "port": 8080}]}`),
+		},
+		{
+			name: "old pod without an ip",
+			old:  testPod("", references("http", testTargetGroupA)),
+			new:  testPod(testPodIP, ""),
+		},
+		{
+			name: "new annotation malformed",
+			old:  testPod(testPodIP, references("http", testTargetGroupA)),
+			new:  testPod(testPodIP, `[{"Arn": `),
+		},
+		{
+			name: "new reference does not resolve",
+			old:  testPod(testPodIP, references("http", testTargetGroupA)),
+			new:  testPod(testPodIP, `{"version": "v2", "targetGroups": [{"name": "gone", "port": "http"}]}`),
+		},
+	}
+	handler, _, stop := newTestHandler(t)
+	defer stop()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stale := make([]string, 0)
+			for _, assignment := range handler.staleTargetGroups(tc.old, tc.new) {
+				stale = append(stale, fmt.Sprintf("%s:%d", assignment.tgArn, handler.targetPort(assignment)))
+			}
+			if tc.stale == nil {
+				tc.stale = []string{}
+			}
+			if !reflect.DeepEqual(stale, tc.stale) {
+				t.Errorf("staleTargetGroups() = %q, want %q", stale, tc.stale)
+			}
+		})
+	}
+}
+
+func TestDeregistrationDeferredWhilePaused(t *testing.T) {
+	cases := []struct {
+		name string
+		// live are the pods the reconcile after the resume finds
+		live        []*v1.Pod
+		wantTargets []fake.Target
+	}{
+		{name: "deleted pod is removed on resume", wantTargets: []fake.Target{draining(8080)}},
+		{
+			name:        "target wanted again by a pod with the same ip",
+			live:        []*v1.Pod{testPod(testPodIP, references("http", testTargetGroupA))},
+			wantTargets: []fake.Target{healthy(8080)},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, elb, stop := newTestHandler(t)
+			defer stop()
+			ctx := context.Background()
+
+			pod := testPod(testPodIP, references("http", testTargetGroupA))
+			if err := handler.PodCreated(ctx, pod); err != nil {
+				t.Fatalf("PodCreated() error = %v", err)
+			}
+			handler.PauseTargetGroup(testTargetGroupA)
+			if err := handler.PodDeleted(ctx, pod); err != nil {
+				t.Fatalf("PodDeleted() error = %v, want the deregistration deferred while paused", err)
+			}
+			if got, want := elb.Targets(testTargetGroupA), []fake.Target{healthy(8080)}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("targets while paused = %+v, want %+v", got, want)
+			}
+
+			handler.ResumeTargetGroup(testTargetGroupA)
+			if err := handler.ReconcileTargetGroup(ctx, testTargetGroupA, tc.live); err != nil {
+				t.Fatalf("ReconcileTargetGroup() error = %v", err)
+			}
+			if got := elb.Targets(testTargetGroupA); !reflect.DeepEqual(got, tc.wantTargets) {
+				t.Errorf("targets after the resume = %+v, want %+v", got, tc.wantTargets)
+			}
+			if deferred := handler.deferredDeregistrations(testTargetGroupA); len(deferred) != 0 {
+				t.Errorf("deferred deregistrations after the reconcile = %v, want none", deferred)
+			}
+		})
+	}
+}
+
+// newInspectedFake returns a fake with tg-a, where testPodIP is registered on the given port unless it is zero
+func newInspectedFake(t *testing.T, registeredPort int64) *fake.Client {
+	SetAPIRateLimits(1000, 1000)
+	elb := fake.NewClient()
+	elb.AddLoadBalancer(testLoadBalancer, "nlb", "network", "vpc-test")
+	elb.AddTargetGroup(fake.TargetGroup{Arn: testTargetGroupA, Name: "tg-a", VpcID: "vpc-test", Port: 8080, LoadBalancerArns: []string{testLoadBalancer}})
+	if registeredPort == 0 {
+		return elb
+	}
+	if _, err := elb.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(testTargetGroupA),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String(testPodIP), Port: aws.Int64(registeredPort)}},
+	}); err != nil {
+		t.Fatalf("RegisterTargets() error = %v", err)
+	}
+	return elb
+}
+
+func TestInspectMatchesTargetsByPort(t *testing.T) {
+	// the pod was registered on a port it no longer serves the target group on
+	elb := newInspectedFake(t, 9090)
+
+	inspector := NewInspector(elb, testKey, "vpc-test")
+	pod := testPod(testPodIP, references("http", testTargetGroupA))
+	statuses, err := inspector.Inspect(context.Background(), []*v1.Pod{pod}, nil, nil)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("Inspect() returned %d target groups, want 1", len(statuses))
+	}
+
+	changes := make(map[int64]string)
+	for _, target := range statuses[0].Targets {
+		changes[target.Port] = target.Change()
+	}
+	if want := map[int64]string{8080: ChangeRegister, 9090: ChangeDeregister}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes by port = %v, want %v", changes, want)
+	}
+}
+
+func TestInspectLeavesAdminActionsAlone(t *testing.T) {
+	cases := []struct {
+		name           string
+		registeredPort int64
+		modify         func(*Inspector, *v1.Pod)
+		want           string
+	}{
+		{name: "registered pod", registeredPort: 8080, modify: func(*Inspector, *v1.Pod) {}},
+		{name: "unregistered pod", modify: func(*Inspector, *v1.Pod) {}, want: ChangeRegister},
+		{
+			name:           "detached pod",
+			registeredPort: 8080,
+			modify:         func(inspector *Inspector, pod *v1.Pod) { inspector.SetDetached([]types.UID{pod.UID}) },
+			want:           ChangeDeregister,
+		},
+		{
+			name:           "pod held out by a remediation",
+			registeredPort: 8080,
+			modify:         func(_ *Inspector, pod *v1.Pod) { pod.Annotations[annotation.HeldOutKey] = testTargetGroupA },
+			want:           ChangeDeregister,
+		},
+		{
+			name:   "unregistered pod in a paused target group",
+			modify: func(inspector *Inspector, _ *v1.Pod) { inspector.SetPaused([]string{testTargetGroupA}) },
+		},
+		{
+			name:           "detached pod in a paused target group",
+			registeredPort: 8080,
+			modify: func(inspector *Inspector, pod *v1.Pod) {
+				inspector.SetPaused([]string{testTargetGroupA})
+				inspector.SetDetached([]types.UID{pod.UID})
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			inspector := NewInspector(newInspectedFake(t, tc.registeredPort), testKey, "vpc-test")
+			pod := testPod(testPodIP, references("http", testTargetGroupA))
+			tc.modify(inspector, pod)
+
+			statuses, err := inspector.Inspect(context.Background(), []*v1.Pod{pod}, nil, nil)
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if len(statuses) != 1 || len(statuses[0].Targets) != 1 {
+				t.Fatalf("Inspect() = %+v, want one target in one target group", statuses)
+			}
+			if got := statuses[0].Targets[0].Change(); got != tc.want {
+				t.Errorf("change = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHealthOfSeveralPortsInOneTargetGroup(t *testing.T) {
+	pod := testPod(testPodIP, "")
+	cases := []struct {
+		name        string
+		healths     []targetHealth
+		wantHealthy bool
+		// unhealthy are the ports the watcher counts as unhealthy
+		unhealthy []int64
+	}{
+		{
+			name: "unhealthy port after a healthy one",
+			healths: []targetHealth{
+				{tgArn: testTargetGroupA, port: 8080, state: elbv2.TargetHealthStateEnumHealthy},
+				{tgArn: testTargetGroupA, port: 9090, state: elbv2.TargetHealthStateEnumUnhealthy},
+			},
+			unhealthy: []int64{9090},
+		},
+		{
+			name: "healthy port after an unhealthy one",
+			healths: []targetHealth{
+				{tgArn: testTargetGroupA, port: 8080, state: elbv2.TargetHealthStateEnumUnhealthy},
+				{tgArn: testTargetGroupA, port: 9090, state: elbv2.TargetHealthStateEnumHealthy},
+			},
+			unhealthy: []int64{8080},
+		},
+		{
+			name: "both ports healthy",
+			healths: []targetHealth{
+				{tgArn: testTargetGroupA, port: 8080, state: elbv2.TargetHealthStateEnumHealthy},
+				{tgArn: testTargetGroupA, port: 9090, state: elbv2.TargetHealthStateEnumHealthy},
+			},
+			wantHealthy: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			watcher := healthWatcher{}
+			for _, health := range tc.healths {
+				watcher.changed(pod, health)
+			}
+			if got := healthyIn(tc.healths, testTargetGroupA); got != tc.wantHealthy {
+				t.Errorf("healthyIn() = %v, want %v", got, tc.wantHealthy)
+			}
+			unhealthy := make([]int64, 0)
+			for _, health := range tc.healths {
+				if watcher.unhealthyFor(pod, health) > 0 {
+					unhealthy = append(unhealthy, health.port)
+				}
+			}
+			if tc.unhealthy == nil {
+				tc.unhealthy = []int64{}
+			}
+			if !reflect.DeepEqual(unhealthy, tc.unhealthy) {
+				t.Errorf("unhealthy ports = %v, want %v", unhealthy, tc.unhealthy)
+			}
+		})
+	}
+}
+
+func TestStatusForgottenOnceTerminatingPodIsRemoved(t *testing.T) {
+	handler, _, stop := newTestHandler(t)
+	defer stop()
+	ctx := context.Background()
+
+	pod := testPod(testPodIP, references("http", testTargetGroupA))
+	if err := handler.PodCreated(ctx, pod); err != nil {
+		t.Fatalf("PodCreated() error = %v", err)
+	}
+	if got := handler.statuses.state(pod, testTargetGroupA); got != annotation.StateRegistered {
+		t.Fatalf("state = %q, want %q", got, annotation.StateRegistered)
+	}
+
+	terminating := pod.DeepCopy()
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	if err := handler.PodUpdated(ctx, pod, terminating); err != nil {
+		t.Fatalf("PodUpdated() error = %v", err)
+	}
+	// the controller does not call PodDeleted for the pod's later delete event
+	if _, ok := handler.statuses.pods[pod.UID]; ok {
+		t.Errorf("status of the terminating pod is still tracked: %+v", handler.statuses.pods[pod.UID])
+	}
+}
+
+func TestHealthWatcherSkipsPodsTheControllerLeavesAlone(t *testing.T) {
+	store, err := config.NewStore([]string{"--exclude-namespaces=kube-system"})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	cases := []struct {
+		name     string
+		modify   func(pod *v1.Pod)
+		detached bool
+		want     bool
+	}{
+		{name: "registered pod", modify: func(*v1.Pod) {}, want: true},
+		{name: "excluded namespace", modify: func(pod *v1.Pod) { pod.Namespace = "kube-system" }},
+		{name: "detached pod", modify: func(*v1.Pod) {}, detached: true},
+		{name: "pod without an ip", modify: func(pod *v1.Pod) { pod.Status.PodIP = "" }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := new(Handler)
+			handler.SetConfig(store)
+			handler.SetDetached(func(*v1.Pod) bool { return tc.detached })
+			pod := testPod(testPodIP, references("http", testTargetGroupA))
+			tc.modify(pod)
+			if got := handler.watched(pod); got != tc.want {
+				t.Errorf("watched() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+)
+
+// BackendName is the name the ELBv2 target group backend is registered under
+const BackendName = "elbv2"
+
+func init() {
+	handlers.Register(BackendName, NewBackendFactory(nil))
+}
+
+// NewBackendFactory - return a factory for ELBv2 backends using the given client.
+// A nil client makes Init create one from the default session
+func NewBackendFactory(client elbv2iface.ELBV2API) handlers.Factory {
+	return func(deps handlers.Dependencies) (handlers.Handler, error) {
+		handler := new(Handler)
+		if client != nil {
+			handler.SetClient(client)
+		}
+		handler.SetConditionWriter(readiness.NewWriter(deps.Clientset))
+		handler.SetClientset(deps.Clientset)
+		handler.SetConfig(deps.Config)
+		handler.SetNamespaceLister(deps.Namespaces)
+		handler.SetPodLister(deps.Pods)
+		handler.SetNodeLister(deps.Nodes)
+		handler.SetEventRecorder(deps.Recorder)
+		handler.SetStopChannel(deps.Stop)
+		handler.SetStateStore(deps.State)
+		handler.SetAuditSink(deps.Audit)
+		handler.SetAliases(deps.Aliases)
+		handler.SetPolicy(deps.Policy)
+		handler.SetAccessReviewer(deps.Access)
+		handler.SetDetached(deps.Detached)
+
+		if deps.Config != nil {
+			SetAPIRateLimits(deps.Config.Get().GetAWSAPIQPS(), deps.Config.Get().GetAWSAPIBurst())
+			deps.Config.Subscribe(func(cfg *config.Config) {
+				SetAPIRateLimits(cfg.GetAWSAPIQPS(), cfg.GetAWSAPIBurst())
+			})
+		}
+		return handler, nil
+	}
+}
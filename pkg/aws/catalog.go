@@ -0,0 +1,250 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var (
+	catalogTargetGroups = metrics.NewGauge(
+		"nlb_attacher_catalog_target_groups",
+		"Target groups known to the target group catalog.",
+	)
+	catalogRefreshErrorsTotal = metrics.NewCounter(
+		"nlb_attacher_catalog_refresh_errors_total",
+		"Failed refreshes of the target group catalog.",
+	)
+	catalogRejectionsTotal = metrics.NewCounter(
+		"nlb_attacher_catalog_rejections_total",
+		"Registrations rejected because the target group cannot take pod ips, by reason.",
+		"reason",
+	)
+)
+
+// TargetGroupInfo is what the catalog knows about a target group
+type TargetGroupInfo struct {
+	Arn              string
+	Name             string
+	TargetType       string
+	VpcID            string
+	Protocol         string
+	Port             int64
+	LoadBalancerArns []string
+	// Tags are described on first use, nil until then
+	Tags      map[string]string
+	fetchedAt time.Time
+}
+
+// targetGroupCatalog keeps every target group and load balancer of the account in memory.
+// Target groups created after the last refresh are described on demand
+type targetGroupCatalog struct {
+	client elbv2iface.ELBV2API
+
+	mutex         sync.RWMutex
+	targetGroups  map[string]*TargetGroupInfo
+	loadBalancers map[string]*elbv2.LoadBalancer
+}
+
+func newTargetGroupCatalog(client elbv2iface.ELBV2API) *targetGroupCatalog {
+	return &targetGroupCatalog{
+		client:        client,
+		targetGroups:  make(map[string]*TargetGroupInfo),
+		loadBalancers: make(map[string]*elbv2.LoadBalancer),
+	}
+}
+
+// run refreshes the catalog every period until stop is closed
+func (catalog *targetGroupCatalog) run(period time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := catalog.refresh(aws.BackgroundContext()); err != nil {
+				log.Errorf("Failed to refresh the target group catalog: %v", err)
+			}
+		}
+	}
+}
+
+// refresh pages through all load balancers and target groups and replaces the cached ones
+func (catalog *targetGroupCatalog) refresh(ctx context.Context) error {
+	loadBalancers := make(map[string]*elbv2.LoadBalancer)
+	err := catalog.client.DescribeLoadBalancersPagesWithContext(ctx, &elbv2.DescribeLoadBalancersInput{},
+		func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+			for _, lb := range page.LoadBalancers {
+				loadBalancers[aws.StringValue(lb.LoadBalancerArn)] = lb
+			}
+			return true
+		})
+	if err != nil {
+		catalogRefreshErrorsTotal.Inc()
+		return fmt.Errorf("failed to describe load balancers: %v", err)
+	}
+
+	now := time.Now()
+	targetGroups := make(map[string]*TargetGroupInfo)
+	err = catalog.client.DescribeTargetGroupsPagesWithContext(ctx, &elbv2.DescribeTargetGroupsInput{},
+		func(page *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+			for _, tg := range page.TargetGroups {
+				info := newTargetGroupInfo(tg, now)
+				targetGroups[info.Arn] = info
+			}
+			return true
+		})
+	if err != nil {
+		catalogRefreshErrorsTotal.Inc()
+		return fmt.Errorf("failed to describe target groups: %v", err)
+	}
+
+	catalog.mutex.Lock()
+	catalog.loadBalancers = loadBalancers
+	catalog.targetGroups = targetGroups
+	catalog.mutex.Unlock()
+
+	catalogTargetGroups.Set(float64(len(targetGroups)))
+	log.Debugf("Target group catalog refreshed: %d target groups, %d load balancers", len(targetGroups), len(loadBalancers))
+	return nil
+}
+
+// lookup returns the cached target group, describing it when it is not cached or when fresh is set
+func (catalog *targetGroupCatalog) lookup(ctx context.Context, tgArn string, fresh bool) (*TargetGroupInfo, error) {
+	if !fresh {
+		catalog.mutex.RLock()
+		info, ok := catalog.targetGroups[tgArn]
+		catalog.mutex.RUnlock()
+		if ok {
+			return info, nil
+		}
+	}
+
+	result, err := catalog.client.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []*string{aws.String(tgArn)},
+	})
+	if err != nil {
+		return nil, classifyError("DescribeTargetGroups", tgArn, err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, handlers.NewTerminal(fmt.Errorf("target group %s does not exist", tgArn))
+	}
+
+	info := newTargetGroupInfo(result.TargetGroups[0], time.Now())
+	catalog.mutex.Lock()
+	catalog.targetGroups[tgArn] = info
+	catalog.mutex.Unlock()
+	return info, nil
+}
+
+// check returns a terminal error when pod ips cannot be registered with the target group
+func (catalog *targetGroupCatalog) check(ctx context.Context, tgArn string, vpcID string) error {
+	info, err := catalog.lookup(ctx, tgArn, false)
+	if err != nil {
+		return err
+	}
+	reason, problem := info.problem(vpcID)
+	if problem == "" {
+		log.Debugf("Target group %s (%s) is behind %v", tgArn, info.Name, catalog.loadBalancerNames(info))
+		return nil
+	}
+
+	// the cached entry may predate a fix to the target group, look again before rejecting
+	if time.Since(info.fetchedAt) > time.Second {
+		info, err = catalog.lookup(ctx, tgArn, true)
+		if err != nil {
+			return err
+		}
+		reason, problem = info.problem(vpcID)
+		if problem == "" {
+			return nil
+		}
+	}
+
+	catalogRejectionsTotal.Inc(reason)
+	return handlers.NewTerminal(fmt.Errorf("target group %s %s", tgArn, problem))
+}
+
+// tags returns the tags of the target group, describing them the first time they are asked for
+func (catalog *targetGroupCatalog) tags(ctx context.Context, tgArn string) (map[string]string, error) {
+	info, err := catalog.lookup(ctx, tgArn, false)
+	if err != nil {
+		return nil, err
+	}
+	catalog.mutex.RLock()
+	tags := info.Tags
+	catalog.mutex.RUnlock()
+	if tags != nil {
+		return tags, nil
+	}
+
+	result, err := catalog.client.DescribeTagsWithContext(ctx, &elbv2.DescribeTagsInput{
+		ResourceArns: []*string{aws.String(tgArn)},
+	})
+	if err != nil {
+		return nil, classifyError("DescribeTags", tgArn, err)
+	}
+	tags = make(map[string]string)
+	for _, description := range result.TagDescriptions {
+		for _, tag := range description.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	catalog.mutex.Lock()
+	info.Tags = tags
+	catalog.mutex.Unlock()
+	return tags, nil
+}
+
+// loadBalancerNames returns the names of the load balancers the target group is attached to, as far as they are cached
+func (catalog *targetGroupCatalog) loadBalancerNames(info *TargetGroupInfo) []string {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	names := make([]string, 0, len(info.LoadBalancerArns))
+	for _, arn := range info.LoadBalancerArns {
+		if lb, ok := catalog.loadBalancers[arn]; ok {
+			names = append(names, aws.StringValue(lb.LoadBalancerName))
+		} else {
+			names = append(names, arn)
+		}
+	}
+	return names
+}
+
+func newTargetGroupInfo(tg *elbv2.TargetGroup, fetchedAt time.Time) *TargetGroupInfo {
+	return &TargetGroupInfo{
+		Arn:              aws.StringValue(tg.TargetGroupArn),
+		Name:             aws.StringValue(tg.TargetGroupName),
+		TargetType:       aws.StringValue(tg.TargetType),
+		VpcID:            aws.StringValue(tg.VpcId),
+		Protocol:         aws.StringValue(tg.Protocol),
+		Port:             aws.Int64Value(tg.Port),
+		LoadBalancerArns: aws.StringValueSlice(tg.LoadBalancerArns),
+		fetchedAt:        fetchedAt,
+	}
+}
+
+// problem returns why pod ips cannot be registered with the target group, or an empty problem
+func (info *TargetGroupInfo) problem(vpcID string) (string, string) {
+	if info.TargetType != elbv2.TargetTypeEnumIp {
+		return "target-type", fmt.Sprintf("has target type %q, pods can only be registered by ip", info.TargetType)
+	}
+	if len(info.LoadBalancerArns) == 0 {
+		return "unattached", "is not attached to a load balancer"
+	}
+	if vpcID != "" && info.VpcID != vpcID {
+		return "vpc", fmt.Sprintf("is in %s, not in %s", info.VpcID, vpcID)
+	}
+	return "", ""
+}
@@ -0,0 +1,75 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var nodeDrainsTotal = metrics.NewCounter(
+	"nlb_attacher_node_drain_deregistrations_total",
+	"Targets deregistered ahead of eviction because their pod's node is cordoned or tainted for termination, by reason.",
+	"reason",
+)
+
+// Reasons a node is draining
+const (
+	drainCordoned = "cordoned"
+	drainTainted  = "tainted"
+)
+
+// SetNodeLister - set the lister the nodes of pods are looked up in. Without one no pod is deregistered for its node draining
+func (handler *Handler) SetNodeLister(lister corelisters.NodeLister) {
+	handler.nodes = lister
+}
+
+// nodeDraining reports whether the pod's node is cordoned or tainted for termination, with the reason and a description
+func (handler *Handler) nodeDraining(pod *v1.Pod) (string, string) {
+	if handler.nodes == nil || pod.Spec.NodeName == "" {
+		return "", ""
+	}
+	node, err := handler.nodes.Get(pod.Spec.NodeName)
+	if err != nil {
+		log.Debugf("Could not look up node %s of pod %s/%s: %v", pod.Spec.NodeName, pod.Namespace, pod.Name, err)
+		return "", ""
+	}
+	if handler.config != nil {
+		cfg := handler.config.Get()
+		for _, taint := range node.Spec.Taints {
+			if taint.Effect != v1.TaintEffectPreferNoSchedule && cfg.NodeDrainTaint(taint.Key) {
+				return drainTainted, fmt.Sprintf("node %s is tainted %s for termination", node.Name, taint.Key)
+			}
+		}
+	}
+	if node.Spec.Unschedulable {
+		return drainCordoned, fmt.Sprintf("node %s is cordoned", node.Name)
+	}
+	return "", ""
+}
+
+// leaveDrainingNode deregisters the pod from a target group ahead of its eviction from a draining node, so its connections drain
+// for the deregistration delay instead of being reset. Only the first time the entry is seen on a draining node calls ELBv2 and
+// records it, later events find the node-draining state and leave it. The pod is registered again if the node is uncordoned
+func (handler *Handler) leaveDrainingNode(ctx context.Context, assignment targetGroupPodAssignment, reason string, description string) error {
+	pod := assignment.pod
+	if handler.statuses.state(pod, assignment.tgArn) == annotation.StateNodeDraining {
+		return nil
+	}
+	log.Infof("Deregistering pod %s/%s from target group %s ahead of its eviction: %s", pod.Namespace, pod.Name, assignment.tgArn, description)
+	if err := handler.deregisterTargets(ctx, assignment); err != nil {
+		handler.setTargetState(pod, assignment.tgArn, "", err)
+		return err
+	}
+	nodeDrainsTotal.Inc(reason)
+	if handler.recorder != nil {
+		handler.recorder.Eventf(pod, v1.EventTypeNormal, "NodeDraining", "Deregistered from target group %s: %s", assignment.tgArn, description)
+	}
+	handler.setTargetState(pod, assignment.tgArn, annotation.StateNodeDraining, nil)
+	return nil
+}
@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/events"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var dryRunMutationsTotal = metrics.NewCounter(
+	"nlb_attacher_dry_run_mutations_total",
+	"ELBv2 mutations that were skipped because of dry-run mode.",
+	"operation", "target_group",
+)
+
+// SetNamespaceLister - set the lister used to read the per namespace dry-run annotation and the namespace labels the policy selects by
+func (handler *Handler) SetNamespaceLister(lister corelisters.NamespaceLister) {
+	handler.namespaces = lister
+}
+
+// SetEventRecorder - set the recorder used to emit events on pods
+func (handler *Handler) SetEventRecorder(recorder *events.Recorder) {
+	handler.recorder = recorder
+}
+
+// dryRun reports whether mutations for the pod must only be logged, either globally or for its namespace
+func (handler *Handler) dryRun(pod *v1.Pod) bool {
+	if handler.config != nil && handler.config.Get().GetDryRun() {
+		return true
+	}
+	if handler.namespaces == nil {
+		return false
+	}
+
+	namespace, err := handler.namespaces.Get(pod.Namespace)
+	if err != nil {
+		log.Debugf("Could not read namespace %s for dry-run: %v", pod.Namespace, err)
+		return false
+	}
+	return namespace.GetAnnotations()[config.DryRunAnnotationKey] == "true"
+}
+
+// reportDryRun records a mutation that would have been made in logs, pod events and metrics
+func (handler *Handler) reportDryRun(pods []*v1.Pod, operation string, tgArn string) {
+	ips := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		ips = append(ips, pod.Status.PodIP)
+	}
+
+	log.WithFields(log.Fields{
+		"dryRun":      true,
+		"operation":   operation,
+		"targetGroup": tgArn,
+		"targets":     ips,
+	}).Infof("Dry run: would call %s", operation)
+
+	dryRunMutationsTotal.Add(float64(len(pods)), operation, tgArn)
+
+	for _, pod := range pods {
+		handler.recorder.Eventf(pod, v1.EventTypeNormal, "DryRun"+strings.TrimSuffix(operation, "Targets"),
+			"Dry run: would call %s for %s on target group %s", operation, pod.Status.PodIP, tgArn)
+	}
+}
@@ -0,0 +1,32 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+)
+
+// classifyError wraps an elbv2 error with the class telling the controller whether to retry it
+func classifyError(operation string, tgArn string, err error) error {
+	wrapped := fmt.Errorf("%s on target group %s failed: %w", operation, tgArn, err)
+
+	if request.IsErrorThrottle(err) {
+		return handlers.NewThrottled(wrapped)
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case elbv2.ErrCodeTargetGroupNotFoundException,
+			elbv2.ErrCodeInvalidTargetException,
+			elbv2.ErrCodeTooManyTargetsException,
+			elbv2.ErrCodeTooManyRegistrationsForTargetIdException,
+			"ValidationError",
+			"AccessDenied":
+			return handlers.NewTerminal(wrapped)
+		}
+	}
+	return handlers.NewRetryable(wrapped)
+}
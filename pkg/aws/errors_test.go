@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/aws/fake"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want handlers.ErrorClass
+	}{
+		{"throttled", fake.ThrottlingError(), handlers.Throttled},
+		{"target group not found", fake.TargetGroupNotFoundError(testTargetGroupA), handlers.Terminal},
+		{"invalid target", awserr.New(elbv2.ErrCodeInvalidTargetException, "not a valid address", nil), handlers.Terminal},
+		{"too many targets", awserr.New(elbv2.ErrCodeTooManyTargetsException, "limit reached", nil), handlers.Terminal},
+		{"too many registrations", awserr.New(elbv2.ErrCodeTooManyRegistrationsForTargetIdException, "limit reached", nil), handlers.Terminal},
+		{"validation error", awserr.New("ValidationError", "bad arn", nil), handlers.Terminal},
+		{"access denied", awserr.New("AccessDenied", "not authorized", nil), handlers.Terminal},
+		{"other aws error", awserr.New("InternalFailure", "try again", nil), handlers.Retryable},
+		{"not an aws error", errors.New("connection reset"), handlers.Retryable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := classifyError("RegisterTargets", testTargetGroupA, tc.err)
+			if got := handlers.Classify(err); got != tc.want {
+				t.Errorf("class = %s, want %s", got, tc.want)
+			}
+			if want := "RegisterTargets on target group " + testTargetGroupA + " failed: "; !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("message = %q, want it to start with %q", err.Error(), want)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%v does not wrap %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestRegistrationFailureIsClassified(t *testing.T) {
+	cases := []struct {
+		name  string
+		fault error
+		want  handlers.ErrorClass
+	}{
+		{"throttled", fake.ThrottlingError(), handlers.Throttled},
+		{"target group deleted", fake.TargetGroupNotFoundError(testTargetGroupA), handlers.Terminal},
+		{"access denied", awserr.New("AccessDenied", "not authorized", nil), handlers.Terminal},
+		{"service unavailable", awserr.New("ServiceUnavailable", "try again", nil), handlers.Retryable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, elb, stop := newTestHandler(t)
+			defer stop()
+			elb.InjectFault("RegisterTargets", tc.fault, 1)
+
+			err := handler.PodCreated(context.Background(), testPod(testPodIP, references("http", testTargetGroupA)))
+			if err == nil {
+				t.Fatal("PodCreated() error = nil, want one")
+			}
+			if got := handlers.Classify(err); got != tc.want {
+				t.Errorf("class of %v = %s, want %s", err, got, tc.want)
+			}
+			if calls := elb.Calls("RegisterTargets"); calls != 1 {
+				t.Errorf("RegisterTargets called %d times, want 1", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package fake
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// defaultPageSize matches the ELBv2 default for describe calls
+const defaultPageSize = 400
+
+// RegisterTargets - register targets, or bring draining targets back
+func (client *Client) RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	return client.RegisterTargetsWithContext(aws.BackgroundContext(), input)
+}
+
+// RegisterTargetsWithContext - register targets, or bring draining targets back
+func (client *Client) RegisterTargetsWithContext(ctx aws.Context, input *elbv2.RegisterTargetsInput, opts ...request.Option) (*elbv2.RegisterTargetsOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "RegisterTargets"); err != nil {
+		return nil, err
+	}
+	tg, ok := client.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, targetGroupNotFound(aws.StringValue(input.TargetGroupArn))
+	}
+	client.expire(tg)
+
+	// validate everything first, ELBv2 registers all of the targets or none
+	for _, description := range input.Targets {
+		if err := client.validateTarget(tg, description); err != nil {
+			return nil, err
+		}
+	}
+
+	now := client.now()
+	for _, description := range input.Targets {
+		port := client.targetPort(tg, description)
+		key := targetKey(aws.StringValue(description.Id), port)
+		if existing, ok := tg.targets[key]; ok && existing.drainingSince.IsZero() {
+			continue
+		}
+		tg.targets[key] = &target{id: aws.StringValue(description.Id), port: port, registeredAt: now}
+	}
+	client.complete(opts)
+	return &elbv2.RegisterTargetsOutput{}, nil
+}
+
+// DeregisterTargets - start draining targets. They are removed once the deregistration delay passes
+func (client *Client) DeregisterTargets(input *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	return client.DeregisterTargetsWithContext(aws.BackgroundContext(), input)
+}
+
+// DeregisterTargetsWithContext - start draining targets. They are removed once the deregistration delay passes
+func (client *Client) DeregisterTargetsWithContext(ctx aws.Context, input *elbv2.DeregisterTargetsInput, opts ...request.Option) (*elbv2.DeregisterTargetsOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DeregisterTargets"); err != nil {
+		return nil, err
+	}
+	tg, ok := client.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, targetGroupNotFound(aws.StringValue(input.TargetGroupArn))
+	}
+	client.expire(tg)
+
+	for _, description := range input.Targets {
+		key := targetKey(aws.StringValue(description.Id), client.targetPort(tg, description))
+		if _, ok := tg.targets[key]; !ok {
+			return nil, client.invalidTarget(fmt.Sprintf("The following targets are not registered in target group: %s", key))
+		}
+	}
+
+	now := client.now()
+	for _, description := range input.Targets {
+		t := tg.targets[targetKey(aws.StringValue(description.Id), client.targetPort(tg, description))]
+		if t.drainingSince.IsZero() {
+			t.drainingSince = now
+		}
+	}
+	client.expire(tg)
+	client.complete(opts)
+	return &elbv2.DeregisterTargetsOutput{}, nil
+}
+
+// DescribeTargetHealth - return the health of every target, or of the requested targets
+func (client *Client) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	return client.DescribeTargetHealthWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeTargetHealthWithContext - return the health of every target, or of the requested targets
+func (client *Client) DescribeTargetHealthWithContext(ctx aws.Context, input *elbv2.DescribeTargetHealthInput, opts ...request.Option) (*elbv2.DescribeTargetHealthOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DescribeTargetHealth"); err != nil {
+		return nil, err
+	}
+	tg, ok := client.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, targetGroupNotFound(aws.StringValue(input.TargetGroupArn))
+	}
+	client.expire(tg)
+
+	output := &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: make([]*elbv2.TargetHealthDescription, 0)}
+	if len(input.Targets) > 0 {
+		for _, description := range input.Targets {
+			port := client.targetPort(tg, description)
+			state, reason := elbv2.TargetHealthStateEnumUnused, elbv2.TargetHealthReasonEnumTargetNotRegistered
+			if t, ok := tg.targets[targetKey(aws.StringValue(description.Id), port)]; ok {
+				state, reason = client.health(tg, t)
+			}
+			output.TargetHealthDescriptions = append(output.TargetHealthDescriptions, healthDescription(aws.StringValue(description.Id), port, state, reason))
+		}
+		return output, nil
+	}
+
+	keys := make([]string, 0, len(tg.targets))
+	for key := range tg.targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t := tg.targets[key]
+		state, reason := client.health(tg, t)
+		output.TargetHealthDescriptions = append(output.TargetHealthDescriptions, healthDescription(t.id, t.port, state, reason))
+	}
+	return output, nil
+}
+
+// DescribeTargetGroupAttributes - return the deregistration delay of a target group
+func (client *Client) DescribeTargetGroupAttributes(input *elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	return client.DescribeTargetGroupAttributesWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeTargetGroupAttributesWithContext - return the deregistration delay of a target group
+func (client *Client) DescribeTargetGroupAttributesWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupAttributesInput, opts ...request.Option) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DescribeTargetGroupAttributes"); err != nil {
+		return nil, err
+	}
+	tg, ok := client.targetGroups[aws.StringValue(input.TargetGroupArn)]
+	if !ok {
+		return nil, targetGroupNotFound(aws.StringValue(input.TargetGroupArn))
+	}
+
+	return &elbv2.DescribeTargetGroupAttributesOutput{
+		Attributes: []*elbv2.TargetGroupAttribute{
+			{
+				Key:   aws.String("deregistration_delay.timeout_seconds"),
+				Value: aws.String(strconv.FormatInt(int64(tg.DeregistrationDelay.Seconds()), 10)),
+			},
+		},
+	}, nil
+}
+
+// DescribeTags - return the tags of target groups
+func (client *Client) DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error) {
+	return client.DescribeTagsWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeTagsWithContext - return the tags of target groups, sorted by key
+func (client *Client) DescribeTagsWithContext(ctx aws.Context, input *elbv2.DescribeTagsInput, opts ...request.Option) (*elbv2.DescribeTagsOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DescribeTags"); err != nil {
+		return nil, err
+	}
+	output := &elbv2.DescribeTagsOutput{TagDescriptions: make([]*elbv2.TagDescription, 0)}
+	for _, arn := range aws.StringValueSlice(input.ResourceArns) {
+		tg, ok := client.targetGroups[arn]
+		if !ok {
+			return nil, targetGroupNotFound(arn)
+		}
+		keys := make([]string, 0, len(tg.Tags))
+		for key := range tg.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		description := &elbv2.TagDescription{ResourceArn: aws.String(arn), Tags: make([]*elbv2.Tag, 0, len(keys))}
+		for _, key := range keys {
+			description.Tags = append(description.Tags, &elbv2.Tag{Key: aws.String(key), Value: aws.String(tg.Tags[key])})
+		}
+		output.TagDescriptions = append(output.TagDescriptions, description)
+	}
+	return output, nil
+}
+
+// DescribeTargetGroups - return one page of target groups, filtered by arn, name or load balancer
+func (client *Client) DescribeTargetGroups(input *elbv2.DescribeTargetGroupsInput) (*elbv2.DescribeTargetGroupsOutput, error) {
+	return client.DescribeTargetGroupsWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeTargetGroupsWithContext - return one page of target groups, filtered by arn, name or load balancer
+func (client *Client) DescribeTargetGroupsWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupsInput, opts ...request.Option) (*elbv2.DescribeTargetGroupsOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DescribeTargetGroups"); err != nil {
+		return nil, err
+	}
+
+	arns := make([]string, 0)
+	if len(input.TargetGroupArns) > 0 {
+		for _, arn := range aws.StringValueSlice(input.TargetGroupArns) {
+			if _, ok := client.targetGroups[arn]; !ok {
+				return nil, targetGroupNotFound(arn)
+			}
+			arns = append(arns, arn)
+		}
+	} else {
+		names := aws.StringValueSlice(input.Names)
+		for arn, tg := range client.targetGroups {
+			if len(names) > 0 && !contains(names, tg.Name) {
+				continue
+			}
+			if input.LoadBalancerArn != nil && !contains(tg.LoadBalancerArns, aws.StringValue(input.LoadBalancerArn)) {
+				continue
+			}
+			arns = append(arns, arn)
+		}
+	}
+	sort.Strings(arns)
+
+	start, end, next, err := client.page(input.Marker, input.PageSize, len(arns))
+	if err != nil {
+		return nil, err
+	}
+	output := &elbv2.DescribeTargetGroupsOutput{TargetGroups: make([]*elbv2.TargetGroup, 0), NextMarker: next}
+	for _, arn := range arns[start:end] {
+		output.TargetGroups = append(output.TargetGroups, describeTargetGroup(client.targetGroups[arn]))
+	}
+	return output, nil
+}
+
+// DescribeTargetGroupsPages - call fn with every page of target groups
+func (client *Client) DescribeTargetGroupsPages(input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool) error {
+	return client.DescribeTargetGroupsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DescribeTargetGroupsPagesWithContext - call fn with every page of target groups
+func (client *Client) DescribeTargetGroupsPagesWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := client.DescribeTargetGroupsWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextMarker == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.Marker = output.NextMarker
+	}
+}
+
+// DescribeLoadBalancers - return one page of load balancers, filtered by arn or name
+func (client *Client) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	return client.DescribeLoadBalancersWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeLoadBalancersWithContext - return one page of load balancers, filtered by arn or name
+func (client *Client) DescribeLoadBalancersWithContext(ctx aws.Context, input *elbv2.DescribeLoadBalancersInput, opts ...request.Option) (*elbv2.DescribeLoadBalancersOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if err := client.begin(ctx, "DescribeLoadBalancers"); err != nil {
+		return nil, err
+	}
+
+	filterArns := aws.StringValueSlice(input.LoadBalancerArns)
+	filterNames := aws.StringValueSlice(input.Names)
+	arns := make([]string, 0)
+	for arn, lb := range client.loadBalancers {
+		if len(filterArns) > 0 && !contains(filterArns, arn) {
+			continue
+		}
+		if len(filterNames) > 0 && !contains(filterNames, aws.StringValue(lb.LoadBalancerName)) {
+			continue
+		}
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+
+	start, end, next, err := client.page(input.Marker, input.PageSize, len(arns))
+	if err != nil {
+		return nil, err
+	}
+	output := &elbv2.DescribeLoadBalancersOutput{LoadBalancers: make([]*elbv2.LoadBalancer, 0), NextMarker: next}
+	for _, arn := range arns[start:end] {
+		lb := *client.loadBalancers[arn]
+		output.LoadBalancers = append(output.LoadBalancers, &lb)
+	}
+	return output, nil
+}
+
+// DescribeLoadBalancersPages - call fn with every page of load balancers
+func (client *Client) DescribeLoadBalancersPages(input *elbv2.DescribeLoadBalancersInput, fn func(*elbv2.DescribeLoadBalancersOutput, bool) bool) error {
+	return client.DescribeLoadBalancersPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DescribeLoadBalancersPagesWithContext - call fn with every page of load balancers
+func (client *Client) DescribeLoadBalancersPagesWithContext(ctx aws.Context, input *elbv2.DescribeLoadBalancersInput, fn func(*elbv2.DescribeLoadBalancersOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := client.DescribeLoadBalancersWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextMarker == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.Marker = output.NextMarker
+	}
+}
+
+// begin counts the call and fails it when the context is done or a fault is injected. Callers hold the mutex
+func (client *Client) begin(ctx aws.Context, operation string) error {
+	if err := client.call(operation); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	return nil
+}
+
+// complete runs the complete handlers the request options add, on a request carrying a fresh request id. Callers hold the mutex
+func (client *Client) complete(opts []request.Option) {
+	r := &request.Request{RequestID: client.requestID()}
+	r.ApplyOptions(opts...)
+	r.Handlers.Complete.Run(r)
+}
+
+// page returns the slice bounds for the marker and page size, and the marker of the next page
+func (client *Client) page(marker *string, pageSize *int64, total int) (int, int, *string, error) {
+	start := 0
+	if marker != nil {
+		parsed, err := strconv.Atoi(aws.StringValue(marker))
+		if err != nil || parsed < 0 || parsed > total {
+			return 0, 0, nil, awserr.NewRequestFailure(awserr.New("ValidationError", "Invalid marker", nil), 400, client.requestID())
+		}
+		start = parsed
+	}
+
+	size := defaultPageSize
+	if pageSize != nil {
+		size = int(*pageSize)
+	}
+	end := start + size
+	if end >= total {
+		return start, total, nil, nil
+	}
+	return start, end, aws.String(strconv.Itoa(end)), nil
+}
+
+func (client *Client) validateTarget(tg *targetGroup, description *elbv2.TargetDescription) error {
+	id := aws.StringValue(description.Id)
+	if tg.TargetType == elbv2.TargetTypeEnumIp && net.ParseIP(id) == nil {
+		return client.invalidTarget(fmt.Sprintf("The IP address '%s' is not a valid IPv4 address", id))
+	}
+	if client.targetPort(tg, description) < 1 {
+		return client.invalidTarget(fmt.Sprintf("A port must be given for target '%s'", id))
+	}
+	return nil
+}
+
+func (client *Client) invalidTarget(message string) error {
+	return awserr.NewRequestFailure(awserr.New(elbv2.ErrCodeInvalidTargetException, message, nil), 400, client.requestID())
+}
+
+// targetPort returns the port of the description, defaulting to the port of the target group
+func (client *Client) targetPort(tg *targetGroup, description *elbv2.TargetDescription) int64 {
+	if description.Port != nil {
+		return *description.Port
+	}
+	return tg.Port
+}
+
+func describeTargetGroup(tg *targetGroup) *elbv2.TargetGroup {
+	return &elbv2.TargetGroup{
+		TargetGroupArn:   aws.String(tg.Arn),
+		TargetGroupName:  aws.String(tg.Name),
+		TargetType:       aws.String(tg.TargetType),
+		VpcId:            aws.String(tg.VpcID),
+		Protocol:         aws.String(tg.Protocol),
+		Port:             aws.Int64(tg.Port),
+		LoadBalancerArns: aws.StringSlice(tg.LoadBalancerArns),
+	}
+}
+
+func healthDescription(id string, port int64, state string, reason string) *elbv2.TargetHealthDescription {
+	health := &elbv2.TargetHealth{State: aws.String(state)}
+	if reason != "" {
+		health.Reason = aws.String(reason)
+	}
+	return &elbv2.TargetHealthDescription{
+		Target:       &elbv2.TargetDescription{Id: aws.String(id), Port: aws.Int64(port)},
+		TargetHealth: health,
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+//go:generate go run gen_unimplemented.go
+
+// Client is a stateful in-memory elbv2iface.ELBV2API. It implements the calls the attacher makes
+// against target groups and load balancers; any other call returns a not implemented error
+type Client struct {
+	unimplemented
+
+	mutex         sync.Mutex
+	now           func() time.Time
+	requests      int
+	targetGroups  map[string]*targetGroup
+	loadBalancers map[string]*elbv2.LoadBalancer
+	faults        []*fault
+	calls         map[string]int
+}
+
+var _ elbv2iface.ELBV2API = &Client{}
+
+// TargetGroup describes a target group created with AddTargetGroup
+type TargetGroup struct {
+	Arn              string
+	Name             string
+	TargetType       string
+	VpcID            string
+	Protocol         string
+	Port             int64
+	LoadBalancerArns []string
+	Tags             map[string]string
+
+	// DeregistrationDelay is how long a deregistered target stays draining before it is removed
+	DeregistrationDelay time.Duration
+	// HealthyAfter is how long a registered target stays initial before it turns healthy
+	HealthyAfter time.Duration
+}
+
+// Target is a snapshot of one registered target
+type Target struct {
+	ID     string
+	Port   int64
+	State  string
+	Reason string
+}
+
+type targetGroup struct {
+	TargetGroup
+	targets map[string]*target
+}
+
+type target struct {
+	id            string
+	port          int64
+	registeredAt  time.Time
+	drainingSince time.Time
+	forcedState   string
+	forcedReason  string
+}
+
+type fault struct {
+	operation string
+	err       error
+	remaining int
+}
+
+// NewClient - return an empty fake
+func NewClient() *Client {
+	return &Client{
+		now:           time.Now,
+		targetGroups:  make(map[string]*targetGroup),
+		loadBalancers: make(map[string]*elbv2.LoadBalancer),
+		calls:         make(map[string]int),
+	}
+}
+
+// SetClock - replace time.Now, so health and draining transitions can be driven by the caller
+func (client *Client) SetClock(now func() time.Time) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.now = now
+}
+
+// AddLoadBalancer - create a load balancer of the given type ("network" or "application") in a vpc
+func (client *Client) AddLoadBalancer(arn string, name string, lbType string, vpcID string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.loadBalancers[arn] = &elbv2.LoadBalancer{
+		LoadBalancerArn:  &arn,
+		LoadBalancerName: &name,
+		Type:             &lbType,
+		VpcId:            &vpcID,
+		State:            &elbv2.LoadBalancerState{Code: stringPtr(elbv2.LoadBalancerStateEnumActive)},
+	}
+}
+
+// AddTargetGroup - create or replace a target group. An empty TargetType defaults to ip
+func (client *Client) AddTargetGroup(tg TargetGroup) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if tg.TargetType == "" {
+		tg.TargetType = elbv2.TargetTypeEnumIp
+	}
+	if tg.Protocol == "" {
+		tg.Protocol = elbv2.ProtocolEnumTcp
+	}
+	client.targetGroups[tg.Arn] = &targetGroup{TargetGroup: tg, targets: make(map[string]*target)}
+}
+
+// RemoveTargetGroup - remove a target group and all of its targets
+func (client *Client) RemoveTargetGroup(arn string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.targetGroups, arn)
+}
+
+// Targets - return the targets of a target group with their current health, sorted by id and port
+func (client *Client) Targets(tgArn string) []Target {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	tg, ok := client.targetGroups[tgArn]
+	if !ok {
+		return nil
+	}
+	client.expire(tg)
+
+	targets := make([]Target, 0, len(tg.targets))
+	for _, t := range tg.targets {
+		state, reason := client.health(tg, t)
+		targets = append(targets, Target{ID: t.id, Port: t.port, State: state, Reason: reason})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].ID != targets[j].ID {
+			return targets[i].ID < targets[j].ID
+		}
+		return targets[i].Port < targets[j].Port
+	})
+	return targets
+}
+
+// SetTargetHealth - force the health of a registered target until it is deregistered. An empty state clears it
+func (client *Client) SetTargetHealth(tgArn string, id string, port int64, state string, reason string) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	tg, ok := client.targetGroups[tgArn]
+	if !ok {
+		return targetGroupNotFound(tgArn)
+	}
+	t, ok := tg.targets[targetKey(id, port)]
+	if !ok {
+		return fmt.Errorf("target %s:%d is not registered with %s", id, port, tgArn)
+	}
+	t.forcedState = state
+	t.forcedReason = reason
+	return nil
+}
+
+// InjectFault - make the next count calls of operation (e.g. "RegisterTargets", or "*" for every call) fail with err.
+// A count of zero or less fails every call until ClearFaults
+func (client *Client) InjectFault(operation string, err error, count int) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.faults = append(client.faults, &fault{operation: operation, err: err, remaining: count})
+}
+
+// ClearFaults - remove every injected fault
+func (client *Client) ClearFaults() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.faults = nil
+}
+
+// Calls - return how many times operation was called, including failed calls
+func (client *Client) Calls(operation string) int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.calls[operation]
+}
+
+// ThrottlingError - return the error ELBv2 responds with when the request rate is exceeded
+func ThrottlingError() error {
+	return awserr.NewRequestFailure(awserr.New("Throttling", "Rate exceeded", nil), 400, "fake-throttled")
+}
+
+// unimplemented is embedded by Client and stubs every ELBV2API call. The stubs are generated into unimplemented.go
+type unimplemented struct{}
+
+// notImplemented is returned by the calls the fake does not implement
+func notImplemented(operation string) error {
+	return fmt.Errorf("%s is not implemented by fake.Client", operation)
+}
+
+// TargetGroupNotFoundError - return the error ELBv2 responds with for an unknown target group
+func TargetGroupNotFoundError(arn string) error {
+	return targetGroupNotFound(arn)
+}
+
+// call counts the operation and returns the injected fault, if any. Callers hold the mutex
+func (client *Client) call(operation string) error {
+	client.calls[operation]++
+	for i, f := range client.faults {
+		if f.operation != operation && f.operation != "*" {
+			continue
+		}
+		if f.remaining > 0 {
+			f.remaining--
+			if f.remaining == 0 {
+				client.faults = append(client.faults[:i], client.faults[i+1:]...)
+			}
+		}
+		return f.err
+	}
+	return nil
+}
+
+// expire removes targets that finished draining. Callers hold the mutex
+func (client *Client) expire(tg *targetGroup) {
+	now := client.now()
+	for key, t := range tg.targets {
+		if !t.drainingSince.IsZero() && !now.Before(t.drainingSince.Add(tg.DeregistrationDelay)) {
+			delete(tg.targets, key)
+		}
+	}
+}
+
+// health derives the state of a target from its timers. Callers hold the mutex
+func (client *Client) health(tg *targetGroup, t *target) (string, string) {
+	switch {
+	case !t.drainingSince.IsZero():
+		return elbv2.TargetHealthStateEnumDraining, elbv2.TargetHealthReasonEnumTargetDeregistrationInProgress
+	case t.forcedState != "":
+		return t.forcedState, t.forcedReason
+	case len(tg.LoadBalancerArns) == 0:
+		return elbv2.TargetHealthStateEnumUnused, elbv2.TargetHealthReasonEnumTargetNotInUse
+	case client.now().Before(t.registeredAt.Add(tg.HealthyAfter)):
+		return elbv2.TargetHealthStateEnumInitial, elbv2.TargetHealthReasonEnumElbInitialHealthChecking
+	default:
+		return elbv2.TargetHealthStateEnumHealthy, ""
+	}
+}
+
+func (client *Client) requestID() string {
+	client.requests++
+	return fmt.Sprintf("fake-%08d", client.requests)
+}
+
+func targetGroupNotFound(arn string) error {
+	return awserr.NewRequestFailure(awserr.New(elbv2.ErrCodeTargetGroupNotFoundException,
+		fmt.Sprintf("One or more target groups not found: %s", arn), nil), 400, "fake-not-found")
+}
+
+func targetKey(id string, port int64) string {
+	return fmt.Sprintf("%s:%d", id, port)
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
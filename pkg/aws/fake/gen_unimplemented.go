@@ -0,0 +1,99 @@
+//go:build ignore
+// +build ignore
+
+// gen_unimplemented writes unimplemented.go: a stub of every elbv2iface.ELBV2API method
+// returning a not implemented error. Run it with go generate after upgrading aws-sdk-go
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const ifacePackage = "github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+func main() {
+	dir, err := exec.Command("go", "list", "-f", "{{.Dir}}", ifacePackage).Output()
+	if err != nil {
+		log.Fatalf("failed to locate %s: %v", ifacePackage, err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath.Join(strings.TrimSpace(string(dir)), "interface.go"), nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var methods []*ast.Field
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+		if !ok || spec.Name.Name != "ELBV2API" {
+			return true
+		}
+		methods = spec.Type.(*ast.InterfaceType).Methods.List
+		return false
+	})
+	if len(methods) == 0 {
+		log.Fatal("ELBV2API not found")
+	}
+
+	var out bytes.Buffer
+	out.WriteString(`// Code generated by gen_unimplemented.go. DO NOT EDIT.
+
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+`)
+	for _, method := range methods {
+		name := method.Names[0].Name
+		signature := method.Type.(*ast.FuncType)
+
+		var results []string
+		for _, result := range signature.Results.List {
+			switch typ := expression(fset, result.Type); typ {
+			case "error":
+				results = append(results, "notImplemented("+quote(name)+")")
+			case "*request.Request":
+				results = append(results, "&request.Request{Error: notImplemented("+quote(name)+")}")
+			default:
+				results = append(results, "nil")
+			}
+		}
+
+		out.WriteString("\nfunc (unimplemented) " + name + expression(fset, signature)[len("func"):] + " {\n")
+		out.WriteString("\treturn " + strings.Join(results, ", ") + "\n}\n")
+	}
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("unimplemented.go", source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func expression(fset *token.FileSet, node ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		log.Fatal(err)
+	}
+	return buf.String()
+}
+
+func quote(s string) string {
+	return `"` + s + `"`
+}
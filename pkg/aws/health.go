@@ -0,0 +1,347 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+)
+
+var targetHealthTargets = metrics.NewGauge(
+	"nlb_attacher_target_health_targets",
+	"Pod targets per target group and health state, as of the last health check.",
+	"target_group", "state",
+)
+
+// targetHealthStates are all states a target can report, so gauges of states that emptied drop to zero
+var targetHealthStates = []string{
+	elbv2.TargetHealthStateEnumInitial,
+	elbv2.TargetHealthStateEnumHealthy,
+	elbv2.TargetHealthStateEnumUnhealthy,
+	elbv2.TargetHealthStateEnumUnused,
+	elbv2.TargetHealthStateEnumDraining,
+	elbv2.TargetHealthStateEnumUnavailable,
+}
+
+// targetHealth is the health one target group reports for a target of a pod
+type targetHealth struct {
+	tgArn       string
+	port        int64
+	state       string
+	reason      string
+	description string
+	// since is when the target entered its state, as far as the watcher saw it
+	since time.Time
+}
+
+// healthWatcher remembers the last health of every pod target, by target group and port, so only changes are reported as events
+type healthWatcher struct {
+	mutex sync.Mutex
+	seen  map[types.UID]map[string]targetHealth
+}
+
+// SetPodLister - set the lister the health watcher finds the pods of a target group with
+func (handler *Handler) SetPodLister(lister corelisters.PodLister) {
+	handler.pods = lister
+}
+
+// SetDetached - set the func reporting whether an operator detached a pod. The health watcher leaves detached pods alone
+func (handler *Handler) SetDetached(detached func(pod *v1.Pod) bool) {
+	handler.detached = detached
+}
+
+// watched reports whether the health watcher reports on and remediates the pod. Pods the controller does not handle,
+// in namespaces left out or detached by an operator, are not watched
+func (handler *Handler) watched(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" || handler.dryRun(pod) {
+		return false
+	}
+	if handler.config != nil && !handler.config.Get().NamespaceAllowed(pod.Namespace) {
+		return false
+	}
+	return handler.detached == nil || !handler.detached(pod)
+}
+
+// watchTargetHealth checks the health of every pod target every period until stop is closed
+func (handler *Handler) watchTargetHealth(period time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			handler.checkTargetHealth(ctx)
+			cancel()
+		}
+	}
+}
+
+// checkTargetHealth describes the health of every managed target group and mirrors it into pod conditions and events
+func (handler *Handler) checkTargetHealth(ctx context.Context) {
+	if handler.pods == nil {
+		return
+	}
+	checkedAt := time.Now()
+	pods, err := handler.pods.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Failed to list pods for the health check: %v", err)
+		return
+	}
+
+	// target group -> ip:port, with port 0 for the target group's port -> pod
+	members := make(map[string]map[string]*v1.Pod)
+	live := make(map[types.UID]bool, len(pods))
+	byUID := make(map[types.UID]*v1.Pod, len(pods))
+	for _, pod := range pods {
+		live[pod.UID] = true
+		byUID[pod.UID] = pod
+		if !handler.watched(pod) {
+			continue
+		}
+		if reason, _ := handler.nodeDraining(pod); reason != "" {
+			// deregistered ahead of the eviction, its draining target is neither reported nor remediated
+			continue
+		}
+		assignments, err := handler.getPodTargetGroupAssignments(pod)
+		if err != nil {
+			continue
+		}
+		for _, assignment := range selected(assignments) {
+			if !handler.targetGroupAllowed(assignment.tgArn) {
+				continue
+			}
+			if allowed, _, _ := handler.permitted(ctx, pod, assignment.tgArn); !allowed {
+				continue
+			}
+			if members[assignment.tgArn] == nil {
+				members[assignment.tgArn] = make(map[string]*v1.Pod)
+			}
+			members[assignment.tgArn][fmt.Sprintf("%s:%d", assignment.podIPAddress, assignment.port)] = pod
+		}
+	}
+
+	// pod key -> health in each of its target groups
+	healths := make(map[string][]targetHealth)
+	podsByKey := make(map[string]*v1.Pod)
+	// pod uid -> target groups reporting a target of the pod, draining ones included
+	reported := make(map[types.UID]map[string]bool)
+	// pod uid -> whether a target group it is a member of could not be described
+	unknown := make(map[types.UID]bool)
+	for tgArn, byTarget := range members {
+		result, err := handler.client.DescribeTargetHealthWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(tgArn),
+		})
+		if err != nil {
+			log.Warnf("Failed to describe the target health of %s: %v", tgArn, classifyError("DescribeTargetHealth", tgArn, err))
+			for _, pod := range byTarget {
+				unknown[pod.UID] = true
+			}
+			continue
+		}
+
+		counts := make(map[string]int)
+		for _, description := range result.TargetHealthDescriptions {
+			ip, port := aws.StringValue(description.Target.Id), aws.Int64Value(description.Target.Port)
+			pod, ok := byTarget[fmt.Sprintf("%s:%d", ip, port)]
+			if !ok {
+				pod, ok = byTarget[ip+":0"]
+			}
+			if !ok || description.TargetHealth == nil {
+				continue
+			}
+			health := targetHealth{
+				tgArn:       tgArn,
+				port:        port,
+				state:       aws.StringValue(description.TargetHealth.State),
+				reason:      aws.StringValue(description.TargetHealth.Reason),
+				description: aws.StringValue(description.TargetHealth.Description),
+			}
+			counts[health.state]++
+			if reported[pod.UID] == nil {
+				reported[pod.UID] = make(map[string]bool)
+			}
+			reported[pod.UID][tgArn] = true
+
+			key := pod.Namespace + "/" + pod.Name
+			healths[key] = append(healths[key], health)
+			podsByKey[key] = pod
+		}
+		for _, state := range targetHealthStates {
+			targetHealthTargets.Set(float64(counts[state]), tgArn, state)
+		}
+	}
+
+	for key, podHealths := range healths {
+		sort.Slice(podHealths, func(i, j int) bool {
+			if podHealths[i].tgArn != podHealths[j].tgArn {
+				return podHealths[i].tgArn < podHealths[j].tgArn
+			}
+			return podHealths[i].port < podHealths[j].port
+		})
+		handler.reportTargetHealth(podsByKey[key], podHealths)
+		handler.remediate(ctx, podsByKey[key], podHealths)
+		handler.writeStatus(podsByKey[key])
+	}
+	for _, pod := range pods {
+		if _, ok := healths[pod.Namespace+"/"+pod.Name]; !ok && !unknown[pod.UID] && pod.DeletionTimestamp == nil {
+			handler.clearTargetHealth(pod)
+		}
+	}
+	handler.releaseHeldOut(checkedAt, byUID, reported)
+	handler.health.forget(live)
+	handler.statuses.forget(live)
+	handler.remediations.forget(live)
+}
+
+// reportTargetHealth sets the health condition of the pod and records an event for every target whose health changed.
+// The status of a target group with several targets of the pod shows the first one that is not healthy
+func (handler *Handler) reportTargetHealth(pod *v1.Pod, healths []targetHealth) {
+	status := v1.ConditionTrue
+	reason := "Healthy"
+	messages := make([]string, 0, len(healths))
+	worst := make(map[string]targetHealth)
+	for _, health := range healths {
+		if last, ok := worst[health.tgArn]; !ok || last.state == elbv2.TargetHealthStateEnumHealthy {
+			worst[health.tgArn] = health
+		}
+		messages = append(messages, health.String())
+		if health.state != elbv2.TargetHealthStateEnumHealthy && status == v1.ConditionTrue {
+			status = v1.ConditionFalse
+			reason = health.conditionReason()
+		}
+
+		if !handler.health.changed(pod, health) {
+			continue
+		}
+		eventType := v1.EventTypeNormal
+		if health.state == elbv2.TargetHealthStateEnumUnhealthy || health.state == elbv2.TargetHealthStateEnumUnavailable {
+			eventType = v1.EventTypeWarning
+		}
+		handler.recorder.Eventf(pod, eventType, "Target"+strings.Title(health.state),
+			"Target %s %s", pod.Status.PodIP, health.String())
+	}
+	for _, health := range worst {
+		handler.setTargetHealth(pod, health)
+	}
+
+	if handler.conditionWriter == nil {
+		return
+	}
+	if err := handler.conditionWriter.SetCondition(pod, readiness.HealthConditionType, status, reason, strings.Join(messages, "; ")); err != nil {
+		log.Error(err)
+	}
+}
+
+// clearTargetHealth resets the health condition of a pod no target group reports anymore, and forgets its targets' health
+func (handler *Handler) clearTargetHealth(pod *v1.Pod) {
+	handler.health.clear(pod.UID)
+	if handler.conditionWriter == nil {
+		return
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != readiness.HealthConditionType || condition.Status == v1.ConditionUnknown {
+			continue
+		}
+		if err := handler.conditionWriter.SetCondition(pod, readiness.HealthConditionType, v1.ConditionUnknown, "NotRegistered", "no target group reports the pod"); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// changed records the health of the pod target and reports whether it differs from the last check.
+// Healthy targets seen for the first time are not a change, so restarts do not flood pods with events.
+// The health is only kept in memory: after a restart since is the first check that saw the state, so a remediation
+// waits its whole duration again rather than acting on how long an earlier attacher saw the pod unhealthy
+func (watcher *healthWatcher) changed(pod *v1.Pod, health targetHealth) bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	if watcher.seen == nil {
+		watcher.seen = make(map[types.UID]map[string]targetHealth)
+	}
+	if watcher.seen[pod.UID] == nil {
+		watcher.seen[pod.UID] = make(map[string]targetHealth)
+	}
+
+	last, ok := watcher.seen[pod.UID][health.target()]
+	health.since = time.Now()
+	if ok && last.state == health.state {
+		health.since = last.since
+	}
+	watcher.seen[pod.UID][health.target()] = health
+	if !ok {
+		return health.state != elbv2.TargetHealthStateEnumHealthy
+	}
+	return last.state != health.state || last.reason != health.reason
+}
+
+// unhealthyFor returns how long the target group has reported the pod's target unhealthy, counted from when this attacher first saw it
+func (watcher *healthWatcher) unhealthyFor(pod *v1.Pod, target targetHealth) time.Duration {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	health, ok := watcher.seen[pod.UID][target.target()]
+	if !ok || health.state != elbv2.TargetHealthStateEnumUnhealthy {
+		return 0
+	}
+	return time.Since(health.since)
+}
+
+// forget drops the remembered health of pods that no longer exist
+func (watcher *healthWatcher) forget(live map[types.UID]bool) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	for uid := range watcher.seen {
+		if !live[uid] {
+			delete(watcher.seen, uid)
+		}
+	}
+}
+
+// clear drops the remembered health of the pod
+func (watcher *healthWatcher) clear(uid types.UID) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	delete(watcher.seen, uid)
+}
+
+// target identifies the target by target group and port
+func (health targetHealth) target() string {
+	return fmt.Sprintf("%s:%d", health.tgArn, health.port)
+}
+
+// conditionReason turns the ELBv2 reason code, e.g. Target.FailedHealthChecks, into a condition reason
+func (health targetHealth) conditionReason() string {
+	if health.reason == "" {
+		return strings.Title(health.state)
+	}
+	return health.reason[strings.Index(health.reason, ".")+1:]
+}
+
+func (health targetHealth) String() string {
+	message := fmt.Sprintf("in target group %s is %s", health.tgArn, health.state)
+	if health.port != 0 {
+		message = fmt.Sprintf("on port %d in target group %s is %s", health.port, health.tgArn, health.state)
+	}
+	if health.reason != "" {
+		message += fmt.Sprintf(": %s", health.reason)
+	}
+	if health.description != "" {
+		message += fmt.Sprintf(" (%s)", health.description)
+	}
+	return message
+}
@@ -0,0 +1,319 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// Changes a reconcile or a gc makes to a target
+const (
+	ChangeRegister   = "register"
+	ChangeDeregister = "deregister"
+)
+
+// TargetStatus is one target of a target group, as the pods want it and as ELBv2 has it
+type TargetStatus struct {
+	IP     string
+	Port   int64
+	Pod    string
+	PodUID types.UID
+	// Desired is set when an opted in pod lists the target group in its annotation
+	Desired bool
+	// Registered is set when ELBv2 has the target and it is not draining
+	Registered   bool
+	Health       string
+	HealthReason string
+	// Paused is set when the target group is paused, so nothing is changed about the target
+	Paused bool
+}
+
+// Change - return what the attacher would change about the target: register a desired target, deregister a stale one,
+// or nothing. Targets of no known pod were never registered by the attacher and are left alone, and so are the targets
+// of paused target groups
+func (target TargetStatus) Change() string {
+	switch {
+	case target.Paused:
+		return ""
+	case target.Desired && !target.Registered:
+		return ChangeRegister
+	case target.Registered && !target.Desired && target.Pod != "":
+		return ChangeDeregister
+	}
+	return ""
+}
+
+// Unmanaged - report whether the target belongs to no pod the attacher knows of
+func (target TargetStatus) Unmanaged() bool {
+	return target.Pod == ""
+}
+
+// TargetGroupStatus is the desired and actual targets of one target group
+type TargetGroupStatus struct {
+	Arn    string
+	Name   string
+	Paused bool
+	// Port is the port of the target group, which targets are registered on unless their pod names another
+	Port    int64
+	Targets []TargetStatus
+	// Err is why the target group could not be described
+	Err error
+}
+
+// Inspector compares the targets the pods want with the targets ELBv2 has, outside of the controller.
+// It uses the handler's annotation parsing, rate limits and deregistration
+type Inspector struct {
+	handler  *Handler
+	vpcID    string
+	paused   map[string]bool
+	detached map[types.UID]bool
+}
+
+// NewInspector - return an inspector using the client, or a client from the default session when it is nil.
+// Target groups outside vpcID, when set, are reported as errors the same way the controller rejects them
+func NewInspector(client elbv2iface.ELBV2API, annotationKey string, vpcID string) *Inspector {
+	if client == nil {
+		client = elbv2.New(session.New())
+	}
+	handler := new(Handler)
+	handler.client = withRateLimits(client)
+	handler.targetGroupAnnotationKey = annotationKey
+	handler.pausedTargetGroups = make(map[string]bool)
+	handler.catalog = newTargetGroupCatalog(handler.client)
+	return &Inspector{handler: handler, vpcID: vpcID}
+}
+
+// SetAuditSink - set the sink the deregistrations of RemoveStale are recorded in
+func (inspector *Inspector) SetAuditSink(sink audit.Sink) {
+	inspector.handler.SetAuditSink(sink)
+}
+
+// SetAliases - set the store the target group aliases of the pods are resolved with
+func (inspector *Inspector) SetAliases(store *alias.Store) {
+	inspector.handler.SetAliases(store)
+}
+
+// SetPolicy - set the policy pods are only desired in the target groups it allows of, with the namespaces it selects by
+func (inspector *Inspector) SetPolicy(store *policy.Store, namespaces corelisters.NamespaceLister) {
+	inspector.handler.SetPolicy(store)
+	inspector.handler.SetNamespaceLister(namespaces)
+}
+
+// SetPaused - set the target groups paused through the admin api, whose targets are left as they are
+func (inspector *Inspector) SetPaused(tgArns []string) {
+	inspector.paused = make(map[string]bool, len(tgArns))
+	for _, tgArn := range tgArns {
+		inspector.paused[tgArn] = true
+	}
+}
+
+// SetDetached - set the pods detached through the admin api, which are not desired in any target group
+func (inspector *Inspector) SetDetached(uids []types.UID) {
+	inspector.detached = make(map[types.UID]bool, len(uids))
+	for _, uid := range uids {
+		inspector.detached[uid] = true
+	}
+}
+
+// Inspect - describe every target group the pods or the recorded owners reference and allowed accepts, and match its targets to pods.
+// Every pod given is one the attacher manages. Detached pods and pods a remediation holds out of a target group are not desired in it
+func (inspector *Inspector) Inspect(ctx context.Context, pods []*v1.Pod, recorded []state.Owner, allowed func(tgArn string) bool) ([]TargetGroupStatus, error) {
+	// target group -> ip:port -> desired target, where port 0 is the target group's port
+	desired := make(map[string]map[string]TargetStatus)
+	// ip -> pod, to name targets whose pod no longer wants them
+	known := make(map[string]TargetStatus)
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		target := TargetStatus{IP: pod.Status.PodIP, Pod: pod.Namespace + "/" + pod.Name, PodUID: pod.UID}
+		known[target.IP] = target
+
+		assignments, err := inspector.handler.getPodTargetGroupAssignments(pod)
+		if err != nil {
+			continue
+		}
+		for _, assignment := range selected(assignments) {
+			if inspector.detached[pod.UID] || annotation.IsHeldOut(pod, assignment.tgArn) {
+				// inspected so a target still registered is found, but not desired
+				if desired[assignment.tgArn] == nil {
+					desired[assignment.tgArn] = make(map[string]TargetStatus)
+				}
+				continue
+			}
+			if allowed, _, _ := inspector.handler.permitted(ctx, pod, assignment.tgArn); !allowed {
+				continue
+			}
+			if desired[assignment.tgArn] == nil {
+				desired[assignment.tgArn] = make(map[string]TargetStatus)
+			}
+			target.Desired = true
+			target.Port = assignment.port
+			desired[assignment.tgArn][targetID(target.IP, target.Port)] = target
+		}
+	}
+	for _, owner := range recorded {
+		for _, tgArn := range owner.TargetGroups {
+			if desired[tgArn] == nil {
+				desired[tgArn] = make(map[string]TargetStatus)
+			}
+		}
+		if _, ok := known[owner.IP]; !ok {
+			known[owner.IP] = TargetStatus{IP: owner.IP, Pod: owner.Pod, PodUID: owner.UID}
+		}
+	}
+
+	tgArns := make([]string, 0, len(desired))
+	for tgArn := range desired {
+		if allowed == nil || allowed(tgArn) {
+			tgArns = append(tgArns, tgArn)
+		}
+	}
+	sort.Strings(tgArns)
+
+	statuses := make([]TargetGroupStatus, 0, len(tgArns))
+	errs := make([]error, 0)
+	for _, tgArn := range tgArns {
+		status := inspector.inspectTargetGroup(ctx, tgArn, desired[tgArn], known)
+		if status.Err != nil {
+			errs = append(errs, status.Err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, handlers.Combine(errs...)
+}
+
+// inspectTargetGroup describes the targets of one target group and merges them with the desired ones
+func (inspector *Inspector) inspectTargetGroup(ctx context.Context, tgArn string, desired map[string]TargetStatus, known map[string]TargetStatus) TargetGroupStatus {
+	status := TargetGroupStatus{Arn: tgArn, Name: tgArn, Paused: inspector.paused[tgArn]}
+	info, err := inspector.handler.catalog.lookup(ctx, tgArn, false)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Name = info.Name
+	status.Port = info.Port
+	if err := inspector.handler.catalog.check(ctx, tgArn, inspector.vpcID); err != nil {
+		status.Err = err
+		return status
+	}
+
+	result, err := inspector.handler.client.DescribeTargetHealthWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(tgArn),
+	})
+	if err != nil {
+		status.Err = classifyError("DescribeTargetHealth", tgArn, err)
+		return status
+	}
+
+	// ip:port -> target, so a pod moved to another port is both registered on the new port and deregistered from the old one
+	targets := make(map[string]TargetStatus)
+	for _, target := range desired {
+		if target.Port == 0 {
+			target.Port = info.Port
+		}
+		targets[targetID(target.IP, target.Port)] = target
+	}
+	for _, description := range result.TargetHealthDescriptions {
+		ip := aws.StringValue(description.Target.Id)
+		port := aws.Int64Value(description.Target.Port)
+		id := targetID(ip, port)
+		target, ok := targets[id]
+		if !ok {
+			target = known[ip]
+			target.IP = ip
+			target.Port = port
+			target.Desired = false
+		}
+		if description.TargetHealth != nil {
+			target.Health = aws.StringValue(description.TargetHealth.State)
+			target.HealthReason = aws.StringValue(description.TargetHealth.Reason)
+		}
+		target.Registered = target.Health != elbv2.TargetHealthStateEnumDraining
+		targets[id] = target
+	}
+
+	for _, target := range targets {
+		target.Paused = status.Paused
+		status.Targets = append(status.Targets, target)
+	}
+	sort.Slice(status.Targets, func(i, j int) bool {
+		if status.Targets[i].IP == status.Targets[j].IP {
+			return status.Targets[i].Port < status.Targets[j].Port
+		}
+		return compareIPs(status.Targets[i].IP, status.Targets[j].IP)
+	})
+	return status
+}
+
+// RemoveStale - deregister every target that a known pod no longer wants, recording the trigger in the audit log.
+// It returns how many targets were deregistered
+func (inspector *Inspector) RemoveStale(ctx context.Context, statuses []TargetGroupStatus, trigger audit.Trigger) (int, error) {
+	ctx = audit.WithTrigger(ctx, trigger)
+	removed := 0
+	errs := make([]error, 0)
+	for _, status := range statuses {
+		for _, target := range status.Targets {
+			if target.Change() != ChangeDeregister {
+				continue
+			}
+			stale := targetGroupPodAssignment{tgArn: status.Arn, pod: stalePod(target), port: target.Port}
+			if err := inspector.handler.deregisterTargets(ctx, stale); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			removed++
+		}
+	}
+	return removed, handlers.Combine(errs...)
+}
+
+// stalePod builds a pod standing in for the one a stale target belonged to
+func stalePod(target TargetStatus) *v1.Pod {
+	namespace, name, err := cache.SplitMetaNamespaceKey(target.Pod)
+	if err != nil {
+		name = target.Pod
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: target.PodUID},
+		Status:     v1.PodStatus{PodIP: target.IP},
+	}
+}
+
+// targetID identifies a target of a target group by its ip and port
+func targetID(ip string, port int64) string {
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// compareIPs orders ip addresses numerically, and anything else after them by its text
+func compareIPs(a, b string) bool {
+	ipA, ipB := net.ParseIP(a).To16(), net.ParseIP(b).To16()
+	if ipA == nil || ipB == nil {
+		return fmt.Sprint(ipA == nil, a) < fmt.Sprint(ipB == nil, b)
+	}
+	for i := range ipA {
+		if ipA[i] != ipB[i] {
+			return ipA[i] < ipB[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package aws
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/access"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+)
+
+// SetPolicy - set the policy deciding which target groups the pods of each namespace may use. A nil store allows every target group
+func (handler *Handler) SetPolicy(store *policy.Store) {
+	handler.policy = store
+}
+
+// SetAccessReviewer - set the reviewer asking whether a pod's service account may bind a target group. A nil reviewer allows every target group
+func (handler *Handler) SetAccessReviewer(reviewer *access.Reviewer) {
+	handler.access = reviewer
+}
+
+// permitted reports whether the policy lets the pod's namespace use the target group and its service account may bind it, and why not
+func (handler *Handler) permitted(ctx context.Context, pod *v1.Pod, tgArn string) (bool, string, error) {
+	allowed, reason, err := handler.policyAllows(ctx, pod, tgArn)
+	if err != nil || !allowed {
+		return allowed, reason, err
+	}
+	return handler.access.Allowed(pod.Namespace, pod.Spec.ServiceAccountName, policy.NameFromArn(tgArn))
+}
+
+// policyAllows reports whether the policy lets the pod's namespace use the target group, and why not
+func (handler *Handler) policyAllows(ctx context.Context, pod *v1.Pod, tgArn string) (bool, string, error) {
+	if handler.policy == nil {
+		return true, "", nil
+	}
+	var namespaceLabels map[string]string
+	if handler.namespaces != nil {
+		if namespace, err := handler.namespaces.Get(pod.Namespace); err == nil {
+			namespaceLabels = namespace.GetLabels()
+		} else {
+			log.Debugf("Could not read the labels of namespace %s for the policy: %v", pod.Namespace, err)
+		}
+	}
+	return handler.policy.Allowed(pod.Namespace, namespaceLabels, handler.policyTarget(ctx, tgArn))
+}
+
+// policyTarget describes the target group to the policy, its tags are only described when a tag rule needs them
+func (handler *Handler) policyTarget(ctx context.Context, tgArn string) policy.Target {
+	target := policy.Target{Arn: tgArn, Name: policy.NameFromArn(tgArn)}
+	if handler.catalog != nil {
+		target.Tags = func() (map[string]string, error) {
+			return handler.catalog.tags(ctx, tgArn)
+		}
+	}
+	return target
+}
+
+// leaveDenied deregisters the pod from a target group the policy or an access review does not allow it to use. Only the first
+// time the entry is seen denied calls ELBv2 and records the denial, later events find the denied state and leave it
+func (handler *Handler) leaveDenied(ctx context.Context, assignment targetGroupPodAssignment, reason string) error {
+	pod := assignment.pod
+	if handler.statuses.state(pod, assignment.tgArn) == annotation.StateDenied {
+		return nil
+	}
+	log.Warnf("Not registering pod %s/%s: %s", pod.Namespace, pod.Name, reason)
+	policy.CountDenial(pod.Namespace)
+	if handler.recorder != nil {
+		handler.recorder.Eventf(pod, v1.EventTypeWarning, "TargetGroupDenied", "Not registered with target group %s: %s", assignment.tgArn, reason)
+	}
+	if err := handler.deregisterTargets(ctx, assignment); err != nil {
+		handler.setTargetState(pod, assignment.tgArn, "", err)
+		return err
+	}
+	handler.setTargetState(pod, assignment.tgArn, annotation.StateDenied, errors.New(reason))
+	return nil
+}
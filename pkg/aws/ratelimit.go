@@ -0,0 +1,313 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+)
+
+const (
+	// throttleDecrease is the factor the rate of a bucket is multiplied by on a throttling response
+	throttleDecrease = 0.5
+	// recoveryIncrease is the share of the configured rate added back after every successful call
+	recoveryIncrease = 0.05
+	// minimumQPS is the lowest rate throttling can push a bucket down to
+	minimumQPS = 0.1
+)
+
+var (
+	apiRateLimit = metrics.NewGauge(
+		"nlb_attacher_aws_api_rate_limit",
+		"Current client side rate limit in requests per second of each ELBv2 api per account and region.",
+		"api", "account", "region",
+	)
+	apiThrottlesTotal = metrics.NewCounter(
+		"nlb_attacher_aws_api_throttles_total",
+		"ELBv2 throttling responses per api, account and region.",
+		"api", "account", "region",
+	)
+	apiRequestsTotal = metrics.NewCounter(
+		"nlb_attacher_aws_api_requests_total",
+		"ELBv2 requests per api, account and region.",
+		"api", "account", "region",
+	)
+	apiWaitSeconds = metrics.NewCounter(
+		"nlb_attacher_aws_api_rate_limit_wait_seconds_total",
+		"Time spent waiting for the client side rate limit per api, account and region.",
+		"api", "account", "region",
+	)
+)
+
+// apiLimits is shared by every ELBv2 client in the process, so all of them draw from the same buckets
+var apiLimits = newAPIRateLimiter(5, 10)
+
+// SetAPIRateLimits - set the rate and burst each ELBv2 api gets per account and region. Throttled buckets
+// recover towards the new rate
+func SetAPIRateLimits(qps float64, burst int) {
+	apiLimits.update(qps, burst)
+}
+
+// apiRateLimiter holds a token bucket per api, account and region
+type apiRateLimiter struct {
+	mutex   sync.Mutex
+	qps     float64
+	burst   int
+	buckets map[string]*adaptiveBucket
+}
+
+// adaptiveBucket is a token bucket whose rate is halved on throttling and recovers with every success
+type adaptiveBucket struct {
+	mutex   sync.Mutex
+	limiter *rate.Limiter
+	current float64
+	labels  []string
+}
+
+func newAPIRateLimiter(qps float64, burst int) *apiRateLimiter {
+	return &apiRateLimiter{qps: qps, burst: burst, buckets: make(map[string]*adaptiveBucket)}
+}
+
+func (limiter *apiRateLimiter) update(qps float64, burst int) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.qps == qps && limiter.burst == burst {
+		return
+	}
+	limiter.qps = qps
+	limiter.burst = burst
+	for _, bucket := range limiter.buckets {
+		bucket.mutex.Lock()
+		if bucket.current > qps {
+			bucket.current = qps
+		}
+		// adjusted in place: a new limiter would start with a full burst and leave the callers waiting on this one at the old rate
+		bucket.limiter.SetLimit(rate.Limit(bucket.current))
+		bucket.limiter.SetBurst(burst)
+		apiRateLimit.Set(bucket.current, bucket.labels...)
+		bucket.mutex.Unlock()
+	}
+	log.Infof("ELBv2 api rate limit set to %g requests per second with a burst of %d", qps, burst)
+}
+
+// bucket returns the bucket of the api for the account and region of the arn
+func (limiter *apiRateLimiter) bucket(api string, arn string) (*adaptiveBucket, float64) {
+	account, region := "unknown", "unknown"
+	if parts := strings.Split(arn, ":"); len(parts) > 4 {
+		region, account = parts[3], parts[4]
+	}
+	key := api + "/" + account + "/" + region
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &adaptiveBucket{
+			limiter: rate.NewLimiter(rate.Limit(limiter.qps), limiter.burst),
+			current: limiter.qps,
+			labels:  []string{api, account, region},
+		}
+		limiter.buckets[key] = bucket
+		apiRateLimit.Set(bucket.current, bucket.labels...)
+	}
+	return bucket, limiter.qps
+}
+
+// call waits for a token, makes the call and adapts the rate to the response. The call is traced as a span,
+// fn passes the option recording the request id on to the api call
+func (limiter *apiRateLimiter) call(ctx context.Context, api string, arn string, fn func(traced request.Option) error) error {
+	bucket, maxQPS := limiter.bucket(api, arn)
+
+	_, span := tracing.Start(ctx, "elbv2."+api, tracing.KindClient,
+		tracing.String("rpc.service", "elbv2"),
+		tracing.String("rpc.method", api),
+		tracing.String("elbv2.arn", arn),
+	)
+	defer span.End()
+
+	start := time.Now()
+	if err := bucket.limiter.Wait(ctx); err != nil {
+		if ctx.Err() == nil {
+			// the wait would outlast the deadline of ctx
+			err = fmt.Errorf("%w: waiting for the %s rate limit", context.DeadlineExceeded, api)
+		}
+		span.RecordError(err)
+		return err
+	}
+	waited := time.Since(start)
+	apiWaitSeconds.Add(waited.Seconds(), bucket.labels...)
+	apiRequestsTotal.Inc(bucket.labels...)
+
+	requestID := ""
+	err := fn(captureRequestID(&requestID))
+	if failure, ok := err.(awserr.RequestFailure); ok && requestID == "" {
+		requestID = failure.RequestID()
+	}
+	span.SetAttributes(tracing.Float("elbv2.rate_limit_wait_seconds", waited.Seconds()), tracing.String("aws.request_id", requestID))
+	span.RecordError(err)
+	if err != nil && request.IsErrorThrottle(err) {
+		apiThrottlesTotal.Inc(bucket.labels...)
+		lowered := bucket.adapt(maxQPS, func(current float64) float64 { return current * throttleDecrease })
+		log.Warnf("ELBv2 %s throttled, lowering its rate to %g requests per second", api, lowered)
+	} else if err == nil {
+		bucket.adapt(maxQPS, func(current float64) float64 { return current + maxQPS*recoveryIncrease })
+	}
+	return err
+}
+
+// adapt moves the rate of the bucket to next(current rate), kept between the minimum and maxQPS, and returns it
+func (bucket *adaptiveBucket) adapt(maxQPS float64, next func(float64) float64) float64 {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	floor := minimumQPS
+	if floor > maxQPS {
+		floor = maxQPS
+	}
+	updated := next(bucket.current)
+	if updated < floor {
+		updated = floor
+	}
+	if updated > maxQPS {
+		updated = maxQPS
+	}
+	if updated != bucket.current {
+		bucket.current = updated
+		bucket.limiter.SetLimit(rate.Limit(updated))
+		apiRateLimit.Set(updated, bucket.labels...)
+	}
+	return updated
+}
+
+// rateLimitedClient wraps the ELBv2 calls the attacher makes with the shared per api buckets.
+// Every other call goes straight to the wrapped client
+type rateLimitedClient struct {
+	elbv2iface.ELBV2API
+	limits *apiRateLimiter
+}
+
+// withRateLimits - wrap the client with the shared buckets, unless it already is
+func withRateLimits(client elbv2iface.ELBV2API) elbv2iface.ELBV2API {
+	if _, wrapped := client.(*rateLimitedClient); wrapped {
+		return client
+	}
+	return &rateLimitedClient{ELBV2API: client, limits: apiLimits}
+}
+
+func (client *rateLimitedClient) RegisterTargetsWithContext(ctx aws.Context, input *elbv2.RegisterTargetsInput, opts ...request.Option) (output *elbv2.RegisterTargetsOutput, err error) {
+	err = client.limits.call(ctx, "RegisterTargets", aws.StringValue(input.TargetGroupArn), func(traced request.Option) error {
+		output, err = client.ELBV2API.RegisterTargetsWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+func (client *rateLimitedClient) DeregisterTargetsWithContext(ctx aws.Context, input *elbv2.DeregisterTargetsInput, opts ...request.Option) (output *elbv2.DeregisterTargetsOutput, err error) {
+	err = client.limits.call(ctx, "DeregisterTargets", aws.StringValue(input.TargetGroupArn), func(traced request.Option) error {
+		output, err = client.ELBV2API.DeregisterTargetsWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+func (client *rateLimitedClient) DescribeTargetHealthWithContext(ctx aws.Context, input *elbv2.DescribeTargetHealthInput, opts ...request.Option) (output *elbv2.DescribeTargetHealthOutput, err error) {
+	err = client.limits.call(ctx, "DescribeTargetHealth", aws.StringValue(input.TargetGroupArn), func(traced request.Option) error {
+		output, err = client.ELBV2API.DescribeTargetHealthWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+func (client *rateLimitedClient) DescribeTargetGroupAttributesWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupAttributesInput, opts ...request.Option) (output *elbv2.DescribeTargetGroupAttributesOutput, err error) {
+	err = client.limits.call(ctx, "DescribeTargetGroupAttributes", aws.StringValue(input.TargetGroupArn), func(traced request.Option) error {
+		output, err = client.ELBV2API.DescribeTargetGroupAttributesWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+func (client *rateLimitedClient) DescribeTagsWithContext(ctx aws.Context, input *elbv2.DescribeTagsInput, opts ...request.Option) (output *elbv2.DescribeTagsOutput, err error) {
+	arn := ""
+	if len(input.ResourceArns) > 0 {
+		arn = aws.StringValue(input.ResourceArns[0])
+	}
+	err = client.limits.call(ctx, "DescribeTags", arn, func(traced request.Option) error {
+		output, err = client.ELBV2API.DescribeTagsWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+func (client *rateLimitedClient) DescribeTargetGroupsWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupsInput, opts ...request.Option) (output *elbv2.DescribeTargetGroupsOutput, err error) {
+	arn := aws.StringValue(input.LoadBalancerArn)
+	if len(input.TargetGroupArns) > 0 {
+		arn = aws.StringValue(input.TargetGroupArns[0])
+	}
+	err = client.limits.call(ctx, "DescribeTargetGroups", arn, func(traced request.Option) error {
+		output, err = client.ELBV2API.DescribeTargetGroupsWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+// DescribeTargetGroupsPagesWithContext - page through the target groups, taking a token per page
+func (client *rateLimitedClient) DescribeTargetGroupsPagesWithContext(ctx aws.Context, input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := client.DescribeTargetGroupsWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(output.NextMarker) == ""
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.Marker = output.NextMarker
+	}
+}
+
+func (client *rateLimitedClient) DescribeLoadBalancersWithContext(ctx aws.Context, input *elbv2.DescribeLoadBalancersInput, opts ...request.Option) (output *elbv2.DescribeLoadBalancersOutput, err error) {
+	arn := ""
+	if len(input.LoadBalancerArns) > 0 {
+		arn = aws.StringValue(input.LoadBalancerArns[0])
+	}
+	err = client.limits.call(ctx, "DescribeLoadBalancers", arn, func(traced request.Option) error {
+		output, err = client.ELBV2API.DescribeLoadBalancersWithContext(ctx, input, withOption(opts, traced)...)
+		return err
+	})
+	return output, err
+}
+
+// DescribeLoadBalancersPagesWithContext - page through the load balancers, taking a token per page
+func (client *rateLimitedClient) DescribeLoadBalancersPagesWithContext(ctx aws.Context, input *elbv2.DescribeLoadBalancersInput, fn func(*elbv2.DescribeLoadBalancersOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := client.DescribeLoadBalancersWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(output.NextMarker) == ""
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.Marker = output.NextMarker
+	}
+}
+
+// withOption returns opts with one more option, leaving the caller's slice untouched
+func withOption(opts []request.Option, option request.Option) []request.Option {
+	return append(append(make([]request.Option, 0, len(opts)+1), opts...), option)
+}
@@ -0,0 +1,114 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/birdrides/nlb-attacher/pkg/aws/fake"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	cases := []struct {
+		name   string
+		maxQPS float64
+		// throttled are the outcomes of the calls in order, true for a throttling response
+		throttled []bool
+		want      float64
+	}{
+		{"success at the configured rate", 10, []bool{false}, 10},
+		{"throttled once", 10, []bool{true}, 5},
+		{"throttled twice", 10, []bool{true, true}, 2.5},
+		{"throttled down to the minimum", 10, []bool{true, true, true, true, true, true, true, true, true, true}, minimumQPS},
+		{"recovers after a throttle", 10, []bool{true, false, false}, 6},
+		{"recovers up to the configured rate", 10, []bool{true, false, false, false, false, false, false, false, false, false, false, false}, 10},
+		{"minimum above the configured rate", 0.05, []bool{true}, 0.05},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := newAPIRateLimiter(tc.maxQPS, 100)
+			for _, throttled := range tc.throttled {
+				limiter.call(context.Background(), "RegisterTargets", testTargetGroupA, func(traced request.Option) error {
+					if throttled {
+						return fake.ThrottlingError()
+					}
+					return nil
+				})
+			}
+			bucket, _ := limiter.bucket("RegisterTargets", testTargetGroupA)
+			if diff := bucket.current - tc.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("rate = %g, want %g", bucket.current, tc.want)
+			}
+			if got := float64(bucket.limiter.Limit()); got != bucket.current {
+				t.Errorf("limiter rate = %g, want the bucket's %g", got, bucket.current)
+			}
+		})
+	}
+}
+
+func TestAPIRateLimitReload(t *testing.T) {
+	cases := []struct {
+		name      string
+		throttled int
+		qps       float64
+		burst     int
+		want      float64
+	}{
+		{"raised rate keeps a throttled bucket down", 2, 20, 10, 2.5},
+		{"lowered rate caps a throttled bucket", 1, 1, 10, 1},
+		{"lowered rate caps an unthrottled bucket", 0, 2, 10, 2},
+		{"same rate with another burst", 0, 10, 1, 10},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := newAPIRateLimiter(10, 10)
+			for i := 0; i < tc.throttled; i++ {
+				limiter.call(context.Background(), "RegisterTargets", testTargetGroupA, func(traced request.Option) error {
+					return fake.ThrottlingError()
+				})
+			}
+			bucket, _ := limiter.bucket("RegisterTargets", testTargetGroupA)
+			// spend the burst, a reload must not hand it out again
+			for i := 0; i < 10; i++ {
+				bucket.limiter.Allow()
+			}
+			reloaded := bucket.limiter
+
+			limiter.update(tc.qps, tc.burst)
+			if bucket.limiter != reloaded {
+				t.Fatal("update replaced the limiter of the bucket")
+			}
+			if bucket.current != tc.want || float64(bucket.limiter.Limit()) != tc.want {
+				t.Errorf("rate = %g (limiter %g), want %g", bucket.current, float64(bucket.limiter.Limit()), tc.want)
+			}
+			if got := bucket.limiter.Burst(); got != tc.burst {
+				t.Errorf("burst = %d, want %d", got, tc.burst)
+			}
+			if bucket.limiter.Allow() {
+				t.Error("a token was available right after the reload, want the spent burst kept")
+			}
+		})
+	}
+}
+
+func TestRateLimitWaitPastDeadline(t *testing.T) {
+	limiter := newAPIRateLimiter(minimumQPS, 1)
+	noop := func(traced request.Option) error { return nil }
+	if err := limiter.call(context.Background(), "DescribeTargetGroupAttributes", testTargetGroupA, noop); err != nil {
+		t.Fatalf("first call error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	err := limiter.call(ctx, "DescribeTargetGroupAttributes", testTargetGroupA, noop)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call error = %v, want one wrapping %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("call waited %s for a token it could not get before the deadline", waited)
+	}
+}
@@ -0,0 +1,362 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var (
+	remediationsTotal = metrics.NewCounter(
+		"nlb_attacher_remediations_total",
+		"Remediations of pods that stayed unhealthy in a target group, by action and result.",
+		"target_group", "action", "result",
+	)
+	remediationsActive = metrics.NewGauge(
+		"nlb_attacher_remediations_active",
+		"Remediated pods that still exist and count towards max-concurrent-remediations.",
+	)
+)
+
+// remediation is the action taken on a pod that stayed unhealthy in a target group
+type remediation struct {
+	tgArn  string
+	action string
+	at     time.Time
+}
+
+// remediator tracks the remediations in effect, at most one per pod. A remediation ends when the pod is gone,
+// for labelled pods when the target group reports them healthy again, and for deregistered pods when their target
+// finished draining. Deregistered pods stay held out by their held-out annotation after that, so a restart keeps them out
+type remediator struct {
+	mutex  sync.Mutex
+	active map[types.UID]remediation
+}
+
+// SetClientset - set the clientset remediations label and evict pods with
+func (handler *Handler) SetClientset(clientset kubernetes.Interface) {
+	handler.clientset = clientset
+}
+
+// remediate acts on the pod when a target group with a remediation policy reported it unhealthy for longer than the policy allows
+func (handler *Handler) remediate(ctx context.Context, pod *v1.Pod, healths []targetHealth) {
+	if handler.config == nil {
+		return
+	}
+	cfg := handler.config.Get()
+
+	active, ok := handler.remediations.get(pod.UID)
+	if !ok && pod.Labels[config.UnhealthyLabelKey] == "true" {
+		// labelled before a restart. The target group is not known anymore, so the label is removed once
+		// every target group reports the pod healthy
+		active, ok = remediation{action: config.RemediationLabel, at: time.Now()}, true
+		handler.remediations.restore(pod.UID, active)
+	}
+	if ok {
+		if active.action == config.RemediationLabel && recovered(healths, active.tgArn) {
+			handler.recoverLabelled(pod, active)
+		}
+		return
+	}
+
+	assignments, err := handler.getPodTargetGroupAssignments(pod)
+	if err != nil {
+		return
+	}
+	for _, health := range healths {
+		if health.state != elbv2.TargetHealthStateEnumUnhealthy || handler.isPaused(health.tgArn) {
+			continue
+		}
+		assignment, ok := assignmentFor(assignments, health.tgArn)
+		if !ok {
+			continue
+		}
+		policy, ok := remediationPolicy(cfg, assignment)
+		if !ok {
+			continue
+		}
+		unhealthyFor := handler.health.unhealthyFor(pod, health)
+		if unhealthyFor < policy.After {
+			continue
+		}
+
+		trigger := audit.Trigger{
+			Event:  "remediation",
+			Key:    pod.Namespace + "/" + pod.Name,
+			Reason: fmt.Sprintf("unhealthy in %s for %s", health.tgArn, unhealthyFor.Round(time.Second)),
+		}
+		if err := handler.startRemediation(audit.WithTrigger(ctx, trigger), assignment, policy.Action, cfg.GetMaxConcurrentRemediations()); err != nil {
+			log.Warnf("Not remediating pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			return
+		}
+		log.Warnf("Remediated pod %s/%s with %s after %s unhealthy in target group %s", pod.Namespace, pod.Name, policy.Action, unhealthyFor.Round(time.Second), health.tgArn)
+		remediationsTotal.Inc(health.tgArn, policy.Action, "succeeded")
+		handler.recorder.Eventf(pod, v1.EventTypeWarning, "TargetRemediated",
+			"Target %s was unhealthy in target group %s for %s, remediated with %s", pod.Status.PodIP, health.tgArn, unhealthyFor.Round(time.Second), policy.Action)
+		return
+	}
+}
+
+// remediationPolicy returns how the pod is remediated in the target group: by the health options of its annotation
+// entry when it has them, else by the first matching configured policy
+func remediationPolicy(cfg *config.Config, assignment targetGroupPodAssignment) (config.RemediationPolicy, bool) {
+	health := assignment.options.Health
+	if health == nil || health.Remediation == "" {
+		return cfg.RemediationPolicyFor(assignment.tgArn)
+	}
+	if health.Remediation == annotation.RemediationNone || health.After == nil {
+		return config.RemediationPolicy{}, false
+	}
+	return config.RemediationPolicy{Pattern: assignment.tgArn, Action: health.Remediation, After: health.After.Duration}, true
+}
+
+// startRemediation reserves a remediation and takes the action, with registrations held off until the action is done
+func (handler *Handler) startRemediation(ctx context.Context, assignment targetGroupPodAssignment, action string, limit int) error {
+	pod, tgArn := assignment.pod, assignment.tgArn
+	active := remediation{tgArn: tgArn, action: action, at: time.Now()}
+	// registrations in flight finish first, later ones find the reservation and hold the pod out
+	handler.remediationMutex.Lock()
+	reserved := handler.remediations.reserve(pod.UID, active, limit)
+	handler.remediationMutex.Unlock()
+	if !reserved {
+		remediationsTotal.Inc(tgArn, action, "limited")
+		return fmt.Errorf("%d remediations are already in effect", limit)
+	}
+	if err := handler.runRemediation(ctx, assignment, action); err != nil {
+		handler.remediations.release(pod.UID)
+		remediationsTotal.Inc(tgArn, action, "failed")
+		return fmt.Errorf("failed to %s it: %v", action, err)
+	}
+	return nil
+}
+
+// runRemediation takes the action on the pod
+func (handler *Handler) runRemediation(ctx context.Context, assignment targetGroupPodAssignment, action string) error {
+	pod, tgArn := assignment.pod, assignment.tgArn
+	switch action {
+	case config.RemediationDeregister:
+		// the annotation goes first, so a pod deregistered by an attacher that then restarts stays out
+		if err := handler.setHeldOut(pod, tgArn); err != nil {
+			return err
+		}
+		if err := handler.deregisterTargets(ctx, assignment); err != nil {
+			return err
+		}
+		handler.setTargetState(pod, tgArn, annotation.StateHeldOut, nil)
+		return nil
+	case config.RemediationLabel:
+		return handler.setUnhealthyLabel(pod, true)
+	case config.RemediationEvict:
+		if handler.clientset == nil {
+			return fmt.Errorf("no clientset to evict with")
+		}
+		err := handler.clientset.CoreV1().Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		})
+		if apierrors.IsTooManyRequests(err) {
+			return fmt.Errorf("eviction is blocked by a pod disruption budget: %v", err)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown remediation action %q", action)
+}
+
+// recoverLabelled removes the unhealthy label once the target group reports the pod healthy again
+func (handler *Handler) recoverLabelled(pod *v1.Pod, active remediation) {
+	if err := handler.setUnhealthyLabel(pod, false); err != nil {
+		log.Errorf("Failed to remove the unhealthy label of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	handler.remediations.release(pod.UID)
+	if active.tgArn == "" {
+		log.Infof("Pod %s/%s is healthy in all its target groups again", pod.Namespace, pod.Name)
+		handler.recorder.Eventf(pod, v1.EventTypeNormal, "TargetRecovered",
+			"Target %s is healthy in all its target groups again, removed the %s label", pod.Status.PodIP, config.UnhealthyLabelKey)
+		return
+	}
+	log.Infof("Pod %s/%s is healthy in target group %s again", pod.Namespace, pod.Name, active.tgArn)
+	handler.recorder.Eventf(pod, v1.EventTypeNormal, "TargetRecovered",
+		"Target %s is healthy in target group %s again, removed the %s label", pod.Status.PodIP, active.tgArn, config.UnhealthyLabelKey)
+}
+
+// releaseHeldOut ends the deregister remediations started before checkedAt whose target finished draining, or whose
+// pod was let back in by removing the target group from its held-out annotation. It frees their slot of
+// max-concurrent-remediations, the annotation keeps holding the pods out
+func (handler *Handler) releaseHeldOut(checkedAt time.Time, pods map[types.UID]*v1.Pod, reported map[types.UID]map[string]bool) {
+	for uid, active := range handler.remediations.snapshot() {
+		if active.action != config.RemediationDeregister || !active.at.Before(checkedAt) {
+			continue
+		}
+		pod, ok := pods[uid]
+		if !ok {
+			continue
+		}
+		if reported[uid][active.tgArn] && annotation.IsHeldOut(pod, active.tgArn) {
+			continue
+		}
+		handler.remediations.release(uid)
+		log.Infof("Remediation of pod %s/%s in target group %s is done", pod.Namespace, pod.Name, active.tgArn)
+	}
+}
+
+// setHeldOut adds the target group to the held-out annotation of the pod
+func (handler *Handler) setHeldOut(pod *v1.Pod, tgArn string) error {
+	if annotation.IsHeldOut(pod, tgArn) {
+		return nil
+	}
+	if handler.clientset == nil {
+		return fmt.Errorf("no clientset to annotate with")
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				annotation.HeldOutKey: strings.Join(append(annotation.HeldOut(pod), tgArn), ","),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = handler.clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.MergePatchType, patch)
+	return err
+}
+
+// setUnhealthyLabel adds or removes the unhealthy label of the pod
+func (handler *Handler) setUnhealthyLabel(pod *v1.Pod, unhealthy bool) error {
+	if handler.clientset == nil {
+		return fmt.Errorf("no clientset to label with")
+	}
+	var value interface{}
+	if unhealthy {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{config.UnhealthyLabelKey: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = handler.clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.MergePatchType, patch)
+	return err
+}
+
+// holdsOut reports whether a remediation deregistered the pod from the target group, so it must not be registered again.
+// The remediation in effect covers the pod until the informer has seen its held-out annotation
+func (handler *Handler) holdsOut(pod *v1.Pod, tgArn string) bool {
+	if annotation.IsHeldOut(pod, tgArn) {
+		return true
+	}
+	active, ok := handler.remediations.get(pod.UID)
+	return ok && active.action == config.RemediationDeregister && active.tgArn == tgArn
+}
+
+// recovered reports whether the target group reports the pod healthy, or every target group when tgArn is empty
+func recovered(healths []targetHealth, tgArn string) bool {
+	if tgArn != "" {
+		return healthyIn(healths, tgArn)
+	}
+	for _, health := range healths {
+		if health.state != elbv2.TargetHealthStateEnumHealthy {
+			return false
+		}
+	}
+	return len(healths) > 0
+}
+
+// healthyIn reports whether the target group reports every target of the pod healthy
+func healthyIn(healths []targetHealth, tgArn string) bool {
+	found := false
+	for _, health := range healths {
+		if health.tgArn != tgArn {
+			continue
+		}
+		if health.state != elbv2.TargetHealthStateEnumHealthy {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+func (remediations *remediator) get(uid types.UID) (remediation, bool) {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	active, ok := remediations.active[uid]
+	return active, ok
+}
+
+// reserve records the remediation unless the limit of remediations in effect is reached
+func (remediations *remediator) reserve(uid types.UID, active remediation, limit int) bool {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	if remediations.active == nil {
+		remediations.active = make(map[types.UID]remediation)
+	}
+	if len(remediations.active) >= limit {
+		return false
+	}
+	remediations.active[uid] = active
+	remediationsActive.Set(float64(len(remediations.active)))
+	return true
+}
+
+// restore records a remediation found on the pod, regardless of the limit since it is already in effect
+func (remediations *remediator) restore(uid types.UID, active remediation) {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	if remediations.active == nil {
+		remediations.active = make(map[types.UID]remediation)
+	}
+	remediations.active[uid] = active
+	remediationsActive.Set(float64(len(remediations.active)))
+}
+
+// snapshot returns a copy of the remediations in effect
+func (remediations *remediator) snapshot() map[types.UID]remediation {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	active := make(map[types.UID]remediation, len(remediations.active))
+	for uid, remediation := range remediations.active {
+		active[uid] = remediation
+	}
+	return active
+}
+
+func (remediations *remediator) release(uid types.UID) {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	delete(remediations.active, uid)
+	remediationsActive.Set(float64(len(remediations.active)))
+}
+
+// forget ends the remediations of pods that no longer exist
+func (remediations *remediator) forget(live map[types.UID]bool) {
+	remediations.mutex.Lock()
+	defer remediations.mutex.Unlock()
+	for uid := range remediations.active {
+		if !live[uid] {
+			delete(remediations.active, uid)
+		}
+	}
+	remediationsActive.Set(float64(len(remediations.active)))
+}
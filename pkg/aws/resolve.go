@@ -0,0 +1,135 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+)
+
+// targetGroupPodAssignment is one target group entry of a pod's annotation, resolved to the target group ARN
+type targetGroupPodAssignment struct {
+	tgArn        string
+	podIPAddress string
+	pod          *v1.Pod
+	// ref is the name or alias the annotation references the target group by, empty for an ARN
+	ref string
+	// port is the port the pod is registered on, 0 for the port of the target group
+	port    int64
+	options annotation.TargetGroup
+	// selected is false when the entry's weight, subset or readiness options leave the pod out, for the reason given
+	selected bool
+	reason   string
+	// err is why the reference did not resolve to a target group, tgArn is empty then
+	err error
+}
+
+// key identifies the assignment in the pod's status: the ARN, or the reference while it does not resolve
+func (assignment targetGroupPodAssignment) key() string {
+	if assignment.err != nil {
+		return assignment.ref
+	}
+	return assignment.tgArn
+}
+
+// resolveTargetGroup returns the ARN of the target group the annotation entry references
+func (handler *Handler) resolveTargetGroup(ctx context.Context, targetGroup annotation.TargetGroup) (string, error) {
+	switch {
+	case targetGroup.Arn != "":
+		return targetGroup.Arn, nil
+	case targetGroup.Name != "":
+		if handler.catalog == nil {
+			return "", handlers.NewTerminal(fmt.Errorf("no target group catalog to resolve target group name %q with", targetGroup.Name))
+		}
+		return handler.catalog.arnForName(ctx, targetGroup.Name)
+	}
+	if handler.aliases == nil {
+		return "", handlers.NewTerminal(fmt.Errorf("target group alias %q is not defined, no alias-config-map is configured", targetGroup.Alias))
+	}
+	tgArn, ok := handler.aliases.Resolve(targetGroup.Alias)
+	if !ok {
+		return "", handlers.NewTerminal(fmt.Errorf("target group alias %q is not defined in config map %s", targetGroup.Alias, handler.aliases.Source()))
+	}
+	return tgArn, nil
+}
+
+// SetAliases - set the store target group aliases are resolved with. A nil store defines no aliases
+func (handler *Handler) SetAliases(store *alias.Store) {
+	handler.aliases = store
+}
+
+// arnForName returns the ARN of the target group with the given name, describing it when it is not cached
+func (catalog *targetGroupCatalog) arnForName(ctx context.Context, name string) (string, error) {
+	catalog.mutex.RLock()
+	for tgArn, info := range catalog.targetGroups {
+		if info.Name == name {
+			catalog.mutex.RUnlock()
+			return tgArn, nil
+		}
+	}
+	catalog.mutex.RUnlock()
+
+	result, err := catalog.client.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		Names: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return "", classifyError("DescribeTargetGroups", name, err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return "", handlers.NewTerminal(fmt.Errorf("target group %q does not exist", name))
+	}
+
+	info := newTargetGroupInfo(result.TargetGroups[0], time.Now())
+	catalog.mutex.Lock()
+	catalog.targetGroups[info.Arn] = info
+	catalog.mutex.Unlock()
+	return info.Arn, nil
+}
+
+// resolved returns the assignments whose reference resolved to a target group
+func resolved(assignments []targetGroupPodAssignment) []targetGroupPodAssignment {
+	result := make([]targetGroupPodAssignment, 0, len(assignments))
+	for _, assignment := range assignments {
+		if assignment.err == nil {
+			result = append(result, assignment)
+		}
+	}
+	return result
+}
+
+// selected returns the resolved assignments the pod is to be registered with
+func selected(assignments []targetGroupPodAssignment) []targetGroupPodAssignment {
+	result := make([]targetGroupPodAssignment, 0, len(assignments))
+	for _, assignment := range assignments {
+		if assignment.err == nil && assignment.selected {
+			result = append(result, assignment)
+		}
+	}
+	return result
+}
+
+// assignmentFor returns the pod's assignment to the target group, if it has one
+func assignmentFor(assignments []targetGroupPodAssignment, tgArn string) (targetGroupPodAssignment, bool) {
+	for _, assignment := range assignments {
+		if assignment.err == nil && assignment.tgArn == tgArn {
+			return assignment, true
+		}
+	}
+	return targetGroupPodAssignment{}, false
+}
+
+// targetPort returns the port the assignment registers the pod on, as far as it is known
+func (handler *Handler) targetPort(assignment targetGroupPodAssignment) int64 {
+	if assignment.port != 0 {
+		return assignment.port
+	}
+	return handler.targetGroupPort(assignment.tgArn)
+}
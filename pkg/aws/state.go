@@ -0,0 +1,30 @@
+package aws
+
+import (
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// SetStateStore - set the store the registered targets are persisted in. A nil store persists nothing
+func (handler *Handler) SetStateStore(store *state.Store) {
+	handler.state = store
+}
+
+// recordRegistered persists the targets registered with the target group
+func (handler *Handler) recordRegistered(tgArn string, assignments []targetGroupPodAssignment) {
+	for _, assignment := range assignments {
+		handler.state.Registered(tgArn, assignment.pod, handler.targetPort(assignment))
+	}
+}
+
+// targetGroupPort returns the port targets are registered on, as far as the catalog knows it without describing the target group
+func (handler *Handler) targetGroupPort(tgArn string) int64 {
+	if handler.catalog == nil {
+		return 0
+	}
+	handler.catalog.mutex.RLock()
+	defer handler.catalog.mutex.RUnlock()
+	if info, ok := handler.catalog.targetGroups[tgArn]; ok {
+		return info.Port
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package aws
+
+import (
+	"encoding/json"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+)
+
+// statusTracker keeps the status of every pod the handler reported on, so registrations and health checks
+// update their own fields without overwriting each other's
+type statusTracker struct {
+	mutex sync.Mutex
+	pods  map[types.UID]map[string]annotation.TargetStatus
+}
+
+// setTargetRef records how the pod references the target group of the assignment, and the port it is registered on
+func (handler *Handler) setTargetRef(pod *v1.Pod, assignment targetGroupPodAssignment) {
+	handler.statuses.update(pod, assignment.key(), func(target *annotation.TargetStatus) {
+		target.Arn = assignment.tgArn
+		target.Ref = assignment.ref
+		if assignment.err == nil {
+			target.Port = handler.targetPort(assignment)
+		}
+	})
+}
+
+// setTargetState records the state of the pod's target in the target group and the error that caused it, if any.
+// An empty state keeps the current one. The states decide what later events do, the errors only show in the status annotation
+func (handler *Handler) setTargetState(pod *v1.Pod, tgArn string, state string, err error) {
+	if state == "" && !handler.statusAnnotation() {
+		return
+	}
+	handler.statuses.update(pod, tgArn, func(target *annotation.TargetStatus) {
+		now := metav1.Now()
+		if target.Port == 0 {
+			target.Port = handler.targetGroupPort(tgArn)
+		}
+		if state != "" && target.State != state {
+			// the health belonged to the previous state, the next health check reports the current one
+			target.State = state
+			target.Since = now
+			target.Health = ""
+			target.HealthReason = ""
+		}
+		// a retry failing the same way keeps the time the error first occurred, so retries do not rewrite the annotation
+		if err != nil && err.Error() != target.LastError {
+			target.LastError = err.Error()
+			target.LastErrorAt = &now
+		}
+	})
+}
+
+// setTargetHealth records the health the target group reported for the pod, for its status annotation
+func (handler *Handler) setTargetHealth(pod *v1.Pod, health targetHealth) {
+	if !handler.statusAnnotation() {
+		return
+	}
+	handler.statuses.update(pod, health.tgArn, func(target *annotation.TargetStatus) {
+		target.Health = health.state
+		target.HealthReason = health.reason
+	})
+}
+
+// statusAnnotation reports whether the status of each pod's targets is written to its status annotation
+func (handler *Handler) statusAnnotation() bool {
+	return handler.clientset != nil && handler.config != nil && handler.config.Get().GetStatusAnnotation()
+}
+
+// writeStatus writes the recorded status of the pod's current target groups to its status annotation, when it changed
+func (handler *Handler) writeStatus(pod *v1.Pod) {
+	if !handler.statusAnnotation() {
+		return
+	}
+	if pod.DeletionTimestamp != nil {
+		return
+	}
+	assignments, err := handler.getPodTargetGroupAssignments(pod)
+	if err != nil {
+		return
+	}
+	current := make(map[string]bool, len(assignments))
+	refs := make(map[string]bool, len(assignments))
+	for _, assignment := range assignments {
+		current[assignment.key()] = true
+		if assignment.ref != "" {
+			refs[assignment.ref] = true
+		}
+	}
+
+	status := handler.statuses.status(pod, current, refs)
+	var value interface{}
+	if len(status.TargetGroups) > 0 {
+		encoded, err := status.Encode()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if encoded == pod.GetAnnotations()[annotation.StatusKey] {
+			return
+		}
+		value = encoded
+	} else if _, ok := pod.GetAnnotations()[annotation.StatusKey]; !ok {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{annotation.StatusKey: value},
+		},
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	_, err = handler.clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.MergePatchType, patch)
+	if apierrors.IsNotFound(err) {
+		log.Debugf("Pod %s/%s is gone, not writing its status", pod.Namespace, pod.Name)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to write the status annotation of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+// update applies fn to the recorded status of the pod's target in the target group, keyed by its ARN or, while it
+// does not resolve, its reference. The first update of a pod starts from its annotation, so a restarted attacher
+// keeps the last errors and the times states began
+func (tracker *statusTracker) update(pod *v1.Pod, key string, fn func(*annotation.TargetStatus)) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	targets := tracker.targets(pod)
+	target, ok := targets[key]
+	if !ok {
+		target.Arn = key
+	}
+	fn(&target)
+	targets[key] = target
+}
+
+// targets returns the recorded targets of the pod, starting from its annotation. The caller holds the mutex
+func (tracker *statusTracker) targets(pod *v1.Pod) map[string]annotation.TargetStatus {
+	if tracker.pods == nil {
+		tracker.pods = make(map[types.UID]map[string]annotation.TargetStatus)
+	}
+	targets, ok := tracker.pods[pod.UID]
+	if !ok {
+		targets = make(map[string]annotation.TargetStatus)
+		if previous, _, err := annotation.ParseStatus(pod); err == nil {
+			for _, target := range previous.TargetGroups {
+				if target.Arn != "" {
+					targets[target.Arn] = target
+				} else {
+					targets[target.Ref] = target
+				}
+			}
+		}
+		tracker.pods[pod.UID] = targets
+	}
+	return targets
+}
+
+// retargeted returns the recorded targets of the pod in target groups that its name or alias references no longer resolve to
+func (tracker *statusTracker) retargeted(pod *v1.Pod, assignments []targetGroupPodAssignment) []annotation.TargetStatus {
+	current := make(map[string]string)
+	for _, assignment := range resolved(assignments) {
+		if assignment.ref != "" {
+			current[assignment.ref] = assignment.tgArn
+		}
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	stale := make([]annotation.TargetStatus, 0)
+	// a pod without recorded targets has none to leave, recording it here would only grow the tracker
+	targets := tracker.pods[pod.UID]
+	if targets == nil && pod.GetAnnotations()[annotation.StatusKey] != "" {
+		targets = tracker.targets(pod)
+	}
+	for _, target := range targets {
+		if target.Ref == "" || target.Arn == "" {
+			continue
+		}
+		if tgArn, ok := current[target.Ref]; ok && tgArn != target.Arn {
+			stale = append(stale, target)
+		}
+	}
+	return stale
+}
+
+// remove drops the recorded target of the pod
+func (tracker *statusTracker) remove(pod *v1.Pod, key string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	delete(tracker.pods[pod.UID], key)
+}
+
+// state returns the recorded state of the pod's target in the target group
+func (tracker *statusTracker) state(pod *v1.Pod, key string) string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.pods[pod.UID][key].State
+}
+
+// status returns the recorded status of the pod's target groups in current, forgetting the others. Targets of
+// references in refs that resolved to another target group are kept until leaveRetargeted deregisters them
+func (tracker *statusTracker) status(pod *v1.Pod, current map[string]bool, refs map[string]bool) annotation.Status {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	status := annotation.Status{TargetGroups: make([]annotation.TargetStatus, 0)}
+	for key, target := range tracker.pods[pod.UID] {
+		retargeted := target.Arn != "" && target.Ref != "" && refs[target.Ref]
+		if !current[key] && !retargeted {
+			delete(tracker.pods[pod.UID], key)
+			continue
+		}
+		if target.State != "" {
+			status.TargetGroups = append(status.TargetGroups, target)
+		}
+	}
+	return status
+}
+
+// forgetPod drops the status of a deleted or terminating pod
+func (tracker *statusTracker) forgetPod(uid types.UID) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	delete(tracker.pods, uid)
+}
+
+// forget drops the status of pods that no longer exist
+func (tracker *statusTracker) forget(live map[types.UID]bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for uid := range tracker.pods {
+		if !live[uid] {
+			delete(tracker.pods, uid)
+		}
+	}
+}
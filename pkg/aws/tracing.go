@@ -0,0 +1,25 @@
+package aws
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+)
+
+// startPodSpan starts the span of a handler call about the pod
+func startPodSpan(ctx context.Context, name string, pod *v1.Pod) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "aws."+name, tracing.KindInternal,
+		tracing.String("k8s.namespace.name", pod.Namespace),
+		tracing.String("k8s.pod.name", pod.Name),
+		tracing.String("k8s.pod.uid", string(pod.UID)),
+		tracing.String("k8s.pod.ip", pod.Status.PodIP),
+	)
+}
+
+// endSpan records the error of the call on the span and ends it
+func endSpan(span *tracing.Span, err error) {
+	span.RecordError(err)
+	span.End()
+}
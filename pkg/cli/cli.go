@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/deployable"
+)
+
+// command is a subcommand of the binary. Its arguments are the configuration flags, plus the command's own
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{name: "run", summary: "run the controller (the default when no command is given)", run: runDaemon},
+	{name: "status", summary: "print the desired and actual targets of every target group", run: runStatus},
+	{name: "plan", summary: "print the changes a reconcile would make", run: runPlan},
+	{name: "gc", summary: "print the stale targets, and deregister them with --apply", run: runGC},
+}
+
+// Main - run the command named by the first argument and return the exit code. Arguments starting with a flag run the controller,
+// so deployments passing only flags keep working
+func Main(args []string) int {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage(os.Stdout)
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args)
+		if err == flag.ErrHelp {
+			return 0
+		}
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: nlb-attacher [command] [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Every command takes the controller's configuration flags. Run a command with --help to list them.")
+}
+
+// runDaemon runs the controller until it is told to shut down
+func runDaemon(args []string) error {
+	configStore, err := config.NewStore(args)
+	if err != nil {
+		return err
+	}
+
+	log.SetLevel(configStore.Get().GetLogLevel())
+	log.WithFields(configStore.Get().Fields()).Info("Loaded configuration")
+
+	app := deployable.NewDeployable(configStore)
+	return app.Run()
+}
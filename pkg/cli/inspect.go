@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/aws"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/controller"
+)
+
+// applyFlag makes gc deregister the stale targets instead of only printing them
+const applyFlag = "apply"
+
+// Environment is what the inspecting commands read from and print to. A nil ELB uses the default AWS session
+type Environment struct {
+	Config    *config.Config
+	Clientset kubernetes.Interface
+	ELB       elbv2iface.ELBV2API
+	// Audit records the deregistrations of gc --apply, when set
+	Audit audit.Sink
+	Out   io.Writer
+}
+
+// Summary counts the targets of a plan by what would happen to them
+type Summary struct {
+	Register   int
+	Deregister int
+	Unchanged  int
+	Unmanaged  int
+}
+
+// Status - print a table of the desired and actual targets of every target group the managed pods reference
+func Status(ctx context.Context, env Environment) error {
+	statuses, err := inspect(ctx, env)
+
+	table := tabwriter.NewWriter(env.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TARGET GROUP\tTARGET\tPOD\tDESIRED\tREGISTERED\tHEALTH")
+	for _, status := range statuses {
+		if status.Err != nil {
+			fmt.Fprintf(table, "%s\t-\t-\t-\t-\terror: %v\n", status.Name, status.Err)
+			continue
+		}
+		name := status.Name
+		if status.Paused {
+			name += " (paused)"
+		}
+		if len(status.Targets) == 0 {
+			fmt.Fprintf(table, "%s\t-\t-\t-\t-\t-\n", name)
+		}
+		for _, target := range status.Targets {
+			fmt.Fprintf(table, "%s\t%s:%d\t%s\t%s\t%s\t%s\n", name, target.IP, target.Port,
+				orDash(target.Pod), yesNo(target.Desired), yesNo(target.Registered), orDash(target.Health))
+		}
+	}
+	if flushErr := table.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// Plan - print what a reconcile would register and deregister, and return the counts
+func Plan(ctx context.Context, env Environment) (Summary, error) {
+	statuses, err := inspect(ctx, env)
+	summary := writePlan(env.Out, statuses)
+	return summary, err
+}
+
+// GC - print the stale targets, the registered targets of known pods that no longer want them, and deregister them when apply is set.
+// It returns how many targets were deregistered. Targets of no known pod are never touched
+func GC(ctx context.Context, env Environment, apply bool) (int, error) {
+	statuses, err := inspect(ctx, env)
+	stale := make([]aws.TargetGroupStatus, 0, len(statuses))
+	for _, status := range statuses {
+		targets := make([]aws.TargetStatus, 0)
+		for _, target := range status.Targets {
+			if target.Change() == aws.ChangeDeregister {
+				targets = append(targets, target)
+			}
+		}
+		if len(targets) > 0 {
+			status.Targets = targets
+			stale = append(stale, status)
+		}
+	}
+
+	summary := writePlan(env.Out, stale)
+	if !apply || summary.Deregister == 0 {
+		if summary.Deregister > 0 {
+			fmt.Fprintf(env.Out, "\nRun again with --%s to deregister them.\n", applyFlag)
+		}
+		return 0, err
+	}
+
+	inspector := newInspector(env, nil)
+	removed, removeErr := inspector.RemoveStale(ctx, stale, audit.Trigger{Event: "gc", Reason: "stale targets removed by nlb-attacher gc"})
+	fmt.Fprintf(env.Out, "\nDeregistered %d of %d stale targets.\n", removed, summary.Deregister)
+	if removeErr != nil {
+		return removed, removeErr
+	}
+	return removed, err
+}
+
+// inspect lists the managed pods and the recorded owners, pauses and detaches, and describes the target groups they reference
+func inspect(ctx context.Context, env Environment) ([]aws.TargetGroupStatus, error) {
+	pods, err := controller.ManagedPods(env.Config, env.Clientset)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := controller.RecordedState(env.Config, env.Clientset)
+	if err != nil {
+		return nil, err
+	}
+	inspector := newInspector(env, targetGroupAliases(env))
+	inspector.SetPaused(recorded.PausedTargetGroups())
+	detached := make([]types.UID, 0)
+	for uid, detachment := range recorded.Detachments() {
+		if detachment.Active(time.Now()) {
+			detached = append(detached, uid)
+		}
+	}
+	inspector.SetDetached(detached)
+	store, namespaces, err := controller.TargetGroupPolicy(env.Config, env.Clientset)
+	if err != nil {
+		// a policy that cannot be read denies everything, as it does in the controller
+		log.Warnf("Target group policy is not read: %v", err)
+	}
+	inspector.SetPolicy(store, namespaces)
+	return inspector.Inspect(ctx, pods, recorded.Owners(), env.Config.TargetGroupAllowed)
+}
+
+// newInspector returns an inspector for the environment resolving the aliases of the store, none when it is nil
+func newInspector(env Environment, aliases *alias.Store) *aws.Inspector {
+	inspector := aws.NewInspector(env.ELB, env.Config.GetTargetGroupAnnotationKey(), env.Config.GetVPCID())
+	if env.Audit != nil {
+		inspector.SetAuditSink(env.Audit)
+	}
+	inspector.SetAliases(aliases)
+	return inspector
+}
+
+// targetGroupAliases loads the aliases of the alias config map. Pods referencing an alias are left out when it cannot be read
+func targetGroupAliases(env Environment) *alias.Store {
+	aliases, err := controller.TargetGroupAliases(env.Config, env.Clientset)
+	if err != nil {
+		log.Warnf("Target group aliases are not resolved: %v", err)
+	}
+	return aliases
+}
+
+// writePlan prints the changes per target group, terraform style, followed by their counts
+func writePlan(out io.Writer, statuses []aws.TargetGroupStatus) Summary {
+	summary := Summary{}
+	for _, status := range statuses {
+		if status.Err != nil {
+			fmt.Fprintf(out, "! %s: %v\n", status.Name, status.Err)
+			continue
+		}
+		lines := make([]string, 0)
+		for _, target := range status.Targets {
+			switch target.Change() {
+			case aws.ChangeRegister:
+				summary.Register++
+				lines = append(lines, fmt.Sprintf("  + %s:%d  %s", target.IP, target.Port, target.Pod))
+			case aws.ChangeDeregister:
+				summary.Deregister++
+				lines = append(lines, fmt.Sprintf("  - %s:%d  %s", target.IP, target.Port, target.Pod))
+			default:
+				if target.Unmanaged() {
+					summary.Unmanaged++
+				} else {
+					summary.Unchanged++
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "%s (%s)\n%s\n", status.Name, status.Arn, strings.Join(lines, "\n"))
+	}
+	fmt.Fprintf(out, "\nPlan: %d to register, %d to deregister, %d unchanged, %d unmanaged.\n",
+		summary.Register, summary.Deregister, summary.Unchanged, summary.Unmanaged)
+	return summary
+}
+
+func runStatus(args []string) error {
+	env, closeAudit, err := environment(args)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+	return Status(context.Background(), env)
+}
+
+func runPlan(args []string) error {
+	env, closeAudit, err := environment(args)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+	_, err = Plan(context.Background(), env)
+	return err
+}
+
+func runGC(args []string) error {
+	apply := false
+	configArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--"+applyFlag || arg == "-"+applyFlag {
+			apply = true
+			continue
+		}
+		configArgs = append(configArgs, arg)
+	}
+
+	env, closeAudit, err := environment(configArgs)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+	_, err = GC(context.Background(), env, apply)
+	return err
+}
+
+// environment loads the configuration from the arguments and connects to the cluster from the kubeconfig.
+// Logs go to stderr so the output stays readable
+func environment(args []string) (Environment, func(), error) {
+	log.SetOutput(os.Stderr)
+	log.SetFormatter(&log.TextFormatter{})
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return Environment{}, nil, err
+	}
+	log.SetLevel(log.WarnLevel)
+	if cfg.GetLogLevel() > log.WarnLevel {
+		log.SetLevel(cfg.GetLogLevel())
+	}
+
+	env := Environment{Config: cfg, Clientset: controller.NewClientset(), Out: os.Stdout}
+	closeAudit := func() {}
+	if path := cfg.GetAuditLog(); path != "" {
+		sink, err := audit.Open(path)
+		if err != nil {
+			return Environment{}, nil, err
+		}
+		env.Audit = sink
+		closeAudit = func() {
+			if err := sink.Close(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	return env, closeAudit, nil
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,373 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/aws"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/controller"
+)
+
+// Where the plugin read an attachment from
+const (
+	SourceAnnotation = "annotation"
+	SourceAWS        = "aws"
+)
+
+// Selection is the pod, deployment or namespace the plugin reports on
+type Selection struct {
+	Kind      string
+	Name      string
+	Namespace string
+}
+
+// Attachment is the state of one opted in pod in one of its target groups
+type Attachment struct {
+	Pod         string
+	TargetGroup string
+	Port        int64
+	State       string
+	Health      string
+	Reason      string
+	LastError   string
+	Source      string
+}
+
+// Attachments - return the attachments of every opted in pod of the selection. They are read from the pods' status annotations,
+// and from ELBv2 for pods the attacher wrote none for, or for every pod when fromAWS is set
+func Attachments(ctx context.Context, env Environment, selection Selection, fromAWS bool) ([]Attachment, error) {
+	pods, err := selectPods(env, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]Attachment, 0)
+	unreported := make([]*v1.Pod, 0)
+	for _, pod := range pods {
+		status, ok, err := annotation.ParseStatus(pod)
+		if fromAWS || !ok || err != nil {
+			unreported = append(unreported, pod)
+			continue
+		}
+		attachments = append(attachments, reportedAttachments(env.Config, pod, status)...)
+	}
+
+	if len(unreported) > 0 {
+		attachments = append(attachments, queriedAttachments(ctx, env, unreported)...)
+	}
+	sort.SliceStable(attachments, func(i, j int) bool { return attachments[i].Pod < attachments[j].Pod })
+	return attachments, nil
+}
+
+// WriteAttachments - print the attachments as a table
+func WriteAttachments(out io.Writer, attachments []Attachment) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "POD\tTARGET GROUP\tPORT\tSTATE\tHEALTH\tREASON\tLAST ERROR\tSOURCE")
+	for _, attachment := range attachments {
+		port := "-"
+		if attachment.Port != 0 {
+			port = fmt.Sprint(attachment.Port)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", attachment.Pod, orDash(attachment.TargetGroup), port,
+			orDash(attachment.State), orDash(attachment.Health), orDash(attachment.Reason), orDash(attachment.LastError), attachment.Source)
+	}
+	return table.Flush()
+}
+
+// selectPods returns the opted in pods of the selection
+func selectPods(env Environment, selection Selection) ([]*v1.Pod, error) {
+	api := env.Clientset.CoreV1().Pods(selection.Namespace)
+	enabled := labels.Set{env.Config.GetEnabledLabelKey(): "true"}
+
+	switch selection.Kind {
+	case "pod":
+		pod, err := api.Get(selection.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !enabled.AsSelector().Matches(labels.Set(pod.Labels)) {
+			return nil, fmt.Errorf("pod %s/%s does not have the %s=true label", pod.Namespace, pod.Name, env.Config.GetEnabledLabelKey())
+		}
+		return []*v1.Pod{pod}, nil
+	case "deployment":
+		deployment, err := env.Clientset.AppsV1().Deployments(selection.Namespace).Get(selection.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of deployment %s/%s: %v", selection.Namespace, selection.Name, err)
+		}
+		requirements, _ := enabled.AsSelector().Requirements()
+		return listPods(env, selection.Namespace, selector.Add(requirements...).String())
+	case "namespace":
+		return listPods(env, selection.Namespace, enabled.AsSelector().String())
+	}
+	return nil, fmt.Errorf("unknown kind %q, use pod, deployment or namespace", selection.Kind)
+}
+
+func listPods(env Environment, namespace string, selector string) ([]*v1.Pod, error) {
+	list, err := env.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	pods := make([]*v1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	return pods, nil
+}
+
+// reportedAttachments returns the pod's target groups as its status annotation reports them
+func reportedAttachments(cfg *config.Config, pod *v1.Pod, status annotation.Status) []Attachment {
+	key := pod.Namespace + "/" + pod.Name
+	targetGroups, err := podTargetGroups(cfg, pod)
+	if err != nil {
+		return []Attachment{{Pod: key, LastError: err.Error(), Source: SourceAnnotation}}
+	}
+
+	reported := make(map[string]annotation.TargetStatus, len(status.TargetGroups))
+	for _, target := range status.TargetGroups {
+		if target.Ref != "" {
+			reported[target.Ref] = target
+		} else {
+			reported[target.Arn] = target
+		}
+	}
+	attachments := make([]Attachment, 0, len(targetGroups))
+	for _, tgArn := range targetGroups {
+		target, ok := reported[tgArn]
+		attachment := Attachment{Pod: key, TargetGroup: targetGroupName(tgArn), Source: SourceAnnotation}
+		if !ok {
+			// the annotation changed after the attacher last handled the pod
+			attachment.State = "pending"
+		} else {
+			attachment.Port = target.Port
+			attachment.State = target.State
+			attachment.Health = target.Health
+			attachment.Reason = target.HealthReason
+			attachment.LastError = target.LastError
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// queriedAttachments describes the target groups of the pods in ELBv2, for pods the attacher reported nothing for
+func queriedAttachments(ctx context.Context, env Environment, pods []*v1.Pod) []Attachment {
+	aliases := targetGroupAliases(env)
+	statuses, err := newInspector(env, aliases).Inspect(ctx, pods, nil, nil)
+	if err != nil {
+		log.Debugf("Some target groups could not be described: %v", err)
+	}
+	// target group -> ip:port -> target, so each port of a pod in one target group is its own row
+	targets := make(map[string]map[string]aws.TargetStatus)
+	ports := make(map[string]int64)
+	errs := make(map[string]error)
+	for _, status := range statuses {
+		byTarget := make(map[string]aws.TargetStatus)
+		for _, target := range status.Targets {
+			byTarget[fmt.Sprintf("%s:%d", target.IP, target.Port)] = target
+		}
+		// pods reference target groups by ARN or name
+		for _, ref := range []string{status.Arn, status.Name} {
+			errs[ref] = status.Err
+			ports[ref] = status.Port
+			targets[ref] = byTarget
+		}
+	}
+
+	attachments := make([]Attachment, 0)
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		entries, err := podEntries(env.Config, pod)
+		if err != nil {
+			attachments = append(attachments, Attachment{Pod: key, LastError: err.Error(), Source: SourceAWS})
+			continue
+		}
+		for _, entry := range entries {
+			tgArn := entry.Ref()
+			attachment := Attachment{Pod: key, TargetGroup: targetGroupName(tgArn), Source: SourceAWS}
+			lookup := tgArn
+			if _, ok := targets[lookup]; !ok {
+				// or by alias
+				if aliased, ok := aliases.Resolve(tgArn); ok {
+					lookup = aliased
+				}
+			}
+			port := entry.TargetPort(pod)
+			if port == 0 {
+				port = ports[lookup]
+			}
+			target, registered := targets[lookup][fmt.Sprintf("%s:%d", pod.Status.PodIP, port)]
+			switch {
+			case errs[lookup] != nil:
+				attachment.State = "unknown"
+				attachment.LastError = errs[lookup].Error()
+			case pod.Status.PodIP == "":
+				attachment.State = "no-ip"
+			case !registered:
+				attachment.State = "not-registered"
+			case target.Health == elbv2.TargetHealthStateEnumDraining:
+				attachment.State = "draining"
+			default:
+				attachment.State = annotation.StateRegistered
+			}
+			if registered {
+				attachment.Port = target.Port
+				attachment.Health = target.Health
+				attachment.Reason = target.HealthReason
+			}
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// podEntries returns the entries of the pod's target groups annotation
+func podEntries(cfg *config.Config, pod *v1.Pod) ([]annotation.TargetGroup, error) {
+	value, ok := pod.GetAnnotations()[cfg.GetTargetGroupAnnotationKey()]
+	if !ok {
+		return nil, nil
+	}
+	return annotation.Parse(value)
+}
+
+// podTargetGroups returns the target group references the pod's annotation lists: ARNs, names or aliases
+func podTargetGroups(cfg *config.Config, pod *v1.Pod) ([]string, error) {
+	parsed, err := podEntries(cfg, pod)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]string, 0, len(parsed))
+	for _, targetGroup := range parsed {
+		refs = append(refs, targetGroup.Ref())
+	}
+	return refs, nil
+}
+
+// targetGroupName returns the name part of a target group ARN, e.g. my-tg of arn:...:targetgroup/my-tg/73e2d6bc24d8a067
+func targetGroupName(tgArn string) string {
+	parts := strings.Split(tgArn, "/")
+	if len(parts) == 3 && strings.HasSuffix(parts[0], ":targetgroup") {
+		return parts[1]
+	}
+	return tgArn
+}
+
+// Plugin - run the kubectl nlb plugin and return the exit code
+func Plugin(args []string) int {
+	log.SetOutput(os.Stderr)
+	log.SetFormatter(&log.TextFormatter{})
+	log.SetLevel(log.WarnLevel)
+
+	flags := flag.NewFlagSet("kubectl nlb", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: kubectl nlb [pod NAME | deployment NAME | namespace NAME] [flags]")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Shows the target groups of opted in pods with their state, health and last error. Without arguments it shows every opted in pod of the namespace.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	namespace := ""
+	flags.StringVar(&namespace, "n", "", "namespace (default the current context's)")
+	flags.StringVar(&namespace, "namespace", "", "namespace (default the current context's)")
+	fromAWS := flags.Bool("aws", false, "query ELBv2 instead of reading the status annotations")
+	labelKey := flags.String("enabled-label-key", config.DefaultEnabledLabelKey, "label pods set to \"true\" to opt in")
+	annotationKey := flags.String("target-group-annotation-key", config.DefaultTargetGroupAnnotationKey, "annotation listing the target groups of a pod")
+
+	positional, err := parseInterspersed(flags, args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if namespace == "" {
+		namespace = controller.CurrentNamespace()
+	}
+
+	selection, err := parseSelection(positional, namespace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load([]string{"--enabled-label-key=" + *labelKey, "--target-group-annotation-key=" + *annotationKey})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	env := Environment{Config: cfg, Clientset: controller.NewClientset(), Out: os.Stdout}
+	attachments, err := Attachments(context.Background(), env, selection, *fromAWS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(attachments) == 0 {
+		fmt.Fprintf(os.Stderr, "No opted in pods found in %s %s\n", selection.Kind, selection.Name)
+		return 0
+	}
+	if err := WriteAttachments(env.Out, attachments); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses flags given before, between and after the positional arguments, the way kubectl takes them
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseSelection reads kind and name, as `pod web-0` or `pod/web-0`. Without them the whole namespace is selected
+func parseSelection(args []string, namespace string) (Selection, error) {
+	if len(args) == 1 && strings.Contains(args[0], "/") {
+		args = strings.SplitN(args[0], "/", 2)
+	}
+	if len(args) == 0 {
+		return Selection{Kind: "namespace", Name: namespace, Namespace: namespace}, nil
+	}
+	if len(args) != 2 {
+		return Selection{}, fmt.Errorf("expected a kind and a name, got %q", strings.Join(args, " "))
+	}
+
+	kind, name := strings.ToLower(args[0]), args[1]
+	switch kind {
+	case "pod", "pods", "po":
+		return Selection{Kind: "pod", Name: name, Namespace: namespace}, nil
+	case "deployment", "deployments", "deploy":
+		return Selection{Kind: "deployment", Name: name, Namespace: namespace}, nil
+	case "namespace", "namespaces", "ns":
+		return Selection{Kind: "namespace", Name: name, Namespace: name}, nil
+	}
+	return Selection{}, fmt.Errorf("unknown kind %q, use pod, deployment or namespace", args[0])
+}
@@ -1,17 +1,303 @@
 package config
 
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+)
+
+// DefaultEnabledLabelKey is the label pods set to "true" to opt in to the attacher
+const DefaultEnabledLabelKey = "nlb-attacher.bird.co/enabled"
+
+// DefaultTargetGroupAnnotationKey is the annotation listing the target groups of a pod
+const DefaultTargetGroupAnnotationKey = "nlb-attacher.bird.co/target-groups"
+
+// UnhealthyLabelKey is the label the label remediation puts on pods that stay unhealthy in a target group
+const UnhealthyLabelKey = "nlb-attacher.bird.co/unhealthy"
+
+// Remediation actions for pods that stay unhealthy in a target group
+const (
+	RemediationDeregister = annotation.RemediationDeregister
+	RemediationLabel      = annotation.RemediationLabel
+	RemediationEvict      = annotation.RemediationEvict
+)
+
+// RemediationPolicy is how the attacher acts on pods that are unhealthy for longer than After in matching target groups
+type RemediationPolicy struct {
+	Pattern string
+	Action  string
+	After   time.Duration
+}
+
+// DryRunAnnotationKey is the namespace annotation that puts every pod in the namespace in dry-run mode
+const DryRunAnnotationKey = "nlb-attacher.bird.co/dry-run"
+
 // Config struct contains target group and namespace filters
 type Config struct {
-	targetGroups string
+	targetGroups []string
 	namespace    string
 	onlyNewPods  bool
+	adminToken   string
+
+	enabledLabelKey          string
+	targetGroupAnnotationKey string
+	resyncPeriod             time.Duration
+	maxRetries               int
+	logLevel                 log.Level
+	listenAddress            string
+	listenPort               int
+
+	includeNamespaces []string
+	excludeNamespaces []string
+	queueQPS          float64
+	queueBurst        int
+	configMap         string
+	stateConfigMap    string
+	aliasConfigMap    string
+	policyConfigMap   string
+	accessReview      bool
+	accessReviewCache time.Duration
+	nodeDrain         bool
+	nodeDrainTaints   []string
+	auditLog          string
+	tracingEndpoint   string
+	tracingRatio      float64
+	dryRun            bool
+	backends          []string
+	awsAPIQPS         float64
+	awsAPIBurst       int
+	vpcID             string
+	catalogRefresh    time.Duration
+	healthCheck       time.Duration
+	remediation       []string
+	maxRemediations   int
+	shutdownTimeout   time.Duration
+	statusAnnotation  bool
+
+	webhookPort     int
+	webhookCertFile string
+	webhookKeyFile  string
+
+	webhookInjectPreStop bool
 }
 
 // GetTargetGroups - return value
-func (config Config) GetTargetGroups() string {
+func (config Config) GetTargetGroups() []string {
 	return config.targetGroups
 }
 
+// GetQueueQPS - return value
+func (config Config) GetQueueQPS() float64 {
+	return config.queueQPS
+}
+
+// GetQueueBurst - return value
+func (config Config) GetQueueBurst() int {
+	return config.queueBurst
+}
+
+// GetDryRun - return value
+func (config Config) GetDryRun() bool {
+	return config.dryRun
+}
+
+// GetAWSAPIQPS - return value
+func (config Config) GetAWSAPIQPS() float64 {
+	return config.awsAPIQPS
+}
+
+// GetAWSAPIBurst - return value
+func (config Config) GetAWSAPIBurst() int {
+	return config.awsAPIBurst
+}
+
+// GetVPCID - return value
+func (config Config) GetVPCID() string {
+	return config.vpcID
+}
+
+// GetCatalogRefreshPeriod - return value
+func (config Config) GetCatalogRefreshPeriod() time.Duration {
+	return config.catalogRefresh
+}
+
+// GetAccessReview - report whether pods' service accounts must be allowed to bind a target group before it registers them
+func (config Config) GetAccessReview() bool {
+	return config.accessReview
+}
+
+// GetAccessReviewCachePeriod - return value
+func (config Config) GetAccessReviewCachePeriod() time.Duration {
+	return config.accessReviewCache
+}
+
+// GetNodeDrain - report whether nodes are watched to deregister the pods of draining nodes
+func (config Config) GetNodeDrain() bool {
+	return config.nodeDrain
+}
+
+// NodeDrainTaint - report whether a taint with the key marks its node for termination
+func (config Config) NodeDrainTaint(key string) bool {
+	for _, pattern := range config.nodeDrainTaints {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHealthCheckPeriod - return value
+func (config Config) GetHealthCheckPeriod() time.Duration {
+	return config.healthCheck
+}
+
+// GetMaxConcurrentRemediations - return value
+func (config Config) GetMaxConcurrentRemediations() int {
+	return config.maxRemediations
+}
+
+// RemediationPolicyFor - return the first remediation policy matching the target group, if any
+func (config Config) RemediationPolicyFor(tgArn string) (RemediationPolicy, bool) {
+	for _, entry := range config.remediation {
+		policy, err := parseRemediationPolicy(entry)
+		if err != nil {
+			continue
+		}
+		if matched, _ := path.Match(policy.Pattern, tgArn); matched {
+			return policy, true
+		}
+	}
+	return RemediationPolicy{}, false
+}
+
+// parseRemediationPolicy - decode a pattern=action:duration entry of the remediation option
+func parseRemediationPolicy(entry string) (RemediationPolicy, error) {
+	separator := strings.LastIndex(entry, "=")
+	if separator < 0 {
+		return RemediationPolicy{}, fmt.Errorf("expected pattern=action:duration")
+	}
+	policy := RemediationPolicy{Pattern: entry[:separator]}
+	if _, err := path.Match(policy.Pattern, ""); err != nil {
+		return RemediationPolicy{}, err
+	}
+
+	parts := strings.SplitN(entry[separator+1:], ":", 2)
+	if len(parts) != 2 {
+		return RemediationPolicy{}, fmt.Errorf("expected pattern=action:duration")
+	}
+	switch parts[0] {
+	case RemediationDeregister, RemediationLabel, RemediationEvict:
+		policy.Action = parts[0]
+	default:
+		return RemediationPolicy{}, fmt.Errorf("unknown action %q, use deregister, label or evict", parts[0])
+	}
+	after, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return RemediationPolicy{}, err
+	}
+	if after <= 0 {
+		return RemediationPolicy{}, fmt.Errorf("duration must be positive")
+	}
+	policy.After = after
+	return policy, nil
+}
+
+// GetShutdownTimeout - return value
+func (config Config) GetShutdownTimeout() time.Duration {
+	return config.shutdownTimeout
+}
+
+// GetBackends - return value
+func (config Config) GetBackends() []string {
+	return config.backends
+}
+
+// GetConfigMap - return the namespace and name of the watched config map, if any
+func (config Config) GetConfigMap() (string, string) {
+	return splitNamespacedName(config.configMap)
+}
+
+// GetAuditLog - return the file the audit log is appended to, - for stdout, or empty when auditing is disabled
+func (config Config) GetAuditLog() string {
+	return config.auditLog
+}
+
+// GetTracingEndpoint - return the OTLP/HTTP collector spans are sent to, or empty when tracing is disabled
+func (config Config) GetTracingEndpoint() string {
+	return config.tracingEndpoint
+}
+
+// GetTracingSampleRatio - return value
+func (config Config) GetTracingSampleRatio() float64 {
+	return config.tracingRatio
+}
+
+// GetStateConfigMap - return the namespace and name of the config map the state is persisted in, if any
+func (config Config) GetStateConfigMap() (string, string) {
+	return splitNamespacedName(config.stateConfigMap)
+}
+
+// GetPolicyConfigMap - return the namespace and name of the config map holding the namespace to target group policy, if any
+func (config Config) GetPolicyConfigMap() (string, string) {
+	return splitNamespacedName(config.policyConfigMap)
+}
+
+// GetAliasConfigMap - return the namespace and name of the config map target group aliases are defined in, if any
+func (config Config) GetAliasConfigMap() (string, string) {
+	return splitNamespacedName(config.aliasConfigMap)
+}
+
+func splitNamespacedName(value string) (string, string) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// NamespaceAllowed - report whether pods in the namespace pass the include and exclude filters
+func (config Config) NamespaceAllowed(namespace string) bool {
+	for _, excluded := range config.excludeNamespaces {
+		if excluded == namespace {
+			return false
+		}
+	}
+	if len(config.includeNamespaces) == 0 {
+		return true
+	}
+	for _, included := range config.includeNamespaces {
+		if included == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetGroupAllowed - report whether the target group matches the allowlist. An empty allowlist allows everything
+func (config Config) TargetGroupAllowed(tgArn string) bool {
+	if len(config.targetGroups) == 0 {
+		return true
+	}
+	for _, pattern := range config.targetGroups {
+		if matched, _ := path.Match(pattern, tgArn); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStatusAnnotation - report whether the state of each pod's targets is written to its status annotation
+func (config Config) GetStatusAnnotation() bool {
+	return config.statusAnnotation
+}
+
 // GetNamespace - return value
 func (config Config) GetNamespace() string {
 	return config.namespace
@@ -22,9 +308,238 @@ func (config Config) GetOnlyNewPods() bool {
 	return config.onlyNewPods
 }
 
-// CreateConfig - return a config struct with it's members set
-func CreateConfig(onlyNewPods bool) *Config {
+// GetAdminToken - return value
+func (config Config) GetAdminToken() string {
+	return config.adminToken
+}
+
+// GetEnabledLabelKey - return value
+func (config Config) GetEnabledLabelKey() string {
+	return config.enabledLabelKey
+}
+
+// GetTargetGroupAnnotationKey - return value
+func (config Config) GetTargetGroupAnnotationKey() string {
+	return config.targetGroupAnnotationKey
+}
+
+// GetResyncPeriod - return value
+func (config Config) GetResyncPeriod() time.Duration {
+	return config.resyncPeriod
+}
+
+// GetMaxRetries - return value
+func (config Config) GetMaxRetries() int {
+	return config.maxRetries
+}
+
+// GetLogLevel - return value
+func (config Config) GetLogLevel() log.Level {
+	return config.logLevel
+}
+
+// GetListenAddress - return value
+func (config Config) GetListenAddress() string {
+	return config.listenAddress
+}
+
+// GetListenPort - return value
+func (config Config) GetListenPort() int {
+	return config.listenPort
+}
+
+// GetWebhookPort - return value
+func (config Config) GetWebhookPort() int {
+	return config.webhookPort
+}
+
+// GetWebhookCertFile - return value
+func (config Config) GetWebhookCertFile() string {
+	return config.webhookCertFile
+}
+
+// GetWebhookKeyFile - return value
+func (config Config) GetWebhookKeyFile() string {
+	return config.webhookKeyFile
+}
+
+// GetWebhookInjectPreStop - return value
+func (config Config) GetWebhookInjectPreStop() bool {
+	return config.webhookInjectPreStop
+}
+
+// WebhooksEnabled - the admission webhooks are served when a certificate is configured
+func (config Config) WebhooksEnabled() bool {
+	return config.webhookCertFile != "" && config.webhookKeyFile != ""
+}
+
+// defaultConfig - return the settings used when nothing overrides them
+func defaultConfig() *Config {
 	return &Config{
-		onlyNewPods: onlyNewPods,
+		enabledLabelKey:          DefaultEnabledLabelKey,
+		targetGroupAnnotationKey: DefaultTargetGroupAnnotationKey,
+		resyncPeriod:             60 * time.Second,
+		maxRetries:               5,
+		logLevel:                 log.DebugLevel,
+		listenAddress:            "0.0.0.0",
+		listenPort:               8080,
+		queueQPS:                 10,
+		queueBurst:               100,
+		webhookPort:              8443,
+		backends:                 []string{"elbv2"},
+		awsAPIQPS:                5,
+		awsAPIBurst:              10,
+		catalogRefresh:           5 * time.Minute,
+		maxRemediations:          1,
+		shutdownTimeout:          20 * time.Second,
+		tracingRatio:             1,
+		statusAnnotation:         false,
+		accessReviewCache:        time.Minute,
+		nodeDrainTaints:          []string{"ToBeDeletedByClusterAutoscaler", "karpenter.sh/disruption", "aws-node-termination-handler/*", "node.cloudprovider.kubernetes.io/shutdown"},
+	}
+}
+
+// Validate - check the settings, reporting every problem at once
+func (config Config) Validate() error {
+	problems := make([]string, 0)
+
+	if errs := validation.IsQualifiedName(config.enabledLabelKey); len(errs) > 0 {
+		problems = append(problems, fmt.Sprintf("enabled-label-key %q: %s", config.enabledLabelKey, strings.Join(errs, ", ")))
+	}
+	if errs := validation.IsQualifiedName(config.targetGroupAnnotationKey); len(errs) > 0 {
+		problems = append(problems, fmt.Sprintf("target-group-annotation-key %q: %s", config.targetGroupAnnotationKey, strings.Join(errs, ", ")))
+	}
+	if config.namespace != "" {
+		if errs := validation.IsDNS1123Label(config.namespace); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("namespace %q: %s", config.namespace, strings.Join(errs, ", ")))
+		}
+	}
+	if config.resyncPeriod < 0 {
+		problems = append(problems, "resync-period must not be negative")
+	}
+	if config.maxRetries < 0 {
+		problems = append(problems, "max-retries must not be negative")
+	}
+	if config.queueQPS <= 0 {
+		problems = append(problems, "queue-qps must be positive")
+	}
+	if config.queueBurst < 1 {
+		problems = append(problems, "queue-burst must be at least 1")
+	}
+	for _, pattern := range config.targetGroups {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("target-groups pattern %q: %v", pattern, err))
+		}
+	}
+	for _, filter := range [][]string{config.includeNamespaces, config.excludeNamespaces} {
+		for _, namespace := range filter {
+			if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+				problems = append(problems, fmt.Sprintf("namespace filter %q: %s", namespace, strings.Join(errs, ", ")))
+			}
+		}
+	}
+	if config.awsAPIQPS <= 0 {
+		problems = append(problems, "aws-api-qps must be positive")
+	}
+	if config.awsAPIBurst < 1 {
+		problems = append(problems, "aws-api-burst must be at least 1")
+	}
+	if config.catalogRefresh <= 0 {
+		problems = append(problems, "catalog-refresh-period must be positive")
+	}
+	if config.accessReviewCache < 0 {
+		problems = append(problems, "access-review-cache-period must not be negative")
+	}
+	for _, pattern := range config.nodeDrainTaints {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("node-drain-taints pattern %q: %v", pattern, err))
+		}
+	}
+	if config.healthCheck < 0 {
+		problems = append(problems, "health-check-period must not be negative")
+	}
+	for _, entry := range config.remediation {
+		if _, err := parseRemediationPolicy(entry); err != nil {
+			problems = append(problems, fmt.Sprintf("remediation %q: %v", entry, err))
+		}
+	}
+	if len(config.remediation) > 0 && config.healthCheck == 0 {
+		problems = append(problems, "remediation needs a health-check-period")
+	}
+	if config.maxRemediations < 1 {
+		problems = append(problems, "max-concurrent-remediations must be at least 1")
+	}
+	if config.tracingRatio < 0 || config.tracingRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing-sample-ratio %g must be between 0 and 1", config.tracingRatio))
+	}
+	if config.tracingEndpoint != "" {
+		if endpoint, err := url.Parse(config.tracingEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing-endpoint %q must be an http or https url", config.tracingEndpoint))
+		}
+	}
+	if config.shutdownTimeout <= 0 {
+		problems = append(problems, "shutdown-timeout must be positive")
+	}
+	if len(config.backends) == 0 {
+		problems = append(problems, "backends must name at least one handler backend")
+	}
+	if config.configMap != "" {
+		if parts := strings.Split(config.configMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("config-map %q must be namespace/name", config.configMap))
+		}
+	}
+	if config.stateConfigMap != "" {
+		if parts := strings.Split(config.stateConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("state-config-map %q must be namespace/name", config.stateConfigMap))
+		}
+		if config.stateConfigMap == config.configMap {
+			problems = append(problems, "state-config-map must not be the watched config-map")
+		}
+	}
+	if config.aliasConfigMap != "" {
+		if parts := strings.Split(config.aliasConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("alias-config-map %q must be namespace/name", config.aliasConfigMap))
+		}
+		if config.aliasConfigMap == config.configMap || config.aliasConfigMap == config.stateConfigMap {
+			problems = append(problems, "alias-config-map must not be the watched config-map or the state-config-map")
+		}
+	}
+	if config.policyConfigMap != "" {
+		if parts := strings.Split(config.policyConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("policy-config-map %q must be namespace/name", config.policyConfigMap))
+		}
+		if config.policyConfigMap == config.configMap || config.policyConfigMap == config.stateConfigMap || config.policyConfigMap == config.aliasConfigMap {
+			problems = append(problems, "policy-config-map must not be the watched config-map, the state-config-map or the alias-config-map")
+		}
+	}
+	if config.listenPort < 1 || config.listenPort > 65535 {
+		problems = append(problems, fmt.Sprintf("listen-port %d is not a valid port", config.listenPort))
+	}
+	if config.webhookPort < 1 || config.webhookPort > 65535 {
+		problems = append(problems, fmt.Sprintf("webhook-port %d is not a valid port", config.webhookPort))
+	}
+	if config.WebhooksEnabled() && config.webhookPort == config.listenPort {
+		problems = append(problems, "webhook-port must differ from listen-port")
+	}
+	if (config.webhookCertFile == "") != (config.webhookKeyFile == "") {
+		problems = append(problems, "webhook-cert-file and webhook-key-file must be set together")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Fields - return the effective settings for logging, with secrets redacted
+func (config Config) Fields() log.Fields {
+	fields := log.Fields{}
+	for _, opt := range options {
+		value := opt.get(&config)
+		if opt.secret && value != "" {
+			value = "REDACTED"
+		}
+		fields[opt.name] = value
 	}
+	return fields
 }
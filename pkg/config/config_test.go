@@ -0,0 +1,218 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{name: "defaults", modify: func(*Config) {}},
+		{
+			name:    "negative resync period",
+			modify:  func(c *Config) { c.resyncPeriod = -time.Second },
+			wantErr: "resync-period must not be negative",
+		},
+		{
+			name:    "webhook certificate without a key",
+			modify:  func(c *Config) { c.webhookCertFile = "/tls/tls.crt" },
+			wantErr: "webhook-cert-file and webhook-key-file must be set together",
+		},
+		{
+			name:    "queue qps not positive",
+			modify:  func(c *Config) { c.queueQPS = 0 },
+			wantErr: "queue-qps must be positive",
+		},
+		{
+			name:    "malformed target group glob",
+			modify:  func(c *Config) { c.targetGroups = []string{"arn:aws:*[tg"} },
+			wantErr: `target-groups pattern "arn:aws:*[tg"`,
+		},
+		{
+			name: "remediation with a health check period",
+			modify: func(c *Config) {
+				c.healthCheck = 30 * time.Second
+				c.remediation = []string{"*=evict:5m"}
+			},
+		},
+		{
+			name: "remediation without a health check period",
+			modify: func(c *Config) {
+				c.healthCheck = 0
+				c.remediation = []string{"*=evict:5m"}
+			},
+			wantErr: "remediation needs a health-check-period",
+		},
+		{
+			name: "unknown remediation action",
+			modify: func(c *Config) {
+				c.healthCheck = 30 * time.Second
+				c.remediation = []string{"*=restart:5m"}
+			},
+			wantErr: `remediation "*=restart:5m": unknown action "restart"`,
+		},
+		{
+			name:    "policy config map without a namespace",
+			modify:  func(c *Config) { c.policyConfigMap = "policy" },
+			wantErr: `policy-config-map "policy" must be namespace/name`,
+		},
+		{
+			name:    "negative access review cache period",
+			modify:  func(c *Config) { c.accessReviewCache = -time.Minute },
+			wantErr: "access-review-cache-period must not be negative",
+		},
+		{
+			name:    "malformed node drain taint glob",
+			modify:  func(c *Config) { c.nodeDrainTaints = []string{"karpenter.sh/[disruption"} },
+			wantErr: `node-drain-taints pattern "karpenter.sh/[disruption"`,
+		},
+		{
+			name: "every problem reported",
+			modify: func(c *Config) {
+				c.maxRetries = -1
+				c.listenPort = 0
+			},
+			wantErr: "max-retries must not be negative; listen-port 0 is not a valid port",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := defaultConfig()
+			tc.modify(config)
+			err := config.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Validate() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		file    string
+		wantErr string
+	}{
+		{name: "defaults", args: []string{}},
+		{name: "flags", args: []string{"--resync-period=30s", "--max-retries=3"}},
+		{name: "file", args: []string{}, file: "resync-period: 30s\nonly-new-pods: true\n"},
+		{
+			name:    "unparsable flag",
+			args:    []string{"--only-new-pods=sometimes"},
+			wantErr: "invalid --only-new-pods",
+		},
+		{
+			name:    "unknown file key",
+			args:    []string{},
+			file:    "resync: 30s\n",
+			wantErr: "resync",
+		},
+		{
+			name:    "flags are validated together",
+			args:    []string{"--webhook-cert-file=/tls/tls.crt"},
+			wantErr: "webhook-cert-file and webhook-key-file must be set together",
+		},
+		{name: "remediation flags", args: []string{"--health-check-period=30s", "--remediation=*=deregister:2m"}},
+		{name: "remediation file", args: []string{}, file: "health-check-period: 30s\nremediation: \"*=label:1m\"\n"},
+		{
+			name:    "remediation without a health check period",
+			args:    []string{"--health-check-period=0s", "--remediation=*=evict:5m"},
+			wantErr: "remediation needs a health-check-period",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				file, err := ioutil.TempFile("", "config-*.yaml")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(file.Name())
+				if _, err := file.WriteString(tc.file); err != nil {
+					t.Fatal(err)
+				}
+				file.Close()
+				args = append([]string{"--config=" + file.Name()}, args...)
+			}
+
+			_, err := Load(args)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Load() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	store, err := NewStore([]string{})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	if err := store.ReloadContents("test", []byte("queue-qps: 20\n")); err != nil {
+		t.Fatalf("ReloadContents() error = %v", err)
+	}
+	if qps := store.Get().queueQPS; qps != 20 {
+		t.Errorf("queue-qps = %g after a reload, want 20", qps)
+	}
+
+	if err := store.ReloadContents("test", []byte("queue-qps: 0\n")); err == nil {
+		t.Fatal("ReloadContents() error = nil, want the invalid queue-qps to be rejected")
+	}
+	if qps := store.Get().queueQPS; qps != 20 {
+		t.Errorf("queue-qps = %g after a rejected reload, want 20", qps)
+	}
+
+	// a deleted config map keeps the current configuration
+	if err := store.ReloadContents("test", nil); err != nil {
+		t.Fatalf("ReloadContents() error = %v", err)
+	}
+	if qps := store.Get().queueQPS; qps != 20 {
+		t.Errorf("queue-qps = %g after the config map was deleted, want 20", qps)
+	}
+
+	// settings that need a restart are ignored
+	if err := store.ReloadContents("test", []byte("queue-qps: 20\nresync-period: 5s\n")); err != nil {
+		t.Fatalf("ReloadContents() error = %v", err)
+	}
+	if period := store.Get().resyncPeriod; period != 60*time.Second {
+		t.Errorf("resync-period = %s after a reload, want it unchanged", period)
+	}
+}
+
+func TestReloadValidatesTheWholeConfiguration(t *testing.T) {
+	store, err := NewStore([]string{"--health-check-period=0s"})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	// health-check-period needs a restart, so the reloaded remediation would run without one
+	err = store.ReloadContents("test", []byte("health-check-period: 30s\nremediation: \"*=evict:5m\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "remediation needs a health-check-period") {
+		t.Fatalf("ReloadContents() error = %v, want the remediation to be rejected", err)
+	}
+	if remediation := store.Get().remediation; len(remediation) != 0 {
+		t.Errorf("remediation = %q after a rejected reload, want none", remediation)
+	}
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix is prepended to the upper cased option name to form its environment variable
+const envPrefix = "NLB_ATTACHER_"
+
+// option is a single setting that can come from the config file, the environment or a flag
+type option struct {
+	name       string
+	usage      string
+	secret     bool
+	reloadable bool
+	set        func(config *Config, value string) error
+	get        func(config *Config) string
+}
+
+// envName - return the environment variable for the option, e.g. NLB_ATTACHER_LISTEN_PORT
+func (opt option) envName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(opt.name, "-", "_", -1))
+}
+
+var options = []option{
+	stringOption("namespace", "only manage pods in this namespace (default all namespaces)", func(c *Config) *string { return &c.namespace }),
+	boolOption("only-new-pods", "ignore pods created before the attacher started", func(c *Config) *bool { return &c.onlyNewPods }),
+	stringOption("enabled-label-key", "label pods set to \"true\" to opt in", func(c *Config) *string { return &c.enabledLabelKey }),
+	stringOption("target-group-annotation-key", "annotation listing the target groups of a pod", func(c *Config) *string { return &c.targetGroupAnnotationKey }),
+	reloadableOption(listOption("include-namespaces", "comma separated namespaces to manage (default all)", func(c *Config) *[]string { return &c.includeNamespaces })),
+	reloadableOption(listOption("exclude-namespaces", "comma separated namespaces to ignore", func(c *Config) *[]string { return &c.excludeNamespaces })),
+	reloadableOption(listOption("target-groups", "comma separated allowlist of target group ARNs, globs allowed (default all)", func(c *Config) *[]string { return &c.targetGroups })),
+	reloadableOption(floatOption("queue-qps", "overall rate at which queued events are processed", func(c *Config) *float64 { return &c.queueQPS })),
+	reloadableOption(intOption("queue-burst", "burst size of the queue rate limit", func(c *Config) *int { return &c.queueBurst })),
+	reloadableOption(floatOption("aws-api-qps", "client side rate limit of each elbv2 api per account and region, lowered while throttled", func(c *Config) *float64 { return &c.awsAPIQPS })),
+	reloadableOption(intOption("aws-api-burst", "burst size of the elbv2 api rate limit", func(c *Config) *int { return &c.awsAPIBurst })),
+	reloadableOption(stringOption("vpc-id", "vpc every target group must be in, registrations with other target groups are rejected (default not checked)", func(c *Config) *string { return &c.vpcID })),
+	durationOption("catalog-refresh-period", "how often every target group and load balancer is described to validate registrations", func(c *Config) *time.Duration { return &c.catalogRefresh }),
+	durationOption("health-check-period", "how often the target health of every pod is mirrored into its conditions and events, e.g. 30s (default disabled)", func(c *Config) *time.Duration { return &c.healthCheck }),
+	reloadableOption(listOption("remediation", "comma separated pattern=action:duration policies acting on pods unhealthy that long in matching target groups, action is deregister, label or evict (default none)", func(c *Config) *[]string { return &c.remediation })),
+	reloadableOption(intOption("max-concurrent-remediations", "how many pods may be remediated at the same time", func(c *Config) *int { return &c.maxRemediations })),
+	reloadableOption(boolOption("dry-run", "log, record and count every elbv2 mutation without making it", func(c *Config) *bool { return &c.dryRun })),
+	listOption("backends", "comma separated handler backends every pod event is passed to, in order", func(c *Config) *[]string { return &c.backends }),
+	stringOption("config-map", "namespace/name of a config map whose config.yaml key is watched and hot reloaded", func(c *Config) *string { return &c.configMap }),
+	stringOption("audit-log", "file every RegisterTargets and DeregisterTargets call is appended to as a JSON line, - for stdout (default not audited)", func(c *Config) *string { return &c.auditLog }),
+	stringOption("tracing-endpoint", "OTLP/HTTP collector the trace spans are sent to, e.g. http://localhost:4318 (default not traced)", func(c *Config) *string { return &c.tracingEndpoint }),
+	floatOption("tracing-sample-ratio", "share of traces that are sampled, from 0 to 1", func(c *Config) *float64 { return &c.tracingRatio }),
+	stringOption("state-config-map", "namespace/name of a config map the registered targets are persisted in, so a restart cleans up pods deleted while down (default not persisted)", func(c *Config) *string { return &c.stateConfigMap }),
+	stringOption("alias-config-map", "namespace/name of a config map whose keys are target group aliases pods can reference, with the target group ARNs as values (default no aliases)", func(c *Config) *string { return &c.aliasConfigMap }),
+	stringOption("policy-config-map", "namespace/name of a config map whose policy.yaml key allowlists target groups per namespace, every target group is denied while it holds no valid policy (default everything allowed)", func(c *Config) *string { return &c.policyConfigMap }),
+	boolOption("access-review", "only register pods whose service account a SubjectAccessReview allows to bind the target group, a virtual targetgroups.nlb-attacher.bird.co resource named after it", func(c *Config) *bool { return &c.accessReview }),
+	durationOption("access-review-cache-period", "how long the answer of a SubjectAccessReview is reused", func(c *Config) *time.Duration { return &c.accessReviewCache }),
+	boolOption("node-drain", "watch nodes and deregister the pods of cordoned nodes and nodes tainted for termination before they are evicted, even when the node is only cordoned", func(c *Config) *bool { return &c.nodeDrain }),
+	reloadableOption(listOption("node-drain-taints", "comma separated keys of the taints marking a node for termination, globs allowed", func(c *Config) *[]string { return &c.nodeDrainTaints })),
+	reloadableOption(boolOption("status-annotation", "write the state, health and last error of each pod's targets to its nlb-attacher.bird.co/status annotation", func(c *Config) *bool { return &c.statusAnnotation })),
+	durationOption("resync-period", "how often the informer relists every pod", func(c *Config) *time.Duration { return &c.resyncPeriod }),
+	durationOption("shutdown-timeout", "how long queued events are processed after a shutdown signal before the rest is left to the next controller", func(c *Config) *time.Duration { return &c.shutdownTimeout }),
+	intOption("max-retries", "how many times a failed event is retried before giving up", func(c *Config) *int { return &c.maxRetries }),
+	{
+		name:       "log-level",
+		usage:      "one of panic, fatal, error, warn, info, debug, trace",
+		reloadable: true,
+		set: func(c *Config, value string) error {
+			level, err := log.ParseLevel(value)
+			if err != nil {
+				return err
+			}
+			c.logLevel = level
+			return nil
+		},
+		get: func(c *Config) string { return c.logLevel.String() },
+	},
+	stringOption("listen-address", "address the http server listens on", func(c *Config) *string { return &c.listenAddress }),
+	intOption("listen-port", "port the http server listens on", func(c *Config) *int { return &c.listenPort }),
+	secretOption(stringOption("admin-token", "bearer token enabling the admin api", func(c *Config) *string { return &c.adminToken })),
+	intOption("webhook-port", "port the admission webhooks listen on", func(c *Config) *int { return &c.webhookPort }),
+	stringOption("webhook-cert-file", "tls certificate for the admission webhooks", func(c *Config) *string { return &c.webhookCertFile }),
+	stringOption("webhook-key-file", "tls key for the admission webhooks", func(c *Config) *string { return &c.webhookKeyFile }),
+	boolOption("webhook-inject-prestop", "inject a preStop sleep covering the deregistration delay", func(c *Config) *bool { return &c.webhookInjectPreStop }),
+}
+
+// Load - build the configuration from defaults, then the optional yaml file, then the environment, then flags.
+// The file is given with --config or NLB_ATTACHER_CONFIG and uses the flag names as keys
+func Load(args []string) (*Config, error) {
+	return load(args, nil)
+}
+
+// load builds the configuration, using fileContents in place of the --config file when it is not nil
+func load(args []string, fileContents []byte) (*Config, error) {
+	config := defaultConfig()
+
+	flags := flag.NewFlagSet("nlb-attacher", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a yaml config file")
+	flagValues := make(map[string]*string)
+	for _, opt := range options {
+		flagValues[opt.name] = flags.String(opt.name, "", fmt.Sprintf("%s (env %s, default %q)", opt.usage, opt.envName(), opt.get(config)))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fileContents != nil {
+		if err := config.applyFile(fileContents, "from configmap"); err != nil {
+			return nil, err
+		}
+	} else if *configFile != "" {
+		raw, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		if err := config.applyFile(raw, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range options {
+		if value, ok := os.LookupEnv(opt.envName()); ok {
+			if err := opt.set(config, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", opt.envName(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		opt, ok := findOption(f.Name)
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := opt.set(config, *flagValues[f.Name]); err != nil {
+			flagErr = fmt.Errorf("invalid --%s: %v", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// applyFile applies every key of the yaml file, rejecting keys that are not options
+func (config *Config) applyFile(raw []byte, path string) error {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(raw, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		opt, ok := findOption(key)
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		if err := opt.set(config, fileValue(values[key])); err != nil {
+			return fmt.Errorf("config file %s: invalid %s: %v", path, key, err)
+		}
+	}
+	return nil
+}
+
+// fileValue converts a decoded yaml value back to the string form the options parse
+func fileValue(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(typed))
+		for _, item := range typed {
+			items = append(items, fileValue(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprintf("%v", typed)
+	}
+}
+
+func findOption(name string) (option, bool) {
+	for _, opt := range options {
+		if opt.name == name {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
+
+func reloadableOption(opt option) option {
+	opt.reloadable = true
+	return opt
+}
+
+func secretOption(opt option) option {
+	opt.secret = true
+	return opt
+}
+
+func stringOption(name string, usage string, field func(*Config) *string) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+		get: func(c *Config) string { return *field(c) },
+	}
+}
+
+func boolOption(name string, usage string, field func(*Config) *bool) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = parsed
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func intOption(name string, usage string, field func(*Config) *int) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = parsed
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func durationOption(name string, usage string, field func(*Config) *time.Duration) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = parsed
+			return nil
+		},
+		get: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func floatOption(name string, usage string, field func(*Config) *float64) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			*field(c) = parsed
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatFloat(*field(c), 'g', -1, 64) },
+	}
+}
+
+func listOption(name string, usage string, field func(*Config) *[]string) option {
+	return option{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			items := make([]string, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			*field(c) = items
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(*field(c), ",") },
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+// ConfigMapKey is the key of the watched config map holding the yaml config file
+const ConfigMapKey = "config.yaml"
+
+var (
+	reloadsTotal = metrics.NewCounter(
+		"nlb_attacher_config_reloads_total",
+		"Configuration reload attempts by source and result.",
+		"source", "result",
+	)
+	lastReloadTimestamp = metrics.NewGauge(
+		"nlb_attacher_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful configuration reload.",
+	)
+)
+
+// Store holds the current configuration and swaps it atomically on reload.
+// Readers should call Get for every decision rather than keeping the result around
+type Store struct {
+	args    []string
+	current atomic.Value
+
+	reloadMutex  sync.Mutex
+	fileContents []byte
+	subscribers  []func(*Config)
+}
+
+// NewStore - load the configuration from the command line arguments and hold it for reloading
+func NewStore(args []string) (*Store, error) {
+	config, err := Load(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreFromConfig(args, config), nil
+}
+
+// NewStoreFromConfig - hold an already loaded configuration. args are used to reload it
+func NewStoreFromConfig(args []string, config *Config) *Store {
+	store := &Store{args: args}
+	store.current.Store(config)
+	return store
+}
+
+// Get - return the current configuration
+func (store *Store) Get() *Config {
+	return store.current.Load().(*Config)
+}
+
+// Subscribe - call fn with the new configuration after every successful reload
+func (store *Store) Subscribe(fn func(*Config)) {
+	store.reloadMutex.Lock()
+	defer store.reloadMutex.Unlock()
+	store.subscribers = append(store.subscribers, fn)
+}
+
+// Reload - reload from the config file, or the last config map contents, plus the environment and flags
+func (store *Store) Reload(source string) error {
+	store.reloadMutex.Lock()
+	defer store.reloadMutex.Unlock()
+	return store.reload(source, store.fileContents)
+}
+
+// ReloadContents - reload using the given yaml in place of the config file. nil contents, from a deleted config map,
+// keep the current configuration: reloading from nothing would drop every setting the file or config map made
+func (store *Store) ReloadContents(source string, contents []byte) error {
+	store.reloadMutex.Lock()
+	defer store.reloadMutex.Unlock()
+
+	if contents == nil {
+		reloadsTotal.Inc(source, "unchanged")
+		log.WithField("source", source).Warn("Configuration source was deleted, keeping the current configuration")
+		return nil
+	}
+	if err := store.reload(source, contents); err != nil {
+		return err
+	}
+	store.fileContents = contents
+	return nil
+}
+
+// reload applies only the reloadable settings of the freshly loaded configuration.
+// Everything else needs a restart, so changes to it are reported and ignored
+func (store *Store) reload(source string, contents []byte) error {
+	logger := log.WithField("source", source)
+
+	loaded, err := load(store.args, contents)
+	if err != nil {
+		reloadsTotal.Inc(source, "failure")
+		logger.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+		return err
+	}
+
+	current := store.Get()
+	next := *current
+	changed := make([]string, 0)
+	ignored := make([]string, 0)
+	for _, opt := range options {
+		value := opt.get(loaded)
+		if value == opt.get(current) {
+			continue
+		}
+		if !opt.reloadable {
+			ignored = append(ignored, opt.name)
+			continue
+		}
+		if err := opt.set(&next, value); err != nil {
+			reloadsTotal.Inc(source, "failure")
+			logger.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+			return err
+		}
+		changed = append(changed, opt.name)
+	}
+
+	if len(ignored) > 0 {
+		logger.WithField("settings", ignored).Warn("Ignoring changed settings that require a restart")
+	}
+
+	if len(changed) == 0 {
+		reloadsTotal.Inc(source, "unchanged")
+		logger.Info("Configuration reloaded with no changes")
+		return nil
+	}
+
+	// the changed settings may not go together with the ones that need a restart
+	if err := next.Validate(); err != nil {
+		reloadsTotal.Inc(source, "failure")
+		logger.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+		return err
+	}
+
+	store.current.Store(&next)
+	for _, subscriber := range store.subscribers {
+		subscriber(&next)
+	}
+
+	reloadsTotal.Inc(source, "success")
+	lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	logger.WithFields(next.Fields()).WithField("changed", changed).Info("Configuration reloaded")
+	return nil
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/event"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+)
+
+// PodNotFoundError is returned by the admin actions when the pod is not in the informer cache
+type PodNotFoundError struct {
+	Key string
+}
+
+func (err PodNotFoundError) Error() string {
+	return fmt.Sprintf("pod %s not found", err.Key)
+}
+
+// NotFound - mark the error as a missing resource
+func (err PodNotFoundError) NotFound() bool {
+	return true
+}
+
+// ReconcilePod - enqueue a forced reconcile of a single pod. This also clears any temporary detach
+func (controller *Controller) ReconcilePod(namespace string, name string, reason string) error {
+	if controller.stopping() {
+		return ShuttingDownError{}
+	}
+
+	key, err := controller.cachedPodKey(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	controller.queue.AddRateLimited(event.Event{
+		Key:       key,
+		Reason:    reason,
+		EventType: "reconcile",
+		Namespace: namespace,
+	})
+	return nil
+}
+
+// ReconcileTargetGroup - enqueue a forced reconcile of every pod that references the target group
+func (controller *Controller) ReconcileTargetGroup(tgArn string, reason string) error {
+	if controller.stopping() {
+		return ShuttingDownError{}
+	}
+
+	if tgArn == "" {
+		return fmt.Errorf("target group arn is required")
+	}
+
+	controller.queue.AddRateLimited(event.Event{
+		Key:       tgArn,
+		Reason:    reason,
+		EventType: "reconcile-targetgroup",
+	})
+	return nil
+}
+
+// DetachPod - enqueue the removal of a pod from its target groups without deleting it.
+// The pod stays detached until it is reconciled again or, when duration is non zero, the duration expires
+func (controller *Controller) DetachPod(namespace string, name string, duration time.Duration, reason string) error {
+	if controller.stopping() {
+		return ShuttingDownError{}
+	}
+
+	key, err := controller.cachedPodKey(namespace, name)
+	if err != nil {
+		return err
+	}
+	pod, err := controller.podFromStore(key)
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		return PodNotFoundError{Key: key}
+	}
+
+	if duration < 0 {
+		return fmt.Errorf("detach duration must not be negative")
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+
+	controller.detachedMutex.Lock()
+	controller.detachedPods[key] = detachment{uid: pod.UID, until: until}
+	controller.detachedMutex.Unlock()
+	controller.state.SetDetached(pod.UID, key, until)
+
+	controller.queue.AddRateLimited(event.Event{
+		Key:       key,
+		Reason:    reason,
+		EventType: "detach",
+		Namespace: namespace,
+	})
+
+	if duration > 0 {
+		controller.queue.AddAfter(event.Event{
+			Key:       key,
+			Reason:    reason,
+			EventType: "reattach",
+			Namespace: namespace,
+		}, duration)
+	}
+	return nil
+}
+
+// PauseTargetGroup - enqueue a pause of all mutations to the target group
+func (controller *Controller) PauseTargetGroup(tgArn string, reason string) error {
+	if controller.stopping() {
+		return ShuttingDownError{}
+	}
+
+	if tgArn == "" {
+		return fmt.Errorf("target group arn is required")
+	}
+
+	controller.queue.AddRateLimited(event.Event{
+		Key:       tgArn,
+		Reason:    reason,
+		EventType: "pause",
+	})
+	return nil
+}
+
+// ResumeTargetGroup - enqueue a resume of mutations to the target group followed by a reconcile
+func (controller *Controller) ResumeTargetGroup(tgArn string, reason string) error {
+	if controller.stopping() {
+		return ShuttingDownError{}
+	}
+
+	if tgArn == "" {
+		return fmt.Errorf("target group arn is required")
+	}
+
+	controller.queue.AddRateLimited(event.Event{
+		Key:       tgArn,
+		Reason:    reason,
+		EventType: "resume",
+	})
+	return nil
+}
+
+func (controller *Controller) cachedPodKey(namespace string, name string) (string, error) {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	_, exists, err := controller.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", PodNotFoundError{Key: key}
+	}
+	return key, nil
+}
+
+// detachment is a detach by an operator of the pod with the uid, in effect until the time when it is not zero
+type detachment struct {
+	uid   types.UID
+	until time.Time
+}
+
+// isDetached reports whether the pod is currently detached by an operator. A detach of an earlier pod
+// with the same name, e.g. of a recreated StatefulSet pod, is dropped
+func (controller *Controller) isDetached(pod *v1.Pod) bool {
+	key := pod.Namespace + "/" + pod.Name
+	controller.detachedMutex.Lock()
+	defer controller.detachedMutex.Unlock()
+
+	detached, ok := controller.detachedPods[key]
+	if !ok {
+		return false
+	}
+	if detached.uid != pod.UID || (!detached.until.IsZero() && time.Now().After(detached.until)) {
+		delete(controller.detachedPods, key)
+		controller.state.ClearDetached(detached.uid)
+		return false
+	}
+	return true
+}
+
+func (controller *Controller) clearDetached(key string) {
+	controller.detachedMutex.Lock()
+	defer controller.detachedMutex.Unlock()
+	if detached, ok := controller.detachedPods[key]; ok {
+		delete(controller.detachedPods, key)
+		controller.state.ClearDetached(detached.uid)
+	}
+}
+
+// adminActions are the audited actions of the admin events
+var adminActions = map[string]string{
+	"pause":                 "PauseTargetGroup",
+	"resume":                "ResumeTargetGroup",
+	"reconcile-targetgroup": "ReconcileTargetGroup",
+	"reconcile":             "ReconcilePod",
+	"detach":                "DetachPod",
+	"reattach":              "ReattachPod",
+}
+
+// processAdminItem handles the events enqueued by the admin actions and records them in the audit log
+func (controller *Controller) processAdminItem(ctx context.Context, newEvent event.Event) error {
+	logger := log.WithFields(log.Fields{
+		"action": newEvent.EventType,
+		"key":    newEvent.Key,
+		"reason": newEvent.Reason,
+	})
+
+	pod, skipped, err := controller.runAdminAction(ctx, newEvent)
+	if skipped != "" {
+		logger.Debug(skipped)
+		return nil
+	}
+	controller.recordAdminAction(ctx, newEvent, pod, err)
+	if err != nil {
+		return err
+	}
+	logger.Info("Processed admin action")
+	return nil
+}
+
+// runAdminAction takes the admin action of the event on the pod it returns, if any. It returns why when nothing was done
+func (controller *Controller) runAdminAction(ctx context.Context, newEvent event.Event) (*v1.Pod, string, error) {
+	eventHandler := controller.handlerFor(newEvent)
+	// pause, resume, detach and target group reconciles need a backend that attaches pods to target groups
+	admin, ok := eventHandler.(handlers.TargetGroupAdmin)
+	if !ok && newEvent.EventType != "reconcile" && newEvent.EventType != "reattach" {
+		return nil, "", handlers.NewTerminal(fmt.Errorf("handler backend %s does not support admin actions", newEvent.Backend))
+	}
+
+	switch newEvent.EventType {
+	case "pause":
+		admin.PauseTargetGroup(newEvent.Key)
+		controller.state.SetPaused(newEvent.Key, true)
+	case "resume":
+		admin.ResumeTargetGroup(newEvent.Key)
+		controller.state.SetPaused(newEvent.Key, false)
+		controller.queue.Add(event.Event{
+			Key:       newEvent.Key,
+			Reason:    newEvent.Reason,
+			EventType: "reconcile-targetgroup",
+		})
+	case "reconcile-targetgroup":
+		pods := make([]*v1.Pod, 0)
+		for _, obj := range controller.informer.GetIndexer().List() {
+			pod, ok := obj.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			if controller.isDetached(pod) || !controller.config.Get().NamespaceAllowed(pod.Namespace) {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+		return nil, "", admin.ReconcileTargetGroup(ctx, newEvent.Key, pods)
+	default:
+		pod, err := controller.podFromStore(newEvent.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		if pod == nil {
+			return nil, "Pod no longer exists, skipping admin action", nil
+		}
+
+		switch newEvent.EventType {
+		case "reconcile":
+			controller.clearDetached(newEvent.Key)
+			err = eventHandler.PodUpdated(ctx, pod, pod)
+		case "detach":
+			err = admin.PodDetached(ctx, pod)
+		case "reattach":
+			if controller.isDetached(pod) {
+				return nil, "Pod detach was extended or replaced, not reattaching yet", nil
+			}
+			err = eventHandler.PodUpdated(ctx, pod, pod)
+		}
+		return pod, "", err
+	}
+	return nil, "", nil
+}
+
+// recordAdminAction writes the audit entry of an admin action. Actions on a pod carry it as their target
+func (controller *Controller) recordAdminAction(ctx context.Context, newEvent event.Event, pod *v1.Pod, err error) {
+	if controller.auditLog == nil {
+		return
+	}
+	entry := audit.Entry{
+		Action:  adminActions[newEvent.EventType],
+		Targets: []audit.Target{},
+		Trigger: audit.TriggerFrom(ctx),
+		Outcome: audit.OutcomeSuccess,
+	}
+	if pod != nil {
+		entry.Targets = append(entry.Targets, audit.Target{
+			ID:     pod.Status.PodIP,
+			Pod:    pod.Namespace + "/" + pod.Name,
+			PodUID: pod.UID,
+		})
+	} else {
+		entry.TargetGroup = newEvent.Key
+	}
+	if err != nil {
+		entry.Outcome = audit.OutcomeFailure
+		entry.Error = err.Error()
+	}
+	audit.Record(controller.auditLog, entry)
+}
+
+func (controller *Controller) podFromStore(key string) (*v1.Pod, error) {
+	obj, exists, err := controller.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("Returned object is not of type Pod: %v", obj)
+	}
+	return pod, nil
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+)
+
+// Aliases - return the target group aliases, nil when no alias config map is set
+func (controller *Controller) Aliases() *alias.Store {
+	return controller.aliases
+}
+
+// watchAliases keeps the alias store in step with the alias config map, and queues an update of every pod
+// referencing an alias that was added, removed or pointed at another target group
+func (controller *Controller) watchAliases() {
+	if controller.aliases == nil {
+		return
+	}
+	namespace, name := controller.config.Get().GetAliasConfigMap()
+	synced := controller.WatchConfigMap(namespace, name, func(configMap *v1.ConfigMap) {
+		if configMap == nil {
+			log.Warnf("Alias config map %s/%s was deleted, pods referencing its aliases keep their targets until it is back", namespace, name)
+		}
+		changed := controller.aliases.Update(configMap)
+		if len(changed) > 0 {
+			controller.requeueAliasReferences(changed)
+		}
+	})
+	controller.watchesSynced = append(controller.watchesSynced, synced)
+}
+
+// requeueAliasReferences queues an update of every pod whose target group annotation references one of the aliases
+func (controller *Controller) requeueAliasReferences(aliases []string) {
+	changed := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		changed[alias] = true
+	}
+	annotationKey := controller.config.Get().GetTargetGroupAnnotationKey()
+
+	requeued := controller.requeuePods(func(pod *v1.Pod) bool {
+		targetGroups, err := annotation.Parse(pod.GetAnnotations()[annotationKey])
+		if err != nil {
+			return false
+		}
+		for _, targetGroup := range targetGroups {
+			if targetGroup.Alias != "" && changed[targetGroup.Alias] {
+				return true
+			}
+		}
+		return false
+	})
+	if requeued > 0 {
+		log.Infof("Requeued %d pods referencing the changed aliases %v", requeued, aliases)
+	}
+}
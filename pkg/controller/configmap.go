@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/birdrides/nlb-attacher/pkg/event"
+)
+
+// WatchConfigMap - call onChange with the config map whenever it is created or its data changes,
+// and with nil when it is deleted. The watch stops with the controller. The returned func reports whether the first list was handled
+func (controller *Controller) WatchConfigMap(namespace string, name string, onChange func(*v1.ConfigMap)) cache.InformerSynced {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	api := controller.clientset.CoreV1()
+
+	listWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return api.ConfigMaps(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return api.ConfigMaps(namespace).Watch(options)
+		},
+	}
+
+	_, informer := cache.NewInformer(listWatcher, &v1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if configMap, ok := obj.(*v1.ConfigMap); ok {
+				onChange(configMap)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldConfigMap, oldOk := old.(*v1.ConfigMap)
+			newConfigMap, newOk := new.(*v1.ConfigMap)
+			if oldOk && newOk && oldConfigMap.ResourceVersion != newConfigMap.ResourceVersion {
+				onChange(newConfigMap)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			onChange(nil)
+		},
+	})
+
+	log.Infof("Watching config map %s", fmt.Sprintf("%s/%s", namespace, name))
+	go informer.Run(controller.shutdownChannel)
+	return informer.HasSynced
+}
+
+// requeuePods queues an update of every cached pod that match accepts, and returns how many it queued
+func (controller *Controller) requeuePods(match func(pod *v1.Pod) bool) int {
+	requeued := 0
+	for _, obj := range controller.informer.GetIndexer().List() {
+		pod, ok := obj.(*v1.Pod)
+		if !ok || !match(pod) {
+			continue
+		}
+		controller.enqueue(event.Event{Key: pod.Namespace + "/" + pod.Name, EventType: "update"}, pod)
+		requeued++
+	}
+	return requeued
+}
@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -11,17 +14,44 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
-	"github.com/birdrides/nlb-attacher/pkg/aws"
+	"github.com/birdrides/nlb-attacher/pkg/access"
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	// registers the elbv2 backend
+	_ "github.com/birdrides/nlb-attacher/pkg/aws"
 	"github.com/birdrides/nlb-attacher/pkg/config"
 	"github.com/birdrides/nlb-attacher/pkg/event"
+	"github.com/birdrides/nlb-attacher/pkg/events"
 	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+)
+
+// itemTimeout bounds the handler calls made for a single queued event
+const itemTimeout = 2 * time.Minute
+
+var (
+	eventErrorsTotal = metrics.NewCounter(
+		"nlb_attacher_event_errors_total",
+		"Failed attempts at processing a queued event by event type, error class and failed backend.",
+		"event_type", "class", "backend",
+	)
+	eventFailuresTotal = metrics.NewCounter(
+		"nlb_attacher_event_failures_total",
+		"Queued events dropped after a terminal error or too many retries.",
+		"event_type", "class", "backend",
+	)
 )
 
 // Controller - the primary struct responsible for all cluster actions
@@ -29,33 +59,74 @@ type Controller struct {
 	clientset       kubernetes.Interface
 	queue           workqueue.RateLimitingInterface
 	informer        cache.SharedIndexInformer
-	eventHandler    handlers.Handler
-	config          config.Config
+	nsInformer      cache.SharedIndexInformer
+	nodeInformer    cache.SharedIndexInformer
+	recorder        *events.Recorder
+	eventHandler    *handlers.Composite
+	config          *config.Store
+	rateLimiter     *reloadableBucketRateLimiter
 	serverStartTime time.Time
 	shutdownChannel chan struct{}
+	state           *state.Store
+	aliases         *alias.Store
+	policy          *policy.Store
+	// watchesSynced report whether the alias and policy config maps and the nodes were read
+	watchesSynced []cache.InformerSynced
+	// auditLog is the sink the controller opened from the audit-log setting, closed once the queue drained
+	auditLog *audit.JSONLinesSink
+
+	// ctx is the parent of every item's context, cancelled when the shutdown deadline passes
+	ctx            context.Context
+	cancelInFlight context.CancelFunc
+	// drained is closed once the worker took the last event off the shut down queue, or by Drain when no worker started
+	drained   chan struct{}
+	drainOnce sync.Once
+	// workerMutex guards workerStarted, so Drain and the start of the worker do not cross
+	workerMutex   sync.Mutex
+	workerStarted bool
+
+	detachedMutex sync.Mutex
+	detachedPods  map[string]detachment
+
+	// handledPods holds the last version of each pod the handlers processed without error.
+	// It is the old pod of the next update and the pod removed on the final delete
+	handledMutex sync.Mutex
+	handledPods  map[string]*v1.Pod
+
+	// traces holds the span that queued each waiting event while tracing is enabled
+	tracesMutex sync.Mutex
+	traces      map[event.Event]queuedTrace
+}
+
+// NewController - create a controller talking to the cluster from the kubeconfig or the in cluster config
+func NewController(configStore *config.Store, globalShutdownChan chan struct{}) *Controller {
+	return NewControllerWithClientset(configStore, returnK8sClient(), globalShutdownChan)
 }
 
-const maxRetries int = 5
-const enableLabelValue string = "nlb-attacher.bird.co/enabled"
-const targetGroupAnnotationKey string = "nlb-attacher.bird.co/target-groups"
+// NewControllerWithClientset - create a controller using the given kubernetes client
+func NewControllerWithClientset(configStore *config.Store, clientset kubernetes.Interface, globalShutdownChan chan struct{}) *Controller {
+	config := configStore.Get()
 
-func NewController(globalShutdownChan chan struct{}) *Controller {
-	//initialize kubernetes client and api handler
-	clientset := returnK8sClient()
+	//initialize kubernetes api handler
 	api := clientset.CoreV1()
 
 	//generate our set of filtering options
-	//TODO: use namespace option from config to restrict
-	listOptions := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=true", enableLabelValue),
+	labelSelector := fmt.Sprintf("%s=true", config.GetEnabledLabelKey())
+
+	namespace := metav1.NamespaceAll
+	if config.GetNamespace() != "" {
+		namespace = config.GetNamespace()
 	}
 
+	// the informer's options carry the resource version, so a dropped watch resumes where the list left off
 	listFunc := func(innerListOptions metav1.ListOptions) (runtime.Object, error) {
-		return api.Pods(metav1.NamespaceAll).List(listOptions)
+		innerListOptions.LabelSelector = labelSelector
+		return api.Pods(namespace).List(innerListOptions)
 	}
 
 	watchFunc := func(innerListOptions metav1.ListOptions) (watch.Interface, error) {
-		return api.Pods(metav1.NamespaceAll).Watch(listOptions)
+		innerListOptions.LabelSelector = labelSelector
+		return api.Pods(namespace).Watch(innerListOptions)
 	}
 
 	listWatcher := cache.ListWatch{
@@ -66,21 +137,78 @@ func NewController(globalShutdownChan chan struct{}) *Controller {
 	informer := cache.NewSharedIndexInformer(
 		&listWatcher,
 		&v1.Pod{},
-		time.Second*60,
+		config.GetResyncPeriod(),
 		cache.Indexers{},
 	)
 
-	//Initialize AWS context by fetching all target groups and ELBS
-	eventHandler := new(aws.Handler)
-	eventHandler.Init(targetGroupAnnotationKey, enableLabelValue)
+	//namespaces are watched for the per namespace dry-run annotation
+	nsListWatcher := cache.NewListWatchFromClient(api.RESTClient(), "namespaces", metav1.NamespaceAll, fields.Everything())
+	nsInformer := cache.NewSharedIndexInformer(nsListWatcher, &v1.Namespace{}, config.GetResyncPeriod(), cache.Indexers{})
+	recorder := events.NewRecorder(clientset, 1000)
 
 	c := &Controller{
 		clientset:       clientset,
-		eventHandler:    eventHandler,
 		informer:        informer,
+		nsInformer:      nsInformer,
+		recorder:        recorder,
+		config:          configStore,
+		rateLimiter:     newReloadableBucketRateLimiter(config.GetQueueQPS(), config.GetQueueBurst()),
 		shutdownChannel: globalShutdownChan,
+		detachedPods:    make(map[string]detachment),
+		handledPods:     make(map[string]*v1.Pod),
+		traces:          make(map[event.Event]queuedTrace),
+		drained:         make(chan struct{}),
+	}
+	if config.GetNodeDrain() {
+		//nodes are watched to deregister the pods of draining nodes ahead of their eviction
+		c.nodeInformer = newNodeInformer(clientset, config.GetResyncPeriod())
+	}
+	c.ctx, c.cancelInFlight = context.WithCancel(context.Background())
+	c.state = newStateStore(config, c)
+	if namespace, name := config.GetAliasConfigMap(); name != "" {
+		c.aliases = alias.NewStore(namespace, name)
+	}
+	if namespace, name := config.GetPolicyConfigMap(); name != "" {
+		c.policy = policy.NewStore(namespace, name)
+	}
+
+	deps := handlers.Dependencies{
+		Clientset:  clientset,
+		Config:     configStore,
+		Namespaces: corelisters.NewNamespaceLister(nsInformer.GetIndexer()),
+		Pods:       corelisters.NewPodLister(informer.GetIndexer()),
+		Recorder:   recorder,
+		State:      c.state,
+		Aliases:    c.aliases,
+		Policy:     c.policy,
+		Detached:   c.isDetached,
+		Stop:       globalShutdownChan,
+	}
+	if c.nodeInformer != nil {
+		deps.Nodes = corelisters.NewNodeLister(c.nodeInformer.GetIndexer())
+	}
+	if config.GetAccessReview() {
+		deps.Access = access.NewReviewer(clientset, config.GetAccessReviewCachePeriod())
+	}
+	if config.GetAuditLog() != "" {
+		sink, err := audit.Open(config.GetAuditLog())
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.auditLog = sink
+		deps.Audit = sink
 	}
 
+	//Initialize every configured backend, the AWS context among them
+	eventHandler, err := handlers.NewComposite(config.GetBackends(), deps)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := eventHandler.Init(config.GetTargetGroupAnnotationKey(), config.GetEnabledLabelKey()); err != nil {
+		log.Fatal(err)
+	}
+	c.eventHandler = eventHandler
+
 	c.configureController() //controller.clientset, controller.eventHandler, informer)
 
 	return c
@@ -88,33 +216,36 @@ func NewController(globalShutdownChan chan struct{}) *Controller {
 
 //TODO: better combine/split/refactor this and the Init method
 func (controller *Controller) configureController() {
-	controller.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
-	var newEvent event.Event
-	var err error
+	controller.queue = workqueue.NewRateLimitingQueue(newControllerRateLimiter(controller.rateLimiter))
+	controller.config.Subscribe(func(config *config.Config) {
+		controller.rateLimiter.Update(config.GetQueueQPS(), config.GetQueueBurst())
+	})
 	controller.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			newEvent.Key, err = cache.MetaNamespaceKeyFunc(obj)
-			newEvent.EventType = "create"
-			log.WithField("pkg", "pod").Infof("Processing add to: %s", newEvent.Key)
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			log.WithField("pkg", "pod").Infof("Processing add to: %s", key)
 			if err == nil {
-				controller.queue.Add(newEvent)
+				controller.enqueue(event.Event{Key: key, EventType: "create"}, obj)
 			}
 		},
 		UpdateFunc: func(old, new interface{}) {
-			newEvent.Key, err = cache.MetaNamespaceKeyFunc(new)
-			newEvent.EventType = "update"
-			log.WithField("pkg", "pod").Infof("Processing update to %s", newEvent.Key)
+			key, err := cache.MetaNamespaceKeyFunc(new)
+			if oldPod, ok := old.(*v1.Pod); ok {
+				if newPod, ok := new.(*v1.Pod); ok && onlyAttacherWrites(oldPod, newPod) {
+					log.WithField("pkg", "pod").Debugf("Ignoring update to %s that only changed the attacher's status", key)
+					return
+				}
+			}
+			log.WithField("pkg", "pod").Infof("Processing update to %s", key)
 			if err == nil {
-				controller.queue.Add(newEvent)
+				controller.enqueue(event.Event{Key: key, EventType: "update"}, new)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			newEvent.Key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-			newEvent.EventType = "delete"
-			newEvent.Namespace = GetObjectMetaData(obj).Namespace
-			log.WithField("pkg", "pod").Infof("Processing delete to %s", newEvent.Key)
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			log.WithField("pkg", "pod").Infof("Processing delete to %s", key)
 			if err == nil {
-				controller.queue.Add(newEvent)
+				controller.enqueue(event.Event{Key: key, EventType: "delete", Namespace: namespaceOfKey(key)}, obj)
 			}
 		},
 	})
@@ -126,28 +257,66 @@ func (controller *Controller) Run() {
 	defer utilruntime.HandleCrash()
 	// make sure the work queue is shutdown which will trigger workers to end
 	defer controller.queue.ShutDown()
+	// persist what the drained events changed before the next controller loads it
+	defer controller.closeState()
 
 	log.Info("Starting nlb-attacher")
 	controller.serverStartTime = time.Now().Local()
 
+	// without a Drain first, stopping cancels whatever is in flight and drops the rest of the queue
+	go func() {
+		<-controller.shutdownChannel
+		controller.queue.ShutDown()
+		controller.cancelInFlight()
+	}()
+
 	go controller.informer.Run(controller.shutdownChannel)
+	go controller.nsInformer.Run(controller.shutdownChannel)
+	go controller.recorder.Run(controller.shutdownChannel)
+	go controller.state.Run(controller.shutdownChannel)
+	controller.watchAliases()
+	controller.watchPolicy()
+	controller.watchNodes()
 
 	// wait for the caches to synchronize before starting the worker
 	if !cache.WaitForCacheSync(controller.shutdownChannel, controller.HasSynced) {
 		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+		controller.closeDrained()
+		return
+	}
+	if !controller.startWorker() {
+		log.Info("nlb-attacher was drained before its caches synced, not starting the worker")
 		return
 	}
 
 	log.Info("nlb-attacher synced and ready")
+	controller.restoreState()
 
 	// runWorker will loop until "something bad" happens.  The .Until will
-	// then rekick the worker after one second
-	wait.Until(controller.runWorker, time.Second, controller.shutdownChannel)
+	// then rekick the worker after one second, until the queue is drained
+	wait.Until(controller.runWorker, time.Second, controller.drained)
+}
+
+// closeState writes the last state changes and closes the audit log
+func (controller *Controller) closeState() {
+	if err := controller.state.Flush(); err != nil {
+		log.Error(err)
+	}
+	if controller.auditLog != nil {
+		if err := controller.auditLog.Close(); err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 // HasSynced is required for the cache.Controller interface.
 func (controller *Controller) HasSynced() bool {
-	return controller.informer.HasSynced()
+	for _, synced := range controller.watchesSynced {
+		if !synced() {
+			return false
+		}
+	}
+	return controller.informer.HasSynced() && controller.nsInformer.HasSynced()
 }
 
 // LastSyncResourceVersion is required for the cache.Controller interface.
@@ -160,6 +329,26 @@ func (controller *Controller) runWorker() {
 	for controller.processNextItem() {
 		// continue looping
 	}
+	// the queue is shut down and empty
+	controller.closeDrained()
+}
+
+// startWorker records that the worker starts. It returns false when the controller was drained already
+func (controller *Controller) startWorker() bool {
+	controller.workerMutex.Lock()
+	defer controller.workerMutex.Unlock()
+	select {
+	case <-controller.drained:
+		return false
+	default:
+	}
+	controller.workerStarted = true
+	return true
+}
+
+// closeDrained closes drained once
+func (controller *Controller) closeDrained() {
+	controller.drainOnce.Do(func() { close(controller.drained) })
 }
 
 // processNextWorkItem deals with one key off the queue.  It returns false
@@ -174,68 +363,216 @@ func (controller *Controller) processNextItem() bool {
 
 	defer controller.queue.Done(newEvent)
 
-	err := controller.processItem(newEvent.(event.Event))
+	if controller.cancelled() {
+		log.Warnf("Shutdown deadline passed, leaving %s to the next controller", newEvent)
+		controller.queue.Forget(newEvent)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(controller.ctx, itemTimeout)
+	defer cancel()
+	ev := newEvent.(event.Event)
+	ctx = audit.WithTrigger(ctx, audit.Trigger{Event: ev.EventType, Key: ev.Key, Reason: ev.Reason})
+	ctx, span := tracing.Start(controller.traceDequeued(ctx, ev), "processItem", tracing.KindConsumer,
+		tracing.String("nlb_attacher.event", ev.EventType),
+		tracing.String("nlb_attacher.backend", ev.Backend),
+		tracing.String("k8s.pod.key", ev.Key),
+	)
+	defer span.End()
 
+	err := controller.processItem(ctx, ev)
+	span.RecordError(err)
 	if err == nil {
 		// No error, tell the queue to stop tracking history
 		controller.queue.Forget(newEvent)
-	} else if controller.queue.NumRequeues(newEvent) < maxRetries {
+		return true
+	}
+
+	if backendErrs, ok := handlers.AsBackendErrors(err); ok && ev.Backend == "" {
+		// retry only the backends that failed, each with its own backoff and retry count
+		controller.queue.Forget(newEvent)
+		for backend, backendErr := range backendErrs {
+			retry := ev
+			retry.Backend = backend
+			controller.handleError(ctx, retry, backendErr)
+		}
+		return true
+	}
+
+	controller.handleError(ctx, ev, err)
+	return true
+}
+
+// handleError requeues or drops a failed event depending on the class of its error
+func (controller *Controller) handleError(ctx context.Context, newEvent event.Event, err error) {
+	class := handlers.Classify(err)
+	eventErrorsTotal.Inc(newEvent.EventType, string(class), newEvent.Backend)
+
+	switch {
+	case controller.stopping() && class != handlers.Terminal:
+		// the queue takes no retries anymore, the next controller's initial list picks the pod up again
+		log.Warnf("Error processing %s while shutting down (not retrying): %v", newEvent, err)
+		controller.queue.Forget(newEvent)
+	case class == handlers.Throttled:
+		// throttling clears up on its own, so it does not use up the retries
+		log.Warnf("Throttled processing %s (will retry): %v", newEvent, err)
+		controller.requeue(ctx, newEvent)
+	case class == handlers.Retryable && controller.queue.NumRequeues(newEvent) < controller.config.Get().GetMaxRetries():
 		log.Errorf("Error processing %s (will retry): %v", newEvent, err)
 		// requeue the item to work on later
-		controller.queue.AddRateLimited(newEvent)
-	} else {
-		// err != nil and too many retries
-		log.Errorf("Error processing %s (giving up): %v", newEvent, err)
+		controller.requeue(ctx, newEvent)
+	default:
+		// terminal error, or too many retries
+		log.Errorf("Error processing %s (giving up, %s): %v", newEvent, class, err)
 		controller.queue.Forget(newEvent)
-		//TODO: calling this sends the main control loop into a stall. investigate why
+		controller.surfaceFailure(newEvent, class, err)
 	}
+}
 
-	return true
+// handlerFor returns the backend an event is limited to, or every backend
+func (controller *Controller) handlerFor(newEvent event.Event) handlers.Handler {
+	if newEvent.Backend != "" {
+		if backend := controller.eventHandler.Backend(newEvent.Backend); backend != nil {
+			return backend
+		}
+	}
+	return controller.eventHandler
+}
+
+// surfaceFailure records an event that was dropped on the pod it was about
+func (controller *Controller) surfaceFailure(newEvent event.Event, class handlers.ErrorClass, err error) {
+	eventFailuresTotal.Inc(newEvent.EventType, string(class), newEvent.Backend)
+
+	pod, lookupErr := controller.podFromStore(newEvent.Key)
+	if lookupErr != nil || pod == nil {
+		return
+	}
+	backend := newEvent.Backend
+	if backend == "" {
+		backend = strings.Join(controller.eventHandler.Names(), ",")
+	}
+	controller.recorder.Eventf(pod, v1.EventTypeWarning, "TargetGroupSyncFailed",
+		"Giving up on %s event for backend %s after a %s error: %v", newEvent.EventType, backend, class, err)
 }
 
-func (controller *Controller) processItem(newEvent event.Event) error {
+func (controller *Controller) processItem(ctx context.Context, newEvent event.Event) error {
 	log.Debugf("Handle event: %v", newEvent)
-	obj, _, err := controller.informer.GetIndexer().GetByKey(newEvent.Key)
+
+	switch newEvent.EventType {
+	case "reconcile", "reconcile-targetgroup", "detach", "reattach", "pause", "resume":
+		return controller.processAdminItem(ctx, newEvent)
+	}
+
+	if newEvent.EventType != "delete" && !controller.config.Get().NamespaceAllowed(namespaceOfKey(newEvent.Key)) {
+		log.Debugf("Namespace of %s is filtered out, skipping %s event", newEvent.Key, newEvent.EventType)
+		return nil
+	}
+
+	obj, exists, err := controller.informer.GetIndexer().GetByKey(newEvent.Key)
 	if err != nil {
 		return fmt.Errorf("Error fetching object with key %s from store: %v", newEvent.Key, err)
 	}
-
-	objectMeta := GetObjectMetaData(obj)
+	if !exists && newEvent.EventType != "delete" {
+		log.Debugf("Pod %s no longer exists, skipping %s event", newEvent.Key, newEvent.EventType)
+		return nil
+	}
 
 	currPod, typePod := obj.(*v1.Pod)
+	if newEvent.EventType == "delete" {
+		if !exists {
+			// a detach ends with the pod, its removal is handled like that of any other pod
+			controller.clearDetached(newEvent.Key)
+		}
+	} else if typePod && controller.isDetached(currPod) {
+		log.Infof("Pod %s is detached by an operator, skipping %s event", newEvent.Key, newEvent.EventType)
+		return nil
+	}
+	eventHandler := controller.handlerFor(newEvent)
 
 	// process events based on its type
 	switch newEvent.EventType {
 	case "create":
 		if typePod {
-			if controller.config.GetOnlyNewPods() {
+			if previous := controller.lastHandled(newEvent.Key); previous != nil {
+				// pods restored from the persisted state, or whose delete was never processed, were handled before
+				return controller.handled(newEvent.Key, currPod, controller.resumeHandled(ctx, eventHandler, previous, currPod))
+			}
+			if controller.config.Get().GetOnlyNewPods() {
+				objectMeta := GetObjectMetaData(obj)
 				if objectMeta.CreationTimestamp.Sub(controller.serverStartTime).Seconds() > 0 {
-					controller.eventHandler.PodCreated(currPod)
+					return controller.handled(newEvent.Key, currPod, eventHandler.PodCreated(ctx, currPod))
 				}
-			} else {
-				log.Debug("inside create event")
-				controller.eventHandler.PodCreated(currPod)
+				return nil
 			}
-			return nil
+			log.Debug("inside create event")
+			return controller.handled(newEvent.Key, currPod, eventHandler.PodCreated(ctx, currPod))
 		}
 		log.Debug(reflect.TypeOf(obj))
-		return fmt.Errorf("Returned object is not of type Pod: %v", obj)
+		return handlers.NewTerminal(fmt.Errorf("Returned object is not of type Pod: %v", obj))
 
 	case "update":
 		if typePod {
-			controller.eventHandler.PodUpdated(currPod, currPod)
-			return nil
+			oldPod := controller.lastHandled(newEvent.Key)
+			if oldPod == nil {
+				oldPod = currPod
+			}
+			return controller.handled(newEvent.Key, currPod, eventHandler.PodUpdated(ctx, oldPod, currPod))
 		}
 		log.Debug(reflect.TypeOf(obj))
-		return fmt.Errorf("Returned object is not of type Pod: %v", obj)
+		return handlers.NewTerminal(fmt.Errorf("Returned object is not of type Pod: %v", obj))
 
 	case "delete":
-		//TODO: handle DeletedFinalStateUnknown
-		//TODO: final deletion event simply gives us the pod name and the fact that it's been deleted
-		//this is not enough to call the handler deletion func
-		//controller.eventHandler.PodDeleted(currPod)
-		log.Warn("We do not currently handle the final deletion event. you might be accumulating dead pods")
+		deletedPod := controller.lastHandled(newEvent.Key)
+		switch {
+		case deletedPod == nil:
+			log.Debugf("Pod %s was never handled, nothing to remove", newEvent.Key)
+			return nil
+		case typePod && currPod.UID == deletedPod.UID:
+			log.Debugf("Pod %s still exists, ignoring stale delete event", newEvent.Key)
+			return nil
+		case deletedPod.DeletionTimestamp != nil:
+			// removed from its target groups when it was marked for deletion
+			controller.forgetHandled(newEvent.Key)
+			return nil
+		}
+
+		if err := eventHandler.PodDeleted(ctx, deletedPod); err != nil {
+			return err
+		}
+		controller.forgetHandled(newEvent.Key)
 		return nil
 	}
 	return nil
 }
+
+// handled remembers the pod as the last version the handlers processed, when they did so without error
+func (controller *Controller) handled(key string, pod *v1.Pod, err error) error {
+	if err != nil {
+		return err
+	}
+	controller.handledMutex.Lock()
+	defer controller.handledMutex.Unlock()
+	controller.handledPods[key] = pod
+	return nil
+}
+
+func (controller *Controller) lastHandled(key string) *v1.Pod {
+	controller.handledMutex.Lock()
+	defer controller.handledMutex.Unlock()
+	return controller.handledPods[key]
+}
+
+func (controller *Controller) forgetHandled(key string) {
+	controller.handledMutex.Lock()
+	defer controller.handledMutex.Unlock()
+	delete(controller.handledPods, key)
+}
+
+// namespaceOfKey returns the namespace part of a namespace/name cache key
+func namespaceOfKey(key string) string {
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return ""
+	}
+	return namespace
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// NewClientset - return a client for the cluster from the kubeconfig or the in cluster config, the same one the controller uses
+func NewClientset() kubernetes.Interface {
+	return returnK8sClient()
+}
+
+// CurrentNamespace - return the namespace of the kubeconfig's current context, default when it sets none
+func CurrentNamespace() string {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).Namespace()
+	if err != nil || namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
+
+// ManagedPods - list the pods the controller would handle: those with the enabled label in the watched, allowed namespaces
+func ManagedPods(cfg *config.Config, clientset kubernetes.Interface) ([]*v1.Pod, error) {
+	namespace := metav1.NamespaceAll
+	if cfg.GetNamespace() != "" {
+		namespace = cfg.GetNamespace()
+	}
+	list, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", cfg.GetEnabledLabelKey()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	pods := make([]*v1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		if cfg.NamespaceAllowed(list.Items[i].Namespace) {
+			pods = append(pods, &list.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// RecordedState - return the persisted state with its record loaded, or nil when persistence is disabled.
+// It holds the targets the controller registered and the target groups and pods the admin api paused and detached
+func RecordedState(cfg *config.Config, clientset kubernetes.Interface) (*state.Store, error) {
+	namespace, name := cfg.GetStateConfigMap()
+	if name == "" {
+		return nil, nil
+	}
+	store := state.NewStore(clientset, namespace, name)
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// TargetGroupAliases - return the aliases of the alias config map, or nil when no alias config map is configured
+func TargetGroupAliases(cfg *config.Config, clientset kubernetes.Interface) (*alias.Store, error) {
+	namespace, name := cfg.GetAliasConfigMap()
+	if name == "" {
+		return nil, nil
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alias config map %s/%s: %v", namespace, name, err)
+	}
+	store := alias.NewStore(namespace, name)
+	store.Update(configMap)
+	return store, nil
+}
+
+// TargetGroupPolicy - return the policy of the policy config map with a lister of the namespaces it selects by,
+// or nil when no policy config map is configured
+func TargetGroupPolicy(cfg *config.Config, clientset kubernetes.Interface) (*policy.Store, corelisters.NamespaceLister, error) {
+	namespace, name := cfg.GetPolicyConfigMap()
+	if name == "" {
+		return nil, nil, nil
+	}
+	store := policy.NewStore(namespace, name)
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return store, nil, fmt.Errorf("failed to get policy config map %s/%s: %v", namespace, name, err)
+	}
+	store.Update(configMap)
+
+	list, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return store, nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range list.Items {
+		if err := indexer.Add(&list.Items[i]); err != nil {
+			return store, nil, err
+		}
+	}
+	return store, corelisters.NewNamespaceLister(indexer), nil
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newNodeInformer returns an informer of every node, the handlers look up whether a pod's node is draining in its cache
+func newNodeInformer(clientset kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	listWatcher := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "nodes", metav1.NamespaceAll, fields.Everything())
+	return cache.NewSharedIndexInformer(listWatcher, &v1.Node{}, resyncPeriod, cache.Indexers{})
+}
+
+// watchNodes queues the pods of a node when it is cordoned or uncordoned, or its taints change, so the handlers
+// deregister them ahead of their eviction from a draining node, and register them again if the drain is called off
+func (controller *Controller) watchNodes() {
+	if controller.nodeInformer == nil {
+		return
+	}
+	controller.nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldNode, oldOk := old.(*v1.Node)
+			newNode, newOk := new.(*v1.Node)
+			if !oldOk || !newOk || !drainChanged(oldNode, newNode) {
+				return
+			}
+			requeued := controller.requeuePods(func(pod *v1.Pod) bool { return pod.Spec.NodeName == newNode.Name })
+			if requeued > 0 {
+				log.Infof("Requeued %d pods after node %s was cordoned, uncordoned or tainted", requeued, newNode.Name)
+			}
+		},
+	})
+	go controller.nodeInformer.Run(controller.shutdownChannel)
+	controller.watchesSynced = append(controller.watchesSynced, controller.nodeInformer.HasSynced)
+}
+
+// drainChanged reports whether the node was cordoned, uncordoned or had its taints changed
+func drainChanged(oldNode *v1.Node, newNode *v1.Node) bool {
+	return oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable || !reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints)
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+)
+
+// Policy - return the policy deciding which target groups the pods of each namespace may use, nil when none is configured
+func (controller *Controller) Policy() *policy.Store {
+	return controller.policy
+}
+
+// Namespaces - return the lister of the namespaces the controller watches
+func (controller *Controller) Namespaces() corelisters.NamespaceLister {
+	return corelisters.NewNamespaceLister(controller.nsInformer.GetIndexer())
+}
+
+// watchPolicy keeps the policy in step with the policy config map. Every pod is queued when the policy changes,
+// and the pods of a namespace when its labels change, as rules may select namespaces by them
+func (controller *Controller) watchPolicy() {
+	if controller.policy == nil {
+		return
+	}
+	namespace, name := controller.config.Get().GetPolicyConfigMap()
+	synced := controller.WatchConfigMap(namespace, name, func(configMap *v1.ConfigMap) {
+		if !controller.policy.Update(configMap) {
+			return
+		}
+		if requeued := controller.requeuePods(func(*v1.Pod) bool { return true }); requeued > 0 {
+			log.Infof("Requeued %d pods after the policy changed", requeued)
+		}
+	})
+	controller.watchesSynced = append(controller.watchesSynced, synced)
+
+	controller.nsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldNamespace, oldOk := old.(*v1.Namespace)
+			newNamespace, newOk := new.(*v1.Namespace)
+			if !oldOk || !newOk || reflect.DeepEqual(oldNamespace.Labels, newNamespace.Labels) {
+				return
+			}
+			requeued := controller.requeuePods(func(pod *v1.Pod) bool { return pod.Namespace == newNamespace.Name })
+			if requeued > 0 {
+				log.Infof("Requeued %d pods after the labels of namespace %s changed", requeued, newNamespace.Name)
+			}
+		},
+	})
+}
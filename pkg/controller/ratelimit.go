@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// reloadableBucketRateLimiter is the overall token bucket of the default controller rate limiter,
+// except that its limit and burst can be changed while the queue is running
+type reloadableBucketRateLimiter struct {
+	limiter *rate.Limiter
+}
+
+func newReloadableBucketRateLimiter(qps float64, burst int) *reloadableBucketRateLimiter {
+	return &reloadableBucketRateLimiter{limiter: rate.NewLimiter(rate.Limit(qps), burst)}
+}
+
+// When - return how long the item should wait before being processed
+func (limiter *reloadableBucketRateLimiter) When(item interface{}) time.Duration {
+	return limiter.limiter.Reserve().Delay()
+}
+
+// NumRequeues - the bucket does not track individual items
+func (limiter *reloadableBucketRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
+
+// Forget - the bucket does not track individual items
+func (limiter *reloadableBucketRateLimiter) Forget(item interface{}) {
+}
+
+// Update - change the limit and burst of the bucket. Tokens already spent are not refunded
+func (limiter *reloadableBucketRateLimiter) Update(qps float64, burst int) {
+	// adjusted in place: a new limiter would start with a full burst
+	limiter.limiter.SetLimit(rate.Limit(qps))
+	limiter.limiter.SetBurst(burst)
+}
+
+// newControllerRateLimiter mirrors workqueue.DefaultControllerRateLimiter with a reloadable bucket
+func newControllerRateLimiter(bucket *reloadableBucketRateLimiter) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, 1000*time.Second),
+		bucket,
+	)
+}
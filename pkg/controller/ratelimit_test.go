@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestReloadableBucketRateLimiter(t *testing.T) {
+	cases := []struct {
+		name  string
+		qps   float64
+		burst int
+	}{
+		{"same settings", 1, 2},
+		{"raised burst", 1, 100},
+		{"lowered rate", 0.5, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket := newReloadableBucketRateLimiter(1, 2)
+			for i := 0; i < 2; i++ {
+				if delay := bucket.When(i); delay != 0 {
+					t.Fatalf("When() = %s within the burst, want 0", delay)
+				}
+			}
+
+			bucket.Update(tc.qps, tc.burst)
+			if got := float64(bucket.limiter.Limit()); got != tc.qps {
+				t.Errorf("limit = %g, want %g", got, tc.qps)
+			}
+			if got := bucket.limiter.Burst(); got != tc.burst {
+				t.Errorf("burst = %d, want %d", got, tc.burst)
+			}
+			// the spent burst is not handed out again
+			if delay := bucket.When("next"); delay == 0 {
+				t.Error("When() = 0 right after the reload, want the next item to wait for a token")
+			}
+		})
+	}
+}
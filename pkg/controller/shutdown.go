@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cancelGrace is how long the worker gets to return once the calls in flight are cancelled
+const cancelGrace = 5 * time.Second
+
+// ShuttingDownError is returned by the admin actions once the controller stopped taking events
+type ShuttingDownError struct{}
+
+func (err ShuttingDownError) Error() string {
+	return "the controller is shutting down"
+}
+
+// Unavailable - mark the error as a temporary refusal
+func (err ShuttingDownError) Unavailable() bool {
+	return true
+}
+
+// Drain - stop taking events and let the worker finish the queued ones. When ctx is done first, the calls in flight
+// are cancelled and the rest of the queue is dropped unprocessed, to be picked up by the next controller's initial list.
+// Draining never deregisters anything on its own: only deletions that already happened are processed
+func (controller *Controller) Drain(ctx context.Context) error {
+	queued := controller.queue.Len()
+	log.Infof("Draining the queue, %d events left", queued)
+	controller.queue.ShutDown()
+
+	controller.workerMutex.Lock()
+	if !controller.workerStarted {
+		// the caches never synced, the next controller's initial list picks the queued events up
+		controller.closeDrained()
+		controller.workerMutex.Unlock()
+		log.Info("The worker never started, nothing to drain")
+		return nil
+	}
+	controller.workerMutex.Unlock()
+
+	select {
+	case <-controller.drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	left := controller.queue.Len()
+	log.Warnf("Shutdown deadline passed, cancelling the calls in flight and dropping %d queued events", left)
+	controller.cancelInFlight()
+	select {
+	case <-controller.drained:
+	case <-time.After(cancelGrace):
+		log.Warn("The worker did not return after cancelling the calls in flight")
+	}
+	return fmt.Errorf("the shutdown deadline passed with %d events queued", left)
+}
+
+// stopping reports whether the controller stopped taking events
+func (controller *Controller) stopping() bool {
+	return controller.queue.ShuttingDown()
+}
+
+// cancelled reports whether the calls in flight were cancelled because the shutdown deadline passed
+func (controller *Controller) cancelled() bool {
+	return controller.ctx.Err() != nil
+}
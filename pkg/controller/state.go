@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/event"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// newStateStore returns the store of the configured state config map with its record loaded, or nil when persistence is disabled
+func newStateStore(cfg *config.Config, controller *Controller) *state.Store {
+	namespace, name := cfg.GetStateConfigMap()
+	if name == "" {
+		return nil
+	}
+	store := state.NewStore(controller.clientset, namespace, name)
+	if err := store.Load(); err != nil {
+		log.Errorf("Starting without persisted state, it is overwritten on the next write: %v", err)
+	}
+	return store
+}
+
+// restoreState seeds the handled pods with the targets the previous controller registered. Recorded pods that are gone,
+// or were replaced by a pod of the same name, were deleted while no controller was running and get a delete event.
+// Targets that are not in the record were never ours and are left alone. The recorded pauses and detaches are taken over
+func (controller *Controller) restoreState() {
+	controller.restoreAdminState()
+
+	owners := controller.state.Owners()
+	if len(owners) == 0 {
+		return
+	}
+	key := controller.config.Get().GetTargetGroupAnnotationKey()
+
+	deleted := 0
+	for _, owner := range owners {
+		pod, err := restoredPod(owner, key)
+		if err != nil {
+			log.Errorf("Ignoring the persisted targets of pod %s: %v", owner.Pod, err)
+			continue
+		}
+		controller.handledMutex.Lock()
+		controller.handledPods[owner.Pod] = pod
+		controller.handledMutex.Unlock()
+
+		obj, exists, err := controller.informer.GetIndexer().GetByKey(owner.Pod)
+		if err == nil && exists {
+			if current, ok := obj.(*v1.Pod); ok && current.UID == owner.UID {
+				continue
+			}
+		}
+		log.Infof("Pod %s was deleted while the controller was down, removing it from %v", owner.Pod, owner.TargetGroups)
+		controller.queue.Add(event.Event{Key: owner.Pod, EventType: "delete", Namespace: namespaceOfKey(owner.Pod)})
+		deleted++
+	}
+	log.Infof("Restored the targets of %d pods from the persisted state, %d of them were deleted while the controller was down", len(owners), deleted)
+}
+
+// restoreAdminState pauses the recorded target groups again and detaches the recorded pods that are still detached
+func (controller *Controller) restoreAdminState() {
+	for _, tgArn := range controller.state.PausedTargetGroups() {
+		log.Infof("Target group %s was paused before the restart, keeping it paused", tgArn)
+		controller.eventHandler.PauseTargetGroup(tgArn)
+	}
+
+	now := time.Now()
+	for uid, detached := range controller.state.Detachments() {
+		if !detached.Active(now) {
+			controller.state.ClearDetached(uid)
+			continue
+		}
+		var until time.Time
+		if detached.Until != nil {
+			until = detached.Until.Time
+			controller.queue.AddAfter(event.Event{
+				Key:       detached.Pod,
+				Reason:    "detach recorded before the restart expired",
+				EventType: "reattach",
+				Namespace: namespaceOfKey(detached.Pod),
+			}, until.Sub(now))
+		}
+		log.Infof("Pod %s was detached before the restart, keeping it detached", detached.Pod)
+		controller.detachedMutex.Lock()
+		controller.detachedPods[detached.Pod] = detachment{uid: uid, until: until}
+		controller.detachedMutex.Unlock()
+	}
+}
+
+// resumeHandled handles the first event of a pod that the persisted state knew, as an update from the recorded version.
+// A pod that replaced the recorded one under the same name is handled as a delete of the old one and a create
+func (controller *Controller) resumeHandled(ctx context.Context, eventHandler handlers.Handler, previous *v1.Pod, current *v1.Pod) error {
+	if previous.UID == current.UID {
+		return eventHandler.PodUpdated(ctx, previous, current)
+	}
+	if err := eventHandler.PodDeleted(ctx, previous); err != nil {
+		return err
+	}
+	return eventHandler.PodCreated(ctx, current)
+}
+
+// restoredPod builds a pod carrying just enough of the recorded one for the handlers to deregister it
+func restoredPod(owner state.Owner, annotationKey string) (*v1.Pod, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(owner.Pod)
+	if err != nil {
+		return nil, err
+	}
+	targetGroups := make([]annotation.TargetGroup, 0, len(owner.TargetGroups))
+	for _, tgArn := range owner.TargetGroups {
+		targetGroups = append(targetGroups, annotation.TargetGroup{Arn: tgArn, Port: int32(owner.Ports[tgArn])})
+	}
+	value, err := annotation.EncodeV2(targetGroups)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			UID:         owner.UID,
+			Annotations: map[string]string{annotationKey: value},
+		},
+		Status: v1.PodStatus{PodIP: owner.IP},
+	}, nil
+}
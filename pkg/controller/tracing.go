@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/birdrides/nlb-attacher/pkg/event"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+)
+
+// queuedTrace is the span that queued an event and when it was queued, so the worker continues its trace
+type queuedTrace struct {
+	span     tracing.SpanContext
+	queuedAt time.Time
+}
+
+// enqueue adds an informer event to the queue under a span of its own, which the worker's spans continue
+func (controller *Controller) enqueue(newEvent event.Event, obj interface{}) {
+	_, span := tracing.Start(context.Background(), "informer."+newEvent.EventType, tracing.KindProducer, podAttributes(newEvent.Key, obj)...)
+	controller.traceQueued(newEvent, span.Context())
+	controller.queue.Add(newEvent)
+	span.End()
+}
+
+// requeue adds a failed event back with the backoff, continuing the trace of the failed attempt
+func (controller *Controller) requeue(ctx context.Context, newEvent event.Event) {
+	controller.traceQueued(newEvent, tracing.SpanContextFrom(ctx))
+	controller.queue.AddRateLimited(newEvent)
+}
+
+// traceQueued remembers the span that queued the event. An event already waiting keeps the earlier span, like the queue keeps the earlier event
+func (controller *Controller) traceQueued(newEvent event.Event, span tracing.SpanContext) {
+	if !span.IsValid() {
+		return
+	}
+	controller.tracesMutex.Lock()
+	defer controller.tracesMutex.Unlock()
+	if _, ok := controller.traces[newEvent]; !ok {
+		controller.traces[newEvent] = queuedTrace{span: span, queuedAt: time.Now()}
+	}
+}
+
+// traceDequeued records the time the event waited in the queue and returns a context continuing its trace
+func (controller *Controller) traceDequeued(ctx context.Context, newEvent event.Event) context.Context {
+	controller.tracesMutex.Lock()
+	queued, ok := controller.traces[newEvent]
+	delete(controller.traces, newEvent)
+	controller.tracesMutex.Unlock()
+	if !ok {
+		return ctx
+	}
+
+	ctx = tracing.ContextWithSpanContext(ctx, queued.span)
+	_, wait := tracing.StartAt(ctx, "workqueue.wait", tracing.KindInternal, queued.queuedAt,
+		tracing.Int("workqueue.requeues", int64(controller.queue.NumRequeues(newEvent))))
+	wait.End()
+	return ctx
+}
+
+// podAttributes describes the pod of an event, which may be a pod, a deleted final state or nothing
+func podAttributes(key string, obj interface{}) []tracing.Attribute {
+	attributes := []tracing.Attribute{tracing.String("k8s.pod.key", key)}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if pod, ok := obj.(*v1.Pod); ok {
+		attributes = append(attributes,
+			tracing.String("k8s.namespace.name", pod.Namespace),
+			tracing.String("k8s.pod.name", pod.Name),
+			tracing.String("k8s.pod.uid", string(pod.UID)),
+		)
+	}
+	return attributes
+}
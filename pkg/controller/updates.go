@@ -0,0 +1,38 @@
+package controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+)
+
+// onlyAttacherWrites reports whether the update of the pod changed nothing but what the attacher itself writes:
+// the status annotation and the registered and target health conditions. Handling such updates would register
+// the pod again after every status write. Resyncs, which keep the resource version, are never dropped
+func onlyAttacherWrites(oldPod *v1.Pod, newPod *v1.Pod) bool {
+	if oldPod.ResourceVersion == newPod.ResourceVersion {
+		return false
+	}
+	return apiequality.Semantic.DeepEqual(withoutAttacherWrites(oldPod), withoutAttacherWrites(newPod))
+}
+
+// withoutAttacherWrites returns a copy of the pod without the fields the attacher writes and the bookkeeping every write changes
+func withoutAttacherWrites(pod *v1.Pod) *v1.Pod {
+	stripped := pod.DeepCopy()
+	stripped.ResourceVersion = ""
+	stripped.ManagedFields = nil
+	delete(stripped.Annotations, annotation.StatusKey)
+	if len(stripped.Annotations) == 0 {
+		stripped.Annotations = nil
+	}
+	conditions := make([]v1.PodCondition, 0, len(stripped.Status.Conditions))
+	for _, condition := range stripped.Status.Conditions {
+		if condition.Type != readiness.ConditionType && condition.Type != readiness.HealthConditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+	stripped.Status.Conditions = conditions
+	return stripped
+}
@@ -35,6 +35,9 @@ func returnK8sClient() *kubernetes.Clientset {
 		kubeconfig := filepath.Join(
 			os.Getenv("HOME"), ".kube", "config",
 		)
+		if path := os.Getenv("KUBECONFIG"); path != "" {
+			kubeconfig = filepath.SplitList(path)[0]
+		}
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 		if err != nil {
 			log.Fatal(err)
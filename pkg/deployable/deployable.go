@@ -1,83 +1,195 @@
 package deployable
 
 import (
+	"context"
 	"os"
 	"os/signal"
-	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 
+	"github.com/birdrides/nlb-attacher/pkg/aws"
 	"github.com/birdrides/nlb-attacher/pkg/config"
 	"github.com/birdrides/nlb-attacher/pkg/controller"
+	"github.com/birdrides/nlb-attacher/pkg/lifecycle"
 	"github.com/birdrides/nlb-attacher/pkg/server"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+	"github.com/birdrides/nlb-attacher/pkg/webhook"
 )
 
+// serverShutdownTimeout is how long open http requests get once everything else stopped
+const serverShutdownTimeout = 5 * time.Second
+
 type Deployable struct {
 	server          *server.Server
 	controller      *controller.Controller
+	config          *config.Store
 	shutdownChannel chan struct{}
+	traces          *tracing.Exporter
 	running         bool
+	// stopping is set once a shutdown signal arrived, so readiness fails while the queue drains
+	stopping int32
 }
 
-func NewDeployable(config *config.Config) *Deployable {
+func NewDeployable(configStore *config.Store) *Deployable {
 	shutdownChannel := make(chan struct{})
+	config := configStore.Get()
+
+	// spans are exported from the start, so the initial list of pods is traced too
+	var traces *tracing.Exporter
+	if config.GetTracingEndpoint() != "" {
+		traces = tracing.NewExporter(config.GetTracingEndpoint(), "nlb-attacher")
+		tracing.Setup(traces, config.GetTracingSampleRatio())
+		go traces.Run()
+		log.Infof("Exporting trace spans to %s", config.GetTracingEndpoint())
+	}
+
+	c := controller.NewController(configStore, shutdownChannel)
 
 	s := server.NewServer(
-		"0.0.0.0/0",
-		8080,
-		shutdownChannel,
+		config.GetListenAddress(),
+		config.GetListenPort(),
+		c,
+		config.GetAdminToken(),
 	)
 
-	c := controller.NewController(shutdownChannel)
+	if config.WebhooksEnabled() {
+		w := webhook.NewWebhook(config.GetEnabledLabelKey(), config.GetTargetGroupAnnotationKey())
+		w.EnableMutation(aws.NewDeregistrationDelayLookup(5*time.Minute), config.GetWebhookInjectPreStop())
+		w.EnableAliases(c.Aliases())
+		if c.Policy() != nil {
+			w.EnablePolicy(c.Policy(), c.Namespaces())
+		}
+		s.EnableWebhooks(config.GetListenAddress(), config.GetWebhookPort(), config.GetWebhookCertFile(), config.GetWebhookKeyFile(), w.RegisterRoutes)
+	}
 
-	return &Deployable{
+	d := &Deployable{
 		server:          s,
 		controller:      c,
+		config:          configStore,
 		shutdownChannel: shutdownChannel,
+		traces:          traces,
 		running:         false,
 	}
+	s.SetReadiness(func() bool {
+		return atomic.LoadInt32(&d.stopping) == 0 && c.HasSynced()
+	})
+	return d
 }
 
 func (d *Deployable) Run() error {
-	log.Info("Will exit on SIGTERM and SIGINT.")
+	log.Info("Will exit on SIGTERM and SIGINT, and reload configuration on SIGHUP.")
 
-	gracefulStop := make(chan os.Signal)
+	d.config.Subscribe(func(config *config.Config) {
+		log.SetLevel(config.GetLogLevel())
+	})
+	d.watchReloads()
+
+	gracefulStop := make(chan os.Signal, 1)
 
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
 
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-
+	serverDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		wg.Add(1)
-		d.server.Run()
+		defer close(serverDone)
+		if err := d.server.Run(); err != nil {
+			log.Errorf("Server stopped: %v", err)
+		}
 	}()
 
+	controllerDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		wg.Add(1)
+		defer close(controllerDone)
 		d.controller.Run()
 	}()
+	d.running = true
 
-	go func() {
-		defer wg.Done()
-		sig := <-gracefulStop
-		log.Infof("Received and broadcasting shutdown signal (%v)...", sig)
-		// Close global shutdown channel
-		close(d.shutdownChannel)
-	}()
+	select {
+	case sig := <-gracefulStop:
+		log.Infof("Received shutdown signal (%v), shutting down...", sig)
+	case <-controllerDone:
+		log.Error("Controller stopped on its own, shutting down...")
+	}
 
-	wg.Wait()
+	err := d.shutdownStages(controllerDone).Shutdown()
+	<-serverDone
+	d.running = false
 
+	if err != nil {
+		return err
+	}
 	log.Info("Deployable successfully shut down.")
-
 	return nil
 }
 
+// shutdownStages stops taking events first and the http server last, so probes and metrics stay up while the queue drains.
+// Nothing is deregistered because the attacher stops: pods stay in their target groups for the next controller
+func (d *Deployable) shutdownStages(controllerDone chan struct{}) *lifecycle.Manager {
+	manager := lifecycle.NewManager()
+
+	manager.Add("drain queue", d.config.Get().GetShutdownTimeout(), func(ctx context.Context) error {
+		atomic.StoreInt32(&d.stopping, 1)
+		return d.controller.Drain(ctx)
+	})
+
+	manager.Add("stop informers and background work", serverShutdownTimeout, func(ctx context.Context) error {
+		// closing the global shutdown channel stops the informers, the event recorder and the backends' background loops
+		close(d.shutdownChannel)
+		select {
+		case <-controllerDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	if d.traces != nil {
+		manager.Add("export remaining spans", serverShutdownTimeout, func(ctx context.Context) error {
+			return d.traces.Shutdown(ctx)
+		})
+	}
+
+	manager.Add("stop http server", serverShutdownTimeout, func(ctx context.Context) error {
+		return d.server.Shutdown(ctx)
+	})
+	return manager
+}
+
+// watchReloads reloads the configuration on SIGHUP and, when configured, on every config map change
+func (d *Deployable) watchReloads() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-reload:
+				log.Info("Received SIGHUP, reloading configuration")
+				d.config.Reload("sighup")
+			case <-d.shutdownChannel:
+				signal.Stop(reload)
+				return
+			}
+		}
+	}()
+
+	namespace, name := d.config.Get().GetConfigMap()
+	if name == "" {
+		return
+	}
+	d.controller.WatchConfigMap(namespace, name, func(configMap *v1.ConfigMap) {
+		var contents []byte
+		if configMap != nil {
+			contents = []byte(configMap.Data[config.ConfigMapKey])
+		}
+		d.config.ReloadContents("configmap", contents)
+	})
+}
+
 func (d *Deployable) IsRunning() bool {
 	return d.running
 }
@@ -6,4 +6,6 @@ type Event struct {
 	Reason    string
 	EventType string
 	Namespace string
+	// Backend limits the event to a single handler backend. Empty means every backend
+	Backend string
 }
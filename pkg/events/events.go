@@ -0,0 +1,78 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// component is reported as the source of every event
+const component = "nlb-attacher"
+
+// Recorder writes kubernetes events about pods in the background so callers never block on the api server
+type Recorder struct {
+	clientset kubernetes.Interface
+	queue     chan *v1.Event
+}
+
+// NewRecorder - return a recorder that buffers up to size events before dropping them
+func NewRecorder(clientset kubernetes.Interface, size int) *Recorder {
+	return &Recorder{
+		clientset: clientset,
+		queue:     make(chan *v1.Event, size),
+	}
+}
+
+// Run - write queued events until the stop channel closes
+func (recorder *Recorder) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case event := <-recorder.queue:
+			if _, err := recorder.clientset.CoreV1().Events(event.Namespace).Create(event); err != nil {
+				log.Warnf("Failed to record event %s on %s/%s: %v", event.Reason, event.Namespace, event.InvolvedObject.Name, err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Eventf - queue an event about the pod. eventType is v1.EventTypeNormal or v1.EventTypeWarning
+func (recorder *Recorder) Eventf(pod *v1.Pod, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", pod.Name, now.UnixNano()),
+			Namespace: pod.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Pod",
+			APIVersion:      "v1",
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Type:           eventType,
+		Source:         v1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	select {
+	case recorder.queue <- event:
+	default:
+		log.Warnf("Event queue is full, dropping event %s on %s/%s", reason, pod.Namespace, pod.Name)
+	}
+}
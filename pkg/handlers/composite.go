@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+var backendCallsTotal = metrics.NewCounter(
+	"nlb_attacher_backend_calls_total",
+	"Handler calls per backend by operation and result. The result is success or the error class.",
+	"backend", "operation", "result",
+)
+
+// BackendErrors is returned by Composite when one or more backends failed, keyed by backend name.
+// The controller retries each failed backend on its own
+type BackendErrors map[string]error
+
+func (errs BackendErrors) Error() string {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, fmt.Sprintf("%s: %v", name, errs[name]))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// class returns the class most worth retrying among the backend errors
+func (errs BackendErrors) class() ErrorClass {
+	class := Terminal
+	for _, err := range errs {
+		class = retryPriority(class, Classify(err))
+	}
+	return class
+}
+
+type namedHandler struct {
+	name    string
+	handler Handler
+}
+
+// Composite implements handlers.Handler and handlers.TargetGroupAdmin by fanning every call out to several named backends in order
+type Composite struct {
+	backends []namedHandler
+}
+
+// NewComposite - create every named backend from the registry
+func NewComposite(names []string, deps Dependencies) (*Composite, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one handler backend is required")
+	}
+
+	composite := &Composite{}
+	for _, name := range names {
+		handler, err := New(name, deps)
+		if err != nil {
+			return nil, err
+		}
+		composite.Add(name, handler)
+	}
+	return composite, nil
+}
+
+// Add - append an already created backend
+func (composite *Composite) Add(name string, handler Handler) {
+	composite.backends = append(composite.backends, namedHandler{name: name, handler: handler})
+}
+
+// Backend - return a single backend by name, or nil if there is none
+func (composite *Composite) Backend(name string) Handler {
+	for _, backend := range composite.backends {
+		if backend.name == name {
+			return backend.handler
+		}
+	}
+	return nil
+}
+
+// Names - return the backend names in call order
+func (composite *Composite) Names() []string {
+	names := make([]string, 0, len(composite.backends))
+	for _, backend := range composite.backends {
+		names = append(names, backend.name)
+	}
+	return names
+}
+
+// Init - initialize every backend
+func (composite *Composite) Init(tgAnnotation string, annotationEnabledValue string) error {
+	for _, backend := range composite.backends {
+		if err := backend.handler.Init(tgAnnotation, annotationEnabledValue); err != nil {
+			return fmt.Errorf("failed to initialize handler backend %s: %v", backend.name, err)
+		}
+	}
+	return nil
+}
+
+// PodCreated - pass the event to every backend
+func (composite *Composite) PodCreated(ctx context.Context, created *v1.Pod) error {
+	return composite.each("PodCreated", func(handler Handler) error {
+		return handler.PodCreated(ctx, created)
+	})
+}
+
+// PodDeleted - pass the event to every backend
+func (composite *Composite) PodDeleted(ctx context.Context, deleted *v1.Pod) error {
+	return composite.each("PodDeleted", func(handler Handler) error {
+		return handler.PodDeleted(ctx, deleted)
+	})
+}
+
+// PodUpdated - pass the event to every backend
+func (composite *Composite) PodUpdated(ctx context.Context, oldPod, newPod *v1.Pod) error {
+	return composite.each("PodUpdated", func(handler Handler) error {
+		return handler.PodUpdated(ctx, oldPod, newPod)
+	})
+}
+
+// PodDetached - pass the event to every backend that implements TargetGroupAdmin
+func (composite *Composite) PodDetached(ctx context.Context, detached *v1.Pod) error {
+	return composite.eachAdmin("PodDetached", func(admin TargetGroupAdmin) error {
+		return admin.PodDetached(ctx, detached)
+	})
+}
+
+// ReconcileTargetGroup - pass the reconcile to every backend that implements TargetGroupAdmin
+func (composite *Composite) ReconcileTargetGroup(ctx context.Context, tgArn string, pods []*v1.Pod) error {
+	return composite.eachAdmin("ReconcileTargetGroup", func(admin TargetGroupAdmin) error {
+		return admin.ReconcileTargetGroup(ctx, tgArn, pods)
+	})
+}
+
+// PauseTargetGroup - pause the target group in every backend that implements TargetGroupAdmin
+func (composite *Composite) PauseTargetGroup(tgArn string) {
+	for _, backend := range composite.backends {
+		if admin, ok := backend.handler.(TargetGroupAdmin); ok {
+			admin.PauseTargetGroup(tgArn)
+		}
+	}
+}
+
+// ResumeTargetGroup - resume the target group in every backend that implements TargetGroupAdmin
+func (composite *Composite) ResumeTargetGroup(tgArn string) {
+	for _, backend := range composite.backends {
+		if admin, ok := backend.handler.(TargetGroupAdmin); ok {
+			admin.ResumeTargetGroup(tgArn)
+		}
+	}
+}
+
+// TestHandler - test every backend
+func (composite *Composite) TestHandler() {
+	for _, backend := range composite.backends {
+		backend.handler.TestHandler()
+	}
+}
+
+// each calls fn on every backend, even after one fails, and collects the failures
+func (composite *Composite) each(operation string, fn func(Handler) error) error {
+	errs := make(BackendErrors)
+	for _, backend := range composite.backends {
+		err := fn(backend.handler)
+		if err != nil {
+			errs[backend.name] = err
+			backendCallsTotal.Inc(backend.name, operation, string(Classify(err)))
+			continue
+		}
+		backendCallsTotal.Inc(backend.name, operation, "success")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// eachAdmin is each limited to the backends that implement TargetGroupAdmin
+func (composite *Composite) eachAdmin(operation string, fn func(TargetGroupAdmin) error) error {
+	admins := &Composite{}
+	for _, backend := range composite.backends {
+		if _, ok := backend.handler.(TargetGroupAdmin); ok {
+			admins.backends = append(admins.backends, backend)
+		}
+	}
+	return admins.each(operation, func(handler Handler) error {
+		return fn(handler.(TargetGroupAdmin))
+	})
+}
+
+// AsBackendErrors - return the per backend errors if err came from a Composite
+func AsBackendErrors(err error) (BackendErrors, bool) {
+	var errs BackendErrors
+	if errors.As(err, &errs) {
+		return errs, true
+	}
+	return nil, false
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrorClass tells the controller what to do with a failed event
+type ErrorClass string
+
+const (
+	// Retryable errors are requeued with backoff until max-retries is reached
+	Retryable ErrorClass = "retryable"
+	// Throttled errors are requeued with backoff and do not count towards max-retries
+	Throttled ErrorClass = "throttled"
+	// Terminal errors will not succeed on retry. The event is dropped and the failure surfaced on the pod
+	Terminal ErrorClass = "terminal"
+)
+
+// Error wraps an error returned by a handler with its class
+type Error struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap - return the wrapped error
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// NewRetryable - mark the error as worth retrying
+func NewRetryable(err error) error {
+	return wrap(Retryable, err)
+}
+
+// NewThrottled - mark the error as caused by rate limiting
+func NewThrottled(err error) error {
+	return wrap(Throttled, err)
+}
+
+// NewTerminal - mark the error as one that retrying will not fix
+func NewTerminal(err error) error {
+	return wrap(Terminal, err)
+}
+
+// Classify - return the class of the error. Errors that were not classified are retryable
+func Classify(err error) ErrorClass {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	if backendErrs, ok := AsBackendErrors(err); ok {
+		return backendErrs.class()
+	}
+	return Retryable
+}
+
+// Combine - merge the errors of several operations into one, keeping the class most worth retrying.
+// Returns nil when every error is nil
+func Combine(errs ...error) error {
+	messages := make([]string, 0, len(errs))
+	class := Terminal
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		messages = append(messages, err.Error())
+		class = retryPriority(class, Classify(err))
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	if len(messages) == 1 {
+		for _, err := range errs {
+			if err != nil {
+				return wrap(class, err)
+			}
+		}
+	}
+	return &Error{Class: class, Err: errors.New(strings.Join(messages, "; "))}
+}
+
+// retryPriority returns whichever class leads to the event being retried soonest
+func retryPriority(a ErrorClass, b ErrorClass) ErrorClass {
+	rank := map[ErrorClass]int{Terminal: 0, Retryable: 1, Throttled: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func wrap(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
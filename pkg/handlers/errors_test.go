@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"unclassified", errors.New("boom"), Retryable},
+		{"retryable", NewRetryable(errors.New("timeout")), Retryable},
+		{"throttled", NewThrottled(errors.New("slow down")), Throttled},
+		{"terminal", NewTerminal(errors.New("not found")), Terminal},
+		{"wrapped terminal", fmt.Errorf("register: %w", NewTerminal(errors.New("not found"))), Terminal},
+		{"backends, most worth retrying wins", BackendErrors{
+			"a": NewTerminal(errors.New("not found")),
+			"b": NewThrottled(errors.New("slow down")),
+		}, Throttled},
+		{"backends, all terminal", BackendErrors{"a": NewTerminal(errors.New("not found"))}, Terminal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Classify(tc.err); got != tc.want {
+				t.Errorf("Classify(%v) = %s, want %s", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCombine(t *testing.T) {
+	cases := []struct {
+		name        string
+		errs        []error
+		wantNil     bool
+		wantClass   ErrorClass
+		wantMessage string
+	}{
+		{name: "no errors", errs: nil, wantNil: true},
+		{name: "only nils", errs: []error{nil, nil}, wantNil: true},
+		{
+			name:        "single error keeps its class",
+			errs:        []error{nil, NewTerminal(errors.New("not found"))},
+			wantClass:   Terminal,
+			wantMessage: "not found",
+		},
+		{
+			name:        "unclassified is retryable",
+			errs:        []error{errors.New("boom")},
+			wantClass:   Retryable,
+			wantMessage: "boom",
+		},
+		{
+			name:        "retryable beats terminal",
+			errs:        []error{NewTerminal(errors.New("not found")), NewRetryable(errors.New("timeout"))},
+			wantClass:   Retryable,
+			wantMessage: "not found; timeout",
+		},
+		{
+			name:        "throttled beats retryable",
+			errs:        []error{NewRetryable(errors.New("timeout")), nil, NewThrottled(errors.New("slow down"))},
+			wantClass:   Throttled,
+			wantMessage: "timeout; slow down",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Combine(tc.errs...)
+			if tc.wantNil {
+				if err != nil {
+					t.Fatalf("Combine() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Combine() = nil, want an error")
+			}
+			if got := Classify(err); got != tc.wantClass {
+				t.Errorf("class = %s, want %s", got, tc.wantClass)
+			}
+			if err.Error() != tc.wantMessage {
+				t.Errorf("message = %q, want %q", err.Error(), tc.wantMessage)
+			}
+		})
+	}
+}
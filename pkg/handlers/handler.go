@@ -1,15 +1,28 @@
 package handlers
 
 import (
+	"context"
+
 	v1 "k8s.io/api/core/v1"
 )
 
 // Handler is implemented by any handler.
-// The Handle method is used to process event
+// The Pod methods return errors classified with NewRetryable, NewThrottled or NewTerminal
+// so the controller knows whether to retry the event
 type Handler interface {
 	Init(tgAnnotation string, annotationEnabledValue string) error
-	PodCreated(created *v1.Pod)
-	PodDeleted(deleted *v1.Pod)
-	PodUpdated(oldPod, newPod *v1.Pod)
+	PodCreated(ctx context.Context, created *v1.Pod) error
+	PodDeleted(ctx context.Context, deleted *v1.Pod) error
+	PodUpdated(ctx context.Context, oldPod, newPod *v1.Pod) error
 	TestHandler()
 }
+
+// TargetGroupAdmin is optionally implemented by handlers whose pods are attached to target groups,
+// and backs the admin actions. PodDetached and ReconcileTargetGroup return classified errors like
+// the Handler methods
+type TargetGroupAdmin interface {
+	PodDetached(ctx context.Context, detached *v1.Pod) error
+	ReconcileTargetGroup(ctx context.Context, tgArn string, pods []*v1.Pod) error
+	PauseTargetGroup(tgArn string)
+	ResumeTargetGroup(tgArn string)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/access"
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/events"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// Dependencies are the shared clients and caches handed to every backend when it is created
+type Dependencies struct {
+	Clientset  kubernetes.Interface
+	Config     *config.Store
+	Namespaces corelisters.NamespaceLister
+	Pods       corelisters.PodLister
+	Recorder   *events.Recorder
+	// Nodes looks up the nodes of pods, nil when node drains are not watched
+	Nodes corelisters.NodeLister
+	// Audit records every mutating api call of the backend, nil when auditing is disabled
+	Audit audit.Sink
+	// State persists the registered targets across restarts, nil when persistence is disabled
+	State *state.Store
+	// Aliases resolves the target group aliases pods reference, nil when no alias config map is configured
+	Aliases *alias.Store
+	// Policy decides which target groups the pods of each namespace may use, nil when no policy config map is configured
+	Policy *policy.Store
+	// Access reviews whether pods' service accounts may bind a target group, nil when access reviews are disabled
+	Access *access.Reviewer
+	// Detached reports whether an operator detached the pod through the admin api, nil when no pod can be detached
+	Detached func(pod *v1.Pod) bool
+	// Stop is closed when the controller shuts down, ending any background work of the backend
+	Stop <-chan struct{}
+}
+
+// Factory creates a backend. Init is called on the result before it receives any event
+type Factory func(deps Dependencies) (Handler, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]Factory)
+)
+
+// Register - make a backend available under the given name. Backends register themselves from an init func
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("handler backend %s registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Registered - return the names of all registered backends
+func Registered() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New - create the backend registered under the given name
+func New(name string, deps Dependencies) (Handler, error) {
+	registryMutex.RLock()
+	factory, ok := registry[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown handler backend %q, registered backends are %v", name, Registered())
+	}
+	return factory(deps)
+}
@@ -0,0 +1,62 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stage is one step of the shutdown, e.g. draining the queue
+type stage struct {
+	name    string
+	timeout time.Duration
+	stop    func(ctx context.Context) error
+}
+
+// Manager shuts the components of the process down one after the other, in the order they were added.
+// Every stage gets its own deadline, and a stage that fails or runs out of time does not keep the later ones from running
+type Manager struct {
+	stages []stage
+}
+
+// NewManager - return a manager without any stages
+func NewManager() *Manager {
+	return &Manager{stages: make([]stage, 0)}
+}
+
+// Add - append a stage. stop must return once ctx is done; a timeout of zero means no deadline
+func (manager *Manager) Add(name string, timeout time.Duration, stop func(ctx context.Context) error) {
+	manager.stages = append(manager.stages, stage{name: name, timeout: timeout, stop: stop})
+}
+
+// Shutdown - run every stage in order and report the ones that failed
+func (manager *Manager) Shutdown() error {
+	failed := make([]string, 0)
+	for i, s := range manager.stages {
+		logger := log.WithFields(log.Fields{"stage": s.name, "step": fmt.Sprintf("%d/%d", i+1, len(manager.stages))})
+		logger.Info("Shutting down")
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if s.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		}
+		start := time.Now()
+		err := s.stop(ctx)
+		cancel()
+
+		if err != nil {
+			logger.Errorf("Shutdown stage failed after %s: %v", time.Since(start).Round(time.Millisecond), err)
+			failed = append(failed, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+		logger.Infof("Shutdown stage done in %s", time.Since(start).Round(time.Millisecond))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unclean shutdown: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
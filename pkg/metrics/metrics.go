@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelSeparator joins label values into a series key. It cannot appear in a label value we emit
+const labelSeparator = "\xff"
+
+// metric is a named family of series exposed in the prometheus text format
+type metric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]float64
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	metric
+}
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	metric
+}
+
+type registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+var defaultRegistry = &registry{}
+
+// NewCounter - create and register a counter with the given label names
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	counter := &Counter{metric: newMetric(name, help, "counter", labelNames)}
+	defaultRegistry.register(&counter.metric)
+	return counter
+}
+
+// NewGauge - create and register a gauge with the given label names
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	gauge := &Gauge{metric: newMetric(name, help, "gauge", labelNames)}
+	defaultRegistry.register(&gauge.metric)
+	return gauge
+}
+
+// Inc - add one to the series with the given label values
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.add(1, labelValues)
+}
+
+// Add - add a non negative value to the series with the given label values
+func (counter *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	counter.add(value, labelValues)
+}
+
+// Set - set the series with the given label values
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	key := gauge.key(labelValues)
+	gauge.mutex.Lock()
+	defer gauge.mutex.Unlock()
+	gauge.series[key] = value
+}
+
+// Inc - add one to the series with the given label values
+func (gauge *Gauge) Inc(labelValues ...string) {
+	gauge.add(1, labelValues)
+}
+
+// Dec - subtract one from the series with the given label values
+func (gauge *Gauge) Dec(labelValues ...string) {
+	gauge.add(-1, labelValues)
+}
+
+// Delete - drop the series with the given label values
+func (gauge *Gauge) Delete(labelValues ...string) {
+	key := gauge.key(labelValues)
+	gauge.mutex.Lock()
+	defer gauge.mutex.Unlock()
+	delete(gauge.series, key)
+}
+
+// Write - render every registered metric in the prometheus text exposition format
+func Write(w io.Writer) error {
+	defaultRegistry.mutex.Lock()
+	metrics := make([]*metric, len(defaultRegistry.metrics))
+	copy(metrics, defaultRegistry.metrics)
+	defaultRegistry.mutex.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newMetric(name string, help string, kind string, labelNames []string) metric {
+	return metric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]float64),
+	}
+}
+
+func (reg *registry) register(m *metric) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	for _, existing := range reg.metrics {
+		if existing.name == m.name {
+			panic(fmt.Sprintf("metric %s registered twice", m.name))
+		}
+	}
+	reg.metrics = append(reg.metrics, m)
+}
+
+func (m *metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+func (m *metric) add(value float64, labelValues []string) {
+	key := m.key(labelValues)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.series[key] += value
+}
+
+func (m *metric) write(w io.Writer) error {
+	m.mutex.Lock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]float64, len(keys))
+	for i, key := range keys {
+		values[i] = m.series[key]
+	}
+	m.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", m.name, m.formatLabels(key), strconv.FormatFloat(values[i], 'g', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metric) formatLabels(key string) string {
+	if len(m.labelNames) == 0 {
+		return ""
+	}
+
+	values := strings.Split(key, labelSeparator)
+	pairs := make([]string, len(m.labelNames))
+	for i, name := range m.labelNames {
+		pairs[i] = fmt.Sprintf("%s=%s", name, strconv.Quote(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
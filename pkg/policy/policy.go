@@ -0,0 +1,257 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+// DataKey is the key of the policy config map holding the policy
+const DataKey = "policy.yaml"
+
+var (
+	denialsTotal = metrics.NewCounter(
+		"nlb_attacher_policy_denials_total",
+		"Target group registrations and admissions denied by the namespace policy or an access review, by namespace.",
+		"namespace",
+	)
+	loadErrorsTotal = metrics.NewCounter(
+		"nlb_attacher_policy_load_errors_total",
+		"Updates of the policy config map that could not be read and were ignored.",
+	)
+)
+
+// Rule allows the namespaces it matches to use the target groups it matches. A rule without namespaces and
+// namespaceSelector matches every namespace
+type Rule struct {
+	// Namespaces are names or globs of the namespaces the rule applies to
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces the rule applies to by their labels
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// TargetGroups are globs of the allowed target groups, matched against the ARN when they start with arn: and the name otherwise
+	TargetGroups []string `json:"targetGroups,omitempty"`
+	// Tags allow the target groups carrying all of them
+	Tags map[string]string `json:"tags,omitempty"`
+
+	selector labels.Selector
+}
+
+// Policy allowlists target groups per namespace. A target group is allowed when any rule matching the namespace allows it
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Target is the target group a pod asks to be registered with
+type Target struct {
+	Arn  string
+	Name string
+	// Tags returns the tags of the target group. A nil Tags lets every tag rule allow the target group, for callers
+	// that cannot describe it
+	Tags func() (map[string]string, error)
+}
+
+// Parse - decode and validate a policy, reporting every problem at once
+func Parse(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+
+	problems := make([]string, 0)
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if len(rule.TargetGroups) == 0 && len(rule.Tags) == 0 {
+			problems = append(problems, fmt.Sprintf("rules[%d]: one of targetGroups and tags is required", i))
+		}
+		for _, pattern := range append(append([]string{}, rule.Namespaces...), rule.TargetGroups...) {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				problems = append(problems, fmt.Sprintf("rules[%d]: %q is not a valid glob", i, pattern))
+			}
+		}
+		if rule.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("rules[%d].namespaceSelector: %v", i, err))
+				continue
+			}
+			rule.selector = selector
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid policy: %s", strings.Join(problems, "; "))
+	}
+	return policy, nil
+}
+
+// Allowed - report whether a rule matching the namespace allows the target group
+func (policy *Policy) Allowed(namespace string, namespaceLabels map[string]string, target Target) (bool, error) {
+	var tags map[string]string
+	for _, rule := range policy.Rules {
+		if !rule.appliesTo(namespace, namespaceLabels) {
+			continue
+		}
+		if rule.matchesTargetGroup(target) {
+			return true, nil
+		}
+		if len(rule.Tags) == 0 {
+			continue
+		}
+		if target.Tags == nil {
+			return true, nil
+		}
+		if tags == nil {
+			var err error
+			if tags, err = target.Tags(); err != nil {
+				return false, err
+			}
+		}
+		if hasTags(tags, rule.Tags) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (rule Rule) appliesTo(namespace string, namespaceLabels map[string]string) bool {
+	if len(rule.Namespaces) == 0 && rule.selector == nil {
+		return true
+	}
+	for _, pattern := range rule.Namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return rule.selector != nil && rule.selector.Matches(labels.Set(namespaceLabels))
+}
+
+func (rule Rule) matchesTargetGroup(target Target) bool {
+	for _, pattern := range rule.TargetGroups {
+		value := target.Name
+		if strings.HasPrefix(pattern, "arn:") {
+			value = target.Arn
+		}
+		if matched, _ := path.Match(pattern, value); matched && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTags(tags map[string]string, required map[string]string) bool {
+	for key, value := range required {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// NameFromArn - return the name part of a target group ARN, empty when the value is not one
+func NameFromArn(tgArn string) string {
+	parts := strings.Split(tgArn, "/")
+	if len(parts) == 3 && strings.HasSuffix(parts[0], ":targetgroup") {
+		return parts[1]
+	}
+	return ""
+}
+
+// Store holds the policy of the policy config map. A nil store allows everything; a configured store denies everything
+// until the config map holds a valid policy
+type Store struct {
+	namespace string
+	name      string
+
+	mutex  sync.RWMutex
+	policy *Policy
+}
+
+// NewStore - return a store for the config map namespace/name that denies everything until it is updated
+func NewStore(namespace string, name string) *Store {
+	return &Store{namespace: namespace, name: name}
+}
+
+// Source - return the namespace/name of the config map the policy comes from
+func (store *Store) Source() string {
+	if store == nil {
+		return ""
+	}
+	return store.namespace + "/" + store.name
+}
+
+// Update - read the policy from the config map, nil when it was deleted, and report whether it changed.
+// An invalid policy is logged and the previous one kept
+func (store *Store) Update(configMap *v1.ConfigMap) bool {
+	var policy *Policy
+	if configMap != nil {
+		data, ok := configMap.Data[DataKey]
+		if !ok {
+			loadErrorsTotal.Inc()
+			log.Errorf("Ignoring policy config map %s: it has no %s key", store.Source(), DataKey)
+			return false
+		}
+		parsed, err := Parse([]byte(data))
+		if err != nil {
+			loadErrorsTotal.Inc()
+			log.Errorf("Ignoring policy config map %s: %v", store.Source(), err)
+			return false
+		}
+		policy = parsed
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	changed := store.policy != policy
+	store.policy = policy
+	if policy == nil {
+		log.Warnf("Policy config map %s is gone, denying every target group", store.Source())
+	} else {
+		log.Infof("Loaded the target group policy of config map %s: %d rules", store.Source(), len(policy.Rules))
+	}
+	return changed
+}
+
+// Loaded - report whether the store holds a valid policy
+func (store *Store) Loaded() bool {
+	if store == nil {
+		return false
+	}
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.policy != nil
+}
+
+// Allowed - report whether pods of the namespace may use the target group, and why not
+func (store *Store) Allowed(namespace string, namespaceLabels map[string]string, target Target) (bool, string, error) {
+	if store == nil {
+		return true, "", nil
+	}
+	store.mutex.RLock()
+	policy := store.policy
+	store.mutex.RUnlock()
+
+	if policy == nil {
+		return false, fmt.Sprintf("policy config map %s holds no valid policy", store.Source()), nil
+	}
+	allowed, err := policy.Allowed(namespace, namespaceLabels, target)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to check target group %s against the policy: %v", target.Arn, err)
+	}
+	if !allowed {
+		return false, fmt.Sprintf("namespace %s may not use target group %s under the policy of config map %s", namespace, target.Arn, store.Source()), nil
+	}
+	return true, "", nil
+}
+
+// CountDenial - count a registration or admission of a pod of the namespace that the policy or an access review denied
+func CountDenial(namespace string) {
+	denialsTotal.Inc(namespace)
+}
@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	webArn   = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/web-public/73e2d6bc24d8a067"
+	adminArn = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/admin/2453ed029918f21f"
+)
+
+const testPolicy = `
+rules:
+- namespaces: ["team-a-*"]
+  targetGroups: ["web-*"]
+- namespaceSelector:
+    matchLabels:
+      tier: platform
+  targetGroups: ["` + adminArn + `"]
+- namespaces: ["tagged"]
+  tags:
+    team: tagged
+`
+
+func TestPolicyAllowed(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	web := Target{Arn: webArn, Name: "web-public"}
+	admin := Target{Arn: adminArn, Name: "admin"}
+	withTags := func(tags map[string]string) Target {
+		return Target{Arn: adminArn, Name: "admin", Tags: func() (map[string]string, error) { return tags, nil }}
+	}
+
+	cases := []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		target    Target
+		want      bool
+	}{
+		{name: "name glob in a namespace glob", namespace: "team-a-prod", target: web, want: true},
+		{name: "namespace outside the glob", namespace: "team-b", target: web},
+		{name: "name glob does not match", namespace: "team-a-prod", target: admin},
+		{name: "arn rule by namespace labels", namespace: "infra", labels: map[string]string{"tier": "platform"}, target: admin, want: true},
+		{name: "namespace labels do not match", namespace: "infra", labels: map[string]string{"tier": "apps"}, target: admin},
+		{name: "arn rule does not match by name", namespace: "infra", labels: map[string]string{"tier": "platform"}, target: Target{Name: "admin"}},
+		{name: "tag rule with the tags", namespace: "tagged", target: withTags(map[string]string{"team": "tagged", "env": "prod"}), want: true},
+		{name: "tag rule with other tags", namespace: "tagged", target: withTags(map[string]string{"team": "other"})},
+		{name: "tag rule without tag lookup", namespace: "tagged", target: admin, want: true},
+		{name: "tag rule of another namespace", namespace: "team-a-prod", target: withTags(map[string]string{"team": "tagged"})},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			allowed, err := policy.Allowed(tc.namespace, tc.labels, tc.target)
+			if err != nil {
+				t.Fatalf("Allowed() error = %v", err)
+			}
+			if allowed != tc.want {
+				t.Errorf("Allowed() = %v, want %v", allowed, tc.want)
+			}
+		})
+	}
+}
+
+func TestPolicyLooksUpTagsLazily(t *testing.T) {
+	cases := []struct {
+		name      string
+		namespace string
+		target    Target
+		wantCalls int
+	}{
+		{name: "allowed by name", namespace: "team-a-prod", target: Target{Arn: webArn, Name: "web-public"}},
+		{name: "no tag rule applies", namespace: "team-b", target: Target{Arn: webArn, Name: "web-public"}},
+		{name: "tag rule applies", namespace: "tagged", target: Target{Arn: adminArn, Name: "admin"}, wantCalls: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := Parse([]byte(testPolicy + "- namespaces: [\"tagged\"]\n  tags:\n    team: other\n"))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			calls := 0
+			tc.target.Tags = func() (map[string]string, error) {
+				calls++
+				return map[string]string{}, nil
+			}
+			if _, err := policy.Allowed(tc.namespace, nil, tc.target); err != nil {
+				t.Fatalf("Allowed() error = %v", err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("tags looked up %d times, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPolicyTagLookupError(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	lookupErr := errors.New("throttled")
+	target := Target{Arn: adminArn, Name: "admin", Tags: func() (map[string]string, error) { return nil, lookupErr }}
+	if allowed, err := policy.Allowed("tagged", nil, target); allowed || err != lookupErr {
+		t.Errorf("Allowed() = %v, %v, want false, %v", allowed, err, lookupErr)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "valid", policy: testPolicy},
+		{name: "unknown field", policy: "rules:\n- namespace: [a]\n  targetGroups: [b]\n", wantErr: `unknown field "namespace"`},
+		{name: "nothing allowed", policy: "rules:\n- namespaces: [a]\n", wantErr: "rules[0]: one of targetGroups and tags is required"},
+		{name: "malformed glob", policy: "rules:\n- targetGroups: [\"web-[\"]\n", wantErr: `rules[0]: "web-[" is not a valid glob`},
+		{
+			name:    "malformed selector",
+			policy:  "rules:\n- namespaceSelector:\n    matchExpressions:\n    - {key: tier, operator: Sometimes}\n  targetGroups: [web]\n",
+			wantErr: "rules[0].namespaceSelector:",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.policy))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("Parse() error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Parse() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoreAllowed(t *testing.T) {
+	target := Target{Arn: webArn, Name: "web-public"}
+	valid := &v1.ConfigMap{Data: map[string]string{DataKey: testPolicy}}
+	cases := []struct {
+		name    string
+		updates []*v1.ConfigMap
+		want    bool
+	}{
+		{name: "nothing loaded yet"},
+		{name: "valid policy", updates: []*v1.ConfigMap{valid}, want: true},
+		{name: "invalid update keeps the policy", updates: []*v1.ConfigMap{valid, {Data: map[string]string{DataKey: "rules: nope"}}}, want: true},
+		{name: "update without the key keeps the policy", updates: []*v1.ConfigMap{valid, {Data: map[string]string{}}}, want: true},
+		{name: "deleted config map", updates: []*v1.ConfigMap{valid, nil}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewStore("kube-system", "nlb-attacher-policy")
+			for _, configMap := range tc.updates {
+				store.Update(configMap)
+			}
+			allowed, reason, err := store.Allowed("team-a-prod", nil, target)
+			if err != nil {
+				t.Fatalf("Allowed() error = %v", err)
+			}
+			if allowed != tc.want {
+				t.Errorf("Allowed() = %v (%s), want %v", allowed, reason, tc.want)
+			}
+			if !allowed && reason == "" {
+				t.Error("Allowed() gave no reason for the denial")
+			}
+		})
+	}
+}
+
+func TestNameFromArn(t *testing.T) {
+	cases := map[string]string{
+		webArn:       "web-public",
+		"web-public": "",
+		"arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/net/nlb/50dc6c495c0c9188": "",
+	}
+	for arn, want := range cases {
+		if got := NameFromArn(arn); got != want {
+			t.Errorf("NameFromArn(%q) = %q, want %q", arn, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package readiness
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConditionType is the pod readiness gate the attacher sets once a pod is in all of its target groups
+const ConditionType v1.PodConditionType = "nlb-attacher.bird.co/registered"
+
+// HealthConditionType mirrors the health the target groups of a pod report for it
+const HealthConditionType v1.PodConditionType = "nlb-attacher.bird.co/target-health"
+
+// Writer updates the attacher's pod conditions through the status subresource
+type Writer struct {
+	clientset kubernetes.Interface
+}
+
+// NewWriter - return a writer using the given clientset
+func NewWriter(clientset kubernetes.Interface) *Writer {
+	return &Writer{clientset: clientset}
+}
+
+// HasGate - report whether the pod spec declares the given condition as a readiness gate
+func HasGate(pod *v1.Pod, conditionType v1.PodConditionType) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCondition - set a condition on the pod unless it already has the same status and reason
+func (writer *Writer) SetCondition(pod *v1.Pod, conditionType v1.PodConditionType, status v1.ConditionStatus, reason string, message string) error {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == status && condition.Reason == reason {
+			return nil
+		}
+	}
+
+	now := metav1.Now()
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.PodCondition{
+				{
+					Type:               conditionType,
+					Status:             status,
+					Reason:             reason,
+					Message:            message,
+					LastProbeTime:      now,
+					LastTransitionTime: now,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.StrategicMergePatchType, patch, "status")
+	if err != nil {
+		return fmt.Errorf("failed to set condition %s on pod %s/%s: %v", conditionType, pod.Namespace, pod.Name, err)
+	}
+
+	log.Debugf("Set condition %s=%s on pod %s/%s", conditionType, status, pod.Namespace, pod.Name)
+	return nil
+}
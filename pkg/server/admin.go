@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminController is the set of operator actions exposed through the admin api.
+// Every action is enqueued on the controller's workqueue rather than run inline
+type AdminController interface {
+	ReconcilePod(namespace string, name string, reason string) error
+	ReconcileTargetGroup(tgArn string, reason string) error
+	DetachPod(namespace string, name string, duration time.Duration, reason string) error
+	PauseTargetGroup(tgArn string, reason string) error
+	ResumeTargetGroup(tgArn string, reason string) error
+}
+
+// NotFoundError is implemented by admin errors that should be reported as a 404
+type NotFoundError interface {
+	NotFound() bool
+}
+
+// UnavailableError is implemented by admin errors that should be reported as a 503
+type UnavailableError interface {
+	Unavailable() bool
+}
+
+type adminRequest struct {
+	Arn      string `json:"arn"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// registerAdminRoutes adds the authenticated admin endpoints to the engine.
+// The admin api is disabled entirely when no token is configured
+func registerAdminRoutes(engine *gin.Engine, admin AdminController, token string) {
+	if admin == nil || token == "" {
+		log.Info("No admin token configured, the admin api is disabled")
+		return
+	}
+
+	group := engine.Group("/admin", requireToken(token))
+
+	group.POST("/pods/:namespace/:name/reconcile", func(c *gin.Context) {
+		req, ok := bindAdminRequest(c)
+		if !ok {
+			return
+		}
+		namespace, name := c.Param("namespace"), c.Param("name")
+		err := admin.ReconcilePod(namespace, name, req.Reason)
+		respondAdmin(c, "reconcile-pod", namespace+"/"+name, req, err)
+	})
+
+	group.POST("/pods/:namespace/:name/detach", func(c *gin.Context) {
+		req, ok := bindAdminRequest(c)
+		if !ok {
+			return
+		}
+
+		var duration time.Duration
+		if req.Duration != "" {
+			var err error
+			duration, err = time.ParseDuration(req.Duration)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + err.Error()})
+				return
+			}
+		}
+
+		namespace, name := c.Param("namespace"), c.Param("name")
+		err := admin.DetachPod(namespace, name, duration, req.Reason)
+		respondAdmin(c, "detach-pod", namespace+"/"+name, req, err)
+	})
+
+	group.POST("/targetgroups/reconcile", func(c *gin.Context) {
+		req, ok := bindAdminRequest(c)
+		if !ok {
+			return
+		}
+		err := admin.ReconcileTargetGroup(req.Arn, req.Reason)
+		respondAdmin(c, "reconcile-targetgroup", req.Arn, req, err)
+	})
+
+	group.POST("/targetgroups/pause", func(c *gin.Context) {
+		req, ok := bindAdminRequest(c)
+		if !ok {
+			return
+		}
+		err := admin.PauseTargetGroup(req.Arn, req.Reason)
+		respondAdmin(c, "pause-targetgroup", req.Arn, req, err)
+	})
+
+	group.POST("/targetgroups/resume", func(c *gin.Context) {
+		req, ok := bindAdminRequest(c)
+		if !ok {
+			return
+		}
+		err := admin.ResumeTargetGroup(req.Arn, req.Reason)
+		respondAdmin(c, "resume-targetgroup", req.Arn, req, err)
+	})
+}
+
+// requireToken rejects any request without a matching bearer token
+func requireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		provided := strings.TrimPrefix(header, "Bearer ")
+
+		if header == provided || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			log.WithFields(log.Fields{
+				"audit":  true,
+				"remote": c.ClientIP(),
+				"path":   c.Request.URL.Path,
+			}).Warn("Rejected unauthenticated admin request")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// bindAdminRequest parses the optional json body of an admin request
+func bindAdminRequest(c *gin.Context) (adminRequest, bool) {
+	var req adminRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+// respondAdmin writes the audit record for an admin action and responds to the caller
+func respondAdmin(c *gin.Context, action string, target string, req adminRequest, err error) {
+	logger := log.WithFields(log.Fields{
+		"audit":    true,
+		"action":   action,
+		"target":   target,
+		"reason":   req.Reason,
+		"duration": req.Duration,
+		"remote":   c.ClientIP(),
+	})
+
+	if err != nil {
+		logger.WithField("error", err.Error()).Warn("Admin action rejected")
+		status := http.StatusBadRequest
+		if notFound, ok := err.(NotFoundError); ok && notFound.NotFound() {
+			status = http.StatusNotFound
+		}
+		if unavailable, ok := err.(UnavailableError); ok && unavailable.Unavailable() {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("Admin action enqueued")
+	c.JSON(http.StatusAccepted, gin.H{"action": action, "target": target, "status": "enqueued"})
+}
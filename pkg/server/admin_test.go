@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testToken = "s3cret"
+
+// fakeAdmin records the admin actions called, and fails them with err
+type fakeAdmin struct {
+	calls []string
+	err   error
+}
+
+func (admin *fakeAdmin) record(call string) error {
+	admin.calls = append(admin.calls, call)
+	return admin.err
+}
+
+func (admin *fakeAdmin) ReconcilePod(namespace string, name string, reason string) error {
+	return admin.record("ReconcilePod " + namespace + "/" + name + " " + reason)
+}
+
+func (admin *fakeAdmin) ReconcileTargetGroup(tgArn string, reason string) error {
+	return admin.record("ReconcileTargetGroup " + tgArn + " " + reason)
+}
+
+func (admin *fakeAdmin) DetachPod(namespace string, name string, duration time.Duration, reason string) error {
+	return admin.record("DetachPod " + namespace + "/" + name + " " + duration.String() + " " + reason)
+}
+
+func (admin *fakeAdmin) PauseTargetGroup(tgArn string, reason string) error {
+	return admin.record("PauseTargetGroup " + tgArn + " " + reason)
+}
+
+func (admin *fakeAdmin) ResumeTargetGroup(tgArn string, reason string) error {
+	return admin.record("ResumeTargetGroup " + tgArn + " " + reason)
+}
+
+type notFoundError struct{ error }
+
+func (notFoundError) NotFound() bool { return true }
+
+type unavailableError struct{ error }
+
+func (unavailableError) Unavailable() bool { return true }
+
+func TestAdminRoutes(t *testing.T) {
+	cases := []struct {
+		name          string
+		path          string
+		body          string
+		authorization string
+		err           error
+		wantStatus    int
+		wantCalls     []string
+	}{
+		{
+			name:          "reconcile pod",
+			path:          "/admin/pods/default/web-0/reconcile",
+			body:          `{"reason": "stuck"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"ReconcilePod default/web-0 stuck"},
+		},
+		{
+			name:          "reconcile pod without a body",
+			path:          "/admin/pods/default/web-0/reconcile",
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"ReconcilePod default/web-0 "},
+		},
+		{
+			name:          "detach pod for a while",
+			path:          "/admin/pods/default/web-0/detach",
+			body:          `{"duration": "10m", "reason": "debugging"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"DetachPod default/web-0 10m0s debugging"},
+		},
+		{
+			name:          "detach pod with an invalid duration",
+			path:          "/admin/pods/default/web-0/detach",
+			body:          `{"duration": "soon"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusBadRequest,
+		},
+		{
+			name:          "pause target group",
+			path:          "/admin/targetgroups/pause",
+			body:          `{"arn": "tg-arn", "reason": "maintenance"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"PauseTargetGroup tg-arn maintenance"},
+		},
+		{
+			name:          "resume target group",
+			path:          "/admin/targetgroups/resume",
+			body:          `{"arn": "tg-arn"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"ResumeTargetGroup tg-arn "},
+		},
+		{
+			name:          "reconcile target group",
+			path:          "/admin/targetgroups/reconcile",
+			body:          `{"arn": "tg-arn"}`,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusAccepted,
+			wantCalls:     []string{"ReconcileTargetGroup tg-arn "},
+		},
+		{
+			name:          "malformed body",
+			path:          "/admin/targetgroups/pause",
+			body:          `{"arn": `,
+			authorization: "Bearer " + testToken,
+			wantStatus:    http.StatusBadRequest,
+		},
+		{
+			name:          "unknown pod",
+			path:          "/admin/pods/default/web-9/reconcile",
+			authorization: "Bearer " + testToken,
+			err:           notFoundError{errors.New("pod default/web-9 not found")},
+			wantStatus:    http.StatusNotFound,
+			wantCalls:     []string{"ReconcilePod default/web-9 "},
+		},
+		{
+			name:          "controller shutting down",
+			path:          "/admin/targetgroups/pause",
+			body:          `{"arn": "tg-arn"}`,
+			authorization: "Bearer " + testToken,
+			err:           unavailableError{errors.New("shutting down")},
+			wantStatus:    http.StatusServiceUnavailable,
+			wantCalls:     []string{"PauseTargetGroup tg-arn "},
+		},
+		{
+			name:          "rejected action",
+			path:          "/admin/targetgroups/pause",
+			authorization: "Bearer " + testToken,
+			err:           errors.New("arn is required"),
+			wantStatus:    http.StatusBadRequest,
+			wantCalls:     []string{"PauseTargetGroup  "},
+		},
+		{
+			name:       "missing token",
+			path:       "/admin/targetgroups/pause",
+			body:       `{"arn": "tg-arn"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "token without the bearer scheme",
+			path:          "/admin/targetgroups/pause",
+			body:          `{"arn": "tg-arn"}`,
+			authorization: testToken,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong token",
+			path:          "/admin/targetgroups/pause",
+			body:          `{"arn": "tg-arn"}`,
+			authorization: "Bearer " + testToken + "x",
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			admin := &fakeAdmin{err: tc.err}
+			engine := gin.New()
+			registerAdminRoutes(engine, admin, testToken)
+
+			request := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tc.wantStatus, recorder.Body.String())
+			}
+			if !reflect.DeepEqual(admin.calls, tc.wantCalls) {
+				t.Errorf("calls = %q, want %q", admin.calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesDisabledWithoutToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admin := &fakeAdmin{}
+	engine := gin.New()
+	registerAdminRoutes(engine, admin, "")
+
+	request := httptest.NewRequest(http.MethodPost, "/admin/targetgroups/pause", strings.NewReader(`{"arn": "tg-arn"}`))
+	request.Header.Set("Authorization", "Bearer ")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotFound || len(admin.calls) != 0 {
+		t.Errorf("status = %d with calls %q, want 404 and no calls", recorder.Code, admin.calls)
+	}
+}
@@ -5,37 +5,83 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
 )
 
 type Server struct {
-	server          *http.Server
-	shutdownChannel chan struct{}
-	running         bool
+	server        *http.Server
+	webhookServer *http.Server
+	certFile      string
+	keyFile       string
+	stopped       chan struct{}
+	stopOnce      sync.Once
+
+	// runningMutex orders Run against a Shutdown arriving before the servers started
+	runningMutex sync.Mutex
+	running      bool
+
+	readyMutex sync.RWMutex
+	ready      func() bool
 }
 
-func NewServer(listenAddress string, listenPort int, globalShutdownChan chan struct{}) *Server {
-	engine := createEngine()
+func NewServer(listenAddress string, listenPort int, admin AdminController, adminToken string) *Server {
+	server := &Server{
+		stopped: make(chan struct{}),
+		running: false,
+	}
+
+	engine := createEngine(server.isReady)
+	registerAdminRoutes(engine, admin, adminToken)
 
-	s := &http.Server{
+	server.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", listenAddress, listenPort),
 		Handler: engine,
 	}
+	return server
+}
+
+// SetReadiness - set the check behind /readyz. Without one the server is ready as soon as it listens
+func (s *Server) SetReadiness(ready func() bool) {
+	s.readyMutex.Lock()
+	defer s.readyMutex.Unlock()
+	s.ready = ready
+}
+
+func (s *Server) isReady() bool {
+	s.readyMutex.RLock()
+	defer s.readyMutex.RUnlock()
+	return s.ready == nil || s.ready()
+}
+
+// EnableWebhooks - serve the admission webhook routes over tls on a separate port
+func (s *Server) EnableWebhooks(listenAddress string, listenPort int, certFile string, keyFile string, register func(*gin.Engine)) {
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+	register(engine)
 
-	return &Server{
-		server:          s,
-		shutdownChannel: globalShutdownChan,
-		running:         false,
+	s.webhookServer = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", listenAddress, listenPort),
+		Handler: engine,
 	}
+	s.certFile = certFile
+	s.keyFile = keyFile
 }
 
+// Run - serve until Shutdown is called. Run returns right away when Shutdown was called before
 func (s *Server) Run() error {
-	var serverWg sync.WaitGroup
-	serverWg.Add(1)
+	s.runningMutex.Lock()
+	select {
+	case <-s.stopped:
+		s.runningMutex.Unlock()
+		log.Info("Server was shut down before it started")
+		return nil
+	default:
+	}
 
 	go func() {
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -43,63 +89,62 @@ func (s *Server) Run() error {
 		}
 	}()
 
+	if s.webhookServer != nil {
+		go func() {
+			if err := s.webhookServer.ListenAndServeTLS(s.certFile, s.keyFile); err != nil && err != http.ErrServerClosed {
+				log.Panicf("webhook listen: %s", err)
+			}
+		}()
+		log.Infof("serving admission webhooks on %s", s.webhookServer.Addr)
+	}
+
 	s.running = true
+	s.runningMutex.Unlock()
 	log.Info("successfully started the gin server...")
-	log.Info("Server is waiting on global shutdown channel broadcast...")
-
-	go func() {
-		defer serverWg.Done()
-		for {
-			select {
-			case <-s.shutdownChannel:
-				log.Warn("Server recieved shutdown signal. Shutting down...")
-				err := s.shutdownServer()
-				if err != nil {
-					log.Panicf("Failed to cleanly shutown server %v", err)
-				}
-				return
-			}
-		}
-	}()
-	serverWg.Wait()
+	log.Info("Server is serving until it is shut down...")
 
+	<-s.stopped
 	return nil
 }
 
 func (s *Server) IsRunning() bool {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
 	return s.running
 }
 
-func (s *Server) Shutdown() error {
-	if !s.running {
+// Shutdown - stop serving, waiting for open requests until ctx is done, and make Run return
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.runningMutex.Lock()
+	running := s.running
+	s.running = false
+	if !running {
+		// Run has not started the servers yet, or already stopped them: it returns without serving
+		s.stopOnce.Do(func() { close(s.stopped) })
+	}
+	s.runningMutex.Unlock()
+	if !running {
 		return fmt.Errorf("Gin server is already stopped")
 	}
+	defer s.stopOnce.Do(func() { close(s.stopped) })
 
-	s.running = false
-	return s.shutdownServer()
-}
-
-func (s *Server) shutdownServer() error {
-	s.running = false
-
-	//TODO: ensure gin has successfully drained all connections
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-	defer cancel()
+	if s.webhookServer != nil {
+		if err := s.webhookServer.Shutdown(ctx); err != nil {
+			log.Errorf("Webhook Server Shutdown: %s", err)
+		}
+	}
 
 	if err := s.server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server Shutdown: %s", err)
-		return err
+		return fmt.Errorf("Server Shutdown: %s", err)
 	}
-
 	return nil
 }
 
-func createEngine() *gin.Engine {
+func createEngine(ready func() bool) *gin.Engine {
 	engine := gin.New()
 
 	engine.Use(gin.LoggerWithConfig(gin.LoggerConfig{
-		SkipPaths: []string{"/healthcheck"},
+		SkipPaths: []string{"/healthcheck", "/readyz", "/metrics"},
 	}))
 
 	engine.GET("/", func(c *gin.Context) {
@@ -110,5 +155,21 @@ func createEngine() *gin.Engine {
 		c.String(http.StatusOK, "healthy")
 	})
 
+	// unlike /healthcheck, /readyz fails until the caches synced and again once shutdown began
+	engine.GET("/readyz", func(c *gin.Context) {
+		if !ready() {
+			c.String(http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		c.String(http.StatusOK, "ready")
+	})
+
+	engine.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+		if err := metrics.Write(c.Writer); err != nil {
+			log.Errorf("Failed to write metrics: %v", err)
+		}
+	})
+
 	return engine
 }
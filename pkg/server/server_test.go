@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// runServer runs the server and returns a channel closed once Run returned
+func runServer(t *testing.T, s *Server) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.Run(); err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	}()
+	return done
+}
+
+func waitForRun(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after Shutdown()")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := NewServer("127.0.0.1", 0, nil, "")
+	done := runServer(t, s)
+	for !s.IsRunning() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	waitForRun(t, done)
+	if s.IsRunning() {
+		t.Error("IsRunning() = true after Shutdown()")
+	}
+	if err := s.Shutdown(context.Background()); err == nil {
+		t.Error("second Shutdown() error = nil, want already stopped")
+	}
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	s := NewServer("127.0.0.1", 0, nil, "")
+	if err := s.Shutdown(context.Background()); err == nil {
+		t.Error("Shutdown() error = nil, want already stopped")
+	}
+
+	waitForRun(t, runServer(t, s))
+	if s.IsRunning() {
+		t.Error("IsRunning() = true, want the server never started")
+	}
+}
+
+func TestConcurrentShutdown(t *testing.T) {
+	s := NewServer("127.0.0.1", 0, nil, "")
+	// a shutdown signal may arrive while Run is starting the servers, either way Run returns
+	go s.Shutdown(context.Background())
+	waitForRun(t, runServer(t, s))
+	if s.IsRunning() {
+		t.Error("IsRunning() = true after Run() returned")
+	}
+}
@@ -0,0 +1,376 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+// DataKey is the config map key holding the state record
+const DataKey = "state.json"
+
+// flushPeriod is how often a changed record is written back
+const flushPeriod = 10 * time.Second
+
+var stateWritesTotal = metrics.NewCounter(
+	"nlb_attacher_state_writes_total",
+	"Writes of the persisted state config map by result.",
+	"result",
+)
+
+// Target is a pod ip the attacher registered with a target group. A port of zero is the target group's port
+type Target struct {
+	Pod  string `json:"pod"`
+	IP   string `json:"ip"`
+	Port int64  `json:"port,omitempty"`
+}
+
+// TargetGroup is what the attacher registered with one target group, keyed by pod UID
+type TargetGroup struct {
+	Targets       map[types.UID]Target `json:"targets"`
+	LastReconcile *metav1.Time         `json:"lastReconcile,omitempty"`
+}
+
+// Detachment is a pod detached through the admin api, until the time when it is set
+type Detachment struct {
+	Pod   string       `json:"pod"`
+	Until *metav1.Time `json:"until,omitempty"`
+}
+
+// Active - report whether the detachment is still in effect at the time
+func (detachment Detachment) Active(now time.Time) bool {
+	return detachment.Until == nil || now.Before(detachment.Until.Time)
+}
+
+// Record is the persisted state, keyed by target group ARN, with the target groups paused and the pods detached
+// through the admin api so a restarted controller and the inspecting commands know of them
+type Record struct {
+	TargetGroups map[string]*TargetGroup `json:"targetGroups"`
+	// Paused is when each paused target group was paused
+	Paused map[string]metav1.Time `json:"paused,omitempty"`
+	// Detached are the detached pods by UID
+	Detached map[types.UID]Detachment `json:"detached,omitempty"`
+}
+
+// Owner is a pod that owns targets in one or more target groups
+type Owner struct {
+	UID          types.UID
+	Pod          string
+	IP           string
+	TargetGroups []string
+	// Ports are the ports the targets were registered on by target group, where they were known
+	Ports map[string]int64
+}
+
+// Store keeps the record in memory and writes it to a config map in the background.
+type Store struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+
+	mutex  sync.Mutex
+	record Record
+	dirty  bool
+}
+
+// NewStore - return a store persisting to the config map namespace/name
+func NewStore(clientset kubernetes.Interface, namespace string, name string) *Store {
+	return &Store{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+		record:    Record{TargetGroups: make(map[string]*TargetGroup)},
+	}
+}
+
+// Load - read the record from the config map. A missing config map is an empty record
+func (store *Store) Load() error {
+	if store == nil {
+		return nil
+	}
+	configMap, err := store.clientset.CoreV1().ConfigMaps(store.namespace).Get(store.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Infof("State config map %s/%s does not exist yet, starting without state", store.namespace, store.name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state config map %s/%s: %v", store.namespace, store.name, err)
+	}
+
+	record := Record{}
+	if raw := configMap.Data[DataKey]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			return fmt.Errorf("invalid %s in state config map %s/%s: %v", DataKey, store.namespace, store.name, err)
+		}
+	}
+	if record.TargetGroups == nil {
+		record.TargetGroups = make(map[string]*TargetGroup)
+	}
+
+	store.mutex.Lock()
+	store.record = record
+	store.mutex.Unlock()
+	log.Infof("Loaded state of %d target groups from config map %s/%s", len(record.TargetGroups), store.namespace, store.name)
+	return nil
+}
+
+// Owners - return every pod with targets in the record, with the target groups it owns targets in
+func (store *Store) Owners() []Owner {
+	if store == nil {
+		return nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	owners := make(map[types.UID]*Owner)
+	for tgArn, tg := range store.record.TargetGroups {
+		for uid, target := range tg.Targets {
+			owner, ok := owners[uid]
+			if !ok {
+				owner = &Owner{UID: uid, Pod: target.Pod, IP: target.IP, Ports: make(map[string]int64)}
+				owners[uid] = owner
+			}
+			owner.TargetGroups = append(owner.TargetGroups, tgArn)
+			if target.Port != 0 {
+				owner.Ports[tgArn] = target.Port
+			}
+		}
+	}
+
+	result := make([]Owner, 0, len(owners))
+	for _, owner := range owners {
+		sort.Strings(owner.TargetGroups)
+		result = append(result, *owner)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Pod < result[j].Pod })
+	return result
+}
+
+// PausedTargetGroups - return the paused target groups in order
+func (store *Store) PausedTargetGroups() []string {
+	if store == nil {
+		return nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tgArns := make([]string, 0, len(store.record.Paused))
+	for tgArn := range store.record.Paused {
+		tgArns = append(tgArns, tgArn)
+	}
+	sort.Strings(tgArns)
+	return tgArns
+}
+
+// SetPaused - record that the target group was paused or resumed
+func (store *Store) SetPaused(tgArn string, paused bool) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.record.Paused[tgArn]
+	switch {
+	case paused && !ok:
+		if store.record.Paused == nil {
+			store.record.Paused = make(map[string]metav1.Time)
+		}
+		store.record.Paused[tgArn] = metav1.Now()
+		store.dirty = true
+	case !paused && ok:
+		delete(store.record.Paused, tgArn)
+		store.dirty = true
+	}
+}
+
+// Detachments - return a copy of the detached pods by UID
+func (store *Store) Detachments() map[types.UID]Detachment {
+	if store == nil {
+		return nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	detachments := make(map[types.UID]Detachment, len(store.record.Detached))
+	for uid, detachment := range store.record.Detached {
+		detachments[uid] = detachment
+	}
+	return detachments
+}
+
+// SetDetached - record that the pod was detached, until the time when it is not zero
+func (store *Store) SetDetached(uid types.UID, pod string, until time.Time) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	detachment := Detachment{Pod: pod}
+	if !until.IsZero() {
+		detachment.Until = &metav1.Time{Time: until}
+	}
+	if store.record.Detached == nil {
+		store.record.Detached = make(map[types.UID]Detachment)
+	}
+	store.record.Detached[uid] = detachment
+	store.dirty = true
+}
+
+// ClearDetached - record that the detach of the pod ended
+func (store *Store) ClearDetached(uid types.UID) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.record.Detached[uid]; ok {
+		delete(store.record.Detached, uid)
+		store.dirty = true
+	}
+}
+
+// Registered - record that the pod's ip was registered with the target group
+func (store *Store) Registered(tgArn string, pod *v1.Pod, port int64) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tg := store.targetGroup(tgArn)
+	target := Target{Pod: pod.Namespace + "/" + pod.Name, IP: pod.Status.PodIP, Port: port}
+	if tg.Targets[pod.UID] != target {
+		tg.Targets[pod.UID] = target
+		store.dirty = true
+	}
+}
+
+// Deregistered - record that the pod's ip is no longer registered with the target group
+func (store *Store) Deregistered(tgArn string, uid types.UID) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tg, ok := store.record.TargetGroups[tgArn]
+	if !ok {
+		return
+	}
+	if _, ok := tg.Targets[uid]; ok {
+		delete(tg.Targets, uid)
+		store.dirty = true
+	}
+	if len(tg.Targets) == 0 {
+		delete(store.record.TargetGroups, tgArn)
+		store.dirty = true
+	}
+}
+
+// Reconciled - record the time the target group was last reconciled
+func (store *Store) Reconciled(tgArn string) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := metav1.Now()
+	store.targetGroup(tgArn).LastReconcile = &now
+	store.dirty = true
+}
+
+// Run - write the record whenever it changed until stop is closed. The last changes are written by a final Flush of the caller
+func (store *Store) Run(stop <-chan struct{}) {
+	if store == nil {
+		return
+	}
+	ticker := time.NewTicker(flushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := store.Flush(); err != nil {
+				log.Error(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Flush - write the record to the config map if it changed since the last write
+func (store *Store) Flush() error {
+	if store == nil {
+		return nil
+	}
+	store.mutex.Lock()
+	if !store.dirty {
+		store.mutex.Unlock()
+		return nil
+	}
+	raw, err := json.Marshal(store.record)
+	store.dirty = false
+	store.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := store.write(string(raw)); err != nil {
+		stateWritesTotal.Inc("failure")
+		store.mutex.Lock()
+		store.dirty = true
+		store.mutex.Unlock()
+		return fmt.Errorf("failed to write state config map %s/%s: %v", store.namespace, store.name, err)
+	}
+	stateWritesTotal.Inc("success")
+	log.Debugf("Wrote %d bytes of state to config map %s/%s", len(raw), store.namespace, store.name)
+	return nil
+}
+
+// write creates or updates the config map
+func (store *Store) write(raw string) error {
+	api := store.clientset.CoreV1().ConfigMaps(store.namespace)
+	configMap, err := api.Get(store.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = api.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: store.name, Namespace: store.namespace},
+			Data:       map[string]string{DataKey: raw},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[DataKey] = raw
+	_, err = api.Update(configMap)
+	return err
+}
+
+// targetGroup returns the record of the target group, creating it. Callers hold the mutex
+func (store *Store) targetGroup(tgArn string) *TargetGroup {
+	tg, ok := store.record.TargetGroups[tgArn]
+	if !ok {
+		tg = &TargetGroup{Targets: make(map[types.UID]Target)}
+		store.record.TargetGroups[tgArn] = tg
+	}
+	return tg
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	tgA = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-a/73e2d6bc24d8a067"
+	tgB = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-b/2453ed029918f21f"
+)
+
+func testPod(name string, ip string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Status:     v1.PodStatus{PodIP: ip},
+	}
+}
+
+func TestOwners(t *testing.T) {
+	cases := []struct {
+		name   string
+		record func(store *Store)
+		want   []Owner
+	}{
+		{name: "empty", record: func(*Store) {}, want: []Owner{}},
+		{
+			name: "pod in two target groups",
+			record: func(store *Store) {
+				pod := testPod("web-0", "10.0.0.1")
+				store.Registered(tgB, pod, 9090)
+				store.Registered(tgA, pod, 0)
+			},
+			want: []Owner{{
+				UID: "uid-web-0", Pod: "default/web-0", IP: "10.0.0.1",
+				TargetGroups: []string{tgA, tgB}, Ports: map[string]int64{tgB: 9090},
+			}},
+		},
+		{
+			name: "deregistered pod",
+			record: func(store *Store) {
+				store.Registered(tgA, testPod("web-0", "10.0.0.1"), 0)
+				store.Registered(tgA, testPod("web-1", "10.0.0.2"), 0)
+				store.Deregistered(tgA, "uid-web-0")
+			},
+			want: []Owner{{UID: "uid-web-1", Pod: "default/web-1", IP: "10.0.0.2", TargetGroups: []string{tgA}, Ports: map[string]int64{}}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewStore(fake.NewSimpleClientset(), "kube-system", "nlb-attacher-state")
+			tc.record(store)
+			if got := store.Owners(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Owners() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFlushAndLoad(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewStore(clientset, "kube-system", "nlb-attacher-state")
+	store.Registered(tgA, testPod("web-0", "10.0.0.1"), 8080)
+	store.SetPaused(tgB, true)
+	store.SetDetached("uid-web-1", "default/web-1", time.Time{})
+
+	// the config map is created on the first write and updated on the next
+	for i := 0; i < 2; i++ {
+		if err := store.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+		store.Registered(tgA, testPod("web-2", "10.0.0.3"), 8080)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	restored := NewStore(clientset, "kube-system", "nlb-attacher-state")
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, want := len(restored.Owners()), 2; got != want {
+		t.Errorf("restored %d owners, want %d", got, want)
+	}
+	if got, want := restored.PausedTargetGroups(), []string{tgB}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PausedTargetGroups() = %v, want %v", got, want)
+	}
+	if detached, ok := restored.Detachments()["uid-web-1"]; !ok || !detached.Active(time.Now()) {
+		t.Errorf("Detachments() = %v, want web-1 detached", restored.Detachments())
+	}
+}
+
+func TestFlushWritesOnlyChanges(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewStore(clientset, "kube-system", "nlb-attacher-state")
+	pod := testPod("web-0", "10.0.0.1")
+	store.Registered(tgA, pod, 0)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	writes := len(clientset.Actions())
+	// registering the same target again changes nothing
+	store.Registered(tgA, pod, 0)
+	store.SetPaused(tgA, false)
+	store.ClearDetached(pod.UID)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got := len(clientset.Actions()); got != writes {
+		t.Errorf("Flush() made %d api calls without changes, want none", got-writes)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name       string
+		configMap  *v1.ConfigMap
+		wantOwners int
+		wantErr    bool
+	}{
+		{name: "missing config map"},
+		{name: "config map without the key", configMap: &v1.ConfigMap{}},
+		{name: "invalid record", configMap: &v1.ConfigMap{Data: map[string]string{DataKey: "{"}}, wantErr: true},
+		{
+			name: "record",
+			configMap: &v1.ConfigMap{Data: map[string]string{DataKey: mustMarshal(t, Record{TargetGroups: map[string]*TargetGroup{
+				tgA: {Targets: map[types.UID]Target{"uid-web-0": {Pod: "default/web-0", IP: "10.0.0.1"}}},
+			}})}},
+			wantOwners: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tc.configMap != nil {
+				tc.configMap.Name, tc.configMap.Namespace = "nlb-attacher-state", "kube-system"
+				if _, err := clientset.CoreV1().ConfigMaps("kube-system").Create(tc.configMap); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := NewStore(clientset, "kube-system", "nlb-attacher-state")
+			if err := store.Load(); (err != nil) != tc.wantErr {
+				t.Fatalf("Load() error = %v, want an error: %v", err, tc.wantErr)
+			}
+			if got := len(store.Owners()); got != tc.wantOwners {
+				t.Errorf("%d owners, want %d", got, tc.wantOwners)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, record Record) string {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
@@ -0,0 +1,267 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/birdrides/nlb-attacher/pkg/metrics"
+)
+
+const (
+	// tracesPath is the OTLP/HTTP path of the trace service
+	tracesPath = "/v1/traces"
+	// queueSize is how many ended spans wait for export before new ones are dropped
+	queueSize = 2048
+	// batchSize is the most spans sent in one request
+	batchSize = 512
+	// exportPeriod is how often a partial batch is sent
+	exportPeriod = 5 * time.Second
+	// exportTimeout bounds one export request
+	exportTimeout = 10 * time.Second
+)
+
+var (
+	spansExportedTotal = metrics.NewCounter(
+		"nlb_attacher_trace_spans_exported_total",
+		"Spans sent to the OTLP collector.",
+	)
+	spansDroppedTotal = metrics.NewCounter(
+		"nlb_attacher_trace_spans_dropped_total",
+		"Spans that were not exported, by reason.",
+		"reason",
+	)
+)
+
+// Exporter sends ended spans in batches to an OTLP/HTTP collector, encoded as JSON
+type Exporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	spans   chan *Span
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewExporter - return an exporter for the collector at endpoint, e.g. http://localhost:4318.
+// The trace path is appended unless the endpoint already ends with it
+func NewExporter(endpoint string, serviceName string) *Exporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, tracesPath) {
+		url += tracesPath
+	}
+	return &Exporter{
+		url:         url,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		spans:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Run - send batches until Shutdown is called, then send what is left
+func (e *Exporter) Run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(exportPeriod)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = batch[:0]
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.spans:
+					batch = append(batch, span)
+					if len(batch) >= batchSize {
+						e.send(batch)
+						batch = batch[:0]
+					}
+				default:
+					e.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown - send the spans that ended so far and stop. Spans ending later are dropped
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	close(e.done)
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("spans left unexported: %v", ctx.Err())
+	}
+}
+
+// export queues an ended span without ever blocking the pipeline it traces
+func (e *Exporter) export(span *Span) {
+	select {
+	case <-e.done:
+		spansDroppedTotal.Inc("shutdown")
+	case e.spans <- span:
+	default:
+		spansDroppedTotal.Inc("queue_full")
+	}
+}
+
+func (e *Exporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		log.Errorf("Failed to encode %d spans: %v", len(batch), err)
+		spansDroppedTotal.Add(float64(len(batch)), "encoding")
+		return
+	}
+
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Warnf("Failed to export %d spans to %s: %v", len(batch), e.url, err)
+		spansDroppedTotal.Add(float64(len(batch)), "export_failed")
+		return
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode/100 != 2 {
+		log.Warnf("Failed to export %d spans to %s: %s", len(batch), e.url, response.Status)
+		spansDroppedTotal.Add(float64(len(batch)), "export_failed")
+		return
+	}
+	spansExportedTotal.Add(float64(len(batch)))
+}
+
+// The OTLP/HTTP JSON encoding of ExportTraceServiceRequest. Ids are hex, 64 bit integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func (e *Exporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.otlp())
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", e.serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: e.serviceName}, Spans: spans}},
+	}}}
+}
+
+func (span *Span) otlp() otlpSpan {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	encoded := otlpSpan{
+		TraceID:           hex.EncodeToString(span.context.TraceID[:]),
+		SpanID:            hex.EncodeToString(span.context.SpanID[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.attributes),
+		Status:            otlpStatus{Code: 1},
+	}
+	if span.parentID != [8]byte{} {
+		encoded.ParentSpanID = hex.EncodeToString(span.parentID[:])
+	}
+	if span.err != "" {
+		encoded.Status = otlpStatus{Code: 2, Message: span.err}
+	}
+	return encoded
+}
+
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		value := otlpValue{}
+		switch typed := attribute.Value.(type) {
+		case string:
+			value.StringValue = &typed
+		case int64:
+			formatted := strconv.FormatInt(typed, 10)
+			value.IntValue = &formatted
+		case float64:
+			value.DoubleValue = &typed
+		case bool:
+			value.BoolValue = &typed
+		default:
+			formatted := fmt.Sprint(typed)
+			value.StringValue = &formatted
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Span kinds, numbered like the OTLP SpanKind enum
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+	KindProducer = 4
+	KindConsumer = 5
+)
+
+// SpanContext identifies a span and carries the sampling decision of its trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid - report whether the span context belongs to a started span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+func (sc SpanContext) String() string {
+	return fmt.Sprintf("%s/%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// Attribute is a key and a string, integer, float or bool value recorded on a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String - return a string attribute
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int - return an integer attribute
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Float - return a floating point attribute
+func Float(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation. A nil span, returned while tracing is disabled, records nothing
+type Span struct {
+	mutex      sync.Mutex
+	context    SpanContext
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        string
+	exporter   *Exporter
+}
+
+type spanContextKey struct{}
+
+// tracer holds the exporter and sampling ratio set by Setup
+var (
+	tracerMutex sync.RWMutex
+	exporter    *Exporter
+	sampleRatio float64
+)
+
+// Setup - export every sampled span through the exporter. A ratio of 1 samples every trace, 0 none
+func Setup(e *Exporter, ratio float64) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	exporter = e
+	sampleRatio = ratio
+}
+
+// Enabled - report whether spans are exported
+func Enabled() bool {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+	return exporter != nil
+}
+
+// Start - start a span as a child of the span in ctx, or as the root of a new trace, and return a context carrying it
+func Start(ctx context.Context, name string, kind int, attributes ...Attribute) (context.Context, *Span) {
+	return StartAt(ctx, name, kind, time.Now(), attributes...)
+}
+
+// StartAt - start a span that began at the given time, e.g. when an event was queued
+func StartAt(ctx context.Context, name string, kind int, start time.Time, attributes ...Attribute) (context.Context, *Span) {
+	tracerMutex.RLock()
+	e, ratio := exporter, sampleRatio
+	tracerMutex.RUnlock()
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: start, attributes: attributes, exporter: e}
+	parent := SpanContextFrom(ctx)
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parentID = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		// the low bits of a random trace id are a uniform sample, so every span of the trace makes the same decision
+		span.context.Sampled = float64(binary.BigEndian.Uint64(span.context.TraceID[8:])>>11)/(1<<53) < ratio
+	}
+	rand.Read(span.context.SpanID[:])
+	return ContextWithSpanContext(ctx, span.context), span
+}
+
+// ContextWithSpanContext - return a context whose next span is a child of sc, e.g. to continue a trace across the workqueue
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFrom - return the span context carried by ctx, if any
+func SpanContextFrom(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Context - return the span context of the span
+func (span *Span) Context() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.context
+}
+
+// SetAttributes - add attributes to the span
+func (span *Span) SetAttributes(attributes ...Attribute) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.attributes = append(span.attributes, attributes...)
+}
+
+// RecordError - mark the span as failed with the error, if any
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.err = err.Error()
+}
+
+// End - end the span now and hand it to the exporter when its trace is sampled
+func (span *Span) End() {
+	span.EndAt(time.Now())
+}
+
+// EndAt - end the span at the given time
+func (span *Span) EndAt(end time.Time) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	if !span.end.IsZero() {
+		span.mutex.Unlock()
+		return
+	}
+	span.end = end
+	span.mutex.Unlock()
+	if span.context.Sampled {
+		span.exporter.export(span)
+	}
+}
@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+)
+
+// InjectPreStopAnnotationKey lets a pod override the global preStop injection setting with "true" or "false"
+const InjectPreStopAnnotationKey = "nlb-attacher.bird.co/inject-prestop"
+
+// defaultTerminationGracePeriodSeconds matches the kubernetes default when a pod does not set one
+const defaultTerminationGracePeriodSeconds int64 = 30
+
+// defaultDeregistrationDelaySeconds matches the ELBv2 default, assumed for target groups whose delay was not looked up in time
+const defaultDeregistrationDelaySeconds int64 = 300
+
+// delayLookupTimeout bounds the ELBv2 calls of an admission, well within the apiserver's webhook timeout
+const delayLookupTimeout = 3 * time.Second
+
+// DeregistrationDelayLookup returns the deregistration delay of a target group, referenced by ARN or name, in seconds
+type DeregistrationDelayLookup interface {
+	DeregistrationDelay(ctx context.Context, tgRef string) (int64, error)
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// EnableMutation - serve /mutate, injecting the readiness gate and optionally a preStop drain delay
+func (webhook *Webhook) EnableMutation(delays DeregistrationDelayLookup, injectPreStop bool) {
+	webhook.delays = delays
+	webhook.injectPreStop = injectPreStop
+	webhook.mutationEnabled = true
+}
+
+// EnableAliases - resolve alias references through the store when looking up deregistration delays
+func (webhook *Webhook) EnableAliases(store *alias.Store) {
+	webhook.aliases = store
+}
+
+// mutate builds a json patch that adds the readiness gate and covers the deregistration delay on pod creation
+func (webhook *Webhook) mutate(ctx context.Context, request *admissionv1beta1.AdmissionRequest, pod *v1.Pod) *admissionv1beta1.AdmissionResponse {
+	if request.Operation != admissionv1beta1.Create {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	patch := make([]patchOperation, 0)
+	patch = append(patch, readinessGatePatch(pod)...)
+
+	if webhook.shouldInjectPreStop(pod) {
+		delay, err := webhook.maxDeregistrationDelay(ctx, pod)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"namespace": request.Namespace,
+				"name":      podName(request, pod),
+			}).Warnf("Not injecting preStop delay: %v", err)
+		} else if delay > 0 {
+			patch = append(patch, webhook.preStopPatch(pod, delay)...)
+		}
+	}
+
+	if len(patch) == 0 {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return deny(fmt.Sprintf("failed to build patch: %v", err))
+	}
+
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     raw,
+		PatchType: &patchType,
+	}
+}
+
+func readinessGatePatch(pod *v1.Pod) []patchOperation {
+	if readiness.HasGate(pod, readiness.ConditionType) {
+		return nil
+	}
+
+	gate := v1.PodReadinessGate{ConditionType: readiness.ConditionType}
+	if len(pod.Spec.ReadinessGates) == 0 {
+		return []patchOperation{{Op: "add", Path: "/spec/readinessGates", Value: []v1.PodReadinessGate{gate}}}
+	}
+	return []patchOperation{{Op: "add", Path: "/spec/readinessGates/-", Value: gate}}
+}
+
+func (webhook *Webhook) shouldInjectPreStop(pod *v1.Pod) bool {
+	switch pod.GetAnnotations()[InjectPreStopAnnotationKey] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return webhook.injectPreStop
+}
+
+// maxDeregistrationDelay returns the longest deregistration delay of the pod's target groups. Target groups whose delay
+// cannot be looked up within delayLookupTimeout count with the ELBv2 default
+func (webhook *Webhook) maxDeregistrationDelay(ctx context.Context, pod *v1.Pod) (int64, error) {
+	value, ok := pod.GetAnnotations()[webhook.targetGroupAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	targetGroups, err := annotation.Parse(value)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, delayLookupTimeout)
+	defer cancel()
+
+	var longest int64
+	for _, targetGroup := range targetGroups {
+		tgRef := targetGroup.Ref()
+		if targetGroup.Alias != "" {
+			tgArn, ok := webhook.aliases.Resolve(targetGroup.Alias)
+			if !ok {
+				// the controller reports the undefined alias on the pod
+				continue
+			}
+			tgRef = tgArn
+		}
+		delay, err := webhook.delays.DeregistrationDelay(ctx, tgRef)
+		if err != nil && (ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded)) {
+			log.Warnf("Timed out looking up the deregistration delay of %s, assuming %d seconds: %v", tgRef, defaultDeregistrationDelaySeconds, err)
+			delay, err = defaultDeregistrationDelaySeconds, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up deregistration delay of %s: %v", tgRef, err)
+		}
+		if delay > longest {
+			longest = delay
+		}
+	}
+	return longest, nil
+}
+
+// preStopPatch sleeps through the deregistration delay in every container serving a target group port
+// and raises the grace period so the sleep cannot eat into the application's own shutdown time.
+// An entry naming no port registers on the port of the target group, which any container exposing a port may serve
+func (webhook *Webhook) preStopPatch(pod *v1.Pod, delay int64) []patchOperation {
+	targetGroups, _ := annotation.Parse(pod.GetAnnotations()[webhook.targetGroupAnnotationKey])
+	portNames := make(map[string]bool)
+	ports := make(map[int32]bool)
+	anyPort := false
+	for _, targetGroup := range targetGroups {
+		switch {
+		case targetGroup.PortName != "":
+			portNames[targetGroup.PortName] = true
+		case targetGroup.Port != 0:
+			ports[targetGroup.Port] = true
+		default:
+			anyPort = true
+		}
+	}
+
+	patch := make([]patchOperation, 0)
+	for i, container := range pod.Spec.Containers {
+		if !servesPort(container, portNames, ports, anyPort) {
+			continue
+		}
+		if container.Lifecycle != nil && container.Lifecycle.PreStop != nil {
+			continue
+		}
+
+		handler := &v1.Handler{
+			Exec: &v1.ExecAction{Command: []string{"sleep", fmt.Sprintf("%d", delay)}},
+		}
+		if container.Lifecycle == nil {
+			patch = append(patch, patchOperation{
+				Op:    "add",
+				Path:  fmt.Sprintf("/spec/containers/%d/lifecycle", i),
+				Value: v1.Lifecycle{PreStop: handler},
+			})
+		} else {
+			patch = append(patch, patchOperation{
+				Op:    "add",
+				Path:  fmt.Sprintf("/spec/containers/%d/lifecycle/preStop", i),
+				Value: handler,
+			})
+		}
+	}
+
+	if len(patch) == 0 {
+		return patch
+	}
+
+	gracePeriod := defaultTerminationGracePeriodSeconds
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	patch = append(patch, patchOperation{
+		Op:    "add",
+		Path:  "/spec/terminationGracePeriodSeconds",
+		Value: gracePeriod + delay,
+	})
+	return patch
+}
+
+func servesPort(container v1.Container, portNames map[string]bool, ports map[int32]bool, anyPort bool) bool {
+	for _, port := range container.Ports {
+		if anyPort || portNames[port.Name] || ports[port.ContainerPort] {
+			return true
+		}
+	}
+	return false
+}
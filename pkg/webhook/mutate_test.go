@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	testLabelKey      = "nlb-attacher.bird.co/enabled"
+	testAnnotationKey = "nlb-attacher.bird.co/target-groups"
+	testTargetGroup   = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-a/73e2d6bc24d8a067"
+)
+
+// fakeDelays returns the same deregistration delay for every target group, or blocks until the context is done
+type fakeDelays struct {
+	delay int64
+	block bool
+}
+
+func (delays fakeDelays) DeregistrationDelay(ctx context.Context, tgRef string) (int64, error) {
+	if delays.block {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return delays.delay, nil
+}
+
+// testPod returns an opted in pod with an app container serving http, an admin container and a sidecar without ports
+func testPod(value string, annotations map[string]string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-0",
+			Namespace:   "default",
+			Labels:      map[string]string{testLabelKey: "true"},
+			Annotations: map[string]string{testAnnotationKey: value},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			{Name: "admin", Ports: []v1.ContainerPort{{Name: "admin", ContainerPort: 9090}}},
+			{Name: "sidecar"},
+		}},
+	}
+	for key, value := range annotations {
+		pod.Annotations[key] = value
+	}
+	return pod
+}
+
+// review posts an admission review of the pod to the webhook's path and returns the response
+func review(t *testing.T, webhook *Webhook, path string, ctx context.Context, request admissionv1beta1.AdmissionRequest, pod *v1.Pod) *admissionv1beta1.AdmissionResponse {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.UID = types.UID("review-uid")
+	request.Namespace = pod.Namespace
+	request.Object = runtime.RawExtension{Raw: raw}
+	body, err := json.Marshal(admissionv1beta1.AdmissionReview{Request: &request})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	webhook.RegisterRoutes(engine)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)).WithContext(ctx))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s responded %d: %s", path, recorder.Code, recorder.Body.String())
+	}
+
+	var result admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || result.Response.UID != request.UID {
+		t.Fatalf("%s responded %+v, want a response to the review", path, result.Response)
+	}
+	return result.Response
+}
+
+func TestMutate(t *testing.T) {
+	withPreStop := func(pod *v1.Pod) {
+		pod.Spec.Containers[0].Lifecycle = &v1.Lifecycle{PreStop: &v1.Handler{Exec: &v1.ExecAction{Command: []string{"true"}}}}
+	}
+	cases := []struct {
+		name        string
+		value       string
+		annotations map[string]string
+		modify      func(pod *v1.Pod)
+		delays      fakeDelays
+		// deadline bounds the admission request, as the apiserver's webhook timeout does
+		deadline time.Duration
+		// want maps the path of every patch operation beyond the readiness gate to its value
+		want map[string]interface{}
+	}{
+		{
+			name:   "v1 entry naming a port",
+			value:  `[{"Arn": "` + testTargetGroup + `", "PortName": "http"}]`,
+			delays: fakeDelays{delay: 60},
+			want: map[string]interface{}{
+				"/spec/containers/0/lifecycle":        map[string]interface{}{"preStop": map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "60"}}}},
+				"/spec/terminationGracePeriodSeconds": float64(90),
+			},
+		},
+		{
+			name:   "v1 entry without a port name serves the target group port from any container",
+			value:  `[{"Arn": "` + testTargetGroup + `"}]`,
+			delays: fakeDelays{delay: 60},
+			want: map[string]interface{}{
+				"/spec/containers/0/lifecycle":        map[string]interface{}{"preStop": map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "60"}}}},
+				"/spec/containers/1/lifecycle":        map[string]interface{}{"preStop": map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "60"}}}},
+				"/spec/terminationGracePeriodSeconds": float64(90),
+			},
+		},
+		{
+			name:   "v2 entry with a port number",
+			value:  `{"version": "v2", "targetGroups": [{"arn": "` + testTargetGroup + `", "port": 9090}]}`,
+			delays: fakeDelays{delay: 10},
+			want: map[string]interface{}{
+				"/spec/containers/1/lifecycle":        map[string]interface{}{"preStop": map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "10"}}}},
+				"/spec/terminationGracePeriodSeconds": float64(40),
+			},
+		},
+		{
+			name:   "container with its own preStop",
+			value:  `[{"Arn": "` + testTargetGroup + `", "PortName": "http"}]`,
+			modify: withPreStop,
+			delays: fakeDelays{delay: 60},
+			want:   map[string]interface{}{},
+		},
+		{
+			name:        "injection turned off by the pod",
+			value:       `[{"Arn": "` + testTargetGroup + `", "PortName": "http"}]`,
+			annotations: map[string]string{InjectPreStopAnnotationKey: "false"},
+			delays:      fakeDelays{delay: 60},
+			want:        map[string]interface{}{},
+		},
+		{
+			name:     "lookup outlasting the request falls back to the default delay",
+			value:    `[{"Arn": "` + testTargetGroup + `", "PortName": "http"}]`,
+			delays:   fakeDelays{block: true},
+			deadline: 50 * time.Millisecond,
+			want: map[string]interface{}{
+				"/spec/containers/0/lifecycle":        map[string]interface{}{"preStop": map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "300"}}}},
+				"/spec/terminationGracePeriodSeconds": float64(330),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			webhook := NewWebhook(testLabelKey, testAnnotationKey)
+			webhook.EnableMutation(tc.delays, true)
+			pod := testPod(tc.value, tc.annotations)
+			if tc.modify != nil {
+				tc.modify(pod)
+			}
+			ctx := context.Background()
+			if tc.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+				defer cancel()
+			}
+
+			response := review(t, webhook, "/mutate", ctx, admissionv1beta1.AdmissionRequest{Operation: admissionv1beta1.Create}, pod)
+			if !response.Allowed {
+				t.Fatalf("response = %+v, want allowed", response)
+			}
+			var patch []patchOperation
+			if err := json.Unmarshal(response.Patch, &patch); err != nil {
+				t.Fatalf("invalid patch %s: %v", response.Patch, err)
+			}
+			if len(patch) == 0 || patch[0].Path != "/spec/readinessGates" {
+				t.Fatalf("patch = %s, want the readiness gate first", response.Patch)
+			}
+			got := make(map[string]interface{})
+			for _, operation := range patch[1:] {
+				got[operation.Path] = operation.Value
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("patch = %s, want %v", response.Patch, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/birdrides/nlb-attacher/pkg/alias"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+)
+
+// Webhook serves the admission webhooks for opted in pods
+type Webhook struct {
+	enabledLabelKey          string
+	targetGroupAnnotationKey string
+
+	mutationEnabled bool
+	injectPreStop   bool
+	delays          DeregistrationDelayLookup
+
+	policy     *policy.Store
+	namespaces corelisters.NamespaceLister
+	aliases    *alias.Store
+}
+
+// NewWebhook - return a webhook for pods carrying the given label and annotation keys
+func NewWebhook(enabledLabelKey string, targetGroupAnnotationKey string) *Webhook {
+	return &Webhook{
+		enabledLabelKey:          enabledLabelKey,
+		targetGroupAnnotationKey: targetGroupAnnotationKey,
+	}
+}
+
+// RegisterRoutes - add the admission endpoints to the engine
+func (webhook *Webhook) RegisterRoutes(engine *gin.Engine) {
+	engine.POST("/validate", webhook.serve(webhook.validate))
+	if webhook.mutationEnabled {
+		engine.POST("/mutate", webhook.serve(webhook.mutate))
+	}
+}
+
+type admitFunc func(ctx context.Context, request *admissionv1beta1.AdmissionRequest, pod *v1.Pod) *admissionv1beta1.AdmissionResponse
+
+// serve decodes an AdmissionReview, hands the pod to admit and writes the review back
+func (webhook *Webhook) serve(admit admitFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var review admissionv1beta1.AdmissionReview
+		if err := c.ShouldBindJSON(&review); err != nil || review.Request == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected an AdmissionReview request"})
+			return
+		}
+
+		request := review.Request
+		var response *admissionv1beta1.AdmissionResponse
+
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(request.Object.Raw, pod); err != nil {
+			response = deny(fmt.Sprintf("failed to decode pod: %v", err))
+		} else if !webhook.optedIn(pod) {
+			response = &admissionv1beta1.AdmissionResponse{Allowed: true}
+		} else {
+			response = admit(c.Request.Context(), request, pod)
+		}
+
+		response.UID = request.UID
+		review.Response = response
+		review.Request = nil
+		c.JSON(http.StatusOK, review)
+	}
+}
+
+// EnablePolicy - also reject pods referencing a target group by ARN that the policy does not allow their namespace to use.
+// Names, aliases and tag rules are left to the controller, which resolves them
+func (webhook *Webhook) EnablePolicy(store *policy.Store, namespaces corelisters.NamespaceLister) {
+	webhook.policy = store
+	webhook.namespaces = namespaces
+}
+
+// validate rejects pods whose target-groups annotation could never be attached, or that the policy denies.
+// Updates leaving the annotation as it is are allowed, even when it was admitted before it became invalid or denied
+func (webhook *Webhook) validate(_ context.Context, request *admissionv1beta1.AdmissionRequest, pod *v1.Pod) *admissionv1beta1.AdmissionResponse {
+	if !webhook.annotationChanged(request, pod) {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+	problems := annotation.Validate(pod, webhook.targetGroupAnnotationKey)
+	if len(problems) == 0 {
+		problems = webhook.policyProblems(request.Namespace, pod)
+	}
+	if len(problems) == 0 {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	log.WithFields(log.Fields{
+		"namespace": request.Namespace,
+		"name":      podName(request, pod),
+		"problems":  problems,
+	}).Info("Rejected pod with an invalid or denied target-groups annotation")
+	return deny(strings.Join(problems, "; "))
+}
+
+// policyProblems returns a problem for every target group ARN of the pod that the policy denies its namespace.
+// Until the policy is loaded pods are admitted, the controller still holds them back
+func (webhook *Webhook) policyProblems(namespace string, pod *v1.Pod) []string {
+	if !webhook.policy.Loaded() {
+		return nil
+	}
+	targetGroups, err := annotation.Parse(pod.GetAnnotations()[webhook.targetGroupAnnotationKey])
+	if err != nil {
+		return nil
+	}
+	var namespaceLabels map[string]string
+	if webhook.namespaces != nil {
+		if ns, err := webhook.namespaces.Get(namespace); err == nil {
+			namespaceLabels = ns.GetLabels()
+		}
+	}
+
+	problems := make([]string, 0)
+	for _, targetGroup := range targetGroups {
+		if targetGroup.Arn == "" {
+			continue
+		}
+		target := policy.Target{Arn: targetGroup.Arn, Name: policy.NameFromArn(targetGroup.Arn)}
+		allowed, reason, err := webhook.policy.Allowed(namespace, namespaceLabels, target)
+		if err == nil && !allowed {
+			policy.CountDenial(namespace)
+			problems = append(problems, fmt.Sprintf("%s: %s", webhook.targetGroupAnnotationKey, reason))
+		}
+	}
+	return problems
+}
+
+// annotationChanged reports whether the request creates the pod, opts it in or changes its target-groups annotation.
+// Other updates, such as the controller's own status annotation, labels or finalizers, are never held to checks the pod was admitted without
+func (webhook *Webhook) annotationChanged(request *admissionv1beta1.AdmissionRequest, pod *v1.Pod) bool {
+	if request.Operation != admissionv1beta1.Update || len(request.OldObject.Raw) == 0 {
+		return true
+	}
+	oldPod := &v1.Pod{}
+	if err := json.Unmarshal(request.OldObject.Raw, oldPod); err != nil {
+		return true
+	}
+	if !webhook.optedIn(oldPod) {
+		return true
+	}
+	oldValue, oldOk := oldPod.GetAnnotations()[webhook.targetGroupAnnotationKey]
+	value, ok := pod.GetAnnotations()[webhook.targetGroupAnnotationKey]
+	return oldOk != ok || oldValue != value
+}
+
+func (webhook *Webhook) optedIn(pod *v1.Pod) bool {
+	return pod.GetLabels()[webhook.enabledLabelKey] == "true"
+}
+
+func deny(message string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: message,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
+
+// podName returns a printable name for pods that only have generateName set at admission time
+func podName(request *admissionv1beta1.AdmissionRequest, pod *v1.Pod) string {
+	if request.Name != "" {
+		return request.Name
+	}
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
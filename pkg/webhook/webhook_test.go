@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+)
+
+const testPolicy = `
+rules:
+- namespaces: ["team-a"]
+  targetGroups: ["tg-a"]
+`
+
+func TestValidate(t *testing.T) {
+	valid := `[{"Arn": "` + testTargetGroup + `", "PortName": "http"}]`
+	invalid := `[{"Arn": "not-an-arn", "PortName": "http"}]`
+	cases := []struct {
+		name      string
+		namespace string
+		value     string
+		// old is the annotation value of the pod before an update, an update is sent when it is set
+		old       string
+		optedOut  bool
+		policy    string
+		wantAllow bool
+		// wantMessage is part of the message of a denial
+		wantMessage string
+	}{
+		{name: "valid annotation", value: valid, wantAllow: true},
+		{name: "invalid annotation", value: invalid, wantMessage: `"not-an-arn" is not a target group ARN`},
+		{name: "unknown port name", value: `[{"Arn": "` + testTargetGroup + `", "PortName": "grpc"}]`, wantMessage: "grpc"},
+		{name: "pod not opted in", value: invalid, optedOut: true, wantAllow: true},
+		{name: "update leaving an invalid annotation as it is", value: invalid, old: invalid, wantAllow: true},
+		{name: "update breaking the annotation", value: invalid, old: valid, wantMessage: "not-an-arn"},
+		{name: "target group the policy allows", namespace: "team-a", value: valid, policy: testPolicy, wantAllow: true},
+		{name: "target group the policy denies", namespace: "team-b", value: valid, policy: testPolicy, wantMessage: testAnnotationKey},
+		{name: "policy not loaded yet", namespace: "team-b", value: valid, policy: "-", wantAllow: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			webhook := NewWebhook(testLabelKey, testAnnotationKey)
+			if tc.policy != "" {
+				store := policy.NewStore("kube-system", "nlb-attacher-policy")
+				if tc.policy != "-" {
+					store.Update(&v1.ConfigMap{Data: map[string]string{policy.DataKey: tc.policy}})
+				}
+				webhook.EnablePolicy(store, nil)
+			}
+			pod := testPod(tc.value, nil)
+			if tc.namespace != "" {
+				pod.Namespace = tc.namespace
+			}
+			if tc.optedOut {
+				pod.Labels = nil
+			}
+
+			request := admissionv1beta1.AdmissionRequest{Operation: admissionv1beta1.Create}
+			if tc.old != "" {
+				raw, err := json.Marshal(testPod(tc.old, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				request.Operation = admissionv1beta1.Update
+				request.OldObject = runtime.RawExtension{Raw: raw}
+			}
+			response := review(t, webhook, "/validate", context.Background(), request, pod)
+			if response.Allowed != tc.wantAllow {
+				t.Fatalf("Allowed = %v, want %v: %+v", response.Allowed, tc.wantAllow, response.Result)
+			}
+			if !tc.wantAllow && (response.Result == nil || !strings.Contains(response.Result.Message, tc.wantMessage)) {
+				t.Errorf("denial %+v, want a message containing %q", response.Result, tc.wantMessage)
+			}
+		})
+	}
+}
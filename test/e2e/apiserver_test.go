@@ -0,0 +1,639 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// storedEvent is one change to the store, replayed to watches that resume from an older resource version
+type storedEvent struct {
+	resource        string
+	eventType       watch.EventType
+	object          runtime.Object
+	oldObject       runtime.Object
+	resourceVersion int64
+}
+
+type watcher struct {
+	resource  string
+	namespace string
+	selector  labels.Selector
+	fields    fields.Selector
+	events    chan storedEvent
+	done      chan struct{}
+}
+
+// apiServer is an in-memory kubernetes api serving the core/v1 pods, namespaces, nodes, events and config maps the controller uses,
+// and answering its SubjectAccessReviews.
+// Lists and watches honor label selectors, metadata.name field selectors and resource versions, so informers relist and resume like they do
+// against a real apiserver
+type apiServer struct {
+	mutex           sync.Mutex
+	resourceVersion int64
+	objects         map[string]map[string]runtime.Object
+	history         []storedEvent
+	watchers        map[*watcher]bool
+	watchesDownTill time.Time
+	// authorize answers SubjectAccessReviews, nil allows everything
+	authorize func(spec authorizationv1.SubjectAccessReviewSpec) bool
+
+	server *httptest.Server
+}
+
+func newAPIServer() *apiServer {
+	api := &apiServer{
+		objects: map[string]map[string]runtime.Object{
+			"pods":       {},
+			"namespaces": {},
+			"nodes":      {},
+			"events":     {},
+			"configmaps": {},
+		},
+		watchers: make(map[*watcher]bool),
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	return api
+}
+
+func (api *apiServer) close() {
+	api.dropWatches(0)
+	api.server.Close()
+}
+
+// dropWatches closes every open watch and refuses new ones for the given duration
+func (api *apiServer) dropWatches(downFor time.Duration) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.watchesDownTill = time.Now().Add(downFor)
+	for w := range api.watchers {
+		close(w.done)
+		delete(api.watchers, w)
+	}
+}
+
+// upsert creates or replaces an object and notifies the watches
+func (api *apiServer) upsert(resource string, obj runtime.Object) runtime.Object {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	obj = obj.DeepCopyObject()
+	setKind(obj)
+	meta := objectMeta(obj)
+	key := objectKey(meta.Namespace, meta.Name)
+	old, exists := api.objects[resource][key]
+
+	api.resourceVersion++
+	meta.ResourceVersion = strconv.FormatInt(api.resourceVersion, 10)
+	eventType := watch.Modified
+	if !exists {
+		eventType = watch.Added
+		meta.UID = typesUID(resource, key, api.resourceVersion)
+		meta.CreationTimestamp = metav1.Now()
+	} else {
+		meta.UID = objectMeta(old).UID
+		meta.CreationTimestamp = objectMeta(old).CreationTimestamp
+	}
+	api.objects[resource][key] = obj
+	api.record(storedEvent{resource: resource, eventType: eventType, object: obj, oldObject: old, resourceVersion: api.resourceVersion})
+	return obj.DeepCopyObject()
+}
+
+// remove deletes an object and notifies the watches
+func (api *apiServer) remove(resource string, namespace string, name string) bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	key := objectKey(namespace, name)
+	old, exists := api.objects[resource][key]
+	if !exists {
+		return false
+	}
+	delete(api.objects[resource], key)
+
+	api.resourceVersion++
+	obj := old.DeepCopyObject()
+	objectMeta(obj).ResourceVersion = strconv.FormatInt(api.resourceVersion, 10)
+	api.record(storedEvent{resource: resource, eventType: watch.Deleted, object: obj, resourceVersion: api.resourceVersion})
+	return true
+}
+
+func (api *apiServer) get(resource string, namespace string, name string) runtime.Object {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	obj, ok := api.objects[resource][objectKey(namespace, name)]
+	if !ok {
+		return nil
+	}
+	return obj.DeepCopyObject()
+}
+
+func (api *apiServer) list(resource string) []runtime.Object {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return api.sortedObjects(resource, "", labels.Everything(), fields.Everything())
+}
+
+// record appends to the history and fans the event out. Callers hold the mutex
+func (api *apiServer) record(event storedEvent) {
+	api.history = append(api.history, event)
+	for w := range api.watchers {
+		if translated, ok := w.translate(event); ok {
+			select {
+			case w.events <- translated:
+			case <-w.done:
+			}
+		}
+	}
+}
+
+// sortedObjects lists the matching objects ordered by key. Callers hold the mutex
+func (api *apiServer) sortedObjects(resource string, namespace string, selector labels.Selector, fieldSelector fields.Selector) []runtime.Object {
+	keys := make([]string, 0)
+	for key, obj := range api.objects[resource] {
+		meta := objectMeta(obj)
+		if (namespace == "" || meta.Namespace == namespace) && selector.Matches(labels.Set(meta.Labels)) && fieldSelector.Matches(objectFields(meta)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	objects := make([]runtime.Object, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, api.objects[resource][key].DeepCopyObject())
+	}
+	return objects
+}
+
+func (api *apiServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/apis/authorization.k8s.io/v1/subjectaccessreviews" && r.Method == http.MethodPost {
+		api.serveSubjectAccessReview(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/"), "/")
+
+	var resource, namespace, name, subresource string
+	switch {
+	case len(parts) == 1:
+		resource = parts[0]
+	case len(parts) == 2 && (parts[0] == "namespaces" || parts[0] == "nodes"):
+		resource, name = parts[0], parts[1]
+	case len(parts) >= 3 && parts[0] == "namespaces":
+		namespace, resource = parts[1], parts[2]
+		if len(parts) > 3 {
+			name = parts[3]
+		}
+		if len(parts) > 4 {
+			subresource = parts[4]
+		}
+	}
+	if _, known := api.objects[resource]; !known {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "" && r.URL.Query().Get("watch") == "true":
+		api.serveWatch(w, r, resource, namespace)
+	case r.Method == http.MethodGet && name == "":
+		api.serveList(w, r, resource, namespace)
+	case r.Method == http.MethodGet:
+		if obj := api.get(resource, namespace, name); obj != nil {
+			writeJSON(w, http.StatusOK, obj)
+			return
+		}
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("%s %s not found", resource, name))
+	case r.Method == http.MethodPost && resource == "events":
+		api.serveCreateEvent(w, r, namespace)
+	case r.Method == http.MethodPost && resource == "configmaps" && name == "":
+		api.serveCreateConfigMap(w, r, namespace)
+	case r.Method == http.MethodPut && resource == "configmaps":
+		api.serveUpdateConfigMap(w, r, namespace, name)
+	case r.Method == http.MethodPatch && resource == "pods" && subresource == "status":
+		api.servePatchPodStatus(w, r, namespace, name)
+	case r.Method == http.MethodPatch && resource == "pods" && subresource == "":
+		api.servePatchPodMetadata(w, r, namespace, name)
+	case r.Method == http.MethodPost && resource == "pods" && subresource == "eviction":
+		api.serveEviction(w, namespace, name)
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+func (api *apiServer) serveList(w http.ResponseWriter, r *http.Request, resource string, namespace string) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	api.mutex.Lock()
+	objects := api.sortedObjects(resource, namespace, selector, fieldSelector)
+	listMeta := metav1.ListMeta{ResourceVersion: strconv.FormatInt(api.resourceVersion, 10)}
+	api.mutex.Unlock()
+
+	switch resource {
+	case "pods":
+		list := &v1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, ListMeta: listMeta}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.Pod))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case "namespaces":
+		list := &v1.NamespaceList{TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"}, ListMeta: listMeta}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.Namespace))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case "nodes":
+		list := &v1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}, ListMeta: listMeta}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.Node))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case "events":
+		list := &v1.EventList{TypeMeta: metav1.TypeMeta{Kind: "EventList", APIVersion: "v1"}, ListMeta: listMeta}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.Event))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case "configmaps":
+		list := &v1.ConfigMapList{TypeMeta: metav1.TypeMeta{Kind: "ConfigMapList", APIVersion: "v1"}, ListMeta: listMeta}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.ConfigMap))
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func (api *apiServer) serveWatch(w http.ResponseWriter, r *http.Request, resource string, namespace string) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, "streaming is not supported")
+		return
+	}
+
+	watcher := &watcher{
+		resource:  resource,
+		namespace: namespace,
+		selector:  selector,
+		fields:    fieldSelector,
+		events:    make(chan storedEvent, 1000),
+		done:      make(chan struct{}),
+	}
+
+	api.mutex.Lock()
+	if time.Now().Before(api.watchesDownTill) {
+		api.mutex.Unlock()
+		writeStatus(w, http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, "watches are down")
+		return
+	}
+	// replay what happened since the requested resource version, or the current state for a fresh watch
+	since, err := strconv.ParseInt(r.URL.Query().Get("resourceVersion"), 10, 64)
+	if err != nil || since == 0 {
+		for _, obj := range api.sortedObjects(resource, namespace, selector, fieldSelector) {
+			watcher.events <- storedEvent{resource: resource, eventType: watch.Added, object: obj}
+		}
+	} else {
+		for _, event := range api.history {
+			if event.resourceVersion <= since {
+				continue
+			}
+			if translated, ok := watcher.translate(event); ok {
+				watcher.events <- translated
+			}
+		}
+	}
+	api.watchers[watcher] = true
+	api.mutex.Unlock()
+
+	defer func() {
+		api.mutex.Lock()
+		delete(api.watchers, watcher)
+		api.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-watcher.events:
+			raw, err := json.Marshal(event.object)
+			if err != nil {
+				return
+			}
+			if err := encoder.Encode(metav1.WatchEvent{Type: string(event.eventType), Object: runtime.RawExtension{Raw: raw}}); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-watcher.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveSubjectAccessReview answers a review with authorize
+func (api *apiServer) serveSubjectAccessReview(w http.ResponseWriter, r *http.Request) {
+	review := &authorizationv1.SubjectAccessReview{}
+	if err := readJSON(r, review); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	api.mutex.Lock()
+	authorize := api.authorize
+	api.mutex.Unlock()
+
+	review.TypeMeta = metav1.TypeMeta{Kind: "SubjectAccessReview", APIVersion: "authorization.k8s.io/v1"}
+	review.Status.Allowed = authorize == nil || authorize(review.Spec)
+	if !review.Status.Allowed {
+		review.Status.Reason = "no RBAC policy matched"
+	}
+	writeJSON(w, http.StatusCreated, review)
+}
+
+// setAuthorizer replaces the function answering SubjectAccessReviews
+func (api *apiServer) setAuthorizer(authorize func(spec authorizationv1.SubjectAccessReviewSpec) bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.authorize = authorize
+}
+
+func (api *apiServer) serveCreateEvent(w http.ResponseWriter, r *http.Request, namespace string) {
+	event := &v1.Event{}
+	if err := readJSON(r, event); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	event.TypeMeta = metav1.TypeMeta{Kind: "Event", APIVersion: "v1"}
+	event.Namespace = namespace
+	if event.Name == "" {
+		api.mutex.Lock()
+		event.Name = fmt.Sprintf("%s%d", event.GenerateName, api.resourceVersion+1)
+		api.mutex.Unlock()
+	}
+	writeJSON(w, http.StatusCreated, api.upsert("events", event))
+}
+
+func (api *apiServer) serveCreateConfigMap(w http.ResponseWriter, r *http.Request, namespace string) {
+	configMap := &v1.ConfigMap{}
+	if err := readJSON(r, configMap); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	configMap.Namespace = namespace
+	if api.get("configmaps", namespace, configMap.Name) != nil {
+		writeStatus(w, http.StatusConflict, metav1.StatusReasonAlreadyExists, fmt.Sprintf("configmaps %s already exists", configMap.Name))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.upsert("configmaps", configMap))
+}
+
+// serveUpdateConfigMap replaces a config map, refusing updates based on an outdated resource version
+func (api *apiServer) serveUpdateConfigMap(w http.ResponseWriter, r *http.Request, namespace string, name string) {
+	configMap := &v1.ConfigMap{}
+	if err := readJSON(r, configMap); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+	current := api.get("configmaps", namespace, name)
+	if current == nil {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("configmaps %s not found", name))
+		return
+	}
+	if configMap.ResourceVersion != "" && configMap.ResourceVersion != objectMeta(current).ResourceVersion {
+		writeStatus(w, http.StatusConflict, metav1.StatusReasonConflict, fmt.Sprintf("configmaps %s was modified", name))
+		return
+	}
+	configMap.Namespace, configMap.Name = namespace, name
+	writeJSON(w, http.StatusOK, api.upsert("configmaps", configMap))
+}
+
+// servePatchPodStatus merges the conditions of a strategic merge patch into the pod status
+func (api *apiServer) servePatchPodStatus(w http.ResponseWriter, r *http.Request, namespace string, name string) {
+	patch := struct {
+		Status struct {
+			Conditions []v1.PodCondition `json:"conditions"`
+		} `json:"status"`
+	}{}
+	if err := readJSON(r, &patch); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	obj := api.get("pods", namespace, name)
+	if obj == nil {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("pod %s not found", name))
+		return
+	}
+	pod := obj.(*v1.Pod)
+	for _, condition := range patch.Status.Conditions {
+		replaced := false
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == condition.Type {
+				pod.Status.Conditions[i] = condition
+				replaced = true
+			}
+		}
+		if !replaced {
+			pod.Status.Conditions = append(pod.Status.Conditions, condition)
+		}
+	}
+	writeJSON(w, http.StatusOK, api.upsert("pods", pod))
+}
+
+// servePatchPodMetadata applies the labels and annotations of a merge patch to the pod, a null value removes the key
+func (api *apiServer) servePatchPodMetadata(w http.ResponseWriter, r *http.Request, namespace string, name string) {
+	patch := struct {
+		Metadata struct {
+			Labels      map[string]*string `json:"labels"`
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}{}
+	if err := readJSON(r, &patch); err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	obj := api.get("pods", namespace, name)
+	if obj == nil {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("pod %s not found", name))
+		return
+	}
+	pod := obj.(*v1.Pod)
+	pod.Labels = mergeStrings(pod.Labels, patch.Metadata.Labels)
+	pod.Annotations = mergeStrings(pod.Annotations, patch.Metadata.Annotations)
+	writeJSON(w, http.StatusOK, api.upsert("pods", pod))
+}
+
+// mergeStrings applies a merge patch of a string map
+func mergeStrings(current map[string]string, patch map[string]*string) map[string]string {
+	if len(patch) > 0 && current == nil {
+		current = make(map[string]string)
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(current, key)
+			continue
+		}
+		current[key] = *value
+	}
+	return current
+}
+
+// serveEviction terminates the pod like a graceful delete: it is marked for deletion, then removed after a moment
+func (api *apiServer) serveEviction(w http.ResponseWriter, namespace string, name string) {
+	obj := api.get("pods", namespace, name)
+	if obj == nil {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("pod %s not found", name))
+		return
+	}
+	pod := obj.(*v1.Pod)
+	if pod.DeletionTimestamp == nil {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+		api.upsert("pods", pod)
+		time.AfterFunc(200*time.Millisecond, func() { api.remove("pods", namespace, name) })
+	}
+	writeJSON(w, http.StatusCreated, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusSuccess,
+		Code:     http.StatusCreated,
+	})
+}
+
+// translate maps a store event to what this watch sees, taking label changes into account
+func (w *watcher) translate(event storedEvent) (storedEvent, bool) {
+	if event.resource != w.resource {
+		return event, false
+	}
+	meta := objectMeta(event.object)
+	if w.namespace != "" && meta.Namespace != w.namespace {
+		return event, false
+	}
+	if !w.fields.Matches(objectFields(meta)) {
+		return event, false
+	}
+
+	matches := w.selector.Matches(labels.Set(meta.Labels))
+	matched := event.oldObject != nil && w.selector.Matches(labels.Set(objectMeta(event.oldObject).Labels))
+	switch {
+	case event.eventType == watch.Modified && matches && !matched:
+		event.eventType = watch.Added
+	case event.eventType == watch.Modified && !matches && matched:
+		event.eventType = watch.Deleted
+	case !matches:
+		return event, false
+	}
+	return event, true
+}
+
+// objectFields returns the fields a field selector can match on
+func objectFields(meta *metav1.ObjectMeta) fields.Set {
+	return fields.Set{"metadata.name": meta.Name, "metadata.namespace": meta.Namespace}
+}
+
+func objectMeta(obj runtime.Object) *metav1.ObjectMeta {
+	switch typed := obj.(type) {
+	case *v1.Pod:
+		return &typed.ObjectMeta
+	case *v1.Namespace:
+		return &typed.ObjectMeta
+	case *v1.Node:
+		return &typed.ObjectMeta
+	case *v1.Event:
+		return &typed.ObjectMeta
+	case *v1.ConfigMap:
+		return &typed.ObjectMeta
+	}
+	panic(fmt.Sprintf("unsupported object %T", obj))
+}
+
+// setKind fills in the type meta, which watch events need to be decoded
+func setKind(obj runtime.Object) {
+	switch typed := obj.(type) {
+	case *v1.Pod:
+		typed.TypeMeta = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+	case *v1.Namespace:
+		typed.TypeMeta = metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}
+	case *v1.Node:
+		typed.TypeMeta = metav1.TypeMeta{Kind: "Node", APIVersion: "v1"}
+	case *v1.Event:
+		typed.TypeMeta = metav1.TypeMeta{Kind: "Event", APIVersion: "v1"}
+	case *v1.ConfigMap:
+		typed.TypeMeta = metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"}
+	}
+}
+
+func objectKey(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+func typesUID(resource string, key string, resourceVersion int64) types.UID {
+	return types.UID(fmt.Sprintf("%s-%s-%d", resource, strings.Replace(key, "/", "-", -1), resourceVersion))
+}
+
+func readJSON(r *http.Request, into interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, into)
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reason,
+		Message:  message,
+	})
+}
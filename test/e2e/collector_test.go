@@ -0,0 +1,111 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// collectedSpan is the part of an OTLP/HTTP JSON span the scenarios check
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+}
+
+func (span collectedSpan) attribute(key string) string {
+	for _, attribute := range span.Attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// collector is a local OTLP/HTTP trace collector keeping every span it receives
+type collector struct {
+	mutex  sync.Mutex
+	spans  []collectedSpan
+	server *httptest.Server
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "not an OTLP/HTTP JSON trace export", http.StatusBadRequest)
+			return
+		}
+		request := struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []collectedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				c.spans = append(c.spans, scopeSpans.Spans...)
+			}
+		}
+		w.Write([]byte("{}"))
+	}))
+	return c
+}
+
+func (c *collector) close() {
+	c.server.Close()
+}
+
+// find returns the first span with the name whose attribute has the value
+func (c *collector) find(name string, key string, value string) (collectedSpan, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name && span.attribute(key) == value {
+			return span, true
+		}
+	}
+	return collectedSpan{}, false
+}
+
+// parent returns the parent of the span
+func (c *collector) parent(span collectedSpan) (collectedSpan, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, candidate := range c.spans {
+		if candidate.TraceID == span.TraceID && candidate.SpanID == span.ParentSpanID {
+			return candidate, true
+		}
+	}
+	return collectedSpan{}, false
+}
+
+// child returns the span with the name whose parent is the given span
+func (c *collector) child(parent collectedSpan, name string) (collectedSpan, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, candidate := range c.spans {
+		if candidate.Name == name && candidate.TraceID == parent.TraceID && candidate.ParentSpanID == parent.SpanID {
+			return candidate, true
+		}
+	}
+	return collectedSpan{}, false
+}
@@ -0,0 +1,46 @@
+//go:build e2e
+// +build e2e
+
+// Package e2e runs scripted end-to-end scenarios against the real controller, an in-memory
+// kubernetes apiserver and the in-memory ELBv2 fake. It needs no cluster and no AWS account:
+//
+//	go test -tags e2e ./test/e2e [-run TestScenarios/regexp] [-controller-logs]
+package e2e
+
+import (
+	"flag"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var controllerLogs = flag.Bool("controller-logs", false, "show the controller logs")
+
+func TestScenarios(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	if *controllerLogs {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			if err := runScenario(s); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func runScenario(s scenario) error {
+	h, err := newHarness()
+	if err != nil {
+		return err
+	}
+	defer h.close()
+
+	if err := h.startController(); err != nil {
+		return err
+	}
+	return s.run(h)
+}
@@ -0,0 +1,462 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/aws"
+	"github.com/birdrides/nlb-attacher/pkg/aws/fake"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/controller"
+	"github.com/birdrides/nlb-attacher/pkg/handlers"
+	"github.com/birdrides/nlb-attacher/pkg/policy"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+	"github.com/birdrides/nlb-attacher/pkg/state"
+)
+
+// backendName is the backend the harness registers: the real ELBv2 handler talking to the active fake
+const backendName = "e2e-elbv2"
+
+const (
+	namespace     = "default"
+	gracePeriod   = 200 * time.Millisecond
+	waitTimeout   = 15 * time.Second
+	pollInterval  = 50 * time.Millisecond
+	targetGroupA  = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-a/73e2d6bc24d8a067"
+	targetGroupB  = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/tg-b/83e2d6bc24d8a067"
+	missingTarget = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/missing/93e2d6bc24d8a067"
+	instanceGroup = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/instances/a3e2d6bc24d8a067"
+	unattached    = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/unattached/b3e2d6bc24d8a067"
+	otherVPC      = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/other-vpc/c3e2d6bc24d8a067"
+	loadBalancer  = "arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/net/nlb/50dc6c495c0c9188"
+	stateMap      = "nlb-attacher-state"
+	aliasMap      = "nlb-attacher-aliases"
+	policyMap     = "nlb-attacher-policy"
+	// allowAll is the policy every scenario starts with
+	allowAll = "rules:\n- targetGroups: ['*']\n"
+)
+
+// activeELB is the fake the registered backend uses, and activeAudit the sink it records its calls in.
+// Each scenario replaces them before starting a controller
+var (
+	activeELB   *fake.Client
+	activeAudit *auditRecorder
+)
+
+func init() {
+	handlers.Register(backendName, func(deps handlers.Dependencies) (handlers.Handler, error) {
+		deps.Audit = activeAudit
+		return aws.NewBackendFactory(activeELB)(deps)
+	})
+}
+
+// auditRecorder is an audit sink keeping the entries in memory
+type auditRecorder struct {
+	mutex   sync.Mutex
+	entries []audit.Entry
+}
+
+func (recorder *auditRecorder) Write(entry audit.Entry) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.entries = append(recorder.entries, entry)
+	return nil
+}
+
+func (recorder *auditRecorder) Close() error {
+	return nil
+}
+
+// find returns the first entry of the action on the target group whose targets include the pod
+func (recorder *auditRecorder) find(action string, tgArn string, podUID types.UID) (audit.Entry, bool) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	for _, entry := range recorder.entries {
+		if entry.Action != action || entry.TargetGroup != tgArn {
+			continue
+		}
+		for _, target := range entry.Targets {
+			if target.PodUID == podUID {
+				return entry, true
+			}
+		}
+	}
+	return audit.Entry{}, false
+}
+
+// harness runs the real controller against an in-memory apiserver and the ELBv2 fake
+type harness struct {
+	api       *apiServer
+	elb       *fake.Client
+	audit     *auditRecorder
+	clientset kubernetes.Interface
+	config    *config.Store
+
+	nextIP     int
+	stop       chan struct{}
+	controller *controller.Controller
+	stopped    chan struct{}
+}
+
+func newHarness() (*harness, error) {
+	api := newAPIServer()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: api.server.URL})
+	if err != nil {
+		api.close()
+		return nil, err
+	}
+
+	store, err := config.NewStore([]string{
+		"--backends=" + backendName,
+		"--resync-period=2s",
+		"--queue-qps=1000",
+		"--queue-burst=1000",
+		"--max-retries=10",
+		"--vpc-id=vpc-e2e",
+		"--health-check-period=200ms",
+		"--state-config-map=" + namespace + "/" + stateMap,
+		"--alias-config-map=" + namespace + "/" + aliasMap,
+		"--policy-config-map=" + namespace + "/" + policyMap,
+		"--access-review=true",
+		"--access-review-cache-period=1s",
+		"--node-drain=true",
+		"--status-annotation=true",
+		"--log-level=" + log.GetLevel().String(),
+	})
+	if err != nil {
+		api.close()
+		return nil, err
+	}
+
+	elb := fake.NewClient()
+	elb.AddLoadBalancer(loadBalancer, "nlb", "network", "vpc-e2e")
+	for name, arn := range map[string]string{"tg-a": targetGroupA, "tg-b": targetGroupB} {
+		elb.AddTargetGroup(fake.TargetGroup{
+			Arn:              arn,
+			Name:             name,
+			VpcID:            "vpc-e2e",
+			Port:             8080,
+			LoadBalancerArns: []string{loadBalancer},
+			Tags:             map[string]string{"team": strings.TrimPrefix(name, "tg-")},
+		})
+	}
+	// target groups the catalog must reject
+	elb.AddTargetGroup(fake.TargetGroup{Arn: instanceGroup, Name: "instances", TargetType: "instance", VpcID: "vpc-e2e", Port: 8080, LoadBalancerArns: []string{loadBalancer}})
+	elb.AddTargetGroup(fake.TargetGroup{Arn: unattached, Name: "unattached", VpcID: "vpc-e2e", Port: 8080})
+	elb.AddTargetGroup(fake.TargetGroup{Arn: otherVPC, Name: "other-vpc", VpcID: "vpc-other", Port: 8080, LoadBalancerArns: []string{loadBalancer}})
+	activeELB = elb
+	activeAudit = &auditRecorder{}
+
+	api.upsert("namespaces", &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	h := &harness{api: api, elb: elb, audit: activeAudit, clientset: clientset, config: store, nextIP: 1}
+	h.setPolicy(allowAll)
+	return h, nil
+}
+
+func (h *harness) close() {
+	h.stopController()
+	h.api.close()
+}
+
+// startController creates a new controller and waits for its caches to sync
+func (h *harness) startController() error {
+	h.stop = make(chan struct{})
+	h.stopped = make(chan struct{})
+	h.controller = controller.NewControllerWithClientset(h.config, h.clientset, h.stop)
+
+	go func(stopped chan struct{}, c *controller.Controller) {
+		defer close(stopped)
+		c.Run()
+	}(h.stopped, h.controller)
+
+	return h.eventually("controller caches synced", func() error {
+		if !h.controller.HasSynced() {
+			return fmt.Errorf("not synced")
+		}
+		return nil
+	})
+}
+
+// stopController stops the controller and waits for it to exit
+func (h *harness) stopController() {
+	if h.controller == nil {
+		return
+	}
+	close(h.stop)
+	select {
+	case <-h.stopped:
+	case <-time.After(waitTimeout):
+		log.Warn("Controller did not stop in time")
+	}
+	h.controller = nil
+}
+
+// drainController shuts the controller down the way a shutdown signal does: drain the queue, then stop it
+func (h *harness) drainController(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := h.controller.Drain(ctx)
+	h.stopController()
+	return err
+}
+
+// createPod creates a running, opted in pod with a fresh ip in the given target groups
+func (h *harness) createPod(name string, tgArns ...string) *v1.Pod {
+	return h.createPodOn("", name, tgArns...)
+}
+
+// createPodOn creates a pod like createPod, scheduled to the node
+func (h *harness) createPodOn(node string, name string, tgArns ...string) *v1.Pod {
+	return h.api.upsert("pods", h.newPod(node, name, tgArns...)).(*v1.Pod)
+}
+
+// createGatedPod creates a pod like createPod, declaring the registered readiness gate
+func (h *harness) createGatedPod(name string, tgArns ...string) *v1.Pod {
+	pod := h.newPod("", name, tgArns...)
+	pod.Spec.ReadinessGates = []v1.PodReadinessGate{{ConditionType: readiness.ConditionType}}
+	return h.api.upsert("pods", pod).(*v1.Pod)
+}
+
+func (h *harness) newPod(node string, name string, tgArns ...string) *v1.Pod {
+	ip := fmt.Sprintf("10.0.0.%d", h.nextIP)
+	h.nextIP++
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{config.DefaultEnabledLabelKey: "true"},
+			Annotations: map[string]string{config.DefaultTargetGroupAnnotationKey: targetGroupAnnotation(tgArns...)},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   node,
+			Containers: []v1.Container{{Name: "app", Image: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning, PodIP: ip},
+	}
+}
+
+// setNode creates or replaces a node, cordoned when unschedulable is set
+func (h *harness) setNode(name string, unschedulable bool, taints ...v1.Taint) {
+	h.api.upsert("nodes", &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+	})
+}
+
+// setTargetGroups replaces the target group annotation of a pod
+func (h *harness) setTargetGroups(name string, tgArns ...string) error {
+	obj := h.api.get("pods", namespace, name)
+	if obj == nil {
+		return fmt.Errorf("pod %s does not exist", name)
+	}
+	pod := obj.(*v1.Pod)
+	pod.Annotations[config.DefaultTargetGroupAnnotationKey] = targetGroupAnnotation(tgArns...)
+	h.api.upsert("pods", pod)
+	return nil
+}
+
+// setAnnotation replaces the target group annotation of a pod with a raw value, e.g. in the v2 format
+func (h *harness) setAnnotation(name string, value string) error {
+	obj := h.api.get("pods", namespace, name)
+	if obj == nil {
+		return fmt.Errorf("pod %s does not exist", name)
+	}
+	pod := obj.(*v1.Pod)
+	pod.Annotations[config.DefaultTargetGroupAnnotationKey] = value
+	h.api.upsert("pods", pod)
+	return nil
+}
+
+// deletePod terminates a pod the way the kubelet does: mark it for deletion, wait out the grace period, remove it
+func (h *harness) deletePod(name string) error {
+	obj := h.api.get("pods", namespace, name)
+	if obj == nil {
+		return fmt.Errorf("pod %s does not exist", name)
+	}
+	pod := obj.(*v1.Pod)
+	now := metav1.Now()
+	pod.DeletionTimestamp = &now
+	h.api.upsert("pods", pod)
+
+	time.Sleep(gracePeriod)
+	h.api.remove("pods", namespace, name)
+	return nil
+}
+
+// forceDeletePod removes a pod without marking it for deletion first, like kubectl delete --force --grace-period=0
+func (h *harness) forceDeletePod(name string) {
+	h.api.remove("pods", namespace, name)
+}
+
+func (h *harness) podIP(name string) string {
+	obj := h.api.get("pods", namespace, name)
+	if obj == nil {
+		return ""
+	}
+	return obj.(*v1.Pod).Status.PodIP
+}
+
+// expectTargets waits until the target group holds exactly the given ips, ignoring draining targets
+func (h *harness) expectTargets(tgArn string, ips ...string) error {
+	want := append([]string{}, ips...)
+	sort.Strings(want)
+
+	return h.eventually(fmt.Sprintf("targets of %s to be %v", tgArn, want), func() error {
+		got := make([]string, 0)
+		for _, target := range h.elb.Targets(tgArn) {
+			if target.State != "draining" {
+				got = append(got, target.ID)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("got %v", got)
+		}
+		return nil
+	})
+}
+
+// expectTargetPorts waits until the target group holds exactly the given ip:port targets, ignoring draining targets
+func (h *harness) expectTargetPorts(tgArn string, targets ...string) error {
+	want := append([]string{}, targets...)
+	sort.Strings(want)
+
+	return h.eventually(fmt.Sprintf("targets of %s to be %v", tgArn, want), func() error {
+		got := make([]string, 0)
+		for _, target := range h.elb.Targets(tgArn) {
+			if target.State != "draining" {
+				got = append(got, fmt.Sprintf("%s:%d", target.ID, target.Port))
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("got %v", got)
+		}
+		return nil
+	})
+}
+
+// expectEvent waits until an event with the given reason was recorded on the pod
+func (h *harness) expectEvent(podName string, reason string) error {
+	return h.eventually(fmt.Sprintf("event %s on pod %s", reason, podName), func() error {
+		for _, obj := range h.api.list("events") {
+			event := obj.(*v1.Event)
+			if event.InvolvedObject.Name == podName && event.Reason == reason {
+				return nil
+			}
+		}
+		return fmt.Errorf("not recorded")
+	})
+}
+
+// expectCondition waits until the pod has the condition with the given status and reason
+func (h *harness) expectCondition(podName string, conditionType v1.PodConditionType, status v1.ConditionStatus, reason string) error {
+	return h.eventually(fmt.Sprintf("condition %s=%s (%s) on pod %s", conditionType, status, reason, podName), func() error {
+		obj := h.api.get("pods", namespace, podName)
+		if obj == nil {
+			return fmt.Errorf("pod does not exist")
+		}
+		for _, condition := range obj.(*v1.Pod).Status.Conditions {
+			if condition.Type == conditionType {
+				if condition.Status != status || condition.Reason != reason {
+					return fmt.Errorf("got %s (%s)", condition.Status, condition.Reason)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("not set")
+	})
+}
+
+// replacePod removes a pod and creates one of the same name with a new uid and ip, like a statefulset does
+func (h *harness) replacePod(name string, tgArns ...string) *v1.Pod {
+	h.forceDeletePod(name)
+	return h.createPod(name, tgArns...)
+}
+
+// persistedTargets returns the pod names the state config map records for the target group
+func (h *harness) persistedTargets(tgArn string) ([]string, error) {
+	obj := h.api.get("configmaps", namespace, stateMap)
+	if obj == nil {
+		return nil, fmt.Errorf("state config map does not exist")
+	}
+	record := state.Record{}
+	if err := json.Unmarshal([]byte(obj.(*v1.ConfigMap).Data[state.DataKey]), &record); err != nil {
+		return nil, err
+	}
+	pods := make([]string, 0)
+	if tg, ok := record.TargetGroups[tgArn]; ok {
+		for _, target := range tg.Targets {
+			pods = append(pods, target.Pod)
+		}
+	}
+	sort.Strings(pods)
+	return pods, nil
+}
+
+// setAliases replaces the data of the alias config map
+func (h *harness) setAliases(aliases map[string]string) {
+	h.api.upsert("configmaps", &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: aliasMap},
+		Data:       aliases,
+	})
+}
+
+// setPolicy replaces the policy of the policy config map
+func (h *harness) setPolicy(contents string) {
+	h.api.upsert("configmaps", &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: policyMap},
+		Data:       map[string]string{policy.DataKey: contents},
+	})
+}
+
+// setNamespaceLabels replaces the labels of the scenarios' namespace
+func (h *harness) setNamespaceLabels(labels map[string]string) {
+	h.api.upsert("namespaces", &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: labels}})
+}
+
+// setConfig hot reloads the configuration as if the config map changed to the given yaml
+func (h *harness) setConfig(contents string) error {
+	return h.config.ReloadContents("e2e", []byte(contents))
+}
+
+// eventually polls check until it succeeds or the wait times out
+func (h *harness) eventually(description string, check func() error) error {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s: %v", description, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func targetGroupAnnotation(tgArns ...string) string {
+	entries := make([]string, 0, len(tgArns))
+	for _, arn := range tgArns {
+		entries = append(entries, fmt.Sprintf(`{"Arn": %q, "PortName": "http"}`, arn))
+	}
+	return "[" + strings.Join(entries, ", ") + "]"
+}
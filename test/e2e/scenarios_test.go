@@ -0,0 +1,991 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+
+	"github.com/birdrides/nlb-attacher/pkg/access"
+	"github.com/birdrides/nlb-attacher/pkg/annotation"
+	"github.com/birdrides/nlb-attacher/pkg/audit"
+	"github.com/birdrides/nlb-attacher/pkg/aws/fake"
+	"github.com/birdrides/nlb-attacher/pkg/cli"
+	"github.com/birdrides/nlb-attacher/pkg/config"
+	"github.com/birdrides/nlb-attacher/pkg/readiness"
+	"github.com/birdrides/nlb-attacher/pkg/tracing"
+)
+
+// scenario drives the cluster through a script against a running controller and asserts the final target group state
+type scenario struct {
+	name string
+	run  func(h *harness) error
+}
+
+var scenarios = []scenario{
+	{name: "scale-up", run: scaleUp},
+	{name: "scale-down", run: scaleDown},
+	{name: "rolling-update", run: rollingUpdate},
+	{name: "watch-drop", run: watchDrop},
+	{name: "annotation-change", run: annotationChange},
+	{name: "controller-restart", run: controllerRestart},
+	{name: "throttled-registration", run: throttledRegistration},
+	{name: "missing-target-group", run: missingTargetGroup},
+	{name: "rejected-target-groups", run: rejectedTargetGroups},
+	{name: "target-health", run: targetHealth},
+	{name: "remediate-deregister", run: remediateDeregister},
+	{name: "remediate-label", run: remediateLabel},
+	{name: "remediate-evict", run: remediateEvict},
+	{name: "graceful-shutdown", run: gracefulShutdown},
+	{name: "persisted-state", run: persistedState},
+	{name: "audit-log", run: auditLog},
+	{name: "tracing", run: tracedRegistration},
+	{name: "plan-and-gc", run: planAndGC},
+	{name: "admin-state", run: adminState},
+	{name: "kubectl-nlb", run: kubectlNLB},
+	{name: "annotation-v2", run: annotationV2},
+	{name: "target-group-aliases", run: targetGroupAliases},
+	{name: "target-group-policy", run: targetGroupPolicy},
+	{name: "access-review", run: accessReview},
+	{name: "node-drain", run: nodeDrain},
+}
+
+func scaleUp(h *harness) error {
+	ips := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		ips = append(ips, h.createPod(fmt.Sprintf("web-%d", i), targetGroupA).Status.PodIP)
+	}
+	return h.expectTargets(targetGroupA, ips...)
+}
+
+func scaleDown(h *harness) error {
+	ips := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		ips = append(ips, h.createPod(fmt.Sprintf("web-%d", i), targetGroupA).Status.PodIP)
+	}
+	if err := h.expectTargets(targetGroupA, ips...); err != nil {
+		return err
+	}
+
+	// two graceful deletions and one forced deletion that only shows up as the final delete event
+	if err := h.deletePod("web-3"); err != nil {
+		return err
+	}
+	if err := h.deletePod("web-2"); err != nil {
+		return err
+	}
+	h.forceDeletePod("web-1")
+	return h.expectTargets(targetGroupA, ips[0])
+}
+
+func rollingUpdate(h *harness) error {
+	for i := 0; i < 3; i++ {
+		h.createPod(fmt.Sprintf("web-v1-%d", i), targetGroupA)
+	}
+
+	newIPs := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		newIPs = append(newIPs, h.createPod(fmt.Sprintf("web-v2-%d", i), targetGroupA).Status.PodIP)
+		// surge one new pod, then retire one old pod once the new one serves
+		if err := h.eventually("new pod registered", func() error {
+			for _, target := range h.elb.Targets(targetGroupA) {
+				if target.ID == newIPs[i] {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s not registered", newIPs[i])
+		}); err != nil {
+			return err
+		}
+		if err := h.deletePod(fmt.Sprintf("web-v1-%d", i)); err != nil {
+			return err
+		}
+	}
+	return h.expectTargets(targetGroupA, newIPs...)
+}
+
+func watchDrop(h *harness) error {
+	kept := h.createPod("web-0", targetGroupA).Status.PodIP
+	h.createPod("web-1", targetGroupA)
+	moved := h.createPod("web-2", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, kept, h.podIP("web-1"), moved); err != nil {
+		return err
+	}
+
+	// everything below happens while the controller cannot watch, it only learns about it by relisting
+	h.api.dropWatches(1500 * time.Millisecond)
+	added := h.createPod("web-3", targetGroupA).Status.PodIP
+	h.forceDeletePod("web-1")
+	if err := h.setTargetGroups("web-2", targetGroupB); err != nil {
+		return err
+	}
+
+	if err := h.expectTargets(targetGroupA, kept, added); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupB, moved)
+}
+
+func annotationChange(h *harness) error {
+	both := h.createPod("web-0", targetGroupA, targetGroupB).Status.PodIP
+	moved := h.createPod("web-1", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, both, moved); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB, both); err != nil {
+		return err
+	}
+
+	if err := h.setTargetGroups("web-0", targetGroupB); err != nil {
+		return err
+	}
+	if err := h.setTargetGroups("web-1", targetGroupB); err != nil {
+		return err
+	}
+
+	if err := h.expectTargets(targetGroupA); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupB, both, moved)
+}
+
+func controllerRestart(h *harness) error {
+	first := h.createPod("web-0", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, first); err != nil {
+		return err
+	}
+
+	h.stopController()
+	second := h.createPod("web-1", targetGroupA).Status.PodIP
+	third := h.createPod("web-2", targetGroupA).Status.PodIP
+	if err := h.startController(); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, first, second, third); err != nil {
+		return err
+	}
+
+	// the restarted controller keeps handling changes
+	if err := h.deletePod("web-0"); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupA, second, third)
+}
+
+func throttledRegistration(h *harness) error {
+	h.elb.InjectFault("RegisterTargets", fake.ThrottlingError(), 3)
+	ip := h.createPod("web-0", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+	if calls := h.elb.Calls("RegisterTargets"); calls < 4 {
+		return fmt.Errorf("expected the throttled registration to be retried, got %d RegisterTargets calls", calls)
+	}
+	return nil
+}
+
+func missingTargetGroup(h *harness) error {
+	ip := h.createPod("web-0", targetGroupA, missingTarget).Status.PodIP
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+	return h.expectEvent("web-0", "TargetGroupSyncFailed")
+}
+
+func rejectedTargetGroups(h *harness) error {
+	ip := h.createPod("web-0", targetGroupA, instanceGroup, unattached, otherVPC).Status.PodIP
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "TargetGroupSyncFailed"); err != nil {
+		return err
+	}
+	// the catalog rejects them before RegisterTargets is called
+	for _, arn := range []string{instanceGroup, unattached, otherVPC} {
+		if targets := h.elb.Targets(arn); len(targets) > 0 {
+			return fmt.Errorf("expected no targets in %s, got %v", arn, targets)
+		}
+	}
+	return nil
+}
+
+func targetHealth(h *harness) error {
+	ip := h.createPod("web-0", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+	if err := h.expectCondition("web-0", readiness.HealthConditionType, v1.ConditionTrue, "Healthy"); err != nil {
+		return err
+	}
+
+	target := h.elb.Targets(targetGroupA)[0]
+	if err := h.elb.SetTargetHealth(targetGroupA, target.ID, target.Port, "unhealthy", "Target.FailedHealthChecks"); err != nil {
+		return err
+	}
+	if err := h.expectCondition("web-0", readiness.HealthConditionType, v1.ConditionFalse, "FailedHealthChecks"); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "TargetUnhealthy"); err != nil {
+		return err
+	}
+
+	if err := h.elb.SetTargetHealth(targetGroupA, target.ID, target.Port, "", ""); err != nil {
+		return err
+	}
+	if err := h.expectCondition("web-0", readiness.HealthConditionType, v1.ConditionTrue, "Healthy"); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "TargetHealthy"); err != nil {
+		return err
+	}
+
+	// the condition is reset once no target group reports the pod
+	if err := h.setAnnotation("web-0", `{"version":"v2","targetGroups":[]}`); err != nil {
+		return err
+	}
+	return h.expectCondition("web-0", readiness.HealthConditionType, v1.ConditionUnknown, "NotRegistered")
+}
+
+// makeUnhealthy waits for the pod to be registered with the target group and then fails its health checks
+func makeUnhealthy(h *harness, podName string, tgArn string) (fake.Target, error) {
+	ip := h.podIP(podName)
+	var found fake.Target
+	err := h.eventually(fmt.Sprintf("%s registered with %s", podName, tgArn), func() error {
+		for _, target := range h.elb.Targets(tgArn) {
+			if target.ID == ip {
+				found = target
+				return nil
+			}
+		}
+		return fmt.Errorf("not registered")
+	})
+	if err != nil {
+		return found, err
+	}
+	return found, h.elb.SetTargetHealth(tgArn, found.ID, found.Port, "unhealthy", "Target.FailedHealthChecks")
+}
+
+func remediateDeregister(h *harness) error {
+	if err := h.setConfig(fmt.Sprintf("remediation: %s=deregister:500ms\nmax-concurrent-remediations: 1\n", targetGroupA)); err != nil {
+		return err
+	}
+	healthy := h.createPod("web-0", targetGroupA).Status.PodIP
+	returning := h.createPod("web-1", targetGroupA).Status.PodIP
+	h.createGatedPod("web-2", targetGroupA)
+	for _, name := range []string{"web-1", "web-2"} {
+		if _, err := makeUnhealthy(h, name, targetGroupA); err != nil {
+			return err
+		}
+	}
+
+	// a deregistration frees its remediation slot once the target drained, so the second pod follows the first
+	if err := h.expectTargets(targetGroupA, healthy); err != nil {
+		return err
+	}
+	for _, name := range []string{"web-1", "web-2"} {
+		if err := h.expectEvent(name, "TargetRemediated"); err != nil {
+			return err
+		}
+		if err := h.eventually(name+" held out", func() error {
+			pod := h.api.get("pods", namespace, name).(*v1.Pod)
+			if !annotation.IsHeldOut(pod, targetGroupA) {
+				return fmt.Errorf("annotations are %v", pod.Annotations)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	// a held out pod receives no traffic, so it is not ready
+	if err := h.expectCondition("web-2", readiness.ConditionType, v1.ConditionFalse, "HeldOut"); err != nil {
+		return err
+	}
+
+	// neither resyncs nor a restarted controller put the deregistered pods back
+	h.stopController()
+	if err := h.startController(); err != nil {
+		return err
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if err := h.expectTargets(targetGroupA, healthy); err != nil {
+		return err
+	}
+
+	// removing the annotation lets the pod back in
+	pod := h.api.get("pods", namespace, "web-1").(*v1.Pod)
+	delete(pod.Annotations, annotation.HeldOutKey)
+	h.api.upsert("pods", pod)
+	return h.expectTargets(targetGroupA, healthy, returning)
+}
+
+func remediateLabel(h *harness) error {
+	if err := h.setConfig(fmt.Sprintf("remediation: %s=label:500ms\n", targetGroupA)); err != nil {
+		return err
+	}
+	h.createPod("web-0", targetGroupA)
+	target, err := makeUnhealthy(h, "web-0", targetGroupA)
+	if err != nil {
+		return err
+	}
+
+	labelled := func(want bool) func() error {
+		return func() error {
+			obj := h.api.get("pods", namespace, "web-0")
+			if obj == nil {
+				return fmt.Errorf("pod does not exist")
+			}
+			if _, ok := obj.(*v1.Pod).Labels[config.UnhealthyLabelKey]; ok != want {
+				return fmt.Errorf("labelled is %v", ok)
+			}
+			return nil
+		}
+	}
+	if err := h.eventually("unhealthy label", labelled(true)); err != nil {
+		return err
+	}
+
+	// a restarted controller picks the remediation up from the label
+	h.stopController()
+	if err := h.startController(); err != nil {
+		return err
+	}
+	if err := h.elb.SetTargetHealth(targetGroupA, target.ID, target.Port, "", ""); err != nil {
+		return err
+	}
+	if err := h.eventually("unhealthy label removed", labelled(false)); err != nil {
+		return err
+	}
+	return h.expectEvent("web-0", "TargetRecovered")
+}
+
+func remediateEvict(h *harness) error {
+	if err := h.setConfig(fmt.Sprintf("remediation: %s=evict:500ms\nmax-concurrent-remediations: 1\n", targetGroupA)); err != nil {
+		return err
+	}
+	healthy := h.createPod("web-0", targetGroupA).Status.PodIP
+	h.createPod("web-1", targetGroupA)
+	h.createPod("web-2", targetGroupA)
+	if _, err := makeUnhealthy(h, "web-1", targetGroupA); err != nil {
+		return err
+	}
+	if _, err := makeUnhealthy(h, "web-2", targetGroupA); err != nil {
+		return err
+	}
+
+	// only one eviction may be in effect at a time, the second follows once the first pod is gone
+	if err := h.eventually("both unhealthy pods evicted", func() error {
+		for _, name := range []string{"web-1", "web-2"} {
+			if h.api.get("pods", namespace, name) != nil {
+				return fmt.Errorf("%s still exists", name)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupA, healthy)
+}
+
+func gracefulShutdown(h *harness) error {
+	ips := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		ips = append(ips, h.createPod(fmt.Sprintf("web-%d", i), targetGroupA).Status.PodIP)
+	}
+	if err := h.expectTargets(targetGroupA, ips...); err != nil {
+		return err
+	}
+
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	// a stopped controller takes nothing new and leaves every registration in place
+	h.createPod("web-4", targetGroupA)
+	time.Sleep(500 * time.Millisecond)
+	if err := h.expectTargets(targetGroupA, ips...); err != nil {
+		return err
+	}
+	if calls := h.elb.Calls("DeregisterTargets"); calls > 0 {
+		return fmt.Errorf("expected no DeregisterTargets calls, got %d", calls)
+	}
+	return nil
+}
+
+func persistedState(h *harness) error {
+	deleted := h.createPod("web-0", targetGroupA).Status.PodIP
+	h.createPod("web-1", targetGroupA)
+	moved := h.createPod("web-2", targetGroupA, targetGroupB).Status.PodIP
+	if _, err := h.elb.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupA),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String("10.9.9.9")}},
+	}); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, deleted, h.podIP("web-1"), moved, "10.9.9.9"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB, moved); err != nil {
+		return err
+	}
+
+	// draining writes the state, the target registered by someone else is not in it
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	persisted, err := h.persistedTargets(targetGroupA)
+	if err != nil {
+		return err
+	}
+	if want := []string{"default/web-0", "default/web-1", "default/web-2"}; !reflect.DeepEqual(persisted, want) {
+		return fmt.Errorf("expected the state to record %v in %s, got %v", want, targetGroupA, persisted)
+	}
+
+	// while no controller runs: a forced deletion, a replacement under the same name and an annotation change
+	h.forceDeletePod("web-0")
+	replaced := h.replacePod("web-1", targetGroupA).Status.PodIP
+	if err := h.setTargetGroups("web-2", targetGroupA); err != nil {
+		return err
+	}
+
+	if err := h.startController(); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, replaced, moved, "10.9.9.9"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	if persisted, err := h.persistedTargets(targetGroupB); err != nil || len(persisted) > 0 {
+		return fmt.Errorf("expected the state to record nothing in %s, got %v (%v)", targetGroupB, persisted, err)
+	}
+	return nil
+}
+
+func auditLog(h *harness) error {
+	pod := h.createPod("web-0", targetGroupA)
+	if err := h.expectTargets(targetGroupA, pod.Status.PodIP); err != nil {
+		return err
+	}
+	if err := h.deletePod("web-0"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA); err != nil {
+		return err
+	}
+
+	for action, event := range map[string]string{"RegisterTargets": "create", "DeregisterTargets": "update"} {
+		var entry audit.Entry
+		if err := h.eventually(action+" audit entry", func() error {
+			var ok bool
+			if entry, ok = h.audit.find(action, targetGroupA, pod.UID); !ok {
+				return fmt.Errorf("not recorded")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if entry.Outcome != audit.OutcomeSuccess || entry.RequestID == "" {
+			return fmt.Errorf("expected a successful %s with a request id, got %+v", action, entry)
+		}
+		if entry.Trigger == nil || entry.Trigger.Event != event || entry.Trigger.Key != namespace+"/web-0" {
+			return fmt.Errorf("expected %s to be triggered by the %s event of %s/web-0, got %+v", action, event, namespace, entry.Trigger)
+		}
+		if target := entry.Targets[0]; target.ID != pod.Status.PodIP || target.Port != 8080 {
+			return fmt.Errorf("expected %s of %s:8080, got %+v", action, pod.Status.PodIP, target)
+		}
+	}
+	return nil
+}
+
+func tracedRegistration(h *harness) error {
+	collector := newCollector()
+	defer collector.close()
+	exporter := tracing.NewExporter(collector.server.URL, "nlb-attacher")
+	tracing.Setup(exporter, 1)
+	defer tracing.Setup(nil, 0)
+	go exporter.Run()
+
+	pod := h.createPod("web-0", targetGroupA)
+	if err := h.expectTargets(targetGroupA, pod.Status.PodIP); err != nil {
+		return err
+	}
+	if err := h.eventually("registration audited", func() error {
+		if _, ok := h.audit.find("RegisterTargets", targetGroupA, pod.UID); !ok {
+			return fmt.Errorf("not yet")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// the spans around the call end once the worker is done with the event
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	tracing.Setup(nil, 0)
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		return err
+	}
+
+	// the register call leads back to the informer event of the pod through every stage
+	span, ok := collector.find("elbv2.RegisterTargets", "elbv2.arn", targetGroupA)
+	if !ok {
+		return fmt.Errorf("no elbv2.RegisterTargets span of %s was exported", targetGroupA)
+	}
+	chain := []string{span.Name}
+	for span.ParentSpanID != "" {
+		if span, ok = collector.parent(span); !ok {
+			return fmt.Errorf("the parent of %s was not exported", chain[len(chain)-1])
+		}
+		chain = append(chain, span.Name)
+	}
+	if want := []string{"elbv2.RegisterTargets", "aws.PodCreated", "processItem", "informer.create"}; !reflect.DeepEqual(chain, want) {
+		return fmt.Errorf("expected the span chain %v, got %v", want, chain)
+	}
+	if uid := span.attribute("k8s.pod.uid"); uid != string(pod.UID) {
+		return fmt.Errorf("expected the informer span to carry pod uid %s, got %q", pod.UID, uid)
+	}
+	if _, ok := collector.child(span, "workqueue.wait"); !ok {
+		return fmt.Errorf("expected a workqueue.wait span under %s", span.Name)
+	}
+	return nil
+}
+
+func planAndGC(h *harness) error {
+	kept := h.createPod("web-0", targetGroupA).Status.PodIP
+	unwanted := h.createPod("web-1", targetGroupA).Status.PodIP
+	deletedPod := h.createPod("web-2", targetGroupA)
+	deleted := deletedPod.Status.PodIP
+	if _, err := h.elb.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupA),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String("10.9.9.9")}},
+	}); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, kept, unwanted, deleted, "10.9.9.9"); err != nil {
+		return err
+	}
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+
+	// while no controller runs: one pod drops the target group, one is gone and a new one wants it
+	if err := h.setTargetGroups("web-1"); err != nil {
+		return err
+	}
+	h.forceDeletePod("web-2")
+	added := h.createPod("web-3", targetGroupA).Status.PodIP
+
+	out := &bytes.Buffer{}
+	env := cli.Environment{Config: h.config.Get(), Clientset: h.clientset, ELB: h.elb, Audit: h.audit, Out: out}
+	summary, err := cli.Plan(context.Background(), env)
+	if err != nil {
+		return err
+	}
+	if want := (cli.Summary{Register: 1, Deregister: 2, Unchanged: 1, Unmanaged: 1}); summary != want {
+		return fmt.Errorf("expected the plan %+v, got %+v:\n%s", want, summary, out)
+	}
+	for _, line := range []string{"+ " + added + ":8080  default/web-3", "- " + unwanted + ":8080  default/web-1", "- " + deleted + ":8080  default/web-2"} {
+		if !strings.Contains(out.String(), line) {
+			return fmt.Errorf("expected the plan to contain %q:\n%s", line, out)
+		}
+	}
+
+	// without --apply gc only prints
+	if removed, err := cli.GC(context.Background(), env, false); err != nil || removed != 0 {
+		return fmt.Errorf("expected gc without apply to remove nothing, removed %d (%v)", removed, err)
+	}
+	if err := h.expectTargets(targetGroupA, kept, unwanted, deleted, "10.9.9.9"); err != nil {
+		return err
+	}
+
+	removed, err := cli.GC(context.Background(), env, true)
+	if err != nil {
+		return err
+	}
+	if removed != 2 {
+		return fmt.Errorf("expected gc to deregister 2 targets, deregistered %d", removed)
+	}
+	// the target of no known pod and the one only a reconcile registers are left alone
+	if err := h.expectTargets(targetGroupA, kept, "10.9.9.9"); err != nil {
+		return err
+	}
+	entry, ok := h.audit.find("DeregisterTargets", targetGroupA, deletedPod.UID)
+	if !ok || entry.Trigger == nil || entry.Trigger.Event != "gc" {
+		return fmt.Errorf("expected gc's deregistrations in the audit log, got %+v", entry)
+	}
+
+	status := &bytes.Buffer{}
+	env.Out = status
+	if err := cli.Status(context.Background(), env); err != nil {
+		return err
+	}
+	if !strings.Contains(status.String(), added+":8080") || !strings.Contains(status.String(), "default/web-0") {
+		return fmt.Errorf("expected the status to list web-0 and web-3:\n%s", status)
+	}
+	return nil
+}
+
+func adminState(h *harness) error {
+	paused := h.createPod("web-0", targetGroupA).Status.PodIP
+	h.createPod("web-1", targetGroupB)
+	if err := h.expectTargets(targetGroupB, h.podIP("web-1")); err != nil {
+		return err
+	}
+	if err := h.controller.PauseTargetGroup(targetGroupA, "maintenance"); err != nil {
+		return err
+	}
+	if err := h.controller.DetachPod(namespace, "web-1", 0, "debugging"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	// the pause and the detach outlive the controller
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	if err := h.startController(); err != nil {
+		return err
+	}
+	h.createGatedPod("web-2", targetGroupA)
+	// a pod left out of a paused target group does not hold back its rollout
+	if err := h.expectCondition("web-2", readiness.ConditionType, v1.ConditionTrue, "TargetGroupPaused"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, paused); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	// plan neither registers into the paused target group nor puts the detached pod back
+	if err := h.drainController(5 * time.Second); err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	env := cli.Environment{Config: h.config.Get(), Clientset: h.clientset, ELB: h.elb, Out: out}
+	summary, err := cli.Plan(context.Background(), env)
+	if err != nil {
+		return err
+	}
+	if want := (cli.Summary{Unchanged: 2}); summary != want {
+		return fmt.Errorf("expected the plan %+v, got %+v:\n%s", want, summary, out)
+	}
+
+	if err := h.startController(); err != nil {
+		return err
+	}
+	if err := h.controller.ResumeTargetGroup(targetGroupA, "maintenance done"); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupA, paused, h.podIP("web-2"))
+}
+
+func kubectlNLB(h *harness) error {
+	ip := h.createPod("web-0", targetGroupA, missingTarget).Status.PodIP
+	h.createPod("web-1", targetGroupA)
+	if err := h.expectTargets(targetGroupA, ip, h.podIP("web-1")); err != nil {
+		return err
+	}
+
+	env := cli.Environment{Config: h.config.Get(), Clientset: h.clientset, ELB: h.elb, Out: &bytes.Buffer{}}
+	selection := cli.Selection{Kind: "namespace", Name: namespace, Namespace: namespace}
+	want := []cli.Attachment{
+		{Pod: "default/web-0", TargetGroup: "tg-a", Port: 8080, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAnnotation},
+		{Pod: "default/web-0", TargetGroup: "missing", State: annotation.StateFailed, Source: cli.SourceAnnotation},
+		{Pod: "default/web-1", TargetGroup: "tg-a", Port: 8080, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAnnotation},
+	}
+	err := h.eventually("the status annotations to report the targets", func() error {
+		got, err := cli.Attachments(context.Background(), env, selection, false)
+		if err != nil {
+			return err
+		}
+		return compareAttachments(got, want)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the plugin falls back to ELBv2, which does not know the attach errors
+	pod := cli.Selection{Kind: "pod", Name: "web-0", Namespace: namespace}
+	got, err := cli.Attachments(context.Background(), env, pod, true)
+	if err != nil {
+		return err
+	}
+	want = []cli.Attachment{
+		{Pod: "default/web-0", TargetGroup: "tg-a", Port: 8080, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAWS},
+		{Pod: "default/web-0", TargetGroup: "missing", State: "unknown", Source: cli.SourceAWS},
+	}
+	if err := compareAttachments(got, want); err != nil {
+		return err
+	}
+
+	// a pod on two ports of one target group is a row per port
+	if err := h.setAnnotation("web-1", fmt.Sprintf(`{"version": "v2", "targetGroups": [{"arn": %q}, {"arn": %q, "port": 9090}]}`, targetGroupA, targetGroupA)); err != nil {
+		return err
+	}
+	if err := h.expectTargetPorts(targetGroupA, ip+":8080", h.podIP("web-1")+":8080", h.podIP("web-1")+":9090"); err != nil {
+		return err
+	}
+	got, err = cli.Attachments(context.Background(), env, cli.Selection{Kind: "pod", Name: "web-1", Namespace: namespace}, true)
+	if err != nil {
+		return err
+	}
+	want = []cli.Attachment{
+		{Pod: "default/web-1", TargetGroup: "tg-a", Port: 8080, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAWS},
+		{Pod: "default/web-1", TargetGroup: "tg-a", Port: 9090, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAWS},
+	}
+	return compareAttachments(got, want)
+}
+
+// compareAttachments checks the attachments against want, only checking that an error is set where want has a failure
+func compareAttachments(got []cli.Attachment, want []cli.Attachment) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("expected %d attachments, got %+v", len(want), got)
+	}
+	for i := range want {
+		failed := want[i].State == annotation.StateFailed || want[i].State == "unknown"
+		if failed != (got[i].LastError != "") {
+			return fmt.Errorf("expected an error only on failed attachments, got %+v", got[i])
+		}
+		got[i].LastError = ""
+		if got[i] != want[i] {
+			return fmt.Errorf("expected attachment %+v, got %+v", want[i], got[i])
+		}
+	}
+	return nil
+}
+
+func annotationV2(h *harness) error {
+	// tg-a by name on an explicit port, tg-b by ARN with a weight that leaves every pod out
+	ip := h.createPod("web-0").Status.PodIP
+	err := h.setAnnotation("web-0", fmt.Sprintf(`version: v2
+targetGroups:
+- name: tg-a
+  port: 9090
+- arn: %s
+  weight: 0
+`, targetGroupB))
+	if err != nil {
+		return err
+	}
+	if err := h.expectTargetPorts(targetGroupA, ip+":9090"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	// without a port the target moves to the port of the target group, and the full weight adds tg-b
+	if err := h.setAnnotation("web-0", fmt.Sprintf(`{"version": "v2", "targetGroups": [{"arn": %q}, {"arn": %q, "weight": 100}]}`, targetGroupA, targetGroupB)); err != nil {
+		return err
+	}
+	if err := h.expectTargetPorts(targetGroupA, ip+":8080"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB, ip); err != nil {
+		return err
+	}
+	if err := h.setTargetGroups("web-0", targetGroupA); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	// every problem is reported at once, with the path of its field
+	pod := h.api.get("pods", namespace, "web-0").(*v1.Pod).DeepCopy()
+	pod.Annotations[config.DefaultTargetGroupAnnotationKey] = `version: v2
+targetGroups:
+- name: tg-a
+  alias: web
+  port: metrics
+  wieght: 10
+- arn: not-an-arn
+  health: {remediation: evict}
+`
+	key := config.DefaultTargetGroupAnnotationKey
+	want := []string{
+		key + ".targetGroups[0].wieght: unknown field, expected one of arn, name, alias, port, readiness, health, weight, subset",
+		key + ".targetGroups[0]: only one of arn, name and alias may be set",
+		key + ".targetGroups[1].arn: \"not-an-arn\" is not a target group ARN",
+		key + ".targetGroups[1].health.after: a duration above zero is required with health.remediation evict",
+		key + ".targetGroups[0].port: \"metrics\" is not a named container port (have: http)",
+	}
+	if got := annotation.Validate(pod, key); !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("expected problems %q, got %q", want, got)
+	}
+	return nil
+}
+
+func targetGroupAliases(h *harness) error {
+	// the alias is not defined yet, so the pods fail to attach
+	ips := make([]string, 0, 2)
+	for _, name := range []string{"web-0", "web-1"} {
+		ips = append(ips, h.createPod(name).Status.PodIP)
+		if err := h.setAnnotation(name, "version: v2\ntargetGroups:\n- alias: web\n"); err != nil {
+			return err
+		}
+	}
+	env := cli.Environment{Config: h.config.Get(), Clientset: h.clientset, ELB: h.elb, Out: &bytes.Buffer{}}
+	selection := cli.Selection{Kind: "pod", Name: "web-0", Namespace: namespace}
+	want := []cli.Attachment{{Pod: "default/web-0", TargetGroup: "web", State: annotation.StateFailed, Source: cli.SourceAnnotation}}
+	err := h.eventually("the undefined alias to be reported", func() error {
+		got, err := cli.Attachments(context.Background(), env, selection, false)
+		if err != nil {
+			return err
+		}
+		return compareAttachments(got, want)
+	})
+	if err != nil {
+		return err
+	}
+
+	// defining the alias requeues the pods referencing it
+	h.setAliases(map[string]string{"web": targetGroupA})
+	if err := h.expectTargets(targetGroupA, ips...); err != nil {
+		return err
+	}
+
+	// retargeting it moves them
+	h.setAliases(map[string]string{"web": targetGroupB, "api": targetGroupA})
+	if err := h.expectTargets(targetGroupB, ips...); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA); err != nil {
+		return err
+	}
+
+	// the plugin resolves the alias when it falls back to ELBv2
+	got, err := cli.Attachments(context.Background(), env, selection, true)
+	if err != nil {
+		return err
+	}
+	want = []cli.Attachment{{Pod: "default/web-0", TargetGroup: "web", Port: 8080, State: annotation.StateRegistered, Health: elbv2.TargetHealthStateEnumHealthy, Source: cli.SourceAWS}}
+	return compareAttachments(got, want)
+}
+
+func targetGroupPolicy(h *harness) error {
+	ip := h.createPod("web-0", targetGroupA, targetGroupB).Status.PodIP
+	if err := h.expectTargets(targetGroupB, ip); err != nil {
+		return err
+	}
+
+	// restricting the namespace to tg-a by name takes the pod out of tg-b
+	h.setPolicy("rules:\n- namespaces: [default]\n  targetGroups: [tg-a]\n")
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "TargetGroupDenied"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+
+	// a tag rule for labelled namespaces lets it back in once the namespace carries the label
+	h.setPolicy(`rules:
+- namespaces: [default]
+  targetGroups: [tg-a]
+- namespaceSelector:
+    matchLabels: {team: b}
+  tags: {team: b}
+`)
+	// the new policy still denies the unlabelled namespace
+	time.Sleep(500 * time.Millisecond)
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+	h.setNamespaceLabels(map[string]string{"team": "b"})
+	return h.expectTargets(targetGroupB, ip)
+}
+
+func accessReview(h *harness) error {
+	// the default service account may bind tg-a only
+	bindable := map[string]bool{"tg-a": true}
+	var mutex sync.Mutex
+	h.api.setAuthorizer(func(spec authorizationv1.SubjectAccessReviewSpec) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		resource := spec.ResourceAttributes
+		return spec.User == "system:serviceaccount:default:default" && resource != nil &&
+			resource.Verb == access.Verb && resource.Group == access.Group && resource.Resource == access.Resource &&
+			resource.Namespace == namespace && bindable[resource.Name]
+	})
+
+	ip := h.createPod("web-0", targetGroupA, targetGroupB).Status.PodIP
+	if err := h.expectTargets(targetGroupA, ip); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "TargetGroupDenied"); err != nil {
+		return err
+	}
+	if err := h.expectTargets(targetGroupB); err != nil {
+		return err
+	}
+
+	// a grant is picked up by the next resync, and so is its revocation
+	mutex.Lock()
+	bindable["tg-b"] = true
+	mutex.Unlock()
+	if err := h.expectTargets(targetGroupB, ip); err != nil {
+		return err
+	}
+	mutex.Lock()
+	delete(bindable, "tg-a")
+	mutex.Unlock()
+	if err := h.expectTargets(targetGroupA); err != nil {
+		return err
+	}
+	return h.expectTargets(targetGroupB, ip)
+}
+
+func nodeDrain(h *harness) error {
+	h.setNode("node-1", false)
+	h.setNode("node-2", false)
+	first := h.createPodOn("node-1", "web-0", targetGroupA).Status.PodIP
+	second := h.createPodOn("node-2", "web-1", targetGroupA).Status.PodIP
+	if err := h.expectTargets(targetGroupA, first, second); err != nil {
+		return err
+	}
+
+	// cordoning a node deregisters its pods before they are evicted, uncordoning it brings them back
+	h.setNode("node-1", true)
+	if err := h.expectTargets(targetGroupA, second); err != nil {
+		return err
+	}
+	if err := h.expectEvent("web-0", "NodeDraining"); err != nil {
+		return err
+	}
+	h.setNode("node-1", false)
+	if err := h.expectTargets(targetGroupA, first, second); err != nil {
+		return err
+	}
+
+	// a soft taint does not drain the node, the cluster autoscaler's termination taint does
+	h.setNode("node-2", false, v1.Taint{Key: "DeletionCandidateOfClusterAutoscaler", Effect: v1.TaintEffectPreferNoSchedule})
+	time.Sleep(500 * time.Millisecond)
+	if err := h.expectTargets(targetGroupA, first, second); err != nil {
+		return err
+	}
+	h.setNode("node-2", false, v1.Taint{Key: "ToBeDeletedByClusterAutoscaler", Effect: v1.TaintEffectNoSchedule})
+	return h.expectTargets(targetGroupA, first)
+}